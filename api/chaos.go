@@ -0,0 +1,65 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"math/rand"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+)
+
+//newChaos returns the draws of the injected faults, a random number in [0, 1) per request
+func newChaos() func() float64 {
+	var lock sync.Mutex
+	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+	return func() float64 {
+		lock.Lock()
+		defer lock.Unlock()
+		return random.Float64()
+	}
+}
+
+//injectFault fails ChaosFailureRate percent of the requests with 500 and delays ChaosDelayRate percent of them by
+//ChaosDelay, so the resilience of the clients can be tested. It does nothing unless ChaosEnabled is set. Health and
+//readiness are never faulted, so the instance is not restarted
+func (server *Server) injectFault(context *gin.Context) {
+	if server.chaos == nil {
+		context.Next()
+		return
+	}
+	switch context.Request.URL.Path {
+	case path.Join(server.basePath, "health"), path.Join(server.basePath, "ready"):
+		context.Next()
+		return
+	}
+	draw := server.chaos() * 100
+	log := server.Logger.WithFields(logrus.Fields{
+		"rid":    requestID(context),
+		"method": context.Request.Method,
+		"path":   context.Request.URL.Path,
+	})
+	switch {
+	case draw < server.Config.ChaosFailureRate:
+		log.WithField("fault", "failure").Warn("Chaos fault injected")
+		abort(context, http.StatusInternalServerError, ResponseError{
+			Message: "injected fault",
+		})
+		return
+	case draw < server.Config.ChaosFailureRate+server.Config.ChaosDelayRate:
+		delay, err := parseTimeout(server.Config.ChaosDelay, defaults.ChaosDelay)
+		if err != nil {
+			server.Logger.WithField("err", err).Error("Could not parse chaos delay")
+			delay = mustParseTimeout(defaults.ChaosDelay)
+		}
+		log.WithFields(logrus.Fields{"fault": "delay", "delay": delay.String()}).Warn("Chaos fault injected")
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-context.Request.Context().Done():
+		}
+	}
+	context.Next()
+}
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//maxMaintenanceWindow is the longest maintenance window, the start of the current window is searched minute by minute
+const maxMaintenanceWindow = 24 * time.Hour
+
+//cronBounds are the values of the minute, hour, day of month, month and day of week fields, 7 is Sunday as well as 0
+var cronBounds = [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+
+//maintenanceWindow is a recurring period the inventory is read-only in. It starts at the minutes matching the cron
+//fields and lasts duration
+type maintenanceWindow struct {
+	fields     [5][]bool //the values matched by the minute, hour, day of month, month and day of week fields
+	anyDay     bool      //the day of month is *, only the day of week restricts the days
+	anyWeekday bool      //the day of week is *, only the day of month restricts the days
+	duration   time.Duration
+}
+
+//parseMaintenanceWindows parses the MaintenanceWindows, "minute hour day-of-month month day-of-week duration" entries
+//separated by semicolons, in the location of timeZone
+func parseMaintenanceWindows(windows string, timeZone string) ([]maintenanceWindow, *time.Location, error) {
+	location := time.UTC
+	if timeZone != "" {
+		var err error
+		location, err = time.LoadLocation(timeZone)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unknown time zone %q", timeZone)
+		}
+	}
+	var parsed []maintenanceWindow
+	for _, entry := range strings.Split(windows, ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		window, err := parseMaintenanceWindow(entry)
+		if err != nil {
+			return nil, nil, fmt.Errorf("maintenance window %q: %w", strings.TrimSpace(entry), err)
+		}
+		parsed = append(parsed, window)
+	}
+	return parsed, location, nil
+}
+
+//parseMaintenanceWindow parses a window such as "0 2 * * 1-5 90m". The cron fields take *, values, ranges, lists and
+//steps, the window lasts the duration
+func parseMaintenanceWindow(entry string) (maintenanceWindow, error) {
+	var window maintenanceWindow
+	fields := strings.Fields(entry)
+	if len(fields) != len(cronBounds)+1 {
+		return window, fmt.Errorf("%d cron fields and a duration are expected", len(cronBounds))
+	}
+	for i, bounds := range cronBounds {
+		matches, err := parseCronField(fields[i], bounds[0], bounds[1])
+		if err != nil {
+			return window, err
+		}
+		window.fields[i] = matches
+	}
+	window.fields[4][0] = window.fields[4][0] || window.fields[4][7]
+	window.anyDay = fields[2] == "*"
+	window.anyWeekday = fields[4] == "*"
+
+	duration, err := time.ParseDuration(fields[len(cronBounds)])
+	if err != nil {
+		return window, fmt.Errorf("%q is not a duration", fields[len(cronBounds)])
+	}
+	if duration < time.Minute || duration > maxMaintenanceWindow {
+		return window, fmt.Errorf("duration %s must be between 1m and %s", duration, maxMaintenanceWindow)
+	}
+	window.duration = duration
+	return window, nil
+}
+
+//parseCronField returns the values between min and max matched by the comma separated values, ranges and steps
+func parseCronField(field string, min int, max int) ([]bool, error) {
+	matches := make([]bool, max+1)
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		stepped := false
+		if i := strings.IndexByte(part, '/'); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step < 1 {
+				return nil, fmt.Errorf("step %q is not a positive number", part[i+1:])
+			}
+			part, stepped = part[:i], true
+		}
+		from, to := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			from, err = strconv.Atoi(bounds[0])
+			if err != nil {
+				return nil, fmt.Errorf("%q is not a number", bounds[0])
+			}
+			to = from
+			if len(bounds) == 2 {
+				to, err = strconv.Atoi(bounds[1])
+				if err != nil {
+					return nil, fmt.Errorf("%q is not a number", bounds[1])
+				}
+			} else if stepped {
+				to = max //"5/15" runs from 5 to the end of the range
+			}
+		}
+		if from < min || to > max || from > to {
+			return nil, fmt.Errorf("%q is out of the range %d-%d", part, min, max)
+		}
+		for value := from; value <= to; value += step {
+			matches[value] = true
+		}
+	}
+	return matches, nil
+}
+
+//starts checks if the window starts at the minute of t. As in cron, a day matches either of the day of month and the
+//day of week when both are restricted
+func (window maintenanceWindow) starts(t time.Time) bool {
+	day := window.fields[2][t.Day()]
+	weekday := window.fields[4][int(t.Weekday())]
+	dayMatches := day && weekday
+	if !window.anyDay && !window.anyWeekday {
+		dayMatches = day || weekday
+	}
+	return window.fields[0][t.Minute()] && window.fields[1][t.Hour()] && window.fields[3][int(t.Month())] && dayMatches
+}
+
+//end returns the end of the window now is in, ok is false outside of the window. The latest start gives the latest
+//end when the occurrences overlap
+func (window maintenanceWindow) end(now time.Time) (end time.Time, ok bool) {
+	for start := now.Truncate(time.Minute); now.Sub(start) < window.duration; start = start.Add(-time.Minute) {
+		if window.starts(start) {
+			return start.Add(window.duration), true
+		}
+	}
+	return time.Time{}, false
+}
+
+//maintenanceEnd returns the end of the maintenance windows now is in, ok is false outside of all of them
+func (server *Server) maintenanceEnd(now time.Time) (until time.Time, ok bool) {
+	now = now.In(server.maintenanceZone)
+	for _, window := range server.maintenance {
+		if end, inWindow := window.end(now); inWindow && end.After(until) {
+			until, ok = end, true
+		}
+	}
+	return until, ok
+}
+
+//ValidateMaintenanceWindows checks the MaintenanceWindows can be parsed in the MaintenanceTimeZone
+func ValidateMaintenanceWindows(windows string, timeZone string) error {
+	_, _, err := parseMaintenanceWindows(windows, timeZone)
+	return err
+}
+
+//refuseInMaintenance responds 503 to the requests changing the inventory during the MaintenanceWindows, such as the
+//nightly batch imports. The reads are served, the response tells when the window ends
+func (server *Server) refuseInMaintenance(context *gin.Context) {
+	if len(server.maintenance) == 0 || !changesInventory(context, server.basePath) {
+		context.Next()
+		return
+	}
+	until, ok := server.maintenanceEnd(time.Now())
+	if !ok {
+		context.Next()
+		return
+	}
+	until = until.UTC()
+	server.Logger.WithFields(logrus.Fields{"rid": requestID(context), "until": until}).Info("Request refused during maintenance")
+	context.Header("Retry-After", strconv.Itoa(int(math.Ceil(time.Until(until).Seconds()))))
+	abort(context, http.StatusServiceUnavailable, ResponseError{
+		Message:          "the inventory is read-only during maintenance until " + until.Format(time.RFC3339),
+		MaintenanceUntil: &until,
+	})
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"github.com/auknl/warehouse/db"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/trace"
+	"strconv"
+	"time"
+)
+
+//requestDuration is the histogram of the request latencies, the observations of the traced requests carry the trace
+//id as an exemplar
+const requestDuration = "warehouse_http_request_duration_seconds"
+
+//traceIdLabel is the exemplar label of the trace id, the default of the Grafana exemplar links
+const traceIdLabel = "trace_id"
+
+//metrics keeps the registry served by the metrics endpoint and the request latency histogram in it
+type metrics struct {
+	registry *prometheus.Registry
+	duration *prometheus.HistogramVec
+}
+
+//newMetrics registers the request latency histogram and the pool stats of the inventory
+func newMetrics(inventory db.Inventory) *metrics {
+	registry := prometheus.NewRegistry()
+	duration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    requestDuration,
+		Help:    "Time taken to serve the requests.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+	registry.MustRegister(duration, poolCollector{inventory: inventory})
+	return &metrics{registry: registry, duration: duration}
+}
+
+//poolCollector reads the stats of the database connection pool once per scrape
+type poolCollector struct {
+	inventory db.Inventory
+}
+
+//poolMetrics describe the stats reported by poolCollector, in the order it collects them
+var poolMetrics = []*prometheus.Desc{
+	prometheus.NewDesc("warehouse_db_max_open_connections", "Largest number of database connections, 0 is unlimited.", nil, nil),
+	prometheus.NewDesc("warehouse_db_open_connections", "Established database connections, in use and idle.", nil, nil),
+	prometheus.NewDesc("warehouse_db_in_use_connections", "Database connections in use.", nil, nil),
+	prometheus.NewDesc("warehouse_db_idle_connections", "Idle database connections.", nil, nil),
+	prometheus.NewDesc("warehouse_db_wait_count_total", "Requests which waited for a free database connection.", nil, nil),
+}
+
+func (collector poolCollector) Describe(descs chan<- *prometheus.Desc) {
+	for _, desc := range poolMetrics {
+		descs <- desc
+	}
+}
+
+func (collector poolCollector) Collect(collected chan<- prometheus.Metric) {
+	stats := collector.inventory.PoolStats()
+	values := []int64{int64(stats.MaxOpenConnections), int64(stats.OpenConnections), int64(stats.InUse), int64(stats.Idle)}
+	for i, value := range values {
+		collected <- prometheus.MustNewConstMetric(poolMetrics[i], prometheus.GaugeValue, float64(value))
+	}
+	collected <- prometheus.MustNewConstMetric(poolMetrics[len(values)], prometheus.CounterValue, float64(stats.WaitCount))
+}
+
+//observeRequest measures the latency of the request. It runs before trace, the span of the request is read from the
+//request context after the handlers, so a sampled trace can be opened from the latency buckets in Grafana
+func (server *Server) observeRequest(context *gin.Context) {
+	start := time.Now()
+	context.Next()
+
+	observer := server.metrics.duration.WithLabelValues(context.Request.Method, context.FullPath(), strconv.Itoa(context.Writer.Status()))
+	seconds := time.Since(start).Seconds()
+	spanContext := trace.SpanContextFromContext(context.Request.Context())
+	if exemplars, ok := observer.(prometheus.ExemplarObserver); ok && spanContext.IsSampled() {
+		exemplars.ObserveWithExemplar(seconds, prometheus.Labels{traceIdLabel: spanContext.TraceID().String()})
+		return
+	}
+	observer.Observe(seconds)
+}
+
+//getMetrics exposes the request latencies and the state of the database connection pool in the Prometheus text
+//format, or in OpenMetrics with the exemplars when the scraper asks for it
+func (server *Server) getMetrics(context *gin.Context) {
+	log := server.Logger.WithField("rid", requestID(context))
+	log.Debug("getMetrics")
+	promhttp.HandlerFor(server.metrics.registry, promhttp.HandlerOpts{EnableOpenMetrics: true}).ServeHTTP(context.Writer, context.Request)
+	return
+}
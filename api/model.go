@@ -2,5 +2,32 @@ package api
 
 // text constants related to the service endpoints input
 const (
-	productName string = "product_name"
+	productName      string = "product_name"
+	artId            string = "art_id"
+	includeDeleted   string = "include_deleted"
+	cached           string = "cached"
+	names            string = "names"
+	atomic           string = "atomic"
+	dryRun           string = "dry_run"
+	validateArticles string = "validate_articles"
+	continueOnError  string = "continue_on_error"
+	quantity         string = "quantity"
+	force            string = "force"
+	productsFile     string = "file"
+	after            string = "after"
+	since            string = "since"
+	limit            string = "limit"
+	count            string = "n"
+	searchQuery      string = "q"
+	entity           string = "entity"
+	format           string = "format"
+	formatCSV        string = "csv"
+	formatGzip       string = "gzip"
+	ttl              string = "ttl"
+	reservationId    string = "reservation_id"
+	dedup            string = "dedup"
+	dedupLast        string = "last"
+	fields           string = "fields"
+	recipeVersion    string = "version"
+	snapshotFrom     string = "from"
 )
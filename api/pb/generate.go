@@ -0,0 +1,5 @@
+//Package pb has the protobuf messages of the responses served as application/x-protobuf, generated from
+//warehouse.proto with protoc and protoc-gen-go
+package pb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative warehouse.proto
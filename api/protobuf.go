@@ -0,0 +1,53 @@
+package api
+
+import (
+	"github.com/auknl/warehouse/api/pb"
+	"github.com/auknl/warehouse/data"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/protobuf/proto"
+	"mime"
+	"strings"
+)
+
+//protobufMIME is the media type of the protobuf responses, the messages are defined in api/pb/warehouse.proto
+const protobufMIME = "application/x-protobuf"
+
+//acceptsProtobuf checks if the Accept header of the request asks for protobuf, JSON is sent otherwise
+func acceptsProtobuf(context *gin.Context) bool {
+	for _, accepted := range strings.Split(context.GetHeader("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err == nil && mediaType == protobufMIME {
+			return true
+		}
+	}
+	return false
+}
+
+//protobufMessage is the protobuf message of the payload, nil if the payload has no protobuf encoding. Only the
+//inventory and product stock lists have one, the other responses are always JSON
+func protobufMessage(payload interface{}) proto.Message {
+	switch payload := payload.(type) {
+	case ResponseInventory:
+		message := &pb.Inventory{Inventory: make([]*pb.Stock, 0, len(payload.Inventory)), NextCursor: payload.NextCursor}
+		for _, stock := range payload.Inventory {
+			message.Inventory = append(message.Inventory, protobufStock(stock))
+		}
+		return message
+	case ResponseProductStocks:
+		message := &pb.ProductStocks{ProductStocks: make([]*pb.ProductStock, 0, len(payload.ProductStocks)), NotFound: payload.NotFound}
+		for _, stock := range payload.ProductStocks {
+			message.ProductStocks = append(message.ProductStocks, &pb.ProductStock{
+				ProductName:        stock.Name,
+				AvailableProductNo: stock.AvailableProductNo,
+				Deleted:            stock.Deleted,
+			})
+		}
+		return message
+	}
+	return nil
+}
+
+//protobufStock is the protobuf message of the stock
+func protobufStock(stock data.Stock) *pb.Stock {
+	return &pb.Stock{ArtId: stock.ArtId, Name: stock.Name, Stock: string(stock.Stock)}
+}
@@ -1,19 +1,282 @@
 package api
 
-import "github.com/auknl/warehouse/data"
+import (
+	"encoding/json"
+	"github.com/auknl/warehouse/data"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/protobuf/proto"
+	"net/http"
+	"reflect"
+	"time"
+)
+
+// Response is the envelope of every JSON response, either data or error is set
+type Response struct {
+	Data  interface{}    `json:"data,omitempty"`
+	Meta  *ResponseMeta  `json:"meta,omitempty"`
+	Error *ResponseError `json:"error,omitempty"`
+}
+
+// ResponseMeta describes the data of the response, count is the number of listed items
+type ResponseMeta struct {
+	Message    string `json:"message,omitempty"`
+	Count      *int   `json:"count,omitempty"`
+	NextCursor string `json:"next_cursor,omitempty"` //after cursor of the next inventory page, empty on the last one
+}
 
 // ResponseError is the only type of error response any user should ever get
 type ResponseError struct {
-	StatusCode int    `json:"code,omitempty"` //in case new error codes need to be designed
-	Message    string `json:"message,omitempty"`
-	Error      string `json:"errors,omitempty"`
+	StatusCode        int                        `json:"code,omitempty"` //in case new error codes need to be designed
+	Message           string                     `json:"message,omitempty"`
+	Error             string                     `json:"errors,omitempty"`
+	Failures          data.StockAdjustmentErrors `json:"failures,omitempty"`
+	UnknownArticles   []string                   `json:"unknown_articles,omitempty"`
+	BlockingProducts  data.ArticlesInUse         `json:"blocking_products,omitempty"`
+	DuplicateArticles data.DuplicateArticles     `json:"duplicate_articles,omitempty"`
+	ValidationErrors  data.ValidationErrors      `json:"validation_errors,omitempty"`
+	MaintenanceUntil  *time.Time                 `json:"maintenance_until,omitempty"`
+	Cause             string                     `json:"cause,omitempty"` //the category of a failure whose details stay in the logs
 }
 
 // ResponseData is the holder for the actual data in an API response
 type ResponseProduct struct {
-	StatusCode    int                `json:"code,omitempty"` //in case new error codes need to be designed
-	Products      []data.Product     `json:"products,omitempty"`
-	Inventory     []data.Stock       `json:"inventory,omitempty"`
-	ProductStocks data.ProductStocks `json:"product_stocks,omitempty"`
-	Message       string             `json:"message,omitempty"`
+	StatusCode       int                        `json:"code,omitempty"` //in case new error codes need to be designed
+	Products         []data.Product             `json:"products,omitempty"`
+	Inventory        []data.Stock               `json:"inventory,omitempty"`
+	Message          string                     `json:"-"` //sent in the meta of the envelope
+	Failures         data.StockAdjustmentErrors `json:"failures,omitempty"`
+	Stats            *data.Stats                `json:"stats,omitempty"`
+	SalePreview      *data.SalePreview          `json:"sale_preview,omitempty"`
+	Availability     *data.ProductAvailability  `json:"availability,omitempty"`
+	Reservation      *data.Reservation          `json:"reservation,omitempty"`
+	Cart             *data.CartAvailability     `json:"cart,omitempty"`
+	Snapshot         *data.Snapshot             `json:"snapshot,omitempty"`
+	UploadedProducts []string                   `json:"uploaded_products,omitempty"`
+	ProductFailures  data.ProductUploadErrors   `json:"product_failures,omitempty"`
+	NextCursor       string                     `json:"-"` //sent in the meta of the envelope
+	Pool             *ResponsePool              `json:"pool,omitempty"`
+	Schema           *ResponseSchema            `json:"schema,omitempty"`
+}
+
+// ResponseInventory lists the stock of the articles, an empty inventory is an empty list
+type ResponseInventory struct {
+	Inventory  []data.Stock `json:"inventory"`
+	NextCursor string       `json:"-"` //sent in the meta of the envelope
+}
+
+// ResponseProductStocks lists the number of the products that can be built, no product is an empty list
+type ResponseProductStocks struct {
+	ProductStocks data.ProductStocks `json:"product_stocks"`
+	NotFound      []string           `json:"not_found,omitempty"` //requested product names that are not in system
+}
+
+// ResponsePool is the state of the database connection pool reported by the health check
+type ResponsePool struct {
+	MaxOpenConnections int   `json:"max_open_connections"` //0 is unlimited
+	OpenConnections    int   `json:"open_connections"`
+	InUse              int   `json:"in_use"`
+	Idle               int   `json:"idle"`
+	WaitCount          int64 `json:"wait_count"` //requests which waited for a free connection since the start
+}
+
+// ResponseSchema is the migration state of the database reported by the readiness check
+type ResponseSchema struct {
+	Version  int  `json:"version"`  //last migration applied to the database
+	Expected int  `json:"expected"` //last migration of the binary
+	Dirty    bool `json:"dirty"`    //the last migration failed halfway
+}
+
+// ResponseBuildable tells if the requested quantity of a product can be built from the current stock
+type ResponseBuildable struct {
+	Buildable    bool `json:"buildable"`
+	MaxBuildable int  `json:"max_buildable"`
+}
+
+// ResponseVersion is the build info of the running service
+type ResponseVersion struct {
+	Version       string `json:"version"`
+	Environment   string `json:"environment"`
+	GoVersion     string `json:"go_version"`
+	UptimeSeconds int64  `json:"uptime_seconds"`
+}
+
+// ResponseReset tells how many articles and products are removed by the inventory reset
+type ResponseReset struct {
+	RemovedArticles int `json:"removed_articles"`
+	RemovedProducts int `json:"removed_products"`
+}
+
+// ResponseIntegrity is the integrity report of the product and inventory tables
+type ResponseIntegrity struct {
+	Consistent bool `json:"consistent"`
+	data.IntegrityReport
+}
+
+// ResponseAudit is the audit log of the mutations, newest first
+type ResponseAudit struct {
+	Audit []data.AuditEntry `json:"audit"`
+}
+
+// ResponseSales is the number of units sold of each product, the most sold first
+type ResponseSales struct {
+	Sales []data.Sale `json:"sales"`
+}
+
+// ResponseReorder lists the articles to be reordered, ordered by art_id
+type ResponseReorder struct {
+	Reorder []data.Reorder `json:"reorder"`
+}
+
+// ResponseArticleProducts lists the products containing an article, ordered by name
+type ResponseArticleProducts struct {
+	ArticleProducts []data.ArticleProduct `json:"article_products"`
+}
+
+// ResponseStockDiff lists the stock changes of the articles since the snapshot, ordered by art_id
+type ResponseStockDiff struct {
+	Snapshot data.Snapshot     `json:"snapshot"`
+	Deltas   []data.StockDelta `json:"deltas"`
+}
+
+// ResponseNameStocks lists the stock summed by article name, ordered by name
+type ResponseNameStocks struct {
+	NameStocks []data.NameStock `json:"name_stocks"`
+}
+
+// ResponseAllocations lists the units of the products of an order taken from each location, in the order of the lines
+type ResponseAllocations struct {
+	Allocations []data.Allocation `json:"allocations"`
+}
+
+//envelope wraps the payload of a handler in the Response, message and pagination of ResponseProduct and
+//ResponseInventory go to the meta
+func envelope(payload interface{}) Response {
+	switch payload := payload.(type) {
+	case ResponseError:
+		return Response{Error: &payload}
+	case ResponseProduct:
+		meta := &ResponseMeta{Message: payload.Message, NextCursor: payload.NextCursor}
+		switch {
+		case payload.Inventory != nil:
+			meta.Count = itemCount(len(payload.Inventory))
+		case payload.Products != nil:
+			meta.Count = itemCount(len(payload.Products))
+		}
+		payload.Message, payload.NextCursor = "", ""
+		response := Response{Meta: meta}
+		if !reflect.DeepEqual(payload, ResponseProduct{}) { //message only responses have no data
+			response.Data = payload
+		}
+		if *meta == (ResponseMeta{}) {
+			response.Meta = nil
+		}
+		return response
+	case ResponseInventory:
+		if payload.Inventory == nil {
+			payload.Inventory = []data.Stock{}
+		}
+		return Response{Data: payload, Meta: &ResponseMeta{Count: itemCount(len(payload.Inventory)), NextCursor: payload.NextCursor}}
+	case ResponseProductStocks:
+		if payload.ProductStocks == nil {
+			payload.ProductStocks = data.ProductStocks{}
+		}
+		return Response{Data: payload, Meta: &ResponseMeta{Count: itemCount(len(payload.ProductStocks))}}
+	case ResponseAudit:
+		return Response{Data: payload, Meta: &ResponseMeta{Count: itemCount(len(payload.Audit))}}
+	case ResponseSales:
+		return Response{Data: payload, Meta: &ResponseMeta{Count: itemCount(len(payload.Sales))}}
+	case ResponseReorder:
+		if payload.Reorder == nil {
+			payload.Reorder = []data.Reorder{}
+		}
+		return Response{Data: payload, Meta: &ResponseMeta{Count: itemCount(len(payload.Reorder))}}
+	case ResponseArticleProducts:
+		if payload.ArticleProducts == nil {
+			payload.ArticleProducts = []data.ArticleProduct{}
+		}
+		return Response{Data: payload, Meta: &ResponseMeta{Count: itemCount(len(payload.ArticleProducts))}}
+	case ResponseStockDiff:
+		if payload.Deltas == nil {
+			payload.Deltas = []data.StockDelta{}
+		}
+		return Response{Data: payload, Meta: &ResponseMeta{Count: itemCount(len(payload.Deltas))}}
+	case ResponseAllocations:
+		if payload.Allocations == nil {
+			payload.Allocations = []data.Allocation{}
+		}
+		return Response{Data: payload, Meta: &ResponseMeta{Count: itemCount(len(payload.Allocations))}}
+	case ResponseNameStocks:
+		if payload.NameStocks == nil {
+			payload.NameStocks = []data.NameStock{}
+		}
+		return Response{Data: payload, Meta: &ResponseMeta{Count: itemCount(len(payload.NameStocks))}}
+	}
+	return Response{Data: payload}
+}
+
+//itemCount is the pointer of n, a zero count is sent unlike a zero int
+func itemCount(n int) *int {
+	return &n
+}
+
+//respond writes the status and the payload in the response envelope. The payloads having a protobuf message are
+//written as protobuf instead when the client accepts it
+func respond(context *gin.Context, status int, payload interface{}) {
+	if message := protobufMessage(payload); message != nil {
+		context.Writer.Header().Add("Vary", "Accept") //the Origin of CORS is kept
+		if acceptsProtobuf(context) {
+			body, err := proto.Marshal(message)
+			if err != nil {
+				context.JSON(http.StatusInternalServerError, envelope(ResponseError{Message: err.Error()}))
+				return
+			}
+			context.Data(status, protobufMIME, body)
+			return
+		}
+	}
+	context.JSON(status, selectedEnvelope(context, payload))
+}
+
+//selectedEnvelope is the envelope of the payload whose listed objects keep only the fields selected by selectFields
+func selectedEnvelope(context *gin.Context, payload interface{}) Response {
+	response := envelope(payload)
+	if selected, ok := context.Get(fields); ok && response.Data != nil {
+		response.Data = project(response.Data, selected.(map[string]bool))
+	}
+	return response
+}
+
+//project marshals the data into a map whose lists of objects keep only the selected fields. The other values of the
+//data are kept as they are, the data is returned unchanged if it is not a JSON object
+func project(data interface{}, selected map[string]bool) interface{} {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return data
+	}
+	var object map[string]json.RawMessage
+	if json.Unmarshal(raw, &object) != nil {
+		return data
+	}
+	projected := make(map[string]interface{}, len(object))
+	for key, value := range object {
+		var items []map[string]json.RawMessage
+		if json.Unmarshal(value, &items) != nil {
+			projected[key] = value
+			continue
+		}
+		for _, item := range items {
+			for field := range item {
+				if !selected[field] {
+					delete(item, field)
+				}
+			}
+		}
+		projected[key] = items
+	}
+	return projected
+}
+
+//abort writes the status and the payload in the response envelope and stops the handler chain
+func abort(context *gin.Context, status int, payload interface{}) {
+	context.AbortWithStatusJSON(status, envelope(payload))
 }
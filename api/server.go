@@ -1,222 +1,2894 @@
 package api
 
 import (
+	"bytes"
+	"compress/gzip"
+	gocontext "context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/auknl/warehouse/config"
 	"github.com/auknl/warehouse/data"
 	"github.com/auknl/warehouse/db"
+	"github.com/auknl/warehouse/db/events"
 	"github.com/auknl/warehouse/request"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/protobuf/proto"
+	"io"
 	"io/ioutil"
+	"mime"
+	"net"
 	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	goatomic "sync/atomic"
 	"time"
+	"unicode/utf8"
 )
 
+var tracer = otel.Tracer("github.com/auknl/warehouse/api")
+
 // Server serves HTTP requests
 type Server struct {
 	Inventory db.Inventory
+	changes   *events.Broker //stock changes published by Inventory, streamed by inventory/stream
 	router    *gin.Engine
 	Config    Configuration
 	Logger    *logrus.Entry
+	basePath  string         //route prefix the routes are served under
+	startedAt time.Time      //reported as uptime by the version endpoint
+	ready     int32          //1 while the server takes traffic, set to 0 on shutdown so readiness fails
+	inFlight  chan struct{}  //one element per request being served, nil if MaxConcurrentRequests is not set
+	metrics   *metrics       //served by the metrics endpoint
+	chaos     func() float64 //draws the faults injected into the requests, nil unless ChaosEnabled is set
+	//the inventory is read-only during the MaintenanceWindows, in the MaintenanceTimeZone
+	maintenance     []maintenanceWindow
+	maintenanceZone *time.Location
+}
+
+//warehouseHeader names the warehouse location a request is scoped to
+const warehouseHeader = "X-Warehouse-Id"
+
+//traceparentHeader is the W3C trace context header, the trace id in it is used as the request id
+const traceparentHeader = "traceparent"
+
+//bearerPrefix is the scheme of the Authorization header carrying the api key
+const bearerPrefix = "Bearer "
+
+//scopes of the APIKeys, read allows the GET requests and write the requests changing the inventory
+const (
+	scopeRead  = "read"
+	scopeWrite = "write"
+)
+
+//serverTimeHeader carries the time of the inventory response, it is sent back as since on the next poll
+const serverTimeHeader = "X-Server-Time"
+
+//checksumTrailer carries the hex sha256 of the uncompressed inventory export
+const checksumTrailer = "X-Content-SHA256"
+
+// Configuration keeps required info for running server. The fields left empty get the default of config.Configuration
+type Configuration struct {
+	BackendTimeout          string
+	ListenAddress           string
+	RoutePrefix             string                   //"/" serves the routes without prefix
+	ReadTimeout             string                   //reading the whole request, headers included
+	WriteTimeout            string                   //has to be longer than BackendTimeout
+	IdleTimeout             string                   //keep-alive connections waiting for the next request
+	ResponseTimeout         string                   //handlers running longer get 503, whatever they wait for. Not applied to the streamed responses
+	ShutdownGracePeriod     string                   //readiness fails this long before the in-flight requests are drained on shutdown
+	ShutdownTimeout         string                   //draining the in-flight requests, the connections still open then are closed
+	SlowRequestThreshold    string                   //requests taking longer are logged at warn level
+	MaxConcurrentRequests   int                      //requests served at once, the others wait up to LoadShedWait then get 503. Unlimited if 0
+	LoadShedWait            string                   //a request waits this long for one of the MaxConcurrentRequests to finish
+	LogFailedBodies         bool                     //bodies of the requests answered with 4xx are logged at debug level
+	LogBodyLimit            int                      //bytes of the logged bodies, the rest is cut
+	LogBodyRedactFields     []string                 //JSON fields whose values are logged as [REDACTED]
+	RedactBody              func(body []byte) []byte //replaces the sensitive parts of the logged bodies, the LogBodyRedactFields if nil
+	StreamHeartbeat         string                   //comment sent on idle inventory streams so proxies keep them open
+	MaxUploadSize           int64                    //bytes, limits the products file of product/upload
+	DefaultPageSize         int                      //limit of the paged lists when it is not given
+	MaxPageSize             int                      //larger limits are lowered to it
+	MaxArticlesPerProduct   int                      //products with more articles are refused
+	MaxProductsPerUpload    int                      //uploads with more products are refused
+	ImportTimeout           string                   //downloading the inventory of inventory/import, redirects included
+	ImportMaxSize           int64                    //bytes, larger inventories of inventory/import are refused
+	ImportAllowedHosts      []string                 //"host" or "host:port" inventory/import can fetch from, nothing can be imported if empty
+	ImportAllowedSchemes    []string
+	MaxPoolInUse            int      //health fails when this many database connections are in use, so a hung database gets no traffic. Never if 0
+	AllowReset              bool     //DELETE inventory removes everything, only for test environments
+	CaseInsensitiveRoutes   bool     //paths differing from a route only in case, e.g. "Inventory", are redirected to it
+	H2C                     bool     //HTTP/2 is served without TLS besides HTTP/1.1, for the proxies speaking h2c to the service
+	APIKeys                 []string //"key:read+write" bearer tokens of the routes other than health and version, no auth if empty
+	CorsAllowedOrigins      []string //origins of the browser clients, "*" allows any. CORS requests are refused if empty
+	CorsAllowedMethods      []string
+	CorsAllowedHeaders      []string
+	RequestIDHeaders        []string //headers the request id is read from, the first one given is used
+	RequestIDResponseHeader string   //header the request id is returned in
+	ChaosEnabled            bool     //faults are injected into the requests for resilience tests, never in production
+	ChaosFailureRate        float64  //percent of the requests failed with 500 when ChaosEnabled is set
+	ChaosDelayRate          float64  //percent of the requests delayed by ChaosDelay when ChaosEnabled is set
+	ChaosDelay              string
+	MaintenanceWindows      string //"minute hour day month weekday duration" windows separated by semicolons, the inventory is read-only in them
+	MaintenanceTimeZone     string
+	FulfillmentStrategy     string //order the locations of an order are drawn from when the order does not tell, nearest or most-stock-first
+	Version                 string //release reported by the version endpoint
+	Environment             string
+}
+
+//defaults is used for the fields of the Configuration which are not configured
+var defaults = configurationOf(config.Defaults())
+
+//NewConfiguration builds the Configuration of the server from the loaded config, checking the api keys and the
+//maintenance windows. All the problems are listed in the error
+func NewConfiguration(loaded config.Configuration) (Configuration, error) {
+	var problems []string
+	if err := ValidateAPIKeys(loaded.APIKeys); err != nil {
+		problems = append(problems, "ISC_APIKEYS "+err.Error())
+	}
+	if err := ValidateMaintenanceWindows(loaded.MaintenanceWindows, loaded.MaintenanceTimeZone); err != nil {
+		problems = append(problems, "ISC_MAINTENANCEWINDOWS "+err.Error())
+	}
+	if len(problems) != 0 {
+		return Configuration{}, errors.New(strings.Join(problems, ", "))
+	}
+	return configurationOf(loaded), nil
+}
+
+//configurationOf copies the fields of the loaded config the server uses
+func configurationOf(loaded config.Configuration) Configuration {
+	return Configuration{
+		ListenAddress:           loaded.ListenAddress,
+		BackendTimeout:          loaded.BackendTimeout,
+		RoutePrefix:             loaded.RoutePrefix,
+		ReadTimeout:             loaded.ReadTimeout,
+		WriteTimeout:            loaded.WriteTimeout,
+		IdleTimeout:             loaded.IdleTimeout,
+		ResponseTimeout:         loaded.ResponseTimeout,
+		ShutdownGracePeriod:     loaded.ShutdownGracePeriod,
+		ShutdownTimeout:         loaded.ShutdownTimeout,
+		SlowRequestThreshold:    loaded.SlowRequestThreshold,
+		MaxConcurrentRequests:   loaded.MaxConcurrentRequests,
+		LoadShedWait:            loaded.LoadShedWait,
+		LogFailedBodies:         loaded.LogFailedBodies,
+		LogBodyLimit:            loaded.LogBodyLimit,
+		LogBodyRedactFields:     loaded.LogBodyRedactFields,
+		StreamHeartbeat:         loaded.StreamHeartbeat,
+		MaxUploadSize:           loaded.MaxUploadSize,
+		DefaultPageSize:         loaded.DefaultPageSize,
+		MaxPageSize:             loaded.MaxPageSize,
+		MaxPoolInUse:            loaded.MaxPoolInUse,
+		MaxArticlesPerProduct:   loaded.MaxArticlesPerProduct,
+		MaxProductsPerUpload:    loaded.MaxProductsPerUpload,
+		ImportTimeout:           loaded.ImportTimeout,
+		ImportMaxSize:           loaded.ImportMaxSize,
+		ImportAllowedHosts:      loaded.ImportAllowedHosts,
+		ImportAllowedSchemes:    loaded.ImportAllowedSchemes,
+		AllowReset:              loaded.AllowReset,
+		CaseInsensitiveRoutes:   loaded.CaseInsensitiveRoutes,
+		H2C:                     loaded.H2C,
+		APIKeys:                 loaded.APIKeys,
+		CorsAllowedOrigins:      loaded.CorsAllowedOrigins,
+		CorsAllowedMethods:      loaded.CorsAllowedMethods,
+		CorsAllowedHeaders:      loaded.CorsAllowedHeaders,
+		RequestIDHeaders:        loaded.RequestIDHeaders,
+		RequestIDResponseHeader: loaded.RequestIDResponseHeader,
+		ChaosEnabled:            loaded.ChaosEnabled,
+		ChaosFailureRate:        loaded.ChaosFailureRate,
+		ChaosDelayRate:          loaded.ChaosDelayRate,
+		ChaosDelay:              loaded.ChaosDelay,
+		MaintenanceWindows:      loaded.MaintenanceWindows,
+		MaintenanceTimeZone:     loaded.MaintenanceTimeZone,
+		FulfillmentStrategy:     loaded.FulfillmentStrategy,
+		Version:                 loaded.Version,
+		Environment:             loaded.Environment,
+	}
+}
+
+//redacted replaces the values of the LogBodyRedactFields in the logged bodies
+const redacted = "[REDACTED]"
+
+//loadShedRetryAfter is the Retry-After of the requests refused by shedLoad, in seconds
+const loadShedRetryAfter = "1"
+
+//unhealthyRetryAfter is the Retry-After of the failed health checks, in seconds
+const unhealthyRetryAfter = "5"
+
+//the causes of a failed database check reported by the health and readiness checks, the error itself is only logged
+const (
+	causeConnectionLost = "connection_lost"
+	causeTimeout        = "timeout"
+	causeDatabaseError  = "database_error"
+)
+
+//defaultReservationTTL is how long a reservation holds the units when the request has no ttl
+const defaultReservationTTL = 15 * time.Minute
+
+//maxImportRedirects is the number of redirects inventory/import follows
+const maxImportRedirects = 5
+
+//defaultSearchLimit is the number of articles searchArticles returns when no limit is given
+const defaultSearchLimit = 20
+
+//defaultLowStockCount is the number of articles inventory/low returns when n is not given
+const defaultLowStockCount = 10
+
+// NewServer creates a new HTTP server and set up routing.
+func NewServer(inventory db.Inventory, configuration Configuration, logger *logrus.Entry) *Server {
+	changes := events.NewBroker()
+	server := &Server{Inventory: events.NewPublishingInventory(inventory, changes), changes: changes, startedAt: time.Now(), ready: 1}
+	server.metrics = newMetrics(server.Inventory)
+	router := gin.New()
+
+	if configuration.MaxConcurrentRequests > 0 {
+		server.inFlight = make(chan struct{}, configuration.MaxConcurrentRequests)
+	}
+	if configuration.ChaosEnabled {
+		logger.WithFields(logrus.Fields{"failure_rate": configuration.ChaosFailureRate, "delay_rate": configuration.ChaosDelayRate}).Warn("Chaos faults are injected into the requests")
+		server.chaos = newChaos()
+	}
+	maintenance, maintenanceZone, err := parseMaintenanceWindows(configuration.MaintenanceWindows, configuration.MaintenanceTimeZone)
+	if err != nil {
+		logger.WithField("err", err).Error("Could not parse maintenance windows")
+	}
+	server.maintenance, server.maintenanceZone = maintenance, maintenanceZone
+	router.Use(
+		server.setRID,
+		server.observeRequest,
+		server.recoverPanic,
+		server.shedLoad,
+		server.injectFault,
+		server.logFailedBody,
+		server.cors,
+		server.logSlowRequest,
+		server.trace,
+		server.setDeadline,
+		server.setLocation,
+	)
+
+	prefix := configuration.RoutePrefix
+	if prefix == "" {
+		prefix = defaults.RoutePrefix
+	}
+	routes := router.Group(prefix)
+	routes.GET("health", server.isHealthy)
+	routes.GET("ready", server.isReady)
+	routes.GET("version", server.getVersion)
+	routes.GET("metrics", server.getMetrics)
+	//health, ready, version and metrics stay public, the other routes require one of the APIKeys when they are configured
+	private := routes.Group("", server.authenticate, server.refuseInMaintenance)
+	//the upload routes are registered on these groups, so a body of another media type is refused before it is read
+	jsonUploads := private.Group("", server.acceptContentTypes(gin.MIMEJSON))
+	//the listed objects of the routes on these groups can be limited to the given fields
+	stockFields := private.Group("", server.selectFields(stockFieldNames...))
+	productFields := private.Group("", server.selectFields("product_name", "available_product_no", "deleted"))
+	stockFields.GET("inventory", server.getInventory)
+	private.GET("inventory/:"+artId, server.getInventoryItem)
+	productFields.GET("product", server.getProductStock)
+	private.GET("stats", server.getStats)
+	private.GET("integrity", server.checkIntegrity)
+	private.GET("audit", server.getAuditLog)
+	private.GET("sales", server.getSales)
+	jsonUploads.POST("product", server.uploadProducts)
+	jsonUploads.POST("inventory", server.uploadInventory)
+	jsonUploads.POST("catalog", server.uploadCatalog)
+	jsonUploads.POST("inventory/import", server.importInventory)
+	jsonUploads.PATCH("inventory", server.adjustInventory)
+	jsonUploads.POST("inventory/delete", server.deleteArticles)
+	jsonUploads.POST("availability", server.checkCart)
+	jsonUploads.POST("order", server.fulfillOrder)
+	jsonUploads.POST("inventory/snapshot", server.createSnapshot)
+	private.DELETE("inventory", server.resetInventory)
+	jsonUploads.PATCH("inventory/article/:"+artId, server.adjustArticle)
+	jsonUploads.PATCH("inventory/article/:"+artId+"/reorder", server.setReorderLevels)
+	private.GET("inventory/:"+artId+"/products", server.getArticleProducts)
+	private.POST("product/:"+productName, server.postProduct)
+	jsonUploads.PATCH("product/:"+productName, server.renameProduct)
+	private.DELETE("product/:"+productName, server.deleteProduct)
+	private.POST("product/:"+productName+"/restore", server.restoreProduct)
+	private.POST("product/:"+productName+"/return", server.returnProduct)
+	private.POST("product/:"+productName+"/reserve", server.reserveProduct)
+	private.DELETE("reservation/:"+reservationId, server.releaseReservation)
+	productFields.GET("product/:"+productName, server.getProduct)
+	private.GET("product/:"+productName+"/buildable", server.isProductBuildable)
+	private.GET("product/:"+productName+"/articles", server.getProductArticles)
+	private.GET("product/:"+productName+"/availability", server.getProductAvailability)
+
+	//the trailing slash redirect of gin misses the routes having sibling parameters, such as inventory/
+	router.NoRoute(server.redirectRoute)
+
+	server.router = router
+	server.basePath = routes.BasePath()
+	server.Config = configuration
+	server.Logger = logger
+	return server
+}
+
+// Start runs the HTTP server on a specific address until stop is closed, then shuts it down gracefully.
+func (server *Server) Start(stop <-chan struct{}) error {
+	httpServer, err := server.httpServer()
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", httpServer.Addr)
+	if err != nil {
+		return err
+	}
+	return server.serve(httpServer, listener, stop)
+}
+
+//serve serves httpServer on listener until stop is closed. Readiness fails first so the load balancer stops routing,
+//after the ShutdownGracePeriod the listener is closed and the in-flight requests are drained within the ShutdownTimeout
+func (server *Server) serve(httpServer *http.Server, listener net.Listener, stop <-chan struct{}) error {
+	gracePeriod, err := parseTimeout(server.Config.ShutdownGracePeriod, defaults.ShutdownGracePeriod)
+	if err != nil {
+		return fmt.Errorf("invalid shutdown grace period: %w", err)
+	}
+	drainTimeout, err := parseTimeout(server.Config.ShutdownTimeout, defaults.ShutdownTimeout)
+	if err != nil {
+		return fmt.Errorf("invalid shutdown timeout: %w", err)
+	}
+
+	served := make(chan error, 1)
+	go func() {
+		served <- httpServer.Serve(listener)
+	}()
+	select {
+	case err := <-served:
+		return err
+	case <-stop:
+	}
+
+	goatomic.StoreInt32(&server.ready, 0)
+	server.Logger.WithField("grace_period", gracePeriod.String()).Info("shutting down, readiness fails")
+	time.Sleep(gracePeriod)
+	server.Logger.Info("draining in-flight requests")
+	ctx, cancel := gocontext.WithTimeout(gocontext.Background(), drainTimeout)
+	defer cancel()
+	err = httpServer.Shutdown(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot drain in-flight requests: %w", err)
+	}
+	return nil
+}
+
+//httpServer builds the http.Server of the router with the configured timeouts
+func (server *Server) httpServer() (*http.Server, error) {
+	readTimeout, err := parseTimeout(server.Config.ReadTimeout, defaults.ReadTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid read timeout: %w", err)
+	}
+	writeTimeout, err := parseTimeout(server.Config.WriteTimeout, defaults.WriteTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid write timeout: %w", err)
+	}
+	idleTimeout, err := parseTimeout(server.Config.IdleTimeout, defaults.IdleTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid idle timeout: %w", err)
+	}
+	responseTimeout, err := parseTimeout(server.Config.ResponseTimeout, defaults.ResponseTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid response timeout: %w", err)
+	}
+
+	handler := server.limitResponseTime(server.router, responseTimeout)
+	if server.Config.H2C {
+		//the HTTP/1.1 requests pass through, the upgraded and prior knowledge ones are served as HTTP/2
+		handler = h2c.NewHandler(handler, &http2.Server{IdleTimeout: idleTimeout})
+	}
+	return &http.Server{
+		Addr:         server.Config.ListenAddress,
+		Handler:      handler,
+		ReadTimeout:  readTimeout,
+		WriteTimeout: writeTimeout,
+		IdleTimeout:  idleTimeout,
+	}, nil
+}
+
+//limitResponseTime responds 503 with a JSON error when the handler does not respond within timeout, its request
+//context is cancelled then. The inventory stream and export are not limited, they are written while they are read
+func (server *Server) limitResponseTime(handler http.Handler, timeout time.Duration) http.Handler {
+	body, _ := json.Marshal(envelope(ResponseError{
+		Message: "the request took too long",
+	}))
+	limited := http.TimeoutHandler(handler, timeout, string(body))
+	streamed := map[string]bool{
+		path.Join(server.basePath, "inventory/stream"): true,
+		path.Join(server.basePath, "inventory/export"): true,
+	}
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if streamed[request.URL.Path] {
+			handler.ServeHTTP(writer, request)
+			return
+		}
+		limited.ServeHTTP(timeoutWriter{writer}, request)
+	})
+}
+
+//timeoutWriter sets the JSON content type of the 503 response of http.TimeoutHandler, the responses of the handlers
+//keep their own
+type timeoutWriter struct {
+	http.ResponseWriter
+}
+
+func (writer timeoutWriter) WriteHeader(status int) {
+	if status == http.StatusServiceUnavailable && writer.Header().Get("Content-Type") == "" {
+		writer.Header().Set("Content-Type", gin.MIMEJSON+"; charset=utf-8")
+	}
+	writer.ResponseWriter.WriteHeader(status)
+}
+
+//parseTimeout parses the duration of a timeout, fallback is used if it is not set
+func parseTimeout(timeout string, fallback string) (time.Duration, error) {
+	if timeout == "" {
+		timeout = fallback
+	}
+	return time.ParseDuration(timeout)
+}
+
+//mustParseTimeout parses one of the defaults, they are checked by the config tests
+func mustParseTimeout(timeout string) time.Duration {
+	duration, err := time.ParseDuration(timeout)
+	if err != nil {
+		panic(err)
+	}
+	return duration
+}
+
+//redirectRoute redirects the paths with a trailing slash, "inventory/", and with CaseInsensitiveRoutes the paths in
+//another case, "Inventory", to their route. GET requests get 301 and the others 307, so they are sent again with their
+//body. Other paths are not found
+func (server *Server) redirectRoute(context *gin.Context) {
+	route := server.routeOf(context.Request.Method, context.Request.URL.Path)
+	if route == "" || route == context.Request.URL.Path {
+		return
+	}
+	status := http.StatusTemporaryRedirect
+	if context.Request.Method == http.MethodGet {
+		status = http.StatusMovedPermanently
+	}
+	redirect := *context.Request.URL
+	redirect.Path, redirect.RawPath = route, ""
+	context.Redirect(status, redirect.String())
+}
+
+//routeOf is the path of the route of the method requestPath is meant for, empty if there is none. The parameters are
+//kept as they are requested
+func (server *Server) routeOf(method string, requestPath string) string {
+	segments := strings.Split(strings.Trim(requestPath, "/"), "/")
+	for _, route := range server.router.Routes() {
+		routeSegments := strings.Split(strings.Trim(route.Path, "/"), "/")
+		if route.Method != method || len(routeSegments) != len(segments) {
+			continue
+		}
+		matched := make([]string, 0, len(segments))
+		for i, segment := range routeSegments {
+			switch {
+			case strings.HasPrefix(segment, ":"):
+				matched = append(matched, segments[i])
+			case segment == segments[i], server.Config.CaseInsensitiveRoutes && strings.EqualFold(segment, segments[i]):
+				matched = append(matched, segment)
+			}
+		}
+		if len(matched) == len(segments) {
+			return "/" + strings.Join(matched, "/")
+		}
+	}
+	return ""
+}
+
+//logFailedBody logs the start of the request body at debug level when the request is answered with 4xx, so the refused
+//payloads can be seen. Only the first LogBodyLimit bytes the handler reads are kept, they are redacted by RedactBody
+func (server *Server) logFailedBody(context *gin.Context) {
+	if !server.Config.LogFailedBodies || context.Request.Body == nil || !server.Logger.Logger.IsLevelEnabled(logrus.DebugLevel) {
+		context.Next()
+		return
+	}
+	limit := server.Config.LogBodyLimit
+	if limit <= 0 {
+		limit = defaults.LogBodyLimit
+	}
+	body := &bodySnippet{ReadCloser: context.Request.Body, limit: limit}
+	context.Request.Body = body
+	context.Next()
+
+	status := context.Writer.Status()
+	if status < http.StatusBadRequest || status >= http.StatusInternalServerError || body.read == 0 {
+		return
+	}
+	redact := server.Config.RedactBody
+	if redact == nil {
+		redact = redactFields(server.Config.LogBodyRedactFields)
+	}
+	server.Logger.WithFields(logrus.Fields{
+		"rid":       requestID(context),
+		"method":    context.Request.Method,
+		"path":      context.Request.URL.Path,
+		"status":    status,
+		"body":      string(redact(body.kept)),
+		"body_read": body.read,
+		"truncated": body.read > int64(len(body.kept)),
+	}).Debug("Request refused")
+}
+
+//bodySnippet keeps the first limit bytes read from the request body
+type bodySnippet struct {
+	io.ReadCloser
+	limit int
+	kept  []byte
+	read  int64 //bytes read by the handler
+}
+
+func (body *bodySnippet) Read(p []byte) (int, error) {
+	n, err := body.ReadCloser.Read(p)
+	if keep := body.limit - len(body.kept); keep > 0 {
+		if n < keep {
+			keep = n
+		}
+		body.kept = append(body.kept, p[:keep]...)
+	}
+	body.read += int64(n)
+	return n, err
+}
+
+//redactFields returns the redaction of the values of the JSON fields, a cut body is redacted as well
+func redactFields(fields []string) func(body []byte) []byte {
+	var patterns []*regexp.Regexp
+	for _, field := range fields {
+		field = strings.TrimSpace(field)
+		if field != "" {
+			patterns = append(patterns, regexp.MustCompile(`("`+regexp.QuoteMeta(field)+`"\s*:\s*)("(?:[^"\\]|\\.)*"?|[^,}\]\s]+)`))
+		}
+	}
+	return func(body []byte) []byte {
+		for _, pattern := range patterns {
+			body = pattern.ReplaceAll(body, []byte(`${1}"`+redacted+`"`))
+		}
+		return body
+	}
+}
+
+//recoverPanic logs the panics of the handlers with the request id and responds with a generic 500, the stack is only logged
+func (server *Server) recoverPanic(context *gin.Context) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			server.Logger.WithFields(logrus.Fields{
+				"rid":   requestID(context),
+				"panic": recovered,
+				"stack": string(debug.Stack()),
+			}).Error("Recovered from panic")
+			abort(context, http.StatusInternalServerError, ResponseError{
+				Message: "internal server error",
+			})
+		}
+	}()
+	context.Next()
+}
+
+//logSlowRequest logs the requests taking longer than the slow request threshold, others are not logged
+func (server *Server) logSlowRequest(context *gin.Context) {
+	start := time.Now()
+	context.Next()
+	duration := time.Since(start)
+
+	threshold, err := parseTimeout(server.Config.SlowRequestThreshold, defaults.SlowRequestThreshold)
+	if err != nil {
+		server.Logger.WithField("err", err).Error("Could not parse slow request threshold")
+		threshold = mustParseTimeout(defaults.SlowRequestThreshold)
+	}
+	if duration <= threshold {
+		return
+	}
+	server.Logger.WithFields(logrus.Fields{
+		"rid":      requestID(context),
+		"method":   context.Request.Method,
+		"path":     context.Request.URL.Path,
+		"status":   context.Writer.Status(),
+		"duration": duration.String(),
+	}).Warn("Slow request")
+}
+
+//shedLoad lets MaxConcurrentRequests requests be served at once. A request waits up to LoadShedWait for one of them
+//to finish, then it is refused with 503 and Retry-After. Health and readiness are always served, so an overloaded
+//instance is not restarted
+func (server *Server) shedLoad(context *gin.Context) {
+	if server.inFlight == nil {
+		context.Next()
+		return
+	}
+	switch context.Request.URL.Path {
+	case path.Join(server.basePath, "health"), path.Join(server.basePath, "ready"):
+		context.Next()
+		return
+	}
+	select {
+	case server.inFlight <- struct{}{}:
+	default:
+		wait, err := parseTimeout(server.Config.LoadShedWait, defaults.LoadShedWait)
+		if err != nil {
+			server.Logger.WithField("err", err).Error("Could not parse load shed wait")
+			wait = mustParseTimeout(defaults.LoadShedWait)
+		}
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case server.inFlight <- struct{}{}:
+		case <-timer.C:
+			server.Logger.WithFields(logrus.Fields{
+				"rid":    requestID(context),
+				"method": context.Request.Method,
+				"path":   context.Request.URL.Path,
+			}).Warn("Request shed, too many requests in flight")
+			context.Header("Retry-After", loadShedRetryAfter)
+			abort(context, http.StatusServiceUnavailable, ResponseError{
+				Message: "the service is overloaded, try again later",
+			})
+			return
+		}
+	}
+	defer func() { <-server.inFlight }()
+	context.Next()
+}
+
+//trace starts the server span of the request, continuing the trace of the caller if its context is in the headers.
+//Handlers pass the request context to db.Inventory so the db spans are children of this span
+func (server *Server) trace(context *gin.Context) {
+	ctx := otel.GetTextMapPropagator().Extract(context.Request.Context(), propagation.HeaderCarrier(context.Request.Header))
+	ctx, span := tracer.Start(ctx, context.Request.Method+" "+context.FullPath(),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.HTTPMethod(context.Request.Method),
+			semconv.HTTPRoute(context.FullPath()),
+			attribute.String("rid", requestID(context)),
+		))
+	defer span.End()
+
+	context.Request = context.Request.WithContext(ctx)
+	context.Next()
+
+	status := context.Writer.Status()
+	span.SetAttributes(semconv.HTTPStatusCode(status))
+	if status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+}
+
+//setRID sets the request id of the request, the one of the first RequestIDHeaders given is used. The id is kept in
+//the request context for request.GetRID and in the gin context as rid, and returned in the RequestIDResponseHeader
+func (server *Server) setRID(context *gin.Context) {
+	rid := context.GetString("rid")
+	if rid == "" && context.Request != nil {
+		rid = server.headerRID(context)
+	}
+	if rid == "" {
+		rid = uuid.New().String()
+	}
+
+	context.Set("rid", rid)
+	responseHeader := server.Config.RequestIDResponseHeader
+	if responseHeader == "" {
+		responseHeader = defaults.RequestIDResponseHeader
+	}
+	context.Header(responseHeader, rid)
+	if context.Request != nil {
+		context.Request = context.Request.WithContext(request.WithRID(context.Request.Context(), rid))
+	}
+	context.Next()
+}
+
+//headerRID is the request id of the first RequestIDHeaders the request has, X-Request-Id if none is configured. A
+//traceparent header gives its trace id
+func (server *Server) headerRID(context *gin.Context) string {
+	headers := server.Config.RequestIDHeaders
+	if len(headers) == 0 {
+		headers = defaults.RequestIDHeaders
+	}
+	for _, header := range headers {
+		rid := strings.TrimSpace(context.GetHeader(header))
+		if strings.EqualFold(header, traceparentHeader) {
+			//version-traceid-parentid-flags
+			fields := strings.Split(rid, "-")
+			rid = ""
+			if len(fields) == 4 && len(fields[1]) == 32 {
+				rid = fields[1]
+			}
+		}
+		if rid != "" {
+			return rid
+		}
+	}
+	return ""
+}
+
+//requestID returns the request id set by setRID, a new one if the request did not pass it
+func requestID(context *gin.Context) string {
+	if rid := context.GetString("rid"); rid != "" {
+		return rid
+	}
+	return uuid.New().String()
+}
+
+//setDeadline limits the process time of the request by the backend timeout. The deadline is set on the request
+//context, so the db calls made with it are cancelled as well
+func (server *Server) setDeadline(context *gin.Context) {
+	backendTimeout, err := parseTimeout(server.Config.BackendTimeout, defaults.BackendTimeout)
+	if err != nil {
+		server.Logger.WithField("err", err).Error("Could not parse backend timeout duration")
+		backendTimeout = mustParseTimeout(defaults.BackendTimeout)
+	}
+
+	ctx, cancel := gocontext.WithDeadline(context.Request.Context(), time.Now().Add(backendTimeout))
+	defer cancel()
+	context.Request = context.Request.WithContext(ctx)
+	context.Next()
+}
+
+//setLocation scopes the request to the warehouse location named in the X-Warehouse-Id header. Requests without
+//the header use request.DefaultLocation
+func (server *Server) setLocation(context *gin.Context) {
+	location := strings.TrimSpace(context.GetHeader(warehouseHeader))
+	if location != "" {
+		context.Request = context.Request.WithContext(request.WithLocation(context.Request.Context(), location))
+	}
+	context.Next()
+}
+
+//cors sets the Access-Control-Allow-* headers for the requests of the allowed origins and answers their preflight
+//requests. Preflight requests of other origins are refused, their other requests are served without the headers
+//so the browser does not pass the response to the caller
+func (server *Server) cors(context *gin.Context) {
+	origin := context.GetHeader("Origin")
+	if origin == "" {
+		context.Next()
+		return
+	}
+	preflight := context.Request.Method == http.MethodOptions && context.GetHeader("Access-Control-Request-Method") != ""
+	if !server.isAllowedOrigin(origin) {
+		if preflight {
+			server.Logger.WithFields(logrus.Fields{"rid": requestID(context), "origin": origin}).Info("CORS preflight of a not allowed origin")
+			context.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		context.Next()
+		return
+	}
+
+	context.Header("Access-Control-Allow-Origin", origin)
+	context.Writer.Header().Add("Vary", "Origin")
+	if !preflight {
+		context.Next()
+		return
+	}
+	methods, headers := server.Config.CorsAllowedMethods, server.Config.CorsAllowedHeaders
+	if len(methods) == 0 {
+		methods = defaults.CorsAllowedMethods
+	}
+	if len(headers) == 0 {
+		headers = defaults.CorsAllowedHeaders
+	}
+	context.Header("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	context.Header("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+	context.AbortWithStatus(http.StatusNoContent)
+}
+
+//authenticate responds 401 to the requests without an "Authorization: Bearer <key>" header of one of the APIKeys,
+//and 403 if the key does not have the scope of the request. Requests are not authenticated if no key is configured
+func (server *Server) authenticate(context *gin.Context) {
+	if len(server.Config.APIKeys) == 0 {
+		context.Next()
+		return
+	}
+	token := ""
+	if header := context.GetHeader("Authorization"); len(header) > len(bearerPrefix) && strings.EqualFold(header[:len(bearerPrefix)], bearerPrefix) {
+		token = strings.TrimSpace(header[len(bearerPrefix):])
+	}
+	scopes, found := server.apiKeyScopes(token)
+	if token == "" || !found {
+		server.Logger.WithField("rid", requestID(context)).Info("Request without a valid api key")
+		context.Header("WWW-Authenticate", "Bearer")
+		abort(context, http.StatusUnauthorized, ResponseError{
+			Message: "a valid api key is required",
+		})
+		return
+	}
+
+	//reads only need the read scope
+	required := scopeRead
+	if changesInventory(context, server.basePath) {
+		required = scopeWrite
+	}
+	for _, scope := range scopes {
+		if scope == required {
+			context.Next()
+			return
+		}
+	}
+	server.Logger.WithFields(logrus.Fields{"rid": requestID(context), "scope": required}).Info("Request with an api key missing the scope")
+	abort(context, http.StatusForbidden, ResponseError{
+		Message: fmt.Sprintf("api key does not have the %s scope", required),
+	})
+}
+
+//changesInventory checks if the request changes the inventory, all requests but the reads do. The cart check is a read
+//sent as POST
+func changesInventory(context *gin.Context, basePath string) bool {
+	switch context.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return context.FullPath() != path.Join(basePath, "availability")
+}
+
+//apiKeyScopes returns the scopes of the APIKeys entry of the token, found is false if the token is not one of the
+//keys. The hashes are compared in constant time, so neither the length nor the content of the keys can be guessed
+//from the response time
+func (server *Server) apiKeyScopes(token string) (scopes []string, found bool) {
+	tokenHash := sha256.Sum256([]byte(token))
+	for _, entry := range server.Config.APIKeys {
+		key, keyScopes := parseAPIKey(entry)
+		keyHash := sha256.Sum256([]byte(key))
+		if subtle.ConstantTimeCompare(tokenHash[:], keyHash[:]) == 1 && !found {
+			scopes, found = keyScopes, true
+		}
+	}
+	return scopes, found
+}
+
+//parseAPIKey splits an APIKeys entry of "key:scope+scope" form into the key and its scopes, a key without scopes has
+//all of them
+func parseAPIKey(entry string) (string, []string) {
+	entry = strings.TrimSpace(entry)
+	i := strings.LastIndexByte(entry, ':')
+	if i < 0 {
+		return entry, []string{scopeRead, scopeWrite}
+	}
+	return entry[:i], strings.Split(entry[i+1:], "+")
+}
+
+//ValidateAPIKeys checks the APIKeys entries have a key and only the read and write scopes
+func ValidateAPIKeys(entries []string) error {
+	for _, entry := range entries {
+		key, scopes := parseAPIKey(entry)
+		if key == "" {
+			return fmt.Errorf("api key %q is empty", entry)
+		}
+		for _, scope := range scopes {
+			if scope != scopeRead && scope != scopeWrite {
+				return fmt.Errorf("api key scope %q is not supported, use %s or %s", scope, scopeRead, scopeWrite)
+			}
+		}
+	}
+	return nil
+}
+
+//acceptContentTypes returns the middleware responding 415 to the requests whose body is not one of the media types.
+//Requests without a body are let through
+func (server *Server) acceptContentTypes(mediaTypes ...string) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		if server.acceptsContentType(context, mediaTypes...) {
+			context.Next()
+		}
+	}
+}
+
+//acceptsContentType checks the body of the request is one of the media types or there is no body, otherwise the
+//request is aborted with 415
+func (server *Server) acceptsContentType(context *gin.Context, mediaTypes ...string) bool {
+	if context.Request.ContentLength == 0 {
+		return true
+	}
+	contentType := context.ContentType()
+	for _, mediaType := range mediaTypes {
+		if strings.EqualFold(contentType, mediaType) {
+			return true
+		}
+	}
+	server.Logger.WithFields(logrus.Fields{"rid": requestID(context), "content_type": contentType}).Info("Upload of an unsupported content type")
+	abort(context, http.StatusUnsupportedMediaType, ResponseError{
+		Message: fmt.Sprintf("content type %q is not supported, use %s", contentType, strings.Join(mediaTypes, " or ")),
+	})
+	return false
+}
+
+//stockFieldNames are the fields of the listed stocks that can be selected
+var stockFieldNames = []string{"art_id", "name", "stock"}
+
+//selectFields returns the middleware reading the comma separated fields query parameter, the objects listed in the
+//response keep only these fields then. Names other than the known ones are refused with 400
+func (server *Server) selectFields(known ...string) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		if server.selectsFields(context, known...) {
+			context.Next()
+		}
+	}
+}
+
+//selectsFields reads the fields query parameter of the request like selectFields, the request is aborted with 400
+//when a name is not one of the known ones
+func (server *Server) selectsFields(context *gin.Context, known ...string) bool {
+	isKnown := make(map[string]bool, len(known))
+	for _, name := range known {
+		isKnown[name] = true
+	}
+	selected := make(map[string]bool)
+	for _, name := range strings.Split(context.Query(fields), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !isKnown[name] {
+			abort(context, http.StatusBadRequest, ResponseError{
+				Message: fmt.Sprintf("unknown field %q, fields can be %s", name, strings.Join(known, ", ")),
+			})
+			return false
+		}
+		selected[name] = true
+	}
+	if len(selected) > 0 {
+		context.Set(fields, selected)
+	}
+	return true
+}
+
+//isAllowedOrigin checks if the origin is one of the CorsAllowedOrigins
+func (server *Server) isAllowedOrigin(origin string) bool {
+	for _, allowed := range server.Config.CorsAllowedOrigins {
+		if allowed == "*" || strings.EqualFold(strings.TrimSpace(allowed), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+//pageLimit reads the limit query parameter of the paged lists, fallback is used when it is not given. The limit is
+//clamped to [1, MaxPageSize], only a limit that is not a number is an error
+func (server *Server) pageLimit(context *gin.Context, fallback int) (int, error) {
+	return server.queryLimit(context, limit, fallback)
+}
+
+//queryLimit reads the number of records to return from the name query parameter like pageLimit
+func (server *Server) queryLimit(context *gin.Context, name string, fallback int) (int, error) {
+	pageLimit := fallback
+	if text, ok := context.GetQuery(name); ok {
+		parsed, err := strconv.Atoi(strings.TrimSpace(text))
+		if err != nil {
+			return 0, fmt.Errorf("%s %q is not a number", name, text)
+		}
+		pageLimit = parsed
+	}
+
+	if pageLimit < 1 {
+		pageLimit = 1
+	}
+	if max := server.maxLimit(); pageLimit > max {
+		pageLimit = max
+	}
+	return pageLimit, nil
 }
 
-// Configuration keeps required info for running server
-type Configuration struct {
-	BackendTimeout string `default:"25s"`
-	ListenAddress  string `default:":8080"`
+//defaultLimit is the configured DefaultPageSize, the default one if it is not set
+func (server *Server) defaultLimit() int {
+	if server.Config.DefaultPageSize <= 0 {
+		return defaults.DefaultPageSize
+	}
+	return server.Config.DefaultPageSize
+}
+
+//maxLimit is the configured MaxPageSize, the default one if it is not set
+func (server *Server) maxLimit() int {
+	if server.Config.MaxPageSize <= 0 {
+		return defaults.MaxPageSize
+	}
+	return server.Config.MaxPageSize
+}
+
+//checkUploadSize refuses the uploads having more products than MaxProductsPerUpload, or a product having more
+//articles than MaxArticlesPerProduct
+func (server *Server) checkUploadSize(products data.Products) error {
+	maxProducts, maxArticles := server.Config.MaxProductsPerUpload, server.Config.MaxArticlesPerProduct
+	if maxProducts <= 0 {
+		maxProducts = defaults.MaxProductsPerUpload
+	}
+	if maxArticles <= 0 {
+		maxArticles = defaults.MaxArticlesPerProduct
+	}
+	if len(products.Products) > maxProducts {
+		return fmt.Errorf("upload has %d products, at most %d are allowed", len(products.Products), maxProducts)
+	}
+	for _, product := range products.Products {
+		if len(product.ContainArticles) > maxArticles {
+			return fmt.Errorf("product %q has %d articles, at most %d are allowed", product.Name, len(product.ContainArticles), maxArticles)
+		}
+	}
+	return nil
+}
+
+//isHealthy checks if the service is available to respond
+func (server *Server) isHealthy(context *gin.Context) {
+	log := server.Logger.WithField("rid", requestID(context))
+	log.Debug("isHealthy")
+	err := server.Inventory.Ping()
+	if err != nil {
+		log.WithField("err", err.Error()).Error("IsHealthy ping failed")
+		context.Header("Retry-After", unhealthyRetryAfter)
+		respond(context, http.StatusServiceUnavailable, ResponseError{
+			Message: "unhealthy endpoint",
+			Cause:   pingFailureCause(err),
+		})
+		return
+	}
+	stats := server.Inventory.PoolStats()
+	pool := &ResponsePool{
+		MaxOpenConnections: stats.MaxOpenConnections,
+		OpenConnections:    stats.OpenConnections,
+		InUse:              stats.InUse,
+		Idle:               stats.Idle,
+		WaitCount:          stats.WaitCount,
+	}
+	if server.Config.MaxPoolInUse > 0 && stats.InUse >= server.Config.MaxPoolInUse {
+		log.WithField("in_use", stats.InUse).Error("IsHealthy pool saturated")
+		respond(context, http.StatusServiceUnavailable, ResponseError{
+			Message: "database connection pool is saturated",
+			Error:   fmt.Sprintf("%d connections are in use, at most %d are allowed", stats.InUse, server.Config.MaxPoolInUse),
+		})
+		return
+	}
+	respond(context, http.StatusOK, ResponseProduct{
+		Message: "healthy endpoint",
+		Pool:    pool,
+	})
+	return
+}
+
+//isReady tells the load balancer whether to route requests here, it fails once the server is shutting down and while
+//the database schema is not the one of the binary, so a new binary does not serve against an old schema
+func (server *Server) isReady(context *gin.Context) {
+	log := server.Logger.WithField("rid", requestID(context))
+	log.Debug("isReady")
+	if goatomic.LoadInt32(&server.ready) == 0 {
+		respond(context, http.StatusServiceUnavailable, ResponseError{
+			Message: "service is shutting down",
+		})
+		return
+	}
+	version, dirty, err := server.Inventory.GetSchemaVersion(context.Request.Context())
+	if err != nil {
+		log.WithField("err", err).Error("isReady cannot read the schema version")
+		respond(context, http.StatusServiceUnavailable, ResponseError{
+			Message: "database schema version cannot be read",
+			Cause:   pingFailureCause(err),
+		})
+		return
+	}
+	if dirty || version != db.SchemaVersion {
+		message := "database schema has pending migrations"
+		if dirty {
+			message = "database schema is dirty, the last migration failed"
+		} else if version > db.SchemaVersion {
+			message = "database schema is newer than the service"
+		}
+		log.WithFields(logrus.Fields{"version": version, "dirty": dirty}).Warn("isReady schema mismatch")
+		respond(context, http.StatusServiceUnavailable, ResponseError{
+			Message: message,
+			Error:   fmt.Sprintf("schema version is %d, %d is expected", version, db.SchemaVersion),
+		})
+		return
+	}
+	respond(context, http.StatusOK, ResponseProduct{
+		Message: "ready endpoint",
+		Schema:  &ResponseSchema{Version: version, Expected: db.SchemaVersion, Dirty: dirty},
+	})
+	return
+}
+
+//getVersion reports what is deployed, the release and environment are the ones the service is configured with
+func (server *Server) getVersion(context *gin.Context) {
+	log := server.Logger.WithField("rid", requestID(context))
+	log.Debug("getVersion")
+	respond(context, http.StatusOK, ResponseVersion{
+		Version:       server.Config.Version,
+		Environment:   server.Config.Environment,
+		GoVersion:     runtime.Version(),
+		UptimeSeconds: int64(time.Since(server.startedAt).Seconds()),
+	})
+	return
+}
+
+//getInventory provides inventory/stock info
+func (server *Server) getInventory(context *gin.Context) {
+	log := server.Logger.WithField("rid", requestID(context))
+	log.Debug("getInventory")
+	var response ResponseInventory
+	//taken before the query, so the changes made while it runs are returned again on the next poll
+	context.Header(serverTimeHeader, time.Now().UTC().Format(time.RFC3339Nano))
+	_, hasAfter := context.GetQuery(after)
+	_, hasLimit := context.GetQuery(limit)
+	if value, hasSince := context.GetQuery(since); hasSince { //incremental sync, only the changed articles are returned
+		changedSince, err := time.Parse(time.RFC3339, value)
+		if err == nil && (hasAfter || hasLimit) {
+			err = errors.New("since cannot be combined with after or limit")
+		}
+		if err != nil {
+			respond(context, http.StatusBadRequest, ResponseError{
+				Message: err.Error(),
+			})
+			return
+		}
+		stocks, err := server.Inventory.GetInventorySince(context.Request.Context(), changedSince.UTC())
+		if err != nil {
+			respond(context, errorStatus(err, http.StatusNotFound), ResponseError{
+				Message: err.Error(),
+			})
+			return
+		}
+		response = ResponseInventory{
+			Inventory: stocks,
+		}
+	} else if hasAfter || hasLimit { //keyset pagination, the whole inventory is returned otherwise
+		pageSize, err := server.pageLimit(context, server.defaultLimit())
+		if err != nil {
+			respond(context, http.StatusBadRequest, ResponseError{
+				Message: err.Error(),
+			})
+			return
+		}
+		stocks, next, err := server.Inventory.GetInventoryPage(context.Request.Context(), context.Query(after), pageSize)
+		if err != nil {
+			respond(context, errorStatus(err, http.StatusNotFound), ResponseError{
+				Message: err.Error(),
+			})
+			return
+		}
+		response = ResponseInventory{
+			Inventory:  stocks,
+			NextCursor: next,
+		}
+	} else {
+		err, stocks := server.Inventory.GetInventory(context.Request.Context())
+		if err != nil {
+			respond(context, errorStatus(err, http.StatusNotFound), ResponseError{
+				Message: err.Error(),
+			})
+			return
+		}
+		response = ResponseInventory{
+			Inventory: stocks,
+		}
+	}
+	contentType := "application/json; charset=utf-8"
+	var body []byte
+	var err error
+	context.Writer.Header().Add("Vary", "Accept") //the Origin of CORS is kept
+	if acceptsProtobuf(context) {
+		contentType = protobufMIME
+		body, err = proto.Marshal(protobufMessage(response))
+	} else {
+		body, err = json.Marshal(selectedEnvelope(context, response))
+	}
+	if err != nil {
+		respond(context, http.StatusInternalServerError, ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+	//clients polling the inventory get 304 until the content changes
+	etag := fmt.Sprintf(`W/"%x"`, sha256.Sum256(body))
+	context.Header("ETag", etag)
+	if etagMatches(context.GetHeader("If-None-Match"), etag) {
+		context.Status(http.StatusNotModified)
+		return
+	}
+	context.Data(http.StatusOK, contentType, body)
+	return
+}
+
+//streamInventory sends the stock changes of the location as server-sent events until the client disconnects or the
+//backend timeout is reached, EventSource clients connect again by themselves. A comment is sent when there is no
+//change for StreamHeartbeat
+func (server *Server) streamInventory(context *gin.Context) {
+	log := server.Logger.WithField("rid", requestID(context))
+	log.Debug("streamInventory")
+	heartbeat, err := parseTimeout(server.Config.StreamHeartbeat, defaults.StreamHeartbeat)
+	if err != nil {
+		log.WithField("err", err).Error("Could not parse stream heartbeat duration")
+		heartbeat = mustParseTimeout(defaults.StreamHeartbeat)
+	}
+	changes, unsubscribe := server.changes.Subscribe()
+	defer unsubscribe()
+	location := request.LocationFromContext(context.Request.Context())
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+
+	context.Header("Content-Type", "text/event-stream")
+	context.Header("Cache-Control", "no-cache")
+	context.Header("X-Accel-Buffering", "no") //nginx would buffer the events otherwise
+	context.Status(http.StatusOK)
+	context.Writer.Flush()
+	for {
+		select {
+		case <-context.Request.Context().Done():
+			log.Debug("streamInventory, stream closed")
+			return
+		case change := <-changes:
+			if change.Location != location {
+				continue
+			}
+			context.SSEvent("stock", change)
+		case <-ticker.C:
+			_, err = io.WriteString(context.Writer, ": heartbeat\n\n")
+			if err != nil {
+				log.WithField("err", err).Debug("streamInventory, client is gone")
+				return
+			}
+		}
+		context.Writer.Flush()
+	}
+}
+
+//validate responds 422 with the invalid fields of the payload, it returns false in that case
+func validate(context *gin.Context, payload interface{ Validate() error }) bool {
+	err := payload.Validate()
+	if err == nil {
+		return true
+	}
+	var invalid data.ValidationErrors
+	errors.As(err, &invalid)
+	respond(context, http.StatusUnprocessableEntity, ResponseError{
+		Message:          err.Error(),
+		ValidationErrors: invalid,
+	})
+	return false
+}
+
+//jsonSnippetSize is the number of bytes shown on each side of a JSON syntax error
+const jsonSnippetSize = 20
+
+//unmarshalJSON decodes the JSON body into payload. The syntax errors are reported with the line and column of the
+//offending character and the text around it, json.Unmarshal only gives its byte offset
+func unmarshalJSON(body []byte, payload interface{}) error {
+	err := json.Unmarshal(body, payload)
+	var syntaxErr *json.SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		return err
+	}
+	//the offset counts the bytes read, the offending one included
+	offset := int(syntaxErr.Offset) - 1
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > len(body) {
+		offset = len(body)
+	}
+	lineStart := bytes.LastIndexByte(body[:offset], '\n') + 1
+	line := bytes.Count(body[:lineStart], []byte("\n")) + 1
+	column := utf8.RuneCount(body[lineStart:offset]) + 1
+	lineEnd := len(body)
+	if next := bytes.IndexByte(body[offset:], '\n'); next >= 0 {
+		lineEnd = offset + next
+	}
+	from, to := offset-jsonSnippetSize, offset+jsonSnippetSize
+	if from < lineStart {
+		from = lineStart
+	}
+	if to > lineEnd {
+		to = lineEnd
+	}
+	return fmt.Errorf("invalid JSON at line %d, column %d: %s, near %q", line, column, syntaxErr.Error(), strings.TrimSpace(string(body[from:to])))
+}
+
+//errorStatus maps the errors of db.Inventory to the response status, fallback is used for the other errors
+func errorStatus(err error, fallback int) int {
+	switch {
+	case errors.Is(err, db.ErrProductNotFound), errors.Is(err, db.ErrArticleNotFound), errors.Is(err, db.ErrReservationNotFound),
+		errors.Is(err, db.ErrSnapshotNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, db.ErrOutOfStock), errors.Is(err, db.ErrNotEnoughStock), errors.Is(err, db.ErrProductExists), errors.Is(err, db.ErrSnapshotExists):
+		return http.StatusConflict
+	case errors.Is(err, db.ErrVersionMismatch):
+		return http.StatusPreconditionFailed
+	case db.IsConnectionLost(err):
+		return http.StatusServiceUnavailable //the client can send it again once the database is back
+	}
+	return fallback
+}
+
+//pingFailureCause returns the category of a failed database ping or schema read. The error of the driver may name
+//hosts, users or tables, the probes only get its category
+func pingFailureCause(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, gocontext.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return causeTimeout
+	case db.IsConnectionLost(err):
+		return causeConnectionLost
+	}
+	return causeDatabaseError
+}
+
+//etagMatches checks if the If-None-Match header contains the etag, weak comparison is used as in RFC 7232
+func etagMatches(ifNoneMatch string, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+//exportInventory streams the inventory/stock info as a csv file
+func (server *Server) exportInventory(context *gin.Context) {
+	log := server.Logger.WithField("rid", requestID(context))
+	log.Debug("exportInventory")
+	compressed := false
+	switch context.Query(format) {
+	case "", formatCSV:
+	case formatGzip:
+		compressed = true
+	default:
+		respond(context, http.StatusBadRequest, ResponseError{
+			Message: fmt.Sprintf("format %q is not supported, use %s or %s", context.Query(format), formatCSV, formatGzip),
+		})
+		return
+	}
+
+	//the checksum is computed over the uncompressed csv and sent as a trailer once the whole body is written
+	checksum := sha256.New()
+	var body io.Writer = context.Writer
+	var compressor *gzip.Writer
+	if compressed {
+		compressor = gzip.NewWriter(context.Writer)
+		body = compressor
+	}
+	writer := csv.NewWriter(io.MultiWriter(checksum, body))
+	started := false
+	start := func() error {
+		//headers are only sent once the first row is read, so a failing query can still be reported as json
+		started = true
+		if compressed {
+			context.Header("Content-Type", "application/gzip")
+			context.Header("Content-Disposition", `attachment; filename="inventory.csv.gz"`)
+		} else {
+			context.Header("Content-Type", "text/csv")
+			context.Header("Content-Disposition", `attachment; filename="inventory.csv"`)
+		}
+		context.Header("Trailer", checksumTrailer)
+		context.Status(http.StatusOK)
+		return writer.Write([]string{"art_id", "name", "stock"})
+	}
+
+	err := server.Inventory.StreamInventory(context.Request.Context(), func(stock data.Stock) error {
+		if !started {
+			err := start()
+			if err != nil {
+				return err
+			}
+		}
+		return writer.Write([]string{stock.ArtId, stock.Name, string(stock.Stock)})
+	})
+	if err != nil && !started {
+		respond(context, errorStatus(err, http.StatusNotFound), ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		//the trailer is left out, so the client can tell the download is incomplete
+		log.WithField("err", err).Error("exportInventory, streaming stopped")
+		return
+	}
+	if !started {
+		err = start()
+	}
+	writer.Flush()
+	if err == nil {
+		err = writer.Error()
+	}
+	if err == nil && compressor != nil {
+		err = compressor.Close()
+	}
+	if err != nil {
+		log.WithField("err", err).Error("exportInventory, writing csv failed")
+		return
+	}
+	context.Writer.Header().Set(checksumTrailer, hex.EncodeToString(checksum.Sum(nil)))
+	return
+}
+
+//searchArticles finds the articles whose name contains the q parameter
+func (server *Server) searchArticles(context *gin.Context) {
+	log := server.Logger.WithField("rid", requestID(context))
+	log.Debug("searchArticles")
+	query := strings.TrimSpace(context.Query(searchQuery))
+	if query == "" {
+		respond(context, http.StatusBadRequest, ResponseError{
+			Message: "q is required",
+		})
+		return
+	}
+	searchLimit, err := server.pageLimit(context, defaultSearchLimit)
+	if err != nil {
+		respond(context, http.StatusBadRequest, ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	stocks, err := server.Inventory.SearchArticles(context.Request.Context(), query, searchLimit)
+	if err != nil {
+		respond(context, errorStatus(err, http.StatusNotFound), ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+	respond(context, http.StatusOK, ResponseInventory{
+		Inventory: stocks,
+	})
+	return
+}
+
+//getLowestStock returns the n articles with the smallest stock, lowest first, to prioritize restocking
+func (server *Server) getLowestStock(context *gin.Context) {
+	log := server.Logger.WithField("rid", requestID(context))
+	log.Debug("getLowestStock")
+	n, err := server.queryLimit(context, count, defaultLowStockCount)
+	if err != nil {
+		respond(context, http.StatusBadRequest, ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	stocks, err := server.Inventory.GetLowestStock(context.Request.Context(), n)
+	if err != nil {
+		respond(context, errorStatus(err, http.StatusNotFound), ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+	respond(context, http.StatusOK, ResponseInventory{
+		Inventory: stocks,
+	})
+	return
+}
+
+//getStockByName returns the stock summed by article name, for the articles whose ids are SKUs of the same product
+func (server *Server) getStockByName(context *gin.Context) {
+	log := server.Logger.WithField("rid", requestID(context))
+	log.Debug("getStockByName")
+	stocks, err := server.Inventory.GetStockByName(context.Request.Context())
+	if err != nil {
+		respond(context, errorStatus(err, http.StatusInternalServerError), ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+	respond(context, http.StatusOK, ResponseNameStocks{
+		NameStocks: stocks,
+	})
+	return
+}
+
+//getReorderArticles returns the articles whose stock has fallen to their reorder point or below, with the quantity
+//to reorder them up to their reorder level
+func (server *Server) getReorderArticles(context *gin.Context) {
+	log := server.Logger.WithField("rid", requestID(context))
+	log.Debug("getReorderArticles")
+	reorders, err := server.Inventory.GetReorderArticles(context.Request.Context())
+	if err != nil {
+		respond(context, errorStatus(err, http.StatusInternalServerError), ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+	respond(context, http.StatusOK, ResponseReorder{
+		Reorder: reorders,
+	})
+	return
+}
+
+//checkCart tells if the products of the cart are available to promise line by line and all together, without
+//reserving or changing anything
+func (server *Server) checkCart(context *gin.Context) {
+	log := server.Logger.WithField("rid", requestID(context))
+	log.Debug("checkCart")
+	var cart data.Cart
+	jsonData, err := ioutil.ReadAll(context.Request.Body)
+	if err == nil {
+		err = unmarshalJSON(jsonData, &cart)
+	}
+	if err != nil {
+		respond(context, http.StatusBadRequest, ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+	if !validate(context, cart) {
+		return
+	}
+
+	availability, err := server.Inventory.CheckCart(context.Request.Context(), cart)
+	if err != nil {
+		respond(context, errorStatus(err, http.StatusInternalServerError), ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+	respond(context, http.StatusOK, ResponseProduct{
+		Cart: &availability,
+	})
+	return
+}
+
+//fulfillOrder sells the lines of the order from several locations, drawn from by the strategy of the order or the
+//FulfillmentStrategy, and returns how many units of each product are taken from each location
+func (server *Server) fulfillOrder(context *gin.Context) {
+	log := server.Logger.WithField("rid", requestID(context))
+	log.Debug("fulfillOrder")
+	var order data.Order
+	jsonData, err := ioutil.ReadAll(context.Request.Body)
+	if err == nil {
+		err = unmarshalJSON(jsonData, &order)
+	}
+	if err != nil {
+		respond(context, http.StatusBadRequest, ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+	if !validate(context, order) {
+		return
+	}
+	if order.Strategy == "" {
+		order.Strategy = server.Config.FulfillmentStrategy
+	}
+
+	allocations, err := server.Inventory.PlanFulfillment(context.Request.Context(), order)
+	if err != nil {
+		respond(context, errorStatus(err, http.StatusInternalServerError), ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+	respond(context, http.StatusOK, ResponseAllocations{
+		Allocations: allocations,
+	})
+	return
+}
+
+//getArticleProducts lists the products containing the article, how many of them can be built and how many more each
+//unit of the article added to the stock builds
+func (server *Server) getArticleProducts(context *gin.Context) {
+	log := server.Logger.WithField("rid", requestID(context))
+	log.Debug("getArticleProducts")
+	products, err := server.Inventory.GetArticleProducts(context.Request.Context(), context.Param(artId))
+	if err != nil {
+		respond(context, errorStatus(err, http.StatusInternalServerError), ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+	respond(context, http.StatusOK, ResponseArticleProducts{
+		ArticleProducts: products,
+	})
+	return
+}
+
+// getProductStock provides the stock info of available products in system
+func (server *Server) getProductStock(context *gin.Context) {
+	log := server.Logger.WithField("rid", requestID(context))
+	log.Debug("getProductStock")
+	if _, ok := context.GetQuery(names); ok {
+		server.getProductsByNames(context)
+		return
+	}
+	var stocks data.ProductStocks
+	var err error
+	if context.Query(cached) == "true" { //read from the cache of the last refresh, the aggregate is not computed
+		stocks, err = server.Inventory.GetCachedProductStock(context.Request.Context(), context.Query(includeDeleted) == "true")
+	} else {
+		err, stocks = server.Inventory.GetProductStock(context.Request.Context(), context.Query(includeDeleted) == "true")
+	}
+	if err != nil {
+		respond(context, errorStatus(err, http.StatusNotFound), ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+	respond(context, http.StatusOK, ResponseProductStocks{
+		ProductStocks: stocks,
+	})
+	return
+
+}
+
+//getProductsByNames handles the batch get of the products named in the comma separated names query, names that are
+//not in system are listed in not_found
+func (server *Server) getProductsByNames(context *gin.Context) {
+	log := server.Logger.WithField("rid", requestID(context))
+	log.Debug("getProductsByNames")
+	var requested []string
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(context.Query(names), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		requested = append(requested, name)
+	}
+	if len(requested) == 0 {
+		respond(context, http.StatusBadRequest, ResponseError{
+			Message: "names must not be empty",
+		})
+		return
+	}
+	if max := server.maxLimit(); len(requested) > max {
+		respond(context, http.StatusBadRequest, ResponseError{
+			Message: fmt.Sprintf("%d names are requested, at most %d are allowed", len(requested), max),
+		})
+		return
+	}
+
+	stocks, err := server.Inventory.GetProductsByNames(context.Request.Context(), requested)
+	if err != nil {
+		respond(context, errorStatus(err, http.StatusInternalServerError), ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+	found := make(map[string]bool)
+	for _, stock := range stocks {
+		found[stock.Name] = true
+	}
+	var notFound []string
+	for _, name := range requested {
+		if !found[name] {
+			notFound = append(notFound, name)
+		}
+	}
+	respond(context, http.StatusOK, ResponseProductStocks{
+		ProductStocks: stocks,
+		NotFound:      notFound,
+	})
+	return
+}
+
+//getProduct serves GET product/all, gin cannot have the static route next to the product/:product_name routes
+func (server *Server) getProduct(context *gin.Context) {
+	if context.Param(productName) == "all" {
+		server.getAllProducts(context)
+		return
+	}
+	respond(context, http.StatusNotFound, ResponseError{
+		Message: "page not found",
+	})
+}
+
+//getAllProducts provides every product in system with the number of it can be built, zero when it is not in stock
+func (server *Server) getAllProducts(context *gin.Context) {
+	log := server.Logger.WithField("rid", requestID(context))
+	log.Debug("getAllProducts")
+	stocks, err := server.Inventory.GetAllProducts(context.Request.Context())
+	if err != nil {
+		respond(context, errorStatus(err, http.StatusInternalServerError), ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+	respond(context, http.StatusOK, ResponseProductStocks{
+		ProductStocks: stocks,
+	})
+	return
+}
+
+//getStats provides the aggregate info of the warehouse
+func (server *Server) getStats(context *gin.Context) {
+	log := server.Logger.WithField("rid", requestID(context))
+	log.Debug("getStats")
+	stats, err := server.Inventory.GetStats(context.Request.Context())
+	if err != nil {
+		respond(context, errorStatus(err, http.StatusNotFound), ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	respond(context, http.StatusOK, ResponseProduct{
+		Stats: &stats,
+	})
+	return
+}
+
+//checkIntegrity reports the inconsistencies between the product and inventory tables for audits
+func (server *Server) checkIntegrity(context *gin.Context) {
+	log := server.Logger.WithField("rid", requestID(context))
+	log.Debug("checkIntegrity")
+	report, err := server.Inventory.CheckIntegrity(context.Request.Context())
+	if err != nil {
+		respond(context, errorStatus(err, http.StatusInternalServerError), ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+	if !report.Consistent() {
+		log.WithField("report", report).Warn("Inventory is inconsistent")
+	}
+
+	respond(context, http.StatusOK, ResponseIntegrity{
+		Consistent:      report.Consistent(),
+		IntegrityReport: report,
+	})
+	return
+}
+
+//getAuditLog provides the latest audit entries, only the ones of a product or article if entity is given
+func (server *Server) getAuditLog(context *gin.Context) {
+	log := server.Logger.WithField("rid", requestID(context))
+	log.Debug("getAuditLog")
+	auditLimit, err := server.pageLimit(context, server.defaultLimit())
+	if err != nil {
+		respond(context, http.StatusBadRequest, ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	entries, err := server.Inventory.GetAuditLog(context.Request.Context(), strings.TrimSpace(context.Query(entity)), auditLimit)
+	if err != nil {
+		respond(context, errorStatus(err, http.StatusInternalServerError), ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+	respond(context, http.StatusOK, ResponseAudit{
+		Audit: entries,
+	})
+	return
+}
+
+//getSales provides how many units of each product were sold in the location, since the given time when it is given
+func (server *Server) getSales(context *gin.Context) {
+	log := server.Logger.WithField("rid", requestID(context))
+	log.Debug("getSales")
+	var soldSince time.Time
+	if value, hasSince := context.GetQuery(since); hasSince {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			respond(context, http.StatusBadRequest, ResponseError{
+				Message: err.Error(),
+			})
+			return
+		}
+		soldSince = parsed.UTC()
+	}
+
+	sales, err := server.Inventory.GetSales(context.Request.Context(), soldSince)
+	if err != nil {
+		respond(context, errorStatus(err, http.StatusInternalServerError), ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+	respond(context, http.StatusOK, ResponseSales{
+		Sales: sales,
+	})
+	return
+}
+
+//uploadProducts inserts given products to system, the Location of the product is set when a single product is uploaded
+func (server *Server) uploadProducts(context *gin.Context) {
+	log := server.Logger.WithField("rid", requestID(context))
+	log.Debug("uploadProducts")
+	var products data.Products
+	jsonData, err := ioutil.ReadAll(context.Request.Body)
+	if err != nil {
+		respond(context, http.StatusBadRequest, ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	err = unmarshalJSON(jsonData, &products)
+	if err != nil {
+		respond(context, http.StatusBadRequest, ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+	server.storeProducts(context, products)
+	return
+}
+
+//storeProducts uploads the products parsed by uploadProducts or uploadProductsFile and responds with the result
+func (server *Server) storeProducts(context *gin.Context, products data.Products) {
+	err := server.checkUploadSize(products)
+	if err != nil {
+		respond(context, http.StatusBadRequest, ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+	if !validate(context, products) {
+		return
+	}
+	if context.Query(validateArticles) == "true" {
+		unknown, err := server.Inventory.UnknownArticles(context.Request.Context(), products.ArtIds())
+		if err != nil {
+			respond(context, errorStatus(err, http.StatusBadRequest), ResponseError{
+				Message: err.Error(),
+			})
+			return
+		}
+		if len(unknown) > 0 {
+			respond(context, http.StatusBadRequest, ResponseError{
+				Message:         "products refer to articles that are not in inventory",
+				UnknownArticles: unknown,
+			})
+			return
+		}
+	}
+
+	err, insertedRecord := server.Inventory.UploadProducts(context.Request.Context(), products, context.Query(continueOnError) == "true")
+	var failures data.ProductUploadErrors
+	if errors.As(err, &failures) {
+		// products are uploaded partially, report which ones are inserted and which ones failed
+		failed := make(map[string]bool)
+		for _, failure := range failures {
+			failed[failure.Name] = true
+		}
+		var uploaded []string
+		for _, product := range products.Products {
+			if !failed[product.Name] {
+				uploaded = append(uploaded, product.Name)
+			}
+		}
+		respond(context, http.StatusMultiStatus, ResponseProduct{
+			Message:          fmt.Sprintf("%d product inserted", insertedRecord),
+			UploadedProducts: uploaded,
+			ProductFailures:  failures,
+		})
+		return
+	}
+	if errors.Is(err, db.ErrDuplicateProductArticle) {
+		respond(context, http.StatusConflict, ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		respond(context, errorStatus(err, http.StatusBadRequest), ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+	if len(products.Products) == 1 {
+		context.Header("Location", path.Join(server.basePath, "product", url.PathEscape(products.Products[0].Name)))
+	}
+	message := fmt.Sprintf("%d product inserted", insertedRecord)
+	respond(context, http.StatusCreated, ResponseProduct{
+		Message: message,
+	})
+	return
+}
+
+//bodyTooLarge is the message of the read error of http.MaxBytesReader once its limit is exceeded
+const bodyTooLarge = "http: request body too large"
+
+//isBodyTooLarge checks if err is the read error of a body over the limit of http.MaxBytesReader. The error has no
+//exported type before Go 1.19, only its message tells it apart
+func isBodyTooLarge(err error) bool {
+	return err != nil && strings.Contains(err.Error(), bodyTooLarge)
+}
+
+//uploadProductsFile uploads the products of a JSON or CSV file posted as multipart form. CSV files have a header
+//line and product_name,art_id,amount_of lines, the lines of a product are grouped in the order they are read
+func (server *Server) uploadProductsFile(context *gin.Context) {
+	log := server.Logger.WithField("rid", requestID(context))
+	log.Debug("uploadProductsFile")
+	maxUploadSize := server.Config.MaxUploadSize
+	if maxUploadSize <= 0 {
+		maxUploadSize = defaults.MaxUploadSize
+	}
+	context.Request.Body = http.MaxBytesReader(context.Writer, context.Request.Body, maxUploadSize)
+	header, err := context.FormFile(productsFile)
+	if err != nil {
+		status := http.StatusBadRequest
+		if isBodyTooLarge(err) {
+			status = http.StatusRequestEntityTooLarge
+			err = fmt.Errorf("products file is larger than %d bytes", maxUploadSize)
+		}
+		respond(context, status, ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+	file, err := header.Open()
+	if err != nil {
+		log.WithField("err", err).Error("Could not open the uploaded products file")
+		respond(context, http.StatusBadRequest, ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+	defer file.Close()
+
+	var products data.Products
+	if strings.EqualFold(path.Ext(header.Filename), ".csv") {
+		products, err = readProductsCSV(file)
+	} else {
+		err = json.NewDecoder(file).Decode(&products)
+	}
+	if err != nil {
+		respond(context, http.StatusBadRequest, ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+	server.storeProducts(context, products)
+	return
+}
+
+//readProductsCSV reads the products of a product_name,art_id,amount_of CSV file, the first line is the header
+func readProductsCSV(file io.Reader) (data.Products, error) {
+	var products data.Products
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = 3
+	_, err := reader.Read()
+	if err != nil {
+		return products, fmt.Errorf("products file has no header: %w", err)
+	}
+
+	index := make(map[string]int)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return products, nil
+		}
+		if err != nil {
+			return products, err
+		}
+		amount, err := data.ParseQuantity(record[2])
+		if err != nil {
+			return products, fmt.Errorf("amount_of of product %s: %w", record[0], err)
+		}
+		contain := data.ArticleContain{ArtId: record[1], AmountOf: amount}
+		i, ok := index[record[0]]
+		if !ok {
+			i = len(products.Products)
+			index[record[0]] = i
+			products.Products = append(products.Products, data.Product{Name: record[0]})
+		}
+		products.Products[i].ContainArticles = append(products.Products[i].ContainArticles, contain)
+	}
+}
+
+//uploadInventory inserts given inventory/stock info to system
+func (server *Server) uploadInventory(context *gin.Context) {
+	log := server.Logger.WithField("rid", requestID(context))
+	log.Debug("uploadInventory")
+	var inventory data.Inventory
+	jsonData, err := ioutil.ReadAll(context.Request.Body)
+	if err != nil {
+		respond(context, http.StatusBadRequest, ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+	err = unmarshalJSON(jsonData, &inventory)
+	if err != nil {
+		respond(context, http.StatusBadRequest, ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+	server.storeInventory(context, inventory)
+	return
+}
+
+//uploadCatalog inserts the inventory and the products of a new catalog together, either all of them are inserted or
+//none. The products can only contain the articles of the catalog
+func (server *Server) uploadCatalog(context *gin.Context) {
+	log := server.Logger.WithField("rid", requestID(context))
+	log.Debug("uploadCatalog")
+	var catalog data.Catalog
+	jsonData, err := ioutil.ReadAll(context.Request.Body)
+	if err == nil {
+		err = unmarshalJSON(jsonData, &catalog)
+	}
+	if err == nil {
+		err = server.checkUploadSize(data.Products{Products: catalog.Products})
+	}
+	if err != nil {
+		respond(context, http.StatusBadRequest, ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+	if !validate(context, catalog) {
+		return
+	}
+	if duplicates := (data.Inventory{Inventory: catalog.Inventory}).Duplicates(); duplicates != nil {
+		respond(context, http.StatusBadRequest, ResponseError{
+			Message:           duplicates.Error(),
+			DuplicateArticles: duplicates,
+		})
+		return
+	}
+
+	err = server.Inventory.UploadCatalog(context.Request.Context(), catalog)
+	if errors.Is(err, db.ErrDuplicateProductArticle) {
+		respond(context, http.StatusConflict, ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+	if errors.Is(err, db.ErrArticleNotFound) {
+		//a product refers to an article the catalog does not have, the request is wrong and not the url
+		respond(context, http.StatusUnprocessableEntity, ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		respond(context, errorStatus(err, http.StatusBadRequest), ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+	respond(context, http.StatusCreated, ResponseProduct{
+		Message: fmt.Sprintf("%d item inserted, %d product inserted", len(catalog.Inventory), len(catalog.Products)),
+	})
+	return
+}
+
+//importInventory fetches the inventory from the url of the body and uploads it. Only the ImportAllowedHosts can be
+//fetched with the ImportAllowedSchemes, so the server cannot be used to call the hosts of its own network
+func (server *Server) importInventory(context *gin.Context) {
+	log := server.Logger.WithField("rid", requestID(context))
+	log.Debug("importInventory")
+	var source data.InventoryImport
+	jsonData, err := ioutil.ReadAll(context.Request.Body)
+	if err != nil {
+		respond(context, http.StatusBadRequest, ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+	err = unmarshalJSON(jsonData, &source)
+	if err != nil {
+		respond(context, http.StatusBadRequest, ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+	if !validate(context, source) {
+		return
+	}
+	sourceURL, err := server.importAllowed(source.URL)
+	if err != nil {
+		log.WithField("url", source.URL).Warn("importInventory, url refused")
+		respond(context, http.StatusForbidden, ResponseError{
+			Message: "url cannot be imported",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	inventory, err := server.fetchInventory(context.Request.Context(), sourceURL)
+	if err != nil {
+		log.WithField("err", err).Error("importInventory, fetching failed")
+		respond(context, http.StatusBadGateway, ResponseError{
+			Message: "inventory could not be fetched",
+			Error:   err.Error(),
+		})
+		return
+	}
+	server.storeInventory(context, inventory)
+	return
+}
+
+//importAllowed parses the url of an import and checks its scheme and host are allowed. A host without port allows
+//every port of it
+func (server *Server) importAllowed(rawURL string) (*url.URL, error) {
+	sourceURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if sourceURL.User != nil {
+		return nil, errors.New("credentials in the url are not allowed")
+	}
+	schemes := server.Config.ImportAllowedSchemes
+	if len(schemes) == 0 {
+		schemes = defaults.ImportAllowedSchemes
+	}
+	if !containsFold(schemes, sourceURL.Scheme) {
+		return nil, fmt.Errorf("scheme %q is not allowed", sourceURL.Scheme)
+	}
+	hosts := server.Config.ImportAllowedHosts
+	if sourceURL.Hostname() == "" || !containsFold(hosts, sourceURL.Host) && !containsFold(hosts, sourceURL.Hostname()) {
+		return nil, fmt.Errorf("host %q is not allowed", sourceURL.Host)
+	}
+	return sourceURL, nil
+}
+
+//containsFold checks if value is one of the values, ignoring case and surrounding spaces
+func containsFold(values []string, value string) bool {
+	for _, candidate := range values {
+		if strings.EqualFold(strings.TrimSpace(candidate), value) {
+			return true
+		}
+	}
+	return false
+}
+
+//fetchInventory downloads the inventory at sourceURL. CSV files, told by their content type or .csv extension, have
+//the art_id,name,stock header of inventory/export, the others are read as the JSON body of POST inventory
+func (server *Server) fetchInventory(ctx gocontext.Context, sourceURL *url.URL) (data.Inventory, error) {
+	var inventory data.Inventory
+	timeout, err := parseTimeout(server.Config.ImportTimeout, defaults.ImportTimeout)
+	if err != nil {
+		return inventory, fmt.Errorf("invalid import timeout: %w", err)
+	}
+	maxSize := server.Config.ImportMaxSize
+	if maxSize <= 0 {
+		maxSize = defaults.ImportMaxSize
+	}
+	client := &http.Client{
+		Timeout: timeout,
+		//a redirect could lead anywhere, it is followed only if its url is allowed too
+		CheckRedirect: func(redirect *http.Request, via []*http.Request) error {
+			if len(via) >= maxImportRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxImportRedirects)
+			}
+			_, err := server.importAllowed(redirect.URL.String())
+			return err
+		},
+	}
+	fetch, err := http.NewRequestWithContext(ctx, http.MethodGet, sourceURL.String(), nil)
+	if err != nil {
+		return inventory, err
+	}
+	fetch.Header.Set("Accept", "application/json, text/csv")
+	response, err := client.Do(fetch)
+	if err != nil {
+		return inventory, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return inventory, fmt.Errorf("%s responded %s", sourceURL.Host, response.Status)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(response.Body, maxSize+1))
+	if err != nil {
+		return inventory, err
+	}
+	if int64(len(body)) > maxSize {
+		return inventory, fmt.Errorf("inventory is larger than %d bytes", maxSize)
+	}
+	mediaType, _, _ := mime.ParseMediaType(response.Header.Get("Content-Type"))
+	if mediaType == "text/csv" || strings.EqualFold(path.Ext(sourceURL.Path), ".csv") {
+		return readInventoryCSV(bytes.NewReader(body))
+	}
+	err = unmarshalJSON(body, &inventory)
+	return inventory, err
+}
+
+//readInventoryCSV reads the articles of an art_id,name,stock CSV file, the first line is the header
+func readInventoryCSV(file io.Reader) (data.Inventory, error) {
+	var inventory data.Inventory
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = 3
+	_, err := reader.Read()
+	if err != nil {
+		return inventory, fmt.Errorf("inventory file has no header: %w", err)
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return inventory, nil
+		}
+		if err != nil {
+			return inventory, err
+		}
+		stock, err := data.ParseQuantity(record[2])
+		if err != nil {
+			return inventory, fmt.Errorf("stock of article %s: %w", record[0], err)
+		}
+		inventory.Inventory = append(inventory.Inventory, data.Stock{ArtId: record[0], Name: record[1], Stock: stock})
+	}
+}
+
+//storeInventory uploads the inventory parsed by uploadInventory or importInventory and responds with the result. An
+//art_id given more than once is refused, only its last occurrence is uploaded with dedup=last
+func (server *Server) storeInventory(context *gin.Context, inventory data.Inventory) {
+	if !validate(context, inventory) {
+		return
+	}
+	switch context.Query(dedup) {
+	case "":
+		if duplicates := inventory.Duplicates(); duplicates != nil {
+			respond(context, http.StatusBadRequest, ResponseError{
+				Message:           duplicates.Error(),
+				DuplicateArticles: duplicates,
+			})
+			return
+		}
+	case dedupLast:
+		inventory = inventory.KeepLast()
+	default:
+		respond(context, http.StatusBadRequest, ResponseError{
+			Message: "dedup must be last",
+		})
+		return
+	}
+	err, insertedInventory := server.Inventory.UploadInventory(context.Request.Context(), inventory)
+	if err != nil {
+		respond(context, errorStatus(err, http.StatusBadRequest), ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	message := fmt.Sprintf("%d item inserted", insertedInventory)
+	respond(context, http.StatusCreated, ResponseProduct{
+		Message: message,
+	})
+}
+
+//adjustInventory sets or changes the stock of the given articles
+func (server *Server) adjustInventory(context *gin.Context) {
+	log := server.Logger.WithField("rid", requestID(context))
+	log.Debug("adjustInventory")
+	var adjustments []data.StockAdjustment
+	jsonData, err := ioutil.ReadAll(context.Request.Body)
+	if err != nil {
+		respond(context, http.StatusBadRequest, ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+	err = unmarshalJSON(jsonData, &adjustments)
+	if err != nil {
+		respond(context, http.StatusBadRequest, ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	isAtomic := context.Query(atomic) == "true"
+	updated, err := server.Inventory.AdjustArticles(context.Request.Context(), adjustments, isAtomic)
+	var failures data.StockAdjustmentErrors
+	if err != nil {
+		// failed lines are reported back unless the adjustment is atomic
+		if !errors.As(err, &failures) || isAtomic {
+			respond(context, errorStatus(err, http.StatusBadRequest), ResponseError{
+				Message:  err.Error(),
+				Failures: failures,
+			})
+			return
+		}
+	}
+
+	message := fmt.Sprintf("%d item updated", updated)
+	respond(context, http.StatusOK, ResponseProduct{
+		Message:  message,
+		Failures: failures,
+	})
+	return
 }
 
-// NewServer creates a new HTTP server and set up routing.
-func NewServer(inventory db.Inventory, configuration Configuration, logger *logrus.Entry) *Server {
-	server := &Server{Inventory: inventory}
-	router := gin.New()
+//getInventoryItem serves GET inventory/:art_id and the static inventory routes. gin cannot register the static routes
+//next to the art_id wildcard, so they are told apart from the articles here
+func (server *Server) getInventoryItem(context *gin.Context) {
+	switch context.Param(artId) {
+	case "export":
+		server.exportInventory(context)
+	case "aggregate":
+		server.getStockByName(context)
+	case "reorder":
+		server.getReorderArticles(context)
+	case "stream":
+		server.streamInventory(context)
+	case "diff":
+		server.getStockDiff(context)
+	case "search":
+		if server.selectsFields(context, stockFieldNames...) {
+			server.searchArticles(context)
+		}
+	case "low":
+		if server.selectsFields(context, stockFieldNames...) {
+			server.getLowestStock(context)
+		}
+	default:
+		if server.selectsFields(context, stockFieldNames...) {
+			server.getArticle(context)
+		}
+	}
+}
 
-	router.Use(
-		gin.Recovery(),
-		server.setDeadline, //TODO: use deadline while querying db
-	)
+//getArticle handles the get article request, the ETag of the response is the version of the article
+func (server *Server) getArticle(context *gin.Context) {
+	log := server.Logger.WithField("rid", requestID(context))
+	log.Debug("getArticle")
+	stock, version, err := server.Inventory.GetArticle(context.Request.Context(), context.Param(artId))
+	if err != nil {
+		respond(context, errorStatus(err, http.StatusInternalServerError), ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+	etag := articleETag(version)
+	context.Header("ETag", etag)
+	if etagMatches(context.GetHeader("If-None-Match"), etag) {
+		context.Status(http.StatusNotModified)
+		return
+	}
+	respond(context, http.StatusOK, ResponseProduct{
+		Inventory: []data.Stock{stock},
+	})
+	return
+}
 
-	router.GET("warehouse/v1/health", server.isHealthy)
-	router.GET("warehouse/v1/inventory", server.getInventory)
-	router.GET("warehouse/v1/product", server.getProductStock)
-	router.POST("warehouse/v1/product", server.uploadProducts)
-	router.POST("warehouse/v1/inventory", server.uploadInventory)
-	router.POST("warehouse/v1/product/:"+productName, server.sellProduct)
+//adjustArticle handles the stock update of a single article. If-Match has to carry the ETag of the article, the
+//update is refused with 412 when the article is changed since then
+func (server *Server) adjustArticle(context *gin.Context) {
+	log := server.Logger.WithField("rid", requestID(context))
+	log.Debug("adjustArticle")
+	if context.GetHeader("If-Match") == "" {
+		respond(context, http.StatusPreconditionRequired, ResponseError{
+			Message: "If-Match with the ETag of the article is required",
+		})
+		return
+	}
+	version, err := strconv.ParseInt(strings.Trim(strings.TrimSpace(context.GetHeader("If-Match")), `"`), 10, 64)
+	if err != nil {
+		respond(context, http.StatusPreconditionFailed, ResponseError{
+			Message: "If-Match is not an ETag of the article",
+		})
+		return
+	}
+	var adjustment data.StockAdjustment
+	jsonData, err := ioutil.ReadAll(context.Request.Body)
+	if err == nil {
+		err = unmarshalJSON(jsonData, &adjustment)
+	}
+	if err != nil {
+		respond(context, http.StatusBadRequest, ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+	adjustment.ArtId = context.Param(artId)
 
-	server.router = router
-	server.Config = configuration
-	server.Logger = logger
-	return server
+	stock, version, err := server.Inventory.AdjustArticle(context.Request.Context(), adjustment, version)
+	if err != nil {
+		respond(context, errorStatus(err, http.StatusBadRequest), ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+	context.Header("ETag", articleETag(version))
+	respond(context, http.StatusOK, ResponseProduct{
+		Message:   "1 item updated",
+		Inventory: []data.Stock{stock},
+	})
+	return
 }
 
-// Start runs the HTTP server on a specific address.
-func (server *Server) Start() error {
-	return server.router.Run(server.Config.ListenAddress)
+//setReorderLevels sets the reorder point and level of the article, a body without them clears them
+func (server *Server) setReorderLevels(context *gin.Context) {
+	log := server.Logger.WithField("rid", requestID(context))
+	log.Debug("setReorderLevels")
+	var levels data.ReorderLevels
+	jsonData, err := ioutil.ReadAll(context.Request.Body)
+	if err == nil {
+		err = unmarshalJSON(jsonData, &levels)
+	}
+	if err != nil {
+		respond(context, http.StatusBadRequest, ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+	if !validate(context, levels) {
+		return
+	}
+
+	err = server.Inventory.SetReorderLevels(context.Request.Context(), context.Param(artId), levels)
+	if err != nil {
+		respond(context, errorStatus(err, http.StatusInternalServerError), ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+	message := fmt.Sprintf("Reorder levels of article %s are updated", context.Param(artId))
+	if levels.ReorderPoint == "" {
+		message = fmt.Sprintf("Reorder levels of article %s are cleared", context.Param(artId))
+	}
+	respond(context, http.StatusOK, ResponseProduct{
+		Message: message,
+	})
+	return
 }
 
-//setDeadline sets the deadline to limit the process time of the request
-func (server *Server) setDeadline(context *gin.Context) {
-	backendTimeout, err := time.ParseDuration(server.Config.BackendTimeout)
+//createSnapshot saves the stock of the articles of the location under the label of the body, the stock can be
+//diffed against it later for reconciliation
+func (server *Server) createSnapshot(context *gin.Context) {
+	log := server.Logger.WithField("rid", requestID(context))
+	log.Debug("createSnapshot")
+	var snapshot data.Snapshot
+	jsonData, err := ioutil.ReadAll(context.Request.Body)
+	if err == nil {
+		err = unmarshalJSON(jsonData, &snapshot)
+	}
 	if err != nil {
-		server.Logger.WithField("err", err).Error("Could not parse backend timeout duration")
+		respond(context, http.StatusBadRequest, ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+	if !validate(context, snapshot) {
+		return
 	}
 
-	deadline := time.Now().Add(backendTimeout)
-	context.Set("deadline", deadline)
+	snapshot, err = server.Inventory.CreateSnapshot(context.Request.Context(), snapshot.Label)
+	if err != nil {
+		respond(context, errorStatus(err, http.StatusInternalServerError), ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+	respond(context, http.StatusCreated, ResponseProduct{
+		Message:  fmt.Sprintf("Snapshot %s of %d articles is created", snapshot.Label, snapshot.Articles),
+		Snapshot: &snapshot,
+	})
+	return
 }
 
-//isHealthy checks if the service is available to respond
-func (server *Server) isHealthy(context *gin.Context) {
-	log := server.Logger.WithField("rid", request.GetRID(context))
-	log.Debug("isHealthy")
-	err := server.Inventory.Ping()
+//getStockDiff lists the change of the stock of the articles since the snapshot given by from, the unchanged ones are
+//left out
+func (server *Server) getStockDiff(context *gin.Context) {
+	log := server.Logger.WithField("rid", requestID(context))
+	log.Debug("getStockDiff")
+	label := context.Query(snapshotFrom)
+	if label == "" {
+		respond(context, http.StatusBadRequest, ResponseError{
+			Message: "from must be the label of a snapshot",
+		})
+		return
+	}
+	snapshot, deltas, err := server.Inventory.DiffSnapshot(context.Request.Context(), label)
 	if err != nil {
-		log.WithField("err", err.Error()).Error("IsHealthy ping failed")
-		context.JSON(http.StatusInternalServerError, ResponseError{
-			Message: "unhealthy endpoint",
+		respond(context, errorStatus(err, http.StatusInternalServerError), ResponseError{
+			Message: err.Error(),
 		})
 		return
 	}
-	context.JSON(http.StatusOK, ResponseError{
-		Message: "healthy endpoint",
+	respond(context, http.StatusOK, ResponseStockDiff{
+		Snapshot: snapshot,
+		Deltas:   deltas,
 	})
 	return
 }
 
-//getInventory provides inventory/stock info
-func (server *Server) getInventory(context *gin.Context) {
-	log := server.Logger.WithField("rid", request.GetRID(context))
-	log.Debug("getInventory")
-	err, stocks := server.Inventory.GetInventory(context)
+//articleETag is the strong ETag of the article version, If-Match is compared with it
+func articleETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+//deleteArticles removes the given articles from inventory, articles used by products are only deleted when forced
+func (server *Server) deleteArticles(context *gin.Context) {
+	log := server.Logger.WithField("rid", requestID(context))
+	log.Debug("deleteArticles")
+	var artIds []string
+	jsonData, err := ioutil.ReadAll(context.Request.Body)
 	if err != nil {
-		context.JSON(http.StatusNotFound, ResponseError{
+		respond(context, http.StatusBadRequest, ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+	err = unmarshalJSON(jsonData, &artIds)
+	if err != nil {
+		respond(context, http.StatusBadRequest, ResponseError{
 			Message: err.Error(),
 		})
 		return
 	}
 
-	context.JSON(http.StatusOK, ResponseProduct{
-		Inventory: stocks,
+	deleted, err := server.Inventory.DeleteArticles(context.Request.Context(), artIds, context.Query(force) == "true")
+	var inUse data.ArticlesInUse
+	if errors.As(err, &inUse) {
+		respond(context, http.StatusConflict, ResponseError{
+			Message:          err.Error(),
+			BlockingProducts: inUse,
+		})
+		return
+	}
+	if err != nil {
+		respond(context, errorStatus(err, http.StatusBadRequest), ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	message := fmt.Sprintf("%d item deleted", deleted)
+	respond(context, http.StatusOK, ResponseProduct{
+		Message: message,
 	})
 	return
 }
 
-// getProductStock provides the stock info of available products in system
-func (server *Server) getProductStock(context *gin.Context) {
-	log := server.Logger.WithField("rid", request.GetRID(context))
-	log.Debug("getProductStock")
-	err, stocks := server.Inventory.GetProductStock(context)
+//resetInventory removes every article and product, it is refused unless AllowReset is configured
+func (server *Server) resetInventory(context *gin.Context) {
+	log := server.Logger.WithField("rid", requestID(context))
+	log.Debug("resetInventory")
+	if !server.Config.AllowReset {
+		respond(context, http.StatusForbidden, ResponseError{
+			Message: "inventory reset is not allowed",
+		})
+		return
+	}
+
+	articles, products, err := server.Inventory.ResetInventory(context.Request.Context())
 	if err != nil {
-		context.JSON(http.StatusNotFound, ResponseError{
+		respond(context, errorStatus(err, http.StatusInternalServerError), ResponseError{
 			Message: err.Error(),
 		})
 		return
 	}
+	log.WithFields(logrus.Fields{"articles": articles, "products": products}).Warn("Inventory is reset")
+	respond(context, http.StatusOK, ResponseReset{
+		RemovedArticles: articles,
+		RemovedProducts: products,
+	})
+	return
+}
 
-	var product ResponseProduct
-	if len(stocks) == 0 {
-		product = ResponseProduct{
-			Message: "No product in stock",
+//postProduct serves POST product/upload, POST product/refresh and POST product/:product_name. gin cannot register
+//the static routes next to the product_name wildcard, so the posts with a body to product/upload and the refresh are
+//told apart from sales here. Only the upload has to be multipart, the sales accept any body
+func (server *Server) postProduct(context *gin.Context) {
+	if context.Param(productName) == "upload" && context.Request.ContentLength != 0 {
+		if server.acceptsContentType(context, gin.MIMEMultipartPOSTForm) {
+			server.uploadProductsFile(context)
 		}
-	} else {
-		product = ResponseProduct{
-			ProductStocks: stocks,
+		return
+	}
+	if context.Param(productName) == "refresh" {
+		server.refreshProductStock(context)
+		return
+	}
+	server.sellProduct(context)
+}
+
+//refreshProductStock recomputes the product stock cache read by GET product?cached=true
+func (server *Server) refreshProductStock(context *gin.Context) {
+	log := server.Logger.WithField("rid", requestID(context))
+	log.Debug("refreshProductStock")
+	err := server.Inventory.RefreshProductStock(context.Request.Context())
+	if err != nil {
+		respond(context, errorStatus(err, http.StatusInternalServerError), ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+	respond(context, http.StatusOK, ResponseProduct{
+		Message: "product stock cache is refreshed",
+	})
+	return
+}
+
+//sellProduct handles the sell product request
+func (server *Server) sellProduct(context *gin.Context) {
+	log := server.Logger.WithField("rid", requestID(context))
+	log.Debug("sellProduct")
+	productName := context.Param(productName)
+	if context.Query(dryRun) == "true" {
+		server.previewSale(context, productName)
+		return
+	}
+	err := server.Inventory.SellProduct(context.Request.Context(), productName)
+	if err != nil {
+		status := errorStatus(err, http.StatusBadRequest)
+		if errors.Is(err, db.ErrOutOfStock) {
+			status = http.StatusBadRequest //a sale out of stock has always been a bad request
 		}
+		respond(context, status, ResponseError{
+			Message: err.Error(),
+		})
+		return
 	}
-	context.JSON(http.StatusOK, product)
+	message := fmt.Sprintf("Product %s is sold and inventory is updated accordingly", productName)
+	respond(context, http.StatusOK, ResponseProduct{
+		Message: message,
+	})
 	return
+}
 
+//previewSale reports if the product could be sold and the resulting stock, without changing the inventory
+func (server *Server) previewSale(context *gin.Context, productName string) {
+	preview, err := server.Inventory.PreviewSale(context.Request.Context(), productName)
+	if err != nil {
+		respond(context, errorStatus(err, http.StatusBadRequest), ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+	message := fmt.Sprintf("Product %s can be sold", productName)
+	if !preview.Sellable {
+		message = preview.Reason
+	}
+	respond(context, http.StatusOK, ResponseProduct{
+		Message:     message,
+		SalePreview: &preview,
+	})
+	return
 }
 
-//uploadProducts inserts given products to system
-func (server *Server) uploadProducts(context *gin.Context) {
-	log := server.Logger.WithField("rid", request.GetRID(context))
-	log.Debug("uploadProducts")
-	var products data.Products
-	jsonData, err := ioutil.ReadAll(context.Request.Body)
+//getProductAvailability provides how many units of the product can be built at each location and in total, so an
+//order can be routed to the warehouse that has its articles
+func (server *Server) getProductAvailability(context *gin.Context) {
+	log := server.Logger.WithField("rid", requestID(context))
+	log.Debug("getProductAvailability")
+	availability, err := server.Inventory.GetProductAvailability(context.Request.Context(), context.Param(productName))
 	if err != nil {
-		context.JSON(http.StatusBadRequest, ResponseError{
+		respond(context, errorStatus(err, http.StatusInternalServerError), ResponseError{
 			Message: err.Error(),
 		})
 		return
 	}
+	respond(context, http.StatusOK, ResponseProduct{
+		Availability: &availability,
+	})
+	return
+}
 
-	err = json.Unmarshal(jsonData, &products)
+//deleteProduct handles the soft delete product request
+func (server *Server) deleteProduct(context *gin.Context) {
+	log := server.Logger.WithField("rid", requestID(context))
+	log.Debug("deleteProduct")
+	productName := context.Param(productName)
+	err := server.Inventory.DeleteProduct(context.Request.Context(), productName)
 	if err != nil {
-		context.JSON(http.StatusBadRequest, ResponseError{
+		respond(context, errorStatus(err, http.StatusBadRequest), ResponseError{
 			Message: err.Error(),
 		})
 		return
 	}
+	message := fmt.Sprintf("Product %s is deleted", productName)
+	respond(context, http.StatusOK, ResponseProduct{
+		Message: message,
+	})
+	return
+}
 
-	insertedRecord := 0
-	err, insertedRecord = server.Inventory.UploadProducts(context, products)
+//restoreProduct handles the restore request of a soft deleted product
+func (server *Server) restoreProduct(context *gin.Context) {
+	log := server.Logger.WithField("rid", requestID(context))
+	log.Debug("restoreProduct")
+	productName := context.Param(productName)
+	err := server.Inventory.RestoreProduct(context.Request.Context(), productName)
 	if err != nil {
-		context.JSON(http.StatusBadRequest, ResponseError{
+		respond(context, errorStatus(err, http.StatusBadRequest), ResponseError{
 			Message: err.Error(),
 		})
 		return
 	}
-	message := fmt.Sprintf("%d product inserted", insertedRecord)
-	context.JSON(http.StatusOK, ResponseProduct{
+	message := fmt.Sprintf("Product %s is restored", productName)
+	respond(context, http.StatusOK, ResponseProduct{
+		Message: message,
+	})
+	return
+}
+
+//returnProduct puts the articles of the returned units of the product back to the stock, quantity is 1 unless it is
+//given
+func (server *Server) returnProduct(context *gin.Context) {
+	log := server.Logger.WithField("rid", requestID(context))
+	log.Debug("returnProduct")
+	productName := context.Param(productName)
+	returned, err := strconv.Atoi(context.DefaultQuery(quantity, "1"))
+	if err != nil || returned <= 0 {
+		respond(context, http.StatusBadRequest, ResponseError{
+			Message: "quantity must be a positive number",
+		})
+		return
+	}
+
+	err = server.Inventory.ReturnProduct(context.Request.Context(), productName, returned)
+	if err != nil {
+		respond(context, errorStatus(err, http.StatusBadRequest), ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+	message := fmt.Sprintf("%d of product %s returned to the inventory", returned, productName)
+	respond(context, http.StatusOK, ResponseProduct{
 		Message: message,
 	})
 	return
+}
+
+//reserveProduct holds units of the product for the ttl, 15m unless it is given, so they are not sold to anyone else.
+//quantity is 1 unless it is given
+func (server *Server) reserveProduct(context *gin.Context) {
+	log := server.Logger.WithField("rid", requestID(context))
+	log.Debug("reserveProduct")
+	productName := context.Param(productName)
+	reserved, err := strconv.Atoi(context.DefaultQuery(quantity, "1"))
+	if err != nil || reserved <= 0 {
+		respond(context, http.StatusBadRequest, ResponseError{
+			Message: "quantity must be a positive number",
+		})
+		return
+	}
+	held := defaultReservationTTL
+	if value, ok := context.GetQuery(ttl); ok {
+		held, err = time.ParseDuration(value)
+		if err != nil || held <= 0 {
+			respond(context, http.StatusBadRequest, ResponseError{
+				Message: "ttl must be a positive duration, e.g. 15m",
+			})
+			return
+		}
+	}
 
+	reservation, err := server.Inventory.ReserveProduct(context.Request.Context(), productName, reserved, time.Now().Add(held))
+	if err != nil {
+		respond(context, errorStatus(err, http.StatusBadRequest), ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+	message := fmt.Sprintf("%d of product %s reserved until %s", reserved, productName, reservation.ExpiresAt.Format(time.RFC3339))
+	respond(context, http.StatusCreated, ResponseProduct{
+		Message:     message,
+		Reservation: &reservation,
+	})
+	return
 }
 
-//uploadInventory inserts given inventory/stock info to system
-func (server *Server) uploadInventory(context *gin.Context) {
-	log := server.Logger.WithField("rid", request.GetRID(context))
-	log.Debug("uploadInventory")
-	var inventory data.Inventory
+//releaseReservation removes the reservation, its units can be sold again
+func (server *Server) releaseReservation(context *gin.Context) {
+	log := server.Logger.WithField("rid", requestID(context))
+	log.Debug("releaseReservation")
+	id, err := strconv.ParseInt(context.Param(reservationId), 10, 64)
+	if err != nil {
+		respond(context, http.StatusBadRequest, ResponseError{
+			Message: "reservation id must be a number",
+		})
+		return
+	}
+
+	err = server.Inventory.ReleaseReservation(context.Request.Context(), id)
+	if err != nil {
+		respond(context, errorStatus(err, http.StatusInternalServerError), ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+	respond(context, http.StatusOK, ResponseProduct{
+		Message: fmt.Sprintf("reservation %d released", id),
+	})
+	return
+}
+
+//renameProduct gives the product the new_name of the body, its articles and history are kept
+func (server *Server) renameProduct(context *gin.Context) {
+	log := server.Logger.WithField("rid", requestID(context))
+	log.Debug("renameProduct")
+	productName := context.Param(productName)
+	var rename data.ProductRename
 	jsonData, err := ioutil.ReadAll(context.Request.Body)
 	if err != nil {
-		context.JSON(http.StatusBadRequest, ResponseError{
+		respond(context, http.StatusBadRequest, ResponseError{
 			Message: err.Error(),
 		})
 		return
 	}
-	err = json.Unmarshal(jsonData, &inventory)
+	err = unmarshalJSON(jsonData, &rename)
 	if err != nil {
-		context.JSON(http.StatusBadRequest, ResponseError{
+		respond(context, http.StatusBadRequest, ResponseError{
 			Message: err.Error(),
 		})
 		return
 	}
+	if !validate(context, rename) {
+		return
+	}
 
-	insertedInventory := 0
-	err, insertedInventory = server.Inventory.UploadInventory(context, inventory)
+	err = server.Inventory.RenameProduct(context.Request.Context(), productName, rename.NewName)
 	if err != nil {
-		context.JSON(http.StatusBadRequest, ResponseError{
+		respond(context, errorStatus(err, http.StatusBadRequest), ResponseError{
 			Message: err.Error(),
 		})
 		return
 	}
-
-	message := fmt.Sprintf("%d item inserted", insertedInventory)
-	context.JSON(http.StatusOK, ResponseProduct{
+	context.Header("Location", path.Join(server.basePath, "product", url.PathEscape(rename.NewName)))
+	message := fmt.Sprintf("Product %s is renamed to %s", productName, rename.NewName)
+	respond(context, http.StatusOK, ResponseProduct{
 		Message: message,
 	})
 	return
 }
 
-//sellProduct handles the sell product request
-func (server *Server) sellProduct(context *gin.Context) {
-	log := server.Logger.WithField("rid", request.GetRID(context))
-	log.Debug("sellProduct")
+//getProductArticles returns the articles of the latest recipe version of the product, or of the given version
+func (server *Server) getProductArticles(context *gin.Context) {
+	log := server.Logger.WithField("rid", requestID(context))
+	log.Debug("getProductArticles")
+	version := 0
+	if value, ok := context.GetQuery(recipeVersion); ok {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			respond(context, http.StatusBadRequest, ResponseError{
+				Message: "version must be a positive number",
+			})
+			return
+		}
+		version = parsed
+	}
+
+	product, err := server.Inventory.GetProductArticles(context.Request.Context(), context.Param(productName), version)
+	if err != nil {
+		respond(context, errorStatus(err, http.StatusInternalServerError), ResponseError{
+			Message: err.Error(),
+		})
+		return
+	}
+	respond(context, http.StatusOK, ResponseProduct{
+		Products: []data.Product{product},
+	})
+	return
+}
+
+//isProductBuildable checks if the given quantity of the product can be built, quantity is 1 unless it is given
+func (server *Server) isProductBuildable(context *gin.Context) {
+	log := server.Logger.WithField("rid", requestID(context))
+	log.Debug("isProductBuildable")
 	productName := context.Param(productName)
-	err := server.Inventory.SellProduct(context, productName)
+	requested, err := strconv.Atoi(context.DefaultQuery(quantity, "1"))
+	if err != nil || requested <= 0 {
+		respond(context, http.StatusBadRequest, ResponseError{
+			Message: "quantity must be a positive number",
+		})
+		return
+	}
+
+	buildable, maxBuildable, err := server.Inventory.IsProductBuildable(context.Request.Context(), productName, requested)
 	if err != nil {
-		context.JSON(http.StatusBadRequest, ResponseError{
+		respond(context, errorStatus(err, http.StatusInternalServerError), ResponseError{
 			Message: err.Error(),
 		})
 		return
 	}
-	message := fmt.Sprintf("Product %s is sold and inventory is updated accordingly", productName)
-	context.JSON(http.StatusOK, ResponseProduct{
-		Message: message,
+	respond(context, http.StatusOK, ResponseBuildable{
+		Buildable:    buildable,
+		MaxBuildable: maxBuildable,
 	})
 	return
 }
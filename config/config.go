@@ -0,0 +1,334 @@
+//Package config loads the configuration of the warehouse service from the ISC_ environment variables. The env tags of
+//Configuration are the only place the variables are named, and its default tags the only place the defaults are given
+package config
+
+import (
+	"errors"
+	"fmt"
+	"github.com/auknl/warehouse/data"
+	"github.com/auknl/warehouse/postgres"
+	"github.com/sirupsen/logrus"
+	"net"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Prefix is the prefix of the environment variables of the Configuration
+const Prefix = "ISC_"
+
+//Configuration keeps all config info for warehouse service. The env tag names the environment variable of a field
+//without the ISC_ prefix, default is used when the variable is not set. The alias is a former name of the variable
+//which is still read when the variable is not set
+type Configuration struct {
+	LogLevel                string   `env:"LOGLEVEL" default:"info"`
+	LogFormat               string   `env:"LOGFORMAT" default:"json"` //json or text
+	LogReportCaller         bool     `env:"LOGREPORTCALLER" default:"true"`
+	LogOutput               string   `env:"LOGOUTPUT" default:"stderr"`      //stderr, stdout or the path of a rotated file
+	LogMaxSize              int      `env:"LOGMAXSIZE" default:"100"`        //megabytes of the log file before it is rotated
+	LogMaxBackups           int      `env:"LOGMAXBACKUPS" default:"3"`       //rotated files kept, all of them if 0
+	LogMaxAge               int      `env:"LOGMAXAGE" default:"28"`          //days the rotated files are kept, no limit if 0
+	LogFailedBodies         bool     `env:"LOGFAILEDBODIES" default:"false"` //bodies of the requests refused with 4xx are logged at debug level
+	LogBodyLimit            int      `env:"LOGBODYLIMIT" default:"1024"`     //bytes of the logged bodies
+	LogBodyRedactFields     []string `env:"LOGBODYREDACTFIELDS"`             //JSON fields logged as [REDACTED]
+	Version                 string   `env:"VERSION"`
+	Environment             string   `env:"ENVIRONMENT"`
+	BackendTimeout          string   `env:"BACKENDTIMEOUT" default:"25s"`
+	ListenAddress           string   `env:"LISTENADDRESS" default:":8080"`
+	RoutePrefix             string   `env:"ROUTEPREFIX" default:"warehouse/v1"`
+	ReadTimeout             string   `env:"READTIMEOUT" default:"10s"`
+	WriteTimeout            string   `env:"WRITETIMEOUT" default:"30s"`
+	IdleTimeout             string   `env:"IDLETIMEOUT" default:"120s"`
+	ResponseTimeout         string   `env:"RESPONSETIMEOUT" default:"28s"`    //handlers running longer get 503
+	ShutdownGracePeriod     string   `env:"SHUTDOWNGRACEPERIOD" default:"5s"` //readiness fails this long before draining
+	ShutdownTimeout         string   `env:"SHUTDOWNTIMEOUT" default:"30s"`
+	SlowRequestThreshold    string   `env:"SLOWREQUESTTHRESHOLD" default:"2s"`
+	MaxConcurrentRequests   int      `env:"MAXCONCURRENTREQUESTS" default:"0"` //requests served at once, unlimited if 0
+	LoadShedWait            string   `env:"LOADSHEDWAIT" default:"100ms"`      //waiting for a free slot before 503
+	StreamHeartbeat         string   `env:"STREAMHEARTBEAT" default:"15s"`     //comment sent on idle inventory streams
+	MaxUploadSize           int64    `env:"MAXUPLOADSIZE" default:"10485760"`  //bytes
+	DefaultPageSize         int      `env:"DEFAULTPAGESIZE" default:"100"`
+	MaxPageSize             int      `env:"MAXPAGESIZE" default:"1000"` //larger limits are lowered to it
+	MaxPoolInUse            int      `env:"MAXPOOLINUSE" default:"0"`   //health fails when this many database connections are in use, 0 never
+	MaxArticlesPerProduct   int      `env:"MAXARTICLESPERPRODUCT" default:"1000"`
+	MaxProductsPerUpload    int      `env:"MAXPRODUCTSPERUPLOAD" default:"10000"`
+	ImportTimeout           string   `env:"IMPORTTIMEOUT" default:"10s"`
+	ImportMaxSize           int64    `env:"IMPORTMAXSIZE" default:"10485760"` //bytes
+	ImportAllowedHosts      []string `env:"IMPORTALLOWEDHOSTS"`               //hosts inventory/import fetches from, nothing can be imported if it is not set
+	ImportAllowedSchemes    []string `env:"IMPORTALLOWEDSCHEMES" default:"https"`
+	DBDriver                string   `env:"DBDRIVER"`
+	DBURL                   string   `env:"DBURL"` //takes precedence over the fields below
+	DBHost                  string   `env:"DBHOST"`
+	DBPort                  string   `env:"DBPORT"`
+	DBUser                  string   `env:"DBUSER"`
+	DBPassword              string   `env:"DBPASSWORD"`
+	DBName                  string   `env:"DBNAME" alias:"DBDBNAME"`              //ISC_DBNAME, the database or the sqlite file
+	DBRetries               int      `env:"DBRETRIES" default:"2"`                //postgres transactions failing with a transient error are run again
+	DBQueryTimeout          string   `env:"DBQUERYTIMEOUT"`                       //postgres statements running longer are cancelled, no limit if it is not set
+	DBQueries               string   `env:"DBQUERIES"`                            //json file overriding postgres statements by name, the others keep their default
+	DBValidationQuery       string   `env:"DBVALIDATIONQUERY" default:"SELECT 1"` //run on startup, the service does not start if it fails
+	DBValidationTimeout     string   `env:"DBVALIDATIONTIMEOUT" default:"5s"`
+	LogSQL                  bool     `env:"LOGSQL" default:"false"`         //postgres statements are logged with their arguments at debug level
+	LogSQLRedact            bool     `env:"LOGSQLREDACT" default:"true"`    //the arguments of the logged statements are [REDACTED]
+	TracingEnabled          bool     `env:"TRACINGENABLED" default:"false"` //exporter is set by OTEL_EXPORTER_OTLP_* env
+	CacheTTL                string   `env:"CACHETTL"`                       //stock queries are not cached if it is not set
+	APIKeys                 []string `env:"APIKEYS"`                        //"key:read+write", keys without scopes have both, no auth if it is not set
+	CorsAllowedOrigins      []string `env:"CORSALLOWEDORIGINS"`             //browser origins allowed to call the API, CORS is disabled if it is not set
+	CorsAllowedMethods      []string `env:"CORSALLOWEDMETHODS" default:"GET,POST,PATCH,DELETE"`
+	CorsAllowedHeaders      []string `env:"CORSALLOWEDHEADERS" default:"Content-Type,Authorization,X-Warehouse-Id,If-None-Match"`
+	RequestIDHeaders        []string `env:"REQUESTIDHEADERS" default:"X-Request-Id"` //the first one given is the request id, the trace id of traceparent
+	RequestIDResponseHeader string   `env:"REQUESTIDRESPONSEHEADER" default:"X-Request-Id"`
+	ChaosEnabled            bool     `env:"CHAOSENABLED" default:"false"` //never in production, see Validate
+	ChaosFailureRate        float64  `env:"CHAOSFAILURERATE" default:"0"` //percent of the requests failed with 500
+	ChaosDelayRate          float64  `env:"CHAOSDELAYRATE" default:"0"`   //percent of the requests delayed by ISC_CHAOSDELAY
+	ChaosDelay              string   `env:"CHAOSDELAY" default:"1s"`
+	MaintenanceWindows      string   `env:"MAINTENANCEWINDOWS"` //separated by semicolons, the cron fields have commas
+	MaintenanceTimeZone     string   `env:"MAINTENANCETIMEZONE" default:"UTC"`
+	FulfillmentStrategy     string   `env:"FULFILLMENTSTRATEGY" default:"nearest"` //nearest or most-stock-first
+	AllowReset              bool     `env:"ALLOWRESET" default:"false"`            //never in production, see Validate
+	CaseInsensitiveRoutes   bool     `env:"CASEINSENSITIVEROUTES" default:"false"`
+	Seed                    bool     `env:"SEED" default:"false"` //demo data is uploaded on start when the inventory is empty
+	H2C                     bool     `env:"H2C" default:"false"`  //HTTP/2 without TLS besides HTTP/1.1
+}
+
+//Load reads the Configuration from the environment variables found by lookup, os.LookupEnv in the service, and
+//validates it. The fields whose variable is not set get their default, all the problems are listed in the error
+func Load(lookup func(string) (string, bool)) (Configuration, error) {
+	config, err := read(lookup)
+	if err != nil {
+		return config, err
+	}
+	return config, config.Validate()
+}
+
+//Defaults returns the Configuration of the service when no variable is set, it is not validated as the version and
+//the environment have no default
+func Defaults() Configuration {
+	config, _ := read(func(string) (string, bool) { return "", false }) //every default tag can be parsed, see the tests
+	return config
+}
+
+//read reads the Configuration from the environment variables found by lookup like Load, without validating it
+func read(lookup func(string) (string, bool)) (Configuration, error) {
+	var config Configuration
+	var problems []string
+	value := reflect.ValueOf(&config).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		name := Prefix + field.Tag.Get("env")
+		text, ok := lookup(name)
+		if alias := field.Tag.Get("alias"); !ok && alias != "" {
+			text, ok = lookup(Prefix + alias)
+		}
+		if !ok {
+			text = field.Tag.Get("default")
+		}
+		if err := set(value.Field(i), text); err != nil {
+			problems = append(problems, fmt.Sprintf("%s %q %s", name, text, err))
+		}
+	}
+	if len(problems) != 0 {
+		return config, errors.New(strings.Join(problems, ", "))
+	}
+	return config, nil
+}
+
+//set parses text into the field by the type of the field, lists are comma separated. An empty text leaves the zero
+//value
+func set(field reflect.Value, text string) error {
+	if text == "" {
+		return nil
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(text)
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(text)
+		if err != nil {
+			return errors.New("is not a boolean")
+		}
+		field.SetBool(parsed)
+	case reflect.Int, reflect.Int64:
+		parsed, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return errors.New("is not an integer")
+		}
+		field.SetInt(parsed)
+	case reflect.Float64:
+		parsed, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return errors.New("is not a number")
+		}
+		field.SetFloat(parsed)
+	case reflect.Slice:
+		items := strings.Split(text, ",")
+		for i := range items {
+			items[i] = strings.TrimSpace(items[i])
+		}
+		field.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("cannot be loaded into %s", field.Type())
+	}
+	return nil
+}
+
+//Validate checks the required fields are set and the durations and the listen address can be parsed, all the
+//problems are listed in the error. The api keys and the maintenance windows are parsed by api.NewConfiguration
+func (config Configuration) Validate() error {
+	var problems []string
+	required := func(name string, value string) {
+		if value == "" {
+			problems = append(problems, "ISC_"+name+" is required")
+		}
+	}
+	duration := func(name string, value string) {
+		if _, err := time.ParseDuration(value); err != nil {
+			problems = append(problems, fmt.Sprintf("ISC_%s %q is not a duration", name, value))
+		}
+	}
+
+	if config.LogFormat != "json" && config.LogFormat != "text" {
+		problems = append(problems, fmt.Sprintf("ISC_LOGFORMAT %q is not supported, use json or text", config.LogFormat))
+	}
+	required("VERSION", config.Version)
+	required("ENVIRONMENT", config.Environment)
+	duration("BACKENDTIMEOUT", config.BackendTimeout)
+	duration("READTIMEOUT", config.ReadTimeout)
+	duration("WRITETIMEOUT", config.WriteTimeout)
+	duration("IDLETIMEOUT", config.IdleTimeout)
+	duration("RESPONSETIMEOUT", config.ResponseTimeout)
+	duration("SHUTDOWNGRACEPERIOD", config.ShutdownGracePeriod)
+	duration("SHUTDOWNTIMEOUT", config.ShutdownTimeout)
+	duration("SLOWREQUESTTHRESHOLD", config.SlowRequestThreshold)
+	duration("LOADSHEDWAIT", config.LoadShedWait)
+	duration("STREAMHEARTBEAT", config.StreamHeartbeat)
+	duration("IMPORTTIMEOUT", config.ImportTimeout)
+	if config.CacheTTL != "" {
+		duration("CACHETTL", config.CacheTTL)
+	}
+	if config.DBQueryTimeout != "" {
+		duration("DBQUERYTIMEOUT", config.DBQueryTimeout)
+	}
+	if config.MaxPageSize < 1 {
+		problems = append(problems, fmt.Sprintf("ISC_MAXPAGESIZE %d must be positive", config.MaxPageSize))
+	}
+	if config.LogBodyLimit < 1 {
+		problems = append(problems, fmt.Sprintf("ISC_LOGBODYLIMIT %d must be positive", config.LogBodyLimit))
+	}
+	if config.LogMaxSize < 1 {
+		problems = append(problems, fmt.Sprintf("ISC_LOGMAXSIZE %d must be positive", config.LogMaxSize))
+	}
+	if config.LogMaxBackups < 0 || config.LogMaxAge < 0 {
+		problems = append(problems, "ISC_LOGMAXBACKUPS and ISC_LOGMAXAGE cannot be negative")
+	}
+	if config.MaxPoolInUse < 0 {
+		problems = append(problems, fmt.Sprintf("ISC_MAXPOOLINUSE %d cannot be negative", config.MaxPoolInUse))
+	}
+	if config.MaxConcurrentRequests < 0 {
+		problems = append(problems, fmt.Sprintf("ISC_MAXCONCURRENTREQUESTS %d cannot be negative", config.MaxConcurrentRequests))
+	}
+	if config.DefaultPageSize < 1 || config.DefaultPageSize > config.MaxPageSize {
+		problems = append(problems, fmt.Sprintf("ISC_DEFAULTPAGESIZE %d must be between 1 and ISC_MAXPAGESIZE", config.DefaultPageSize))
+	}
+	if config.MaxArticlesPerProduct < 1 {
+		problems = append(problems, fmt.Sprintf("ISC_MAXARTICLESPERPRODUCT %d must be positive", config.MaxArticlesPerProduct))
+	}
+	if config.MaxProductsPerUpload < 1 {
+		problems = append(problems, fmt.Sprintf("ISC_MAXPRODUCTSPERUPLOAD %d must be positive", config.MaxProductsPerUpload))
+	}
+	if config.FulfillmentStrategy != data.FulfillNearest && config.FulfillmentStrategy != data.FulfillMostStock {
+		problems = append(problems, fmt.Sprintf("ISC_FULFILLMENTSTRATEGY %q is not supported, use %s or %s", config.FulfillmentStrategy, data.FulfillNearest, data.FulfillMostStock))
+	}
+	if _, _, err := net.SplitHostPort(config.ListenAddress); err != nil {
+		problems = append(problems, fmt.Sprintf("ISC_LISTENADDRESS %q is not a valid address", config.ListenAddress))
+	}
+
+	//reset wipes the whole inventory, it cannot be enabled in production even by mistake
+	if config.AllowReset && strings.HasPrefix(strings.ToLower(config.Environment), "prod") {
+		problems = append(problems, fmt.Sprintf("ISC_ALLOWRESET cannot be enabled in %s environment", config.Environment))
+	}
+	//the fault rates do nothing unless chaos is enabled, which fails real requests and is never allowed in production
+	if config.ChaosEnabled {
+		if strings.HasPrefix(strings.ToLower(config.Environment), "prod") {
+			problems = append(problems, fmt.Sprintf("ISC_CHAOSENABLED cannot be enabled in %s environment", config.Environment))
+		}
+		if config.ChaosFailureRate < 0 || config.ChaosDelayRate < 0 || config.ChaosFailureRate+config.ChaosDelayRate > 100 {
+			problems = append(problems, "ISC_CHAOSFAILURERATE and ISC_CHAOSDELAYRATE must be percents adding up to at most 100")
+		}
+		duration("CHAOSDELAY", config.ChaosDelay)
+	}
+
+	switch config.DBDriver {
+	case "postgres":
+		if config.DBURL == "" {
+			required("DBHOST", config.DBHost)
+			required("DBPORT", config.DBPort)
+			required("DBUSER", config.DBUser)
+			required("DBPASSWORD", config.DBPassword)
+			required("DBNAME", config.DBName)
+		}
+		duration("DBVALIDATIONTIMEOUT", config.DBValidationTimeout)
+		if config.DBQueries != "" {
+			if _, err := postgres.LoadQueries(config.DBQueries); err != nil {
+				problems = append(problems, "ISC_DBQUERIES "+err.Error())
+			}
+		}
+	case "sqlite":
+		required("DBNAME", config.DBName)
+	case "memory": //needs no settings
+	case "":
+		required("DBDRIVER", config.DBDriver)
+	default:
+		problems = append(problems, fmt.Sprintf("ISC_DBDRIVER %q is not supported, use postgres, sqlite or memory", config.DBDriver))
+	}
+
+	if len(problems) != 0 {
+		return errors.New(strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+//redacted replaces the secrets in the logged configuration
+const redacted = "[REDACTED]"
+
+//Redacted returns the configuration as log fields named after their environment variables, the database password,
+//the password of the database url and the api keys are masked, the scopes of the keys are kept
+func (config Configuration) Redacted() logrus.Fields {
+	fields := logrus.Fields{}
+	value := reflect.ValueOf(config)
+	for i := 0; i < value.NumField(); i++ {
+		fields[Prefix+value.Type().Field(i).Tag.Get("env")] = value.Field(i).Interface()
+	}
+	if config.DBPassword != "" {
+		fields["ISC_DBPASSWORD"] = redacted
+	}
+	if config.DBURL != "" {
+		fields["ISC_DBURL"] = redactURL(config.DBURL)
+	}
+	keys := make([]string, 0, len(config.APIKeys))
+	for _, entry := range config.APIKeys {
+		if i := strings.LastIndexByte(entry, ':'); i >= 0 {
+			keys = append(keys, redacted+entry[i:])
+		} else {
+			keys = append(keys, redacted)
+		}
+	}
+	fields["ISC_APIKEYS"] = keys
+	return fields
+}
+
+//String formats the Redacted configuration, so printing the configuration never shows its secrets
+func (config Configuration) String() string {
+	return fmt.Sprint(config.Redacted())
+}
+
+//redactURL masks the password of a database url, a connection string which is not a url is masked entirely
+func redactURL(dbURL string) string {
+	u, err := url.Parse(dbURL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return redacted
+	}
+	return u.Redacted()
+}
@@ -0,0 +1,32 @@
+package data
+
+import "time"
+
+//operations recorded in the audit log
+const (
+	AuditUploadInventory = "upload_inventory"
+	AuditUploadProduct   = "upload_product"
+	AuditSell            = "sell"
+	AuditReturnProduct   = "return_product"
+	AuditDeleteProduct   = "delete_product"
+	AuditRestoreProduct  = "restore_product"
+	AuditRenameProduct   = "rename_product"
+	AuditAdjustArticle   = "adjust_article"
+	AuditDeleteArticle   = "delete_article"
+	AuditResetInventory  = "reset_inventory"
+)
+
+//AuditEntry is a row of the audit log written in the transaction of the mutation. Entity is the product or article
+//changed, ArtId is set when the stock of an article is changed, StockBefore and StockAfter are its stock around
+//the change when it applies
+type AuditEntry struct {
+	Id          int64     `json:"id"`
+	Operation   string    `json:"operation"`
+	RID         string    `json:"rid"`
+	Entity      string    `json:"entity"`
+	ArtId       string    `json:"art_id,omitempty"`
+	Location    string    `json:"location"`
+	StockBefore Quantity  `json:"stock_before,omitempty"`
+	StockAfter  Quantity  `json:"stock_after,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
@@ -0,0 +1,8 @@
+package data
+
+//Catalog is the inventory and the products of a new catalog, they are uploaded together so the products never refer
+//to articles that are missing
+type Catalog struct {
+	Inventory []Stock   `json:"inventory"`
+	Products  []Product `json:"products"`
+}
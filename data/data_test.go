@@ -0,0 +1,395 @@
+package data
+
+import (
+	"encoding/json"
+	"gotest.tools/assert"
+	"testing"
+)
+
+func TestWireFormat(t *testing.T) {
+	stock, delta := Quantity("10"), Quantity("-0.5")
+	tests := []struct {
+		name  string
+		value interface{}
+		json  string
+		empty interface{}
+	}{
+		{
+			name:  "inventory",
+			value: &Inventory{Inventory: []Stock{{ArtId: "1", Name: "leg", Stock: "12"}}},
+			json:  `{"inventory":[{"art_id":"1","name":"leg","stock":"12"}]}`,
+			empty: &Inventory{},
+		},
+		{
+			name:  "products",
+			value: &Products{Products: []Product{{Name: "Dining Chair", ContainArticles: []ArticleContain{{ArtId: "1", AmountOf: "4"}}}}},
+			json:  `{"products":[{"name":"Dining Chair","contain_articles":[{"art_id":"1","amount_of":"4"}]}]}`,
+			empty: &Products{},
+		},
+		{
+			name:  "product_stocks",
+			value: &ProductStocks{{Name: "Dining Chair", AvailableProductNo: "2"}, {Name: "Dinning Table", AvailableProductNo: "1", Deleted: true}},
+			json:  `[{"product_name":"Dining Chair","available_product_no":"2"},{"product_name":"Dinning Table","available_product_no":"1","deleted":true}]`,
+			empty: &ProductStocks{},
+		},
+		{
+			name:  "stock_adjustments",
+			value: &[]StockAdjustment{{ArtId: "1", Stock: &stock}, {ArtId: "2", Delta: &delta}},
+			json:  `[{"art_id":"1","stock":"10"},{"art_id":"2","delta":"-0.5"}]`,
+			empty: &[]StockAdjustment{},
+		},
+		{
+			name:  "stock_adjustment_errors",
+			value: &StockAdjustmentErrors{{Line: 1, ArtId: "2", Error: "article is not found in system"}},
+			json:  `[{"line":1,"art_id":"2","error":"article is not found in system"}]`,
+			empty: &StockAdjustmentErrors{},
+		},
+		{
+			name:  "stats",
+			value: &Stats{TotalArticles: 4, TotalStock: "32.5", TotalProducts: 2, BuildableProducts: 1},
+			json:  `{"total_articles":4,"total_stock":"32.5","total_products":2,"buildable_products":1}`,
+			empty: &Stats{},
+		},
+		{
+			name:  "product_availability",
+			value: &ProductAvailability{Name: "chair", Locations: []LocationAvailability{{Location: "north", Available: 2}}, Total: 2},
+			json:  `{"product_name":"chair","locations":[{"location":"north","available":2}],"total":2}`,
+			empty: &ProductAvailability{},
+		},
+		{
+			name:  "sale_preview",
+			value: &SalePreview{Name: "Dinning Table", Sellable: true, Inventory: []Stock{{ArtId: "4", Name: "table top", Stock: "0"}}},
+			json:  `{"product_name":"Dinning Table","sellable":true,"inventory":[{"art_id":"4","name":"table top","stock":"0"}]}`,
+			empty: &SalePreview{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			marshaled, err := json.Marshal(tt.value)
+			assert.NilError(t, err)
+			assert.Equal(t, string(marshaled), tt.json)
+
+			err = json.Unmarshal([]byte(tt.json), tt.empty)
+			assert.NilError(t, err)
+			assert.DeepEqual(t, tt.empty, tt.value)
+		})
+	}
+}
+
+func TestQuantity(t *testing.T) {
+	tests := []struct {
+		text        string
+		canonical   Quantity
+		thousandths int64
+		err         string
+	}{
+		{text: "12", canonical: "12", thousandths: 12000},
+		{text: "1.50", canonical: "1.5", thousandths: 1500},
+		{text: "0.125", canonical: "0.125", thousandths: 125},
+		{text: "-0.3", canonical: "-0.3", thousandths: -300},
+		{text: "+2", canonical: "2", thousandths: 2000},
+		{text: "0.0001", err: `"0.0001" has more than 3 decimals`},
+		{text: "1.", err: `"1." is not a decimal number`},
+		{text: "1e3", err: `"1e3" is not a decimal number`},
+		{text: "", err: `"" is not a decimal number`},
+		{text: "99999999999999999999", err: `"99999999999999999999" is too large`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			quantity, err := ParseQuantity(tt.text)
+			if tt.err != "" {
+				assert.Error(t, err, tt.err)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, quantity, tt.canonical)
+
+			value, err := quantity.Value()
+			assert.NilError(t, err)
+			assert.Equal(t, value, tt.thousandths)
+			var scanned Quantity
+			assert.NilError(t, scanned.Scan(value))
+			assert.Equal(t, scanned, tt.canonical)
+		})
+	}
+
+	//numbers and strings are both accepted
+	var contain ArticleContain
+	assert.NilError(t, json.Unmarshal([]byte(`{"art_id":"1","amount_of":0.25}`), &contain))
+	assert.Equal(t, contain.AmountOf, Quantity("0.25"))
+	assert.NilError(t, json.Unmarshal([]byte(`{"art_id":"1","amount_of":"4"}`), &contain))
+	assert.Equal(t, contain.AmountOf, Quantity("4"))
+	assert.Error(t, json.Unmarshal([]byte(`{"art_id":"1","amount_of":"0.2501"}`), &contain), `"0.2501" has more than 3 decimals`)
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		payload  interface{ Validate() error }
+		problems ValidationErrors
+	}{
+		{
+			name:    "valid_products",
+			payload: Products{Products: []Product{{Name: "Dining Chair", ContainArticles: []ArticleContain{{ArtId: "1", AmountOf: "4"}, {ArtId: "2", AmountOf: "0.5"}}}}},
+		},
+		{
+			name: "invalid_amounts",
+			payload: Products{Products: []Product{
+				{Name: "Dining Chair", ContainArticles: []ArticleContain{{ArtId: "1", AmountOf: "4"}, {ArtId: "2", AmountOf: "0"}}},
+				{Name: "Dinning Table", ContainArticles: []ArticleContain{{ArtId: "1", AmountOf: "-1"}, {ArtId: "4"}, {ArtId: "5", AmountOf: "1.2345"}}},
+			}},
+			problems: ValidationErrors{
+				{Field: "products[0].contain_articles[1].amount_of", Message: "must be positive"},
+				{Field: "products[1].contain_articles[0].amount_of", Message: "must be positive"},
+				{Field: "products[1].contain_articles[1].amount_of", Message: "is required"},
+				{Field: "products[1].contain_articles[2].amount_of", Message: `"1.2345" has more than 3 decimals`},
+			},
+		},
+		{
+			name:    "missing_product_fields",
+			payload: Products{Products: []Product{{Name: " "}, {Name: "Stool", ContainArticles: []ArticleContain{{AmountOf: "3"}}}}},
+			problems: ValidationErrors{
+				{Field: "products[0].name", Message: "is required"},
+				{Field: "products[0].contain_articles", Message: "must not be empty"},
+				{Field: "products[1].contain_articles[0].art_id", Message: "is required"},
+			},
+		},
+		{
+			name: "reserved_product_names",
+			payload: Products{Products: []Product{
+				{Name: "upload", ContainArticles: []ArticleContain{{ArtId: "1", AmountOf: "1"}}},
+				{Name: "all", ContainArticles: []ArticleContain{{ArtId: "1", AmountOf: "1"}}},
+				{Name: "refresh", ContainArticles: []ArticleContain{{ArtId: "1", AmountOf: "1"}}},
+			}},
+			problems: ValidationErrors{
+				{Field: "products[0].name", Message: `"upload" is reserved`},
+				{Field: "products[1].name", Message: `"all" is reserved`},
+				{Field: "products[2].name", Message: `"refresh" is reserved`},
+			},
+		},
+		{
+			name:     "reserved_new_name",
+			payload:  ProductRename{NewName: "upload"},
+			problems: ValidationErrors{{Field: "new_name", Message: `"upload" is reserved`}},
+		},
+		{
+			name:    "valid_inventory",
+			payload: Inventory{Inventory: []Stock{{ArtId: "1", Name: "leg", Stock: "12"}, {ArtId: "2", Name: "screw", Stock: "0"}}},
+		},
+		{
+			name:    "invalid_inventory",
+			payload: Inventory{Inventory: []Stock{{ArtId: "1", Name: "leg", Stock: "12"}, {Name: "screw", Stock: "-2"}, {ArtId: "3", Name: "seat"}}},
+			problems: ValidationErrors{
+				{Field: "inventory[1].art_id", Message: "is required"},
+				{Field: "inventory[1].stock", Message: "cannot be negative"},
+				{Field: "inventory[2].stock", Message: "is required"},
+			},
+		},
+		{
+			name:     "reserved_art_id",
+			payload:  Inventory{Inventory: []Stock{{ArtId: "search", Name: "leg", Stock: "12"}}},
+			problems: ValidationErrors{{Field: "inventory[0].art_id", Message: `"search" is reserved`}},
+		},
+		{
+			name:    "valid_reorder_levels",
+			payload: ReorderLevels{ReorderPoint: "0", ReorderTo: "2.5"},
+		},
+		{
+			name:    "cleared_reorder_levels",
+			payload: ReorderLevels{},
+		},
+		{
+			name:     "missing_reorder_level",
+			payload:  ReorderLevels{ReorderPoint: "5"},
+			problems: ValidationErrors{{Field: "reorder_to", Message: "is required"}},
+		},
+		{
+			name:     "reorder_to_not_above_point",
+			payload:  ReorderLevels{ReorderPoint: "5", ReorderTo: "5"},
+			problems: ValidationErrors{{Field: "reorder_to", Message: "must be greater than reorder_point"}},
+		},
+		{
+			name:    "invalid_reorder_levels",
+			payload: ReorderLevels{ReorderPoint: "-1", ReorderTo: "x"},
+			problems: ValidationErrors{
+				{Field: "reorder_point", Message: "cannot be negative"},
+				{Field: "reorder_to", Message: `"x" is not a decimal number`},
+			},
+		},
+		{
+			name: "valid_catalog",
+			payload: Catalog{Inventory: []Stock{{ArtId: "1", Name: "leg", Stock: "12"}},
+				Products: []Product{{Name: "chair", ContainArticles: []ArticleContain{{ArtId: "1", AmountOf: "4"}}}}},
+		},
+		{
+			name: "invalid_catalog",
+			payload: Catalog{Inventory: []Stock{{ArtId: "1", Name: "leg", Stock: "-1"}},
+				Products: []Product{{Name: "chair", ContainArticles: []ArticleContain{{ArtId: "1", AmountOf: "4"}, {ArtId: "2", AmountOf: "1"}, {ArtId: "", AmountOf: "1"}}}}},
+			problems: ValidationErrors{
+				{Field: "inventory[0].stock", Message: "cannot be negative"},
+				{Field: "products[0].contain_articles[2].art_id", Message: "is required"},
+				{Field: "products[0].contain_articles[1].art_id", Message: "is not in the inventory of the catalog"},
+			},
+		},
+		{
+			name:     "empty_catalog",
+			payload:  Catalog{},
+			problems: ValidationErrors{{Field: "inventory", Message: "must not be empty"}, {Field: "products", Message: "must not be empty"}},
+		},
+		{
+			name:    "valid_order",
+			payload: Order{Lines: Cart{{Name: "chair", Quantity: 4}}, Strategy: FulfillMostStock, Locations: []string{"north", "south"}},
+		},
+		{
+			name:    "invalid_order",
+			payload: Order{Lines: Cart{{Name: "chair", Quantity: 0}}, Strategy: "cheapest", Locations: []string{"north", " ", "north"}},
+			problems: ValidationErrors{
+				{Field: "lines[0].quantity", Message: "must be positive"},
+				{Field: "strategy", Message: "must be nearest or most-stock-first"},
+				{Field: "locations[1]", Message: "is required"},
+				{Field: "locations[2]", Message: "is listed more than once"},
+			},
+		},
+		{
+			name:     "empty_order",
+			payload:  Order{},
+			problems: ValidationErrors{{Field: "lines", Message: "must not be empty"}},
+		},
+		{
+			name:    "valid_cart",
+			payload: Cart{{Name: "chair", Quantity: 4}},
+		},
+		{
+			name:     "empty_cart",
+			payload:  Cart{},
+			problems: ValidationErrors{{Field: "cart", Message: "must not be empty"}},
+		},
+		{
+			name:    "invalid_cart_lines",
+			payload: Cart{{Name: "chair", Quantity: 0}, {Name: " ", Quantity: 1}},
+			problems: ValidationErrors{
+				{Field: "[0].quantity", Message: "must be positive"},
+				{Field: "[1].name", Message: "is required"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.payload.Validate()
+			if tt.problems == nil {
+				assert.NilError(t, err)
+				return
+			}
+			assert.DeepEqual(t, err, tt.problems)
+		})
+	}
+
+	err := Inventory{Inventory: []Stock{{Stock: "1"}}}.Validate()
+	assert.Error(t, err, "validation failed for inventory[0].art_id: is required")
+}
+
+func TestCheckCart(t *testing.T) {
+	cart := Cart{{Name: "chair", Quantity: 2}, {Name: "table", Quantity: 1}, {Name: "sofa", Quantity: 1}, {Name: "stool", Quantity: 3}}
+	availability := CheckCart(cart, [][]CartArticle{
+		{{ArtId: "1", Amount: 4000, Available: 12000}, {ArtId: "2", Amount: 1000, Available: 5000}},
+		{{ArtId: "1", Amount: 4000, Available: 12000}},
+		nil,
+		{{ArtId: "3", Amount: 1000, Available: -1000}},
+	})
+	//chair and table are sufficient on their own, together they need 12 legs of the 12
+	assert.DeepEqual(t, availability, CartAvailability{
+		Lines: []CartLineAvailability{
+			{Name: "chair", Requested: 2, Available: 3, Sufficient: true},
+			{Name: "table", Requested: 1, Available: 3, Sufficient: true},
+			{Name: "sofa", Requested: 1},
+			{Name: "stool", Requested: 3},
+		},
+		NotFound: []string{"sofa"},
+	})
+
+	availability = CheckCart(cart[:2], [][]CartArticle{
+		{{ArtId: "1", Amount: 4000, Available: 11000}},
+		{{ArtId: "1", Amount: 4000, Available: 11000}},
+	})
+	//each line has its 2 legs, not both of them
+	assert.Equal(t, availability.Lines[0].Sufficient, true)
+	assert.Equal(t, availability.Lines[1].Sufficient, true)
+	assert.Equal(t, availability.Sufficient, false)
+	availability = CheckCart(cart[:2], [][]CartArticle{
+		{{ArtId: "1", Amount: 4000, Available: 12000}},
+		{{ArtId: "1", Amount: 4000, Available: 12000}},
+	})
+	assert.Equal(t, availability.Sufficient, true)
+}
+
+func TestPlanFulfillment(t *testing.T) {
+	//a chair takes 4 legs and a seat, a stool 3 legs
+	chair := func(legs int64, seats int64) []CartArticle {
+		return []CartArticle{{ArtId: "1", Amount: 4000, Available: legs * 1000}, {ArtId: "2", Amount: 1000, Available: seats * 1000}}
+	}
+	stool := func(legs int64) []CartArticle {
+		return []CartArticle{{ArtId: "1", Amount: 3000, Available: legs * 1000}}
+	}
+	locations := []FulfillmentLocation{
+		{Location: "north", Articles: [][]CartArticle{chair(8, 5), stool(8)}},
+		{Location: "south", Articles: [][]CartArticle{chair(20, 3), stool(20)}},
+		{Location: "west", Articles: [][]CartArticle{chair(4, 0), stool(4)}},
+	}
+	lines := Cart{{Name: "chair", Quantity: 4}, {Name: "stool", Quantity: 2}}
+
+	//the 2 chairs of north and 2 of south, the legs left are 0 in north and 12 in south
+	allocations, notFound, unfilled := PlanFulfillment(Order{Lines: lines, Strategy: FulfillNearest}, locations)
+	assert.DeepEqual(t, allocations, []Allocation{
+		{Location: "north", Name: "chair", Quantity: 2},
+		{Location: "south", Name: "chair", Quantity: 2},
+		{Location: "south", Name: "stool", Quantity: 2},
+	})
+	assert.Equal(t, len(notFound)+len(unfilled), 0)
+
+	//south builds 3 chairs, then north the last one; west has no legs left for a second stool
+	allocations, _, _ = PlanFulfillment(Order{Lines: lines, Strategy: FulfillMostStock}, locations)
+	assert.DeepEqual(t, allocations, []Allocation{
+		{Location: "south", Name: "chair", Quantity: 3},
+		{Location: "north", Name: "chair", Quantity: 1},
+		{Location: "south", Name: "stool", Quantity: 2},
+	})
+
+	//5 chairs are more than the seats with legs of all the locations
+	allocations, notFound, unfilled = PlanFulfillment(Order{Lines: Cart{{Name: "chair", Quantity: 6}, {Name: "sofa", Quantity: 1}}}, []FulfillmentLocation{
+		{Location: "north", Articles: [][]CartArticle{chair(8, 5), nil}},
+		{Location: "south", Articles: [][]CartArticle{chair(20, 3), nil}},
+	})
+	assert.DeepEqual(t, notFound, []string{"sofa"})
+	assert.DeepEqual(t, unfilled, []string{"chair"})
+	assert.Equal(t, len(allocations), 2)
+}
+
+func TestOrder_FulfillmentLocations(t *testing.T) {
+	all := []string{"default", "north", "south"}
+	assert.DeepEqual(t, Order{}.FulfillmentLocations(all, "north"), []string{"north", "default", "south"})
+	assert.DeepEqual(t, Order{}.FulfillmentLocations(all, "east"), []string{"east", "default", "north", "south"})
+	assert.DeepEqual(t, Order{Locations: []string{"south", "north"}}.FulfillmentLocations(all, "north"), []string{"south", "north"})
+}
+
+func TestInventory_Duplicates(t *testing.T) {
+	inventory := Inventory{Inventory: []Stock{
+		{ArtId: "1", Name: "leg", Stock: "12"},
+		{ArtId: "2", Name: "screw", Stock: "17"},
+		{ArtId: "1", Name: "leg", Stock: "4"},
+		{ArtId: "3", Name: "seat", Stock: "2"},
+		{ArtId: "2", Name: "screw", Stock: "8"},
+		{ArtId: "1", Name: "table leg", Stock: "6"},
+	}}
+	assert.DeepEqual(t, inventory.Duplicates(), DuplicateArticles{"1", "2"})
+	assert.Error(t, inventory.Duplicates(), "duplicate art_ids in inventory: 1, 2")
+
+	deduplicated := inventory.KeepLast()
+	assert.DeepEqual(t, deduplicated.Inventory, []Stock{
+		{ArtId: "3", Name: "seat", Stock: "2"},
+		{ArtId: "2", Name: "screw", Stock: "8"},
+		{ArtId: "1", Name: "table leg", Stock: "6"},
+	})
+	assert.Assert(t, deduplicated.Duplicates() == nil)
+	//the inventory is not changed
+	assert.Equal(t, len(inventory.Inventory), 6)
+}
@@ -0,0 +1,115 @@
+package data
+
+import (
+	"sort"
+)
+
+//The strategies of an Order, the order the locations are drawn from for each line
+const (
+	FulfillNearest   = "nearest"          //the locations of the order in the order given, nearest first
+	FulfillMostStock = "most-stock-first" //the locations building the most units of the product first
+)
+
+//Order is a bulk sale of the lines of a cart drawn from several locations. Locations are the ones drawn from, nearest
+//first; the location of the request and then all the others by name when it is empty
+type Order struct {
+	Lines     Cart     `json:"lines"`
+	Strategy  string   `json:"strategy"`
+	Locations []string `json:"locations,omitempty"`
+}
+
+//Allocation is the number of units of a product of an order sold from a location
+type Allocation struct {
+	Location string `json:"location"`
+	Name     string `json:"product_name"`
+	Quantity int    `json:"quantity"`
+}
+
+//FulfillmentLocation is a location an order can draw from, with the articles of the product of each line of the
+//order and their stock available to promise in the location
+type FulfillmentLocation struct {
+	Location string
+	Articles [][]CartArticle
+}
+
+//FulfillmentLocations are the locations the order draws from, nearest first. The ones of the order if it lists them,
+//otherwise home followed by the others of all
+func (order Order) FulfillmentLocations(all []string, home string) []string {
+	if len(order.Locations) != 0 {
+		return order.Locations
+	}
+	locations := []string{home}
+	for _, location := range all {
+		if location != home {
+			locations = append(locations, location)
+		}
+	}
+	return locations
+}
+
+//PlanFulfillment allocates the units of every line of the order to the locations by the strategy of the order, a
+//location gives as many as it can build before the next one is drawn from. The stock a line takes is not available
+//to the next lines. notFound lists the products no location has articles for, unfilled the ones the locations cannot
+//build enough of together
+func PlanFulfillment(order Order, locations []FulfillmentLocation) (allocations []Allocation, notFound []string, unfilled []string) {
+	allocations = []Allocation{}
+	stock := make([]map[string]int64, len(locations))
+	for i, location := range locations {
+		stock[i] = make(map[string]int64)
+		for _, articles := range location.Articles {
+			for _, article := range articles {
+				stock[i][article.ArtId] = article.Available
+			}
+		}
+	}
+	buildable := func(location int, line int) int {
+		var units int64
+		for j, article := range locations[location].Articles[line] {
+			if n := stock[location][article.ArtId] / article.Amount; j == 0 || n < units {
+				units = n
+			}
+		}
+		if units < 0 {
+			return 0
+		}
+		return int(units)
+	}
+
+	for i, line := range order.Lines {
+		drawn := make([]int, 0, len(locations))
+		for location := range locations {
+			if len(locations[location].Articles[i]) != 0 {
+				drawn = append(drawn, location)
+			}
+		}
+		if len(drawn) == 0 {
+			notFound = append(notFound, line.Name)
+			continue
+		}
+		if order.Strategy == FulfillMostStock {
+			sort.SliceStable(drawn, func(a, b int) bool { return buildable(drawn[a], i) > buildable(drawn[b], i) })
+		}
+		remaining := line.Quantity
+		for _, location := range drawn {
+			units := buildable(location, i)
+			if units > remaining {
+				units = remaining
+			}
+			if units == 0 {
+				continue
+			}
+			for _, article := range locations[location].Articles[i] {
+				stock[location][article.ArtId] -= article.Amount * int64(units)
+			}
+			allocations = append(allocations, Allocation{Location: locations[location].Location, Name: line.Name, Quantity: units})
+			remaining -= units
+			if remaining == 0 {
+				break
+			}
+		}
+		if remaining != 0 {
+			unfilled = append(unfilled, line.Name)
+		}
+	}
+	return allocations, notFound, unfilled
+}
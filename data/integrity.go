@@ -0,0 +1,27 @@
+package data
+
+//IntegrityRecord is a row of the product or inventory table that is found inconsistent
+type IntegrityRecord struct {
+	ProductName string   `json:"product_name,omitempty"`
+	ArtId       string   `json:"art_id"`
+	Location    string   `json:"location,omitempty"`
+	Stock       Quantity `json:"stock,omitempty"`
+}
+
+//IntegrityIssue is the number of the records with an inconsistency and the first ones of them
+type IntegrityIssue struct {
+	Count   int               `json:"count"`
+	Samples []IntegrityRecord `json:"samples,omitempty"`
+}
+
+//IntegrityReport lists the inconsistencies between the product and inventory tables
+type IntegrityReport struct {
+	MissingArticles          IntegrityIssue `json:"missing_articles"` //product rows of articles that are not in inventory
+	NegativeStock            IntegrityIssue `json:"negative_stock"`
+	DuplicateProductArticles IntegrityIssue `json:"duplicate_product_articles"`
+}
+
+//Consistent tells if no inconsistency is found
+func (report IntegrityReport) Consistent() bool {
+	return report.MissingArticles.Count == 0 && report.NegativeStock.Count == 0 && report.DuplicateProductArticles.Count == 0
+}
@@ -1,15 +1,22 @@
 package data
 
+import (
+	"strings"
+	"time"
+)
+
 //ArticleContain is the map of product and required item/amount info
 type ArticleContain struct {
-	ArtId    string `json:"art_id,omitempty"`
-	AmountOf string `json:"amount_of,omitempty"`
+	ArtId    string   `json:"art_id"`
+	AmountOf Quantity `json:"amount_of"`
 }
 
-//Product represents product
+//Product represents product. RecipeVersion is the version of its articles, every upload of the product makes a new
+//one, it is ignored in the uploads
 type Product struct {
-	Name            string           `json:"name,omitempty"`
-	ContainArticles []ArticleContain `json:"contain_articles,omitempty"`
+	Name            string           `json:"name"`
+	ContainArticles []ArticleContain `json:"contain_articles"`
+	RecipeVersion   int              `json:"recipe_version,omitempty"`
 }
 
 // Products represents all products
@@ -17,11 +24,165 @@ type Products struct {
 	Products []Product `json:"products"`
 }
 
+//ArtIds returns the distinct article ids the products contain, in the order they are first referenced
+func (products Products) ArtIds() []string {
+	seen := make(map[string]bool)
+	var artIds []string
+	for _, product := range products.Products {
+		for _, article := range product.ContainArticles {
+			if !seen[article.ArtId] {
+				seen[article.ArtId] = true
+				artIds = append(artIds, article.ArtId)
+			}
+		}
+	}
+	return artIds
+}
+
+//ProductRename is the new name of a product
+type ProductRename struct {
+	NewName string `json:"new_name"`
+}
+
 //ProductStock keeps product and its stock for response
 type ProductStock struct {
-	Name               string `json:"product_name,omitempty"`
-	AvailableProductNo string `json:"stock_of_product,omitempty"`
+	Name               string `json:"product_name"`
+	AvailableProductNo string `json:"available_product_no"`
+	Deleted            bool   `json:"deleted,omitempty"`
 }
 
 //ProductStocks list of ProductStock
 type ProductStocks []ProductStock
+
+//SalePreview is the outcome of a dry run sale, Inventory keeps the stock of the product's articles after the sale
+type SalePreview struct {
+	Name      string  `json:"product_name"`
+	Sellable  bool    `json:"sellable"`
+	Reason    string  `json:"reason,omitempty"`
+	Inventory []Stock `json:"inventory"`
+}
+
+//LocationAvailability is how many units of a product can be built from the stock of one location
+type LocationAvailability struct {
+	Location  string `json:"location"`
+	Available int    `json:"available"`
+}
+
+//ProductAvailability is how many units of a product can be built at each location that stocks articles, Total is
+//their sum
+type ProductAvailability struct {
+	Name      string                 `json:"product_name"`
+	Locations []LocationAvailability `json:"locations"`
+	Total     int                    `json:"total"`
+}
+
+//ArticleProduct is a product containing an article and how many of it can be built. GainPerUnit is how many more
+//of it each unit of the article added to the stock builds, 0 when another article of the product limits it
+type ArticleProduct struct {
+	Name        string   `json:"product_name"`
+	AmountOf    Quantity `json:"amount_of"`
+	Buildable   int      `json:"buildable"`
+	GainPerUnit Quantity `json:"gain_per_unit"`
+}
+
+//CartLine is a product and the number of it a shopping cart asks for
+type CartLine struct {
+	Name     string `json:"name"`
+	Quantity int    `json:"quantity"`
+}
+
+//Cart is the lines of a shopping cart, checked together without reserving anything
+type Cart []CartLine
+
+//CartLineAvailability tells if the units of the product available to promise cover the quantity of a cart line
+type CartLineAvailability struct {
+	Name       string `json:"name"`
+	Requested  int    `json:"requested"`
+	Available  int    `json:"available"`
+	Sufficient bool   `json:"sufficient"`
+}
+
+//CartAvailability is the availability of every line of a cart. Sufficient is set when the lines can be built
+//together, lines sharing an article may not be even if each of them is. NotFound lists the unknown products
+type CartAvailability struct {
+	Lines      []CartLineAvailability `json:"lines"`
+	Sufficient bool                   `json:"sufficient"`
+	NotFound   []string               `json:"not_found,omitempty"`
+}
+
+//CartArticle is an article of a cart product, the amount a unit of the product needs and the stock of the article
+//available to promise, in thousandths
+type CartArticle struct {
+	ArtId     string
+	Amount    int64
+	Available int64
+}
+
+//CheckCart computes the availability of the cart from the articles of the product of each line, a product without
+//articles is not found
+func CheckCart(cart Cart, articles [][]CartArticle) CartAvailability {
+	availability := CartAvailability{Lines: make([]CartLineAvailability, 0, len(cart)), Sufficient: true}
+	needed := make(map[string]int64)
+	stock := make(map[string]int64)
+	for i, line := range cart {
+		checked := CartLineAvailability{Name: line.Name, Requested: line.Quantity}
+		if len(articles[i]) == 0 {
+			availability.NotFound = append(availability.NotFound, line.Name)
+		}
+		for j, article := range articles[i] {
+			available := int(article.Available / article.Amount)
+			if available < 0 {
+				available = 0
+			}
+			if j == 0 || available < checked.Available {
+				checked.Available = available
+			}
+			needed[article.ArtId] += article.Amount * int64(line.Quantity)
+			stock[article.ArtId] = article.Available
+		}
+		checked.Sufficient = len(articles[i]) != 0 && checked.Requested <= checked.Available
+		availability.Sufficient = availability.Sufficient && checked.Sufficient
+		availability.Lines = append(availability.Lines, checked)
+	}
+	for artId, amount := range needed {
+		if amount > stock[artId] {
+			availability.Sufficient = false
+		}
+	}
+	return availability
+}
+
+//Sale is how many units of a product were sold in a location, the returned ones are subtracted. The times are of the
+//first and the latest sale or return
+type Sale struct {
+	Name        string    `json:"product_name"`
+	Sold        int       `json:"sold"`
+	FirstSoldAt time.Time `json:"first_sold_at"`
+	LastSoldAt  time.Time `json:"last_sold_at"`
+}
+
+//Reservation holds quantity units of a product in a location until it expires, the reserved units cannot be sold
+type Reservation struct {
+	Id        int64     `json:"id"`
+	Name      string    `json:"product_name"`
+	Location  string    `json:"location"`
+	Quantity  int       `json:"quantity"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+//ProductUploadFailure keeps the reason why a product of an upload could not be inserted
+type ProductUploadFailure struct {
+	Name  string `json:"name"`
+	Error string `json:"error"`
+}
+
+//ProductUploadErrors list of ProductUploadFailure
+type ProductUploadErrors []ProductUploadFailure
+
+func (failures ProductUploadErrors) Error() string {
+	products := make([]string, 0, len(failures))
+	for _, failure := range failures {
+		products = append(products, failure.Name+": "+failure.Error)
+	}
+	return "product upload failed for " + strings.Join(products, ", ")
+}
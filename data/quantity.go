@@ -0,0 +1,149 @@
+package data
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+//quantityDecimals is the number of decimals a Quantity keeps, QuantityUnit is the thousandths of one unit
+const (
+	quantityDecimals = 3
+	QuantityUnit     = 1000
+)
+
+//UnitGain is the thousandths of a product that one unit of an article adds per thousandth of the article in the
+//product. Both the unit added and the gain are in thousandths, so it is QuantityUnit squared and the gain of a unit is
+//UnitGain divided by the amount of the article
+const UnitGain = QuantityUnit * QuantityUnit
+
+//Quantity is a decimal stock or amount such as "12" or "0.25", articles measured by weight or length are not whole
+//units. It is kept as text in JSON to avoid float rounding, and stored in the db as an int64 of thousandths so
+//the db arithmetic is exact
+type Quantity string
+
+//ParseQuantity parses a decimal with at most 3 decimals and returns it in canonical form, "1.50" is "1.5"
+func ParseQuantity(text string) (Quantity, error) {
+	thousandths, err := Quantity(text).Thousandths()
+	if err != nil {
+		return "", err
+	}
+	return QuantityOf(thousandths), nil
+}
+
+//QuantityOf returns the Quantity of the given thousandths
+func QuantityOf(thousandths int64) Quantity {
+	sign := ""
+	if thousandths < 0 {
+		sign = "-"
+	}
+	units := thousandths / QuantityUnit
+	fraction := thousandths % QuantityUnit
+	if units < 0 {
+		units = -units
+	}
+	if fraction < 0 {
+		fraction = -fraction
+	}
+	if fraction == 0 {
+		return Quantity(sign + strconv.FormatInt(units, 10))
+	}
+	decimals := strings.TrimRight(fmt.Sprintf("%03d", fraction), "0")
+	return Quantity(fmt.Sprintf("%s%d.%s", sign, units, decimals))
+}
+
+//Thousandths returns the quantity as an integer number of thousandths
+func (quantity Quantity) Thousandths() (int64, error) {
+	text := string(quantity)
+	negative := strings.HasPrefix(text, "-")
+	text = strings.TrimPrefix(strings.TrimPrefix(text, "-"), "+")
+	units, decimals := text, ""
+	if i := strings.IndexByte(text, '.'); i >= 0 {
+		units, decimals = text[:i], text[i+1:]
+	}
+	if units == "" || !isDigits(units) || !isDigits(decimals) || (strings.Contains(text, ".") && decimals == "") {
+		return 0, fmt.Errorf("%q is not a decimal number", string(quantity))
+	}
+	if len(decimals) > quantityDecimals {
+		return 0, fmt.Errorf("%q has more than %d decimals", string(quantity), quantityDecimals)
+	}
+
+	whole, err := strconv.ParseInt(units, 10, 64)
+	if err != nil || whole > math.MaxInt64/QuantityUnit-1 {
+		return 0, fmt.Errorf("%q is too large", string(quantity))
+	}
+	fraction := int64(0)
+	if decimals != "" {
+		fraction, _ = strconv.ParseInt(decimals+strings.Repeat("0", quantityDecimals-len(decimals)), 10, 64)
+	}
+	thousandths := whole*QuantityUnit + fraction
+	if negative {
+		thousandths = -thousandths
+	}
+	return thousandths, nil
+}
+
+//isDigits checks if text only has ASCII digits
+func isDigits(text string) bool {
+	for _, c := range text {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+//UnmarshalJSON accepts the quantity as a JSON string or number, numbers are parsed from their text so they are not
+//rounded as float
+func (quantity *Quantity) UnmarshalJSON(raw []byte) error {
+	text := string(raw)
+	if bytes.HasPrefix(raw, []byte(`"`)) {
+		err := json.Unmarshal(raw, &text)
+		if err != nil {
+			return err
+		}
+	}
+	parsed, err := ParseQuantity(text)
+	if err != nil {
+		return err
+	}
+	*quantity = parsed
+	return nil
+}
+
+//Value stores the quantity as thousandths, an empty quantity is NULL
+func (quantity Quantity) Value() (driver.Value, error) {
+	if quantity == "" {
+		return nil, nil
+	}
+	return quantity.Thousandths()
+}
+
+//Scan reads the thousandths stored by Value, empty text or NULL is an empty quantity
+func (quantity *Quantity) Scan(src interface{}) error {
+	switch value := src.(type) {
+	case nil:
+		*quantity = ""
+	case int64:
+		*quantity = QuantityOf(value)
+	case []byte:
+		return quantity.Scan(string(value))
+	case string:
+		if value == "" {
+			*quantity = ""
+			return nil
+		}
+		thousandths, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("cannot scan %q as quantity: %w", value, err)
+		}
+		*quantity = QuantityOf(thousandths)
+	default:
+		return fmt.Errorf("cannot scan %T as quantity", src)
+	}
+	return nil
+}
@@ -1,10 +1,17 @@
 package data
 
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
 //Stock the inventory info per item
 type Stock struct {
-	ArtId string `json:"art_id,omitempty"`
-	Name  string `json:"name,omitempty"`
-	Stock string `json:"stock,omitempty"`
+	ArtId string   `json:"art_id"`
+	Name  string   `json:"name"`
+	Stock Quantity `json:"stock"`
 }
 
 //type StockList []Stock
@@ -13,3 +20,154 @@ type Stock struct {
 type Inventory struct {
 	Inventory []Stock `json:"inventory"`
 }
+
+//DuplicateArticles lists the art_ids given more than once in an inventory
+type DuplicateArticles []string
+
+func (artIds DuplicateArticles) Error() string {
+	return "duplicate art_ids in inventory: " + strings.Join(artIds, ", ")
+}
+
+//Duplicates returns the art_ids given more than once in the order of their first occurrence, nil if there is none
+func (inventory Inventory) Duplicates() DuplicateArticles {
+	var duplicates DuplicateArticles
+	seen := make(map[string]int, len(inventory.Inventory))
+	for _, stock := range inventory.Inventory {
+		seen[stock.ArtId]++
+		if seen[stock.ArtId] == 2 {
+			duplicates = append(duplicates, stock.ArtId)
+		}
+	}
+	return duplicates
+}
+
+//KeepLast drops all but the last occurrence of each art_id, the stocks left keep their order
+func (inventory Inventory) KeepLast() Inventory {
+	last := make(map[string]int, len(inventory.Inventory))
+	for i, stock := range inventory.Inventory {
+		last[stock.ArtId] = i
+	}
+	kept := make([]Stock, 0, len(last))
+	for i, stock := range inventory.Inventory {
+		if last[stock.ArtId] == i {
+			kept = append(kept, stock)
+		}
+	}
+	return Inventory{Inventory: kept}
+}
+
+//StockAdjustment sets the stock of an article to Stock or changes it by Delta
+type StockAdjustment struct {
+	ArtId string    `json:"art_id"`
+	Stock *Quantity `json:"stock,omitempty"`
+	Delta *Quantity `json:"delta,omitempty"`
+}
+
+//Validate checks that exactly one of stock or delta is given and the stock is not negative
+func (adjustment StockAdjustment) Validate() error {
+	if adjustment.ArtId == "" {
+		return errors.New("art_id is required")
+	}
+	if (adjustment.Stock == nil) == (adjustment.Delta == nil) {
+		return errors.New("exactly one of stock or delta is required")
+	}
+	if adjustment.Stock != nil {
+		stock, err := adjustment.Stock.Thousandths()
+		if err != nil {
+			return err
+		}
+		if stock < 0 {
+			return errors.New("stock cannot be negative")
+		}
+	}
+	if adjustment.Delta != nil {
+		_, err := adjustment.Delta.Thousandths()
+		return err
+	}
+	return nil
+}
+
+//ReorderLevels are the thresholds of an article for automated purchasing. The article needs a reorder when its stock
+//falls to ReorderPoint or below, it is then reordered up to ReorderTo. Both empty clears them
+type ReorderLevels struct {
+	ReorderPoint Quantity `json:"reorder_point"`
+	ReorderTo    Quantity `json:"reorder_to"`
+}
+
+//Reorder is an article whose stock is at or below its reorder point, Quantity brings it back up to ReorderTo
+type Reorder struct {
+	ArtId        string   `json:"art_id"`
+	Name         string   `json:"name"`
+	Stock        Quantity `json:"stock"`
+	ReorderPoint Quantity `json:"reorder_point"`
+	ReorderTo    Quantity `json:"reorder_to"`
+	Quantity     Quantity `json:"reorder_quantity"`
+}
+
+//StockAdjustmentFailure keeps the reason why a line of an adjustment could not be applied
+type StockAdjustmentFailure struct {
+	Line  int    `json:"line"`
+	ArtId string `json:"art_id"`
+	Error string `json:"error"`
+}
+
+//StockAdjustmentErrors list of StockAdjustmentFailure
+type StockAdjustmentErrors []StockAdjustmentFailure
+
+func (failures StockAdjustmentErrors) Error() string {
+	lines := make([]string, 0, len(failures))
+	for _, failure := range failures {
+		lines = append(lines, fmt.Sprintf("line %d (art_id %s): %s", failure.Line, failure.ArtId, failure.Error))
+	}
+	return "stock adjustment failed for " + strings.Join(lines, ", ")
+}
+
+//ArticlesInUse lists the products using the articles that are requested to be deleted
+type ArticlesInUse []string
+
+func (products ArticlesInUse) Error() string {
+	return "articles are used by products " + strings.Join(products, ", ")
+}
+
+//InventoryImport is the source of an inventory fetched by the server instead of being uploaded
+type InventoryImport struct {
+	URL string `json:"url"`
+}
+
+//Snapshot is the stock of the articles of a location saved under a label, the stock is diffed against it later for
+//reconciliation
+type Snapshot struct {
+	Label     string    `json:"label"`
+	CreatedAt time.Time `json:"created_at"`
+	Articles  int       `json:"articles"`
+}
+
+//NameStock is the stock of all the articles sharing a name, such as the SKUs of the same product
+type NameStock struct {
+	Name     string   `json:"name"`
+	Stock    Quantity `json:"stock"`
+	Articles int      `json:"articles"`
+}
+
+//StockDelta is the change of the stock of an article since a snapshot. An article added since has a zero Before, a
+//removed one a zero After
+type StockDelta struct {
+	ArtId  string   `json:"art_id"`
+	Name   string   `json:"name"`
+	Before Quantity `json:"before"`
+	After  Quantity `json:"after"`
+	Delta  Quantity `json:"delta"`
+}
+
+//NewStockDelta is the delta of the article from before to after, both in thousandths
+func NewStockDelta(artId string, name string, before int64, after int64) StockDelta {
+	return StockDelta{ArtId: artId, Name: name, Before: QuantityOf(before), After: QuantityOf(after), Delta: QuantityOf(after - before)}
+}
+
+//Stats aggregate info of the warehouse
+type Stats struct {
+	TotalArticles     int      `json:"total_articles"`
+	TotalStock        Quantity `json:"total_stock"`
+	TotalProducts     int      `json:"total_products"`
+	BuildableProducts int      `json:"buildable_products"`
+}
@@ -0,0 +1,239 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+//FieldError is the problem of a single field of a request, Field is the path of the field in the JSON body such as
+//products[0].contain_articles[1].amount_of
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+//ValidationErrors lists the problems of all the invalid fields of a request
+type ValidationErrors []FieldError
+
+func (problems ValidationErrors) Error() string {
+	fields := make([]string, 0, len(problems))
+	for _, problem := range problems {
+		fields = append(fields, problem.Field+": "+problem.Message)
+	}
+	return "validation failed for " + strings.Join(fields, ", ")
+}
+
+//add appends the problem of the field
+func (problems *ValidationErrors) add(field string, message string) {
+	*problems = append(*problems, FieldError{Field: field, Message: message})
+}
+
+//err returns the problems as an error, nil if there is none
+func (problems ValidationErrors) err() error {
+	if len(problems) == 0 {
+		return nil
+	}
+	return problems
+}
+
+//quantity adds the problem of the quantity field if it is missing, not a decimal or not positive. Zero is accepted
+//if allowZero is set
+func (problems *ValidationErrors) quantity(field string, quantity Quantity, allowZero bool) {
+	if quantity == "" {
+		problems.add(field, "is required")
+		return
+	}
+	thousandths, err := quantity.Thousandths()
+	if err != nil {
+		problems.add(field, err.Error())
+		return
+	}
+	if allowZero && thousandths < 0 {
+		problems.add(field, "cannot be negative")
+	}
+	if !allowZero && thousandths <= 0 {
+		problems.add(field, "must be positive")
+	}
+}
+
+//reservedProductNames are served by the routes next to product/<name>, a product of these names could not be sold
+var reservedProductNames = []string{"upload", "all", "refresh"}
+
+//reservedArtIds are served by the routes next to inventory/<art_id>, an article of these ids could not be read
+var reservedArtIds = []string{"export", "search", "low", "aggregate", "reorder", "stream", "diff"}
+
+//name adds the problem of the name field if it is missing or one of the reserved names
+func (problems *ValidationErrors) name(field string, name string, reserved []string) {
+	if strings.TrimSpace(name) == "" {
+		problems.add(field, "is required")
+		return
+	}
+	for _, word := range reserved {
+		if name == word {
+			problems.add(field, fmt.Sprintf("%q is reserved", word))
+		}
+	}
+}
+
+//Validate checks the url of the import is given, the server decides which urls can be fetched
+func (source InventoryImport) Validate() error {
+	var problems ValidationErrors
+	if strings.TrimSpace(source.URL) == "" {
+		problems.add("url", "is required")
+	}
+	return problems.err()
+}
+
+//Validate checks the new name of the product is given and not reserved
+func (rename ProductRename) Validate() error {
+	var problems ValidationErrors
+	problems.name("new_name", rename.NewName, reservedProductNames)
+	return problems.err()
+}
+
+//maxSnapshotLabel is the size of the label column of the snapshots
+const maxSnapshotLabel = 255
+
+//Validate checks the snapshot is labelled, the label is its name
+func (snapshot Snapshot) Validate() error {
+	var problems ValidationErrors
+	if strings.TrimSpace(snapshot.Label) == "" {
+		problems.add("label", "is required")
+	} else if len(snapshot.Label) > maxSnapshotLabel {
+		problems.add("label", fmt.Sprintf("must be at most %d characters", maxSnapshotLabel))
+	}
+	return problems.err()
+}
+
+//Validate checks every product has a name that is not reserved and articles, and every article has an art_id and a
+//positive amount. All the problems are returned in ValidationErrors
+func (products Products) Validate() error {
+	var problems ValidationErrors
+	for i, product := range products.Products {
+		path := fmt.Sprintf("products[%d]", i)
+		problems.name(path+".name", product.Name, reservedProductNames)
+		if len(product.ContainArticles) == 0 {
+			problems.add(path+".contain_articles", "must not be empty")
+		}
+		for j, article := range product.ContainArticles {
+			articlePath := fmt.Sprintf("%s.contain_articles[%d]", path, j)
+			if strings.TrimSpace(article.ArtId) == "" {
+				problems.add(articlePath+".art_id", "is required")
+			}
+			problems.quantity(articlePath+".amount_of", article.AmountOf, false)
+		}
+	}
+	return problems.err()
+}
+
+//Validate checks the cart has lines, and every line has a product name and a positive quantity. All the problems are
+//returned in ValidationErrors
+func (cart Cart) Validate() error {
+	var problems ValidationErrors
+	if len(cart) == 0 {
+		problems.add("cart", "must not be empty")
+	}
+	for i, line := range cart {
+		path := fmt.Sprintf("[%d]", i)
+		if strings.TrimSpace(line.Name) == "" {
+			problems.add(path+".name", "is required")
+		}
+		if line.Quantity <= 0 {
+			problems.add(path+".quantity", "must be positive")
+		}
+	}
+	return problems.err()
+}
+
+//Validate checks the order has valid lines as a cart, a known strategy and no location listed twice. All the problems
+//are returned in ValidationErrors
+func (order Order) Validate() error {
+	var problems ValidationErrors
+	if len(order.Lines) == 0 {
+		problems.add("lines", "must not be empty")
+	}
+	for i, line := range order.Lines {
+		path := fmt.Sprintf("lines[%d]", i)
+		if strings.TrimSpace(line.Name) == "" {
+			problems.add(path+".name", "is required")
+		}
+		if line.Quantity <= 0 {
+			problems.add(path+".quantity", "must be positive")
+		}
+	}
+	if order.Strategy != "" && order.Strategy != FulfillNearest && order.Strategy != FulfillMostStock {
+		problems.add("strategy", fmt.Sprintf("must be %s or %s", FulfillNearest, FulfillMostStock))
+	}
+	seen := make(map[string]bool, len(order.Locations))
+	for i, location := range order.Locations {
+		path := fmt.Sprintf("locations[%d]", i)
+		if strings.TrimSpace(location) == "" {
+			problems.add(path, "is required")
+		} else if seen[location] {
+			problems.add(path, "is listed more than once")
+		}
+		seen[location] = true
+	}
+	return problems.err()
+}
+
+//Validate checks every article has an art_id that is not reserved and a stock that is not negative. All the problems
+//are returned in ValidationErrors
+func (inventory Inventory) Validate() error {
+	var problems ValidationErrors
+	for i, stock := range inventory.Inventory {
+		path := fmt.Sprintf("inventory[%d]", i)
+		problems.name(path+".art_id", stock.ArtId, reservedArtIds)
+		problems.quantity(path+".stock", stock.Stock, true)
+	}
+	return problems.err()
+}
+
+//Validate checks the catalog has articles and products, both are valid as in their own uploads, and every article of
+//the products is in the inventory of the catalog. All the problems are returned in ValidationErrors
+func (catalog Catalog) Validate() error {
+	var problems ValidationErrors
+	if len(catalog.Inventory) == 0 {
+		problems.add("inventory", "must not be empty")
+	}
+	if len(catalog.Products) == 0 {
+		problems.add("products", "must not be empty")
+	}
+	var inventoryProblems, productProblems ValidationErrors
+	errors.As(Inventory{Inventory: catalog.Inventory}.Validate(), &inventoryProblems)
+	errors.As(Products{Products: catalog.Products}.Validate(), &productProblems)
+	problems = append(append(problems, inventoryProblems...), productProblems...)
+
+	stocked := make(map[string]bool, len(catalog.Inventory))
+	for _, stock := range catalog.Inventory {
+		stocked[stock.ArtId] = true
+	}
+	for i, product := range catalog.Products {
+		for j, article := range product.ContainArticles {
+			if strings.TrimSpace(article.ArtId) != "" && !stocked[article.ArtId] {
+				problems.add(fmt.Sprintf("products[%d].contain_articles[%d].art_id", i, j), "is not in the inventory of the catalog")
+			}
+		}
+	}
+	return problems.err()
+}
+
+//Validate checks both reorder levels are given, or none of them to clear them. The reorder point cannot be negative
+//and the level to reorder up to has to be above it
+func (levels ReorderLevels) Validate() error {
+	var problems ValidationErrors
+	if levels.ReorderPoint == "" && levels.ReorderTo == "" {
+		return nil
+	}
+	problems.quantity("reorder_point", levels.ReorderPoint, true)
+	problems.quantity("reorder_to", levels.ReorderTo, false)
+	if len(problems) == 0 {
+		point, _ := levels.ReorderPoint.Thousandths()
+		to, _ := levels.ReorderTo.Thousandths()
+		if to <= point {
+			problems.add("reorder_to", "must be greater than reorder_point")
+		}
+	}
+	return problems.err()
+}
@@ -0,0 +1,168 @@
+//Package cache provides a db.Inventory decorator that keeps the results of the stock queries in memory
+package cache
+
+import (
+	"context"
+	"github.com/auknl/warehouse/data"
+	"github.com/auknl/warehouse/db"
+	"github.com/auknl/warehouse/request"
+	"sync"
+	"time"
+)
+
+var _ db.Inventory = (*CachedInventory)(nil)
+
+//CachedInventory caches GetInventory and GetProductStock of the wrapped inventory for ttl. Every call that may
+//change the stock invalidates the whole cache, the other calls are passed through
+type CachedInventory struct {
+	db.Inventory
+	ttl time.Duration
+	now func() time.Time //replaced by tests
+
+	mutex        sync.Mutex
+	generation   uint64 //incremented on invalidation, results read before are not cached
+	inventory    map[string]cachedInventory
+	productStock map[productStockKey]cachedProductStock
+}
+
+type cachedInventory struct {
+	stocks    []data.Stock
+	expiresAt time.Time
+}
+
+type productStockKey struct {
+	location       string
+	includeDeleted bool
+}
+
+type cachedProductStock struct {
+	stocks    data.ProductStocks
+	expiresAt time.Time
+}
+
+//NewCachedInventory wraps the inventory with a cache keeping the results for ttl
+func NewCachedInventory(inventory db.Inventory, ttl time.Duration) *CachedInventory {
+	cached := &CachedInventory{Inventory: inventory, ttl: ttl, now: time.Now}
+	cached.Invalidate()
+	return cached
+}
+
+//Invalidate drops every cached result
+func (cached *CachedInventory) Invalidate() {
+	cached.mutex.Lock()
+	defer cached.mutex.Unlock()
+	cached.generation++
+	cached.inventory = map[string]cachedInventory{}
+	cached.productStock = map[productStockKey]cachedProductStock{}
+}
+
+func (cached *CachedInventory) GetInventory(ctx context.Context) (error, []data.Stock) {
+	location := request.LocationFromContext(ctx)
+	cached.mutex.Lock()
+	entry, ok := cached.inventory[location]
+	generation := cached.generation
+	cached.mutex.Unlock()
+	if ok && cached.now().Before(entry.expiresAt) {
+		return nil, append([]data.Stock(nil), entry.stocks...)
+	}
+
+	err, stocks := cached.Inventory.GetInventory(ctx)
+	if err != nil {
+		return err, stocks
+	}
+	cached.mutex.Lock()
+	if generation == cached.generation {
+		cached.inventory[location] = cachedInventory{stocks: stocks, expiresAt: cached.now().Add(cached.ttl)}
+	}
+	cached.mutex.Unlock()
+	return nil, append([]data.Stock(nil), stocks...)
+}
+
+func (cached *CachedInventory) GetProductStock(ctx context.Context, includeDeleted bool) (error, data.ProductStocks) {
+	key := productStockKey{location: request.LocationFromContext(ctx), includeDeleted: includeDeleted}
+	cached.mutex.Lock()
+	entry, ok := cached.productStock[key]
+	generation := cached.generation
+	cached.mutex.Unlock()
+	if ok && cached.now().Before(entry.expiresAt) {
+		return nil, append(data.ProductStocks(nil), entry.stocks...)
+	}
+
+	err, stocks := cached.Inventory.GetProductStock(ctx, includeDeleted)
+	if err != nil {
+		return err, stocks
+	}
+	cached.mutex.Lock()
+	if generation == cached.generation {
+		cached.productStock[key] = cachedProductStock{stocks: stocks, expiresAt: cached.now().Add(cached.ttl)}
+	}
+	cached.mutex.Unlock()
+	return nil, append(data.ProductStocks(nil), stocks...)
+}
+
+//The calls below may change the stock even when they fail part way, so the cache is invalidated after each of them
+
+func (cached *CachedInventory) UploadProducts(ctx context.Context, product data.Products, continueOnError bool) (error, int) {
+	defer cached.Invalidate()
+	return cached.Inventory.UploadProducts(ctx, product, continueOnError)
+}
+
+func (cached *CachedInventory) UploadInventory(ctx context.Context, inventory data.Inventory) (error, int) {
+	defer cached.Invalidate()
+	return cached.Inventory.UploadInventory(ctx, inventory)
+}
+
+func (cached *CachedInventory) UploadCatalog(ctx context.Context, catalog data.Catalog) error {
+	defer cached.Invalidate()
+	return cached.Inventory.UploadCatalog(ctx, catalog)
+}
+
+func (cached *CachedInventory) SellProduct(ctx context.Context, productName string) error {
+	defer cached.Invalidate()
+	return cached.Inventory.SellProduct(ctx, productName)
+}
+
+func (cached *CachedInventory) ReturnProduct(ctx context.Context, productName string, quantity int) error {
+	defer cached.Invalidate()
+	return cached.Inventory.ReturnProduct(ctx, productName, quantity)
+}
+
+func (cached *CachedInventory) PlanFulfillment(ctx context.Context, order data.Order) ([]data.Allocation, error) {
+	defer cached.Invalidate()
+	return cached.Inventory.PlanFulfillment(ctx, order)
+}
+
+func (cached *CachedInventory) DeleteProduct(ctx context.Context, productName string) error {
+	defer cached.Invalidate()
+	return cached.Inventory.DeleteProduct(ctx, productName)
+}
+
+func (cached *CachedInventory) RestoreProduct(ctx context.Context, productName string) error {
+	defer cached.Invalidate()
+	return cached.Inventory.RestoreProduct(ctx, productName)
+}
+
+func (cached *CachedInventory) RenameProduct(ctx context.Context, oldName string, newName string) error {
+	defer cached.Invalidate()
+	return cached.Inventory.RenameProduct(ctx, oldName, newName)
+}
+
+func (cached *CachedInventory) AdjustArticles(ctx context.Context, adjustments []data.StockAdjustment, atomic bool) (int, error) {
+	defer cached.Invalidate()
+	return cached.Inventory.AdjustArticles(ctx, adjustments, atomic)
+}
+
+func (cached *CachedInventory) AdjustArticle(ctx context.Context, adjustment data.StockAdjustment, version int64) (data.Stock, int64, error) {
+	defer cached.Invalidate()
+	return cached.Inventory.AdjustArticle(ctx, adjustment, version)
+}
+
+func (cached *CachedInventory) DeleteArticles(ctx context.Context, artIds []string, force bool) (int, error) {
+	defer cached.Invalidate()
+	return cached.Inventory.DeleteArticles(ctx, artIds, force)
+}
+
+func (cached *CachedInventory) ResetInventory(ctx context.Context) (int, int, error) {
+	defer cached.Invalidate()
+	return cached.Inventory.ResetInventory(ctx)
+}
@@ -0,0 +1,107 @@
+package cache
+
+import (
+	"context"
+	"github.com/auknl/warehouse/data"
+	"github.com/auknl/warehouse/db/inventorymock"
+	"github.com/auknl/warehouse/request"
+	"gotest.tools/assert"
+	"strconv"
+	"testing"
+	"time"
+)
+
+//countingInventory returns an inventory mock whose stock changes on every query, so cached results can be told apart
+func countingInventory() *inventorymock.Inventory {
+	queries := 0
+	return &inventorymock.Inventory{
+		GetInventoryFunc: func(ctx context.Context) (error, []data.Stock) {
+			queries++
+			return nil, []data.Stock{{ArtId: "1", Name: "leg", Stock: data.Quantity(strconv.Itoa(queries))}}
+		},
+		GetProductStockFunc: func(ctx context.Context, includeDeleted bool) (error, data.ProductStocks) {
+			queries++
+			return nil, data.ProductStocks{{Name: "chair", AvailableProductNo: strconv.Itoa(queries)}}
+		},
+	}
+}
+
+func callsOf(inventory *inventorymock.Inventory, method string) int {
+	calls := 0
+	for _, call := range inventory.RecordedCalls() {
+		if call.Method == method {
+			calls++
+		}
+	}
+	return calls
+}
+
+func TestCachedInventory_Hit(t *testing.T) {
+	inventory := countingInventory()
+	cached := NewCachedInventory(inventory, time.Minute)
+	ctx := context.Background()
+
+	err, first := cached.GetProductStock(ctx, false)
+	assert.NilError(t, err)
+	err, second := cached.GetProductStock(ctx, false)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, second, first)
+	assert.Equal(t, callsOf(inventory, "GetProductStock"), 1)
+
+	//deleted products and other locations are cached separately
+	_, _ = cached.GetProductStock(ctx, true)
+	_, _ = cached.GetProductStock(request.WithLocation(ctx, "north"), false)
+	assert.Equal(t, callsOf(inventory, "GetProductStock"), 3)
+
+	_, _ = cached.GetInventory(ctx)
+	_, _ = cached.GetInventory(ctx)
+	assert.Equal(t, callsOf(inventory, "GetInventory"), 1)
+}
+
+func TestCachedInventory_Expiry(t *testing.T) {
+	inventory := countingInventory()
+	cached := NewCachedInventory(inventory, time.Minute)
+	now := time.Now()
+	cached.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	err, first := cached.GetInventory(ctx)
+	assert.NilError(t, err)
+	now = now.Add(59 * time.Second)
+	_, stocks := cached.GetInventory(ctx)
+	assert.DeepEqual(t, stocks, first)
+
+	now = now.Add(time.Second)
+	_, stocks = cached.GetInventory(ctx)
+	assert.DeepEqual(t, stocks, []data.Stock{{ArtId: "1", Name: "leg", Stock: "2"}})
+	assert.Equal(t, callsOf(inventory, "GetInventory"), 2)
+}
+
+func TestCachedInventory_InvalidatedBySale(t *testing.T) {
+	inventory := countingInventory()
+	cached := NewCachedInventory(inventory, time.Minute)
+	ctx := context.Background()
+
+	_, _ = cached.GetProductStock(ctx, false)
+	_, _ = cached.GetInventory(ctx)
+	err := cached.SellProduct(ctx, "chair")
+	assert.NilError(t, err)
+
+	_, productStock := cached.GetProductStock(ctx, false)
+	assert.DeepEqual(t, productStock, data.ProductStocks{{Name: "chair", AvailableProductNo: "3"}})
+	_, _ = cached.GetInventory(ctx)
+	assert.Equal(t, callsOf(inventory, "GetProductStock"), 2)
+	assert.Equal(t, callsOf(inventory, "GetInventory"), 2)
+
+	//a dry run does not change the stock
+	_, err = cached.PreviewSale(ctx, "chair")
+	assert.NilError(t, err)
+	_, _ = cached.GetProductStock(ctx, false)
+	assert.Equal(t, callsOf(inventory, "GetProductStock"), 2)
+
+	//a return puts the stock back
+	err = cached.ReturnProduct(ctx, "chair", 1)
+	assert.NilError(t, err)
+	_, _ = cached.GetProductStock(ctx, false)
+	assert.Equal(t, callsOf(inventory, "GetProductStock"), 3)
+}
@@ -0,0 +1,1237 @@
+//Package dbtest is the conformance suite of the db.Inventory backends, every backend runs the same scenarios against
+//an empty inventory of its own
+package dbtest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/auknl/warehouse/data"
+	"github.com/auknl/warehouse/db"
+	"github.com/auknl/warehouse/request"
+	"gotest.tools/assert"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+//Run runs every scenario as a subtest, each one with the empty inventory returned by newInventory
+func Run(t *testing.T, newInventory func(t *testing.T) db.Inventory) {
+	for _, scenario := range []struct {
+		name string
+		run  func(t *testing.T, inventory db.Inventory)
+	}{
+		{name: "EndToEnd", run: endToEnd},
+		{name: "EmptyResults", run: emptyResults},
+		{name: "GetStats", run: getStats},
+		{name: "GetAllProducts", run: getAllProducts},
+		{name: "GetProductsByNames", run: getProductsByNames},
+		{name: "RecipeVersions", run: recipeVersions},
+		{name: "RenameProduct", run: renameProduct},
+		{name: "AdjustArticle", run: adjustArticle},
+		{name: "PreviewSale", run: previewSale},
+		{name: "UnknownArticles", run: unknownArticles},
+		{name: "GetInventoryPage", run: getInventoryPage},
+		{name: "GetInventorySince", run: getInventorySince},
+		{name: "GetLowestStock", run: getLowestStock},
+		{name: "ReorderArticles", run: reorderArticles},
+		{name: "SearchArticles", run: searchArticles},
+		{name: "StreamInventory", run: streamInventory},
+		{name: "UploadProductsContinueOnError", run: uploadProductsContinueOnError},
+		{name: "UploadCatalog", run: uploadCatalog},
+		{name: "SentinelErrors", run: sentinelErrors},
+		{name: "GetArticleProducts", run: getArticleProducts},
+		{name: "CheckCart", run: checkCart},
+		{name: "DiffSnapshot", run: diffSnapshot},
+		{name: "GetStockByName", run: getStockByName},
+		{name: "IsProductBuildable", run: isProductBuildable},
+		{name: "DeleteArticles", run: deleteArticles},
+		{name: "Locations", run: locations},
+		{name: "GetProductAvailability", run: getProductAvailability},
+		{name: "FractionalStock", run: fractionalStock},
+		{name: "FractionalBuildable", run: fractionalBuildable},
+		{name: "GetSales", run: getSales},
+		{name: "ReturnProduct", run: returnProduct},
+		{name: "PlanFulfillment", run: planFulfillment},
+		{name: "ReserveProduct", run: reserveProduct},
+		{name: "ResetInventory", run: resetInventory},
+	} {
+		scenario := scenario
+		t.Run(scenario.name, func(t *testing.T) {
+			scenario.run(t, newInventory(t))
+		})
+	}
+}
+
+//testdata is the directory of the example files, next to the postgres backend they were first written for
+func testdata(name string) string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "..", "..", "postgres", "testdata", name)
+}
+
+//ExampleInventory reads the articles of postgres/testdata/example_inventory.json
+func ExampleInventory(t *testing.T) data.Inventory {
+	t.Helper()
+	var inventory data.Inventory
+	file, err := ioutil.ReadFile(testdata("example_inventory.json"))
+	assert.NilError(t, err)
+	assert.NilError(t, json.Unmarshal(file, &inventory))
+	return inventory
+}
+
+//ExampleProducts reads the products of postgres/testdata/example_products.json, made of the example articles
+func ExampleProducts(t *testing.T) data.Products {
+	t.Helper()
+	var products data.Products
+	file, err := ioutil.ReadFile(testdata("example_products.json"))
+	assert.NilError(t, err)
+	assert.NilError(t, json.Unmarshal(file, &products))
+	return products
+}
+
+func endToEnd(t *testing.T, inventory db.Inventory) {
+	ctx := context.Background()
+
+	inventoryData := ExampleInventory(t)
+	err, inserted := inventory.UploadInventory(ctx, inventoryData)
+	assert.NilError(t, err)
+	assert.Equal(t, inserted, len(inventoryData.Inventory))
+
+	products := ExampleProducts(t)
+	err, inserted = inventory.UploadProducts(ctx, products, false)
+	assert.NilError(t, err)
+	assert.Equal(t, inserted, len(products.Products))
+
+	err, stockOfProduct := inventory.GetProductStock(ctx, false)
+	assert.NilError(t, err)
+	assert.Equal(t, len(stockOfProduct), 2)
+
+	//Only one "Dinning Table" was in the stock, selling it
+	err = inventory.SellProduct(ctx, "Dinning Table")
+	assert.NilError(t, err)
+	err = inventory.SellProduct(ctx, "Dinning Table")
+	assert.Error(t, err, "this product is not in stock, cannot be sold")
+	err = inventory.SellProduct(ctx, "NotExist")
+	assert.Error(t, err, "this product is not in system, cannot be sold")
+
+	err, stocks := inventory.GetInventory(ctx)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, stocks, []data.Stock{
+		{ArtId: "1", Name: "leg", Stock: "8"},
+		{ArtId: "2", Name: "screw", Stock: "9"},
+		{ArtId: "3", Name: "seat", Stock: "2"},
+		{ArtId: "4", Name: "table top", Stock: "0"},
+	})
+
+	err, stockOfProduct = inventory.GetProductStock(ctx, false)
+	assert.NilError(t, err)
+	assert.Equal(t, len(stockOfProduct), 1)
+}
+
+func emptyResults(t *testing.T, inventory db.Inventory) {
+	ctx := context.Background()
+
+	//the empty results are lists, they are marshalled to [] instead of null
+	err, stocks := inventory.GetInventory(ctx)
+	assert.NilError(t, err)
+	inventoryJSON, err := json.Marshal(stocks)
+	assert.NilError(t, err)
+	assert.Equal(t, string(inventoryJSON), "[]")
+	err, productStocks := inventory.GetProductStock(ctx, true)
+	assert.NilError(t, err)
+	productsJSON, err := json.Marshal(productStocks)
+	assert.NilError(t, err)
+	assert.Equal(t, string(productsJSON), "[]")
+}
+
+func getStats(t *testing.T, inventory db.Inventory) {
+	ctx := context.Background()
+
+	stats, err := inventory.GetStats(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, stats, data.Stats{TotalStock: "0"})
+
+	err, _ = inventory.UploadInventory(ctx, data.Inventory{Inventory: []data.Stock{
+		{ArtId: "1", Name: "leg", Stock: "8"},
+		{ArtId: "2", Name: "seat", Stock: "0"},
+		{ArtId: "3", Name: "top", Stock: "1"},
+	}})
+	assert.NilError(t, err)
+	err, _ = inventory.UploadProducts(ctx, data.Products{Products: []data.Product{
+		{Name: "chair", ContainArticles: []data.ArticleContain{{ArtId: "1", AmountOf: "4"}, {ArtId: "2", AmountOf: "1"}}},
+		{Name: "table", ContainArticles: []data.ArticleContain{{ArtId: "1", AmountOf: "4"}, {ArtId: "3", AmountOf: "1"}}},
+	}}, false)
+	assert.NilError(t, err)
+
+	stats, err = inventory.GetStats(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, stats, data.Stats{TotalArticles: 3, TotalStock: "9", TotalProducts: 2, BuildableProducts: 1})
+}
+
+func getAllProducts(t *testing.T, inventory db.Inventory) {
+	ctx := context.Background()
+
+	products, err := inventory.GetAllProducts(ctx)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, products, data.ProductStocks{})
+
+	err, _ = inventory.UploadInventory(ctx, data.Inventory{Inventory: []data.Stock{
+		{ArtId: "1", Name: "leg", Stock: "8"},
+		{ArtId: "2", Name: "seat", Stock: "0"},
+		{ArtId: "3", Name: "top", Stock: "1"},
+	}})
+	assert.NilError(t, err)
+	err, _ = inventory.UploadProducts(ctx, data.Products{Products: []data.Product{
+		{Name: "chair", ContainArticles: []data.ArticleContain{{ArtId: "1", AmountOf: "4"}, {ArtId: "2", AmountOf: "1"}}},
+		{Name: "stool", ContainArticles: []data.ArticleContain{{ArtId: "1", AmountOf: "3"}}},
+		{Name: "table", ContainArticles: []data.ArticleContain{{ArtId: "1", AmountOf: "4"}, {ArtId: "3", AmountOf: "1"}}},
+	}}, false)
+	assert.NilError(t, err)
+	assert.NilError(t, inventory.DeleteProduct(ctx, "stool"))
+
+	//the chair cannot be built without seats, it is only hidden by GetProductStock
+	products, err = inventory.GetAllProducts(ctx)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, products, data.ProductStocks{{Name: "chair", AvailableProductNo: "0"}, {Name: "table", AvailableProductNo: "1"}})
+	err, products = inventory.GetProductStock(ctx, false)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, products, data.ProductStocks{{Name: "table", AvailableProductNo: "1"}})
+}
+
+func getProductsByNames(t *testing.T, inventory db.Inventory) {
+	ctx := context.Background()
+	err, _ := inventory.UploadInventory(ctx, data.Inventory{Inventory: []data.Stock{{ArtId: "1", Name: "leg", Stock: "8"}, {ArtId: "2", Name: "top", Stock: "0"}}})
+	assert.NilError(t, err)
+	err, _ = inventory.UploadProducts(ctx, data.Products{Products: []data.Product{
+		{Name: "chair", ContainArticles: []data.ArticleContain{{ArtId: "1", AmountOf: "4"}}},
+		{Name: "table", ContainArticles: []data.ArticleContain{{ArtId: "1", AmountOf: "4"}, {ArtId: "2", AmountOf: "1"}}},
+		{Name: "stool", ContainArticles: []data.ArticleContain{{ArtId: "1", AmountOf: "3"}}},
+	}}, false)
+	assert.NilError(t, err)
+	assert.NilError(t, inventory.DeleteProduct(ctx, "stool"))
+
+	stocks, err := inventory.GetProductsByNames(ctx, []string{"table", "sofa", "chair", "stool"})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, stocks, data.ProductStocks{{Name: "chair", AvailableProductNo: "2"}, {Name: "table", AvailableProductNo: "0"}})
+}
+
+func recipeVersions(t *testing.T, inventory db.Inventory) {
+	ctx := context.Background()
+	err, _ := inventory.UploadInventory(ctx, data.Inventory{Inventory: []data.Stock{{ArtId: "1", Name: "leg", Stock: "8"}, {ArtId: "2", Name: "seat", Stock: "2"}}})
+	assert.NilError(t, err)
+	err, _ = inventory.UploadProducts(ctx, data.Products{Products: []data.Product{
+		{Name: "chair", ContainArticles: []data.ArticleContain{{ArtId: "1", AmountOf: "4"}}},
+	}}, false)
+	assert.NilError(t, err)
+	product, err := inventory.GetProductArticles(ctx, "chair", 0)
+	assert.NilError(t, err)
+	assert.Equal(t, product.RecipeVersion, 1)
+
+	//the re-upload replaces the recipe of the product, the old version stays readable
+	err, _ = inventory.UploadProducts(ctx, data.Products{Products: []data.Product{
+		{Name: "chair", ContainArticles: []data.ArticleContain{{ArtId: "2", AmountOf: "1"}, {ArtId: "1", AmountOf: "3"}}},
+	}}, false)
+	assert.NilError(t, err)
+	product, err = inventory.GetProductArticles(ctx, "chair", 0)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, product, data.Product{Name: "chair", RecipeVersion: 2,
+		ContainArticles: []data.ArticleContain{{ArtId: "1", AmountOf: "3"}, {ArtId: "2", AmountOf: "1"}}})
+	product, err = inventory.GetProductArticles(ctx, "chair", 1)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, product, data.Product{Name: "chair", RecipeVersion: 1,
+		ContainArticles: []data.ArticleContain{{ArtId: "1", AmountOf: "4"}}})
+	products, err := inventory.GetAllProducts(ctx)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, products, data.ProductStocks{{Name: "chair", AvailableProductNo: "2"}})
+
+	_, err = inventory.GetProductArticles(ctx, "chair", 3)
+	assert.Assert(t, errors.Is(err, db.ErrProductNotFound))
+	assert.Error(t, err, "this product is not in system: chair recipe version 3")
+	_, err = inventory.GetProductArticles(ctx, "table", 0)
+	assert.Assert(t, errors.Is(err, db.ErrProductNotFound))
+
+	//the versions move with the name
+	assert.NilError(t, inventory.RenameProduct(ctx, "chair", "stool"))
+	product, err = inventory.GetProductArticles(ctx, "stool", 1)
+	assert.NilError(t, err)
+	assert.Equal(t, product.ContainArticles[0].AmountOf, data.Quantity("4"))
+	_, err = inventory.GetProductArticles(ctx, "chair", 1)
+	assert.Assert(t, errors.Is(err, db.ErrProductNotFound))
+
+	_, _, err = inventory.ResetInventory(ctx)
+	assert.NilError(t, err)
+	_, err = inventory.GetProductArticles(ctx, "stool", 1)
+	assert.Assert(t, errors.Is(err, db.ErrProductNotFound))
+}
+
+func renameProduct(t *testing.T, inventory db.Inventory) {
+	ctx := context.Background()
+	err, _ := inventory.UploadInventory(ctx, data.Inventory{Inventory: []data.Stock{{ArtId: "1", Name: "leg", Stock: "8"}, {ArtId: "2", Name: "top", Stock: "1"}}})
+	assert.NilError(t, err)
+	err, _ = inventory.UploadProducts(ctx, data.Products{Products: []data.Product{
+		{Name: "chair", ContainArticles: []data.ArticleContain{{ArtId: "1", AmountOf: "4"}}},
+		{Name: "table", ContainArticles: []data.ArticleContain{{ArtId: "1", AmountOf: "4"}, {ArtId: "2", AmountOf: "1"}}},
+		{Name: "stool", ContainArticles: []data.ArticleContain{{ArtId: "1", AmountOf: "3"}}},
+	}}, false)
+	assert.NilError(t, err)
+	assert.NilError(t, inventory.DeleteProduct(ctx, "stool"))
+
+	//every article row moves to the new name
+	assert.NilError(t, inventory.RenameProduct(ctx, "table", "desk"))
+	products, err := inventory.GetAllProducts(ctx)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, products, data.ProductStocks{{Name: "chair", AvailableProductNo: "2"}, {Name: "desk", AvailableProductNo: "1"}})
+	entries, err := inventory.GetAuditLog(ctx, "table", 10)
+	assert.NilError(t, err)
+	assert.Equal(t, entries[0].Operation, data.AuditRenameProduct)
+
+	err = inventory.RenameProduct(ctx, "chair", "desk")
+	assert.Assert(t, errors.Is(err, db.ErrProductExists))
+	//the name of a deleted product is still taken, it can be restored
+	err = inventory.RenameProduct(ctx, "chair", "stool")
+	assert.Assert(t, errors.Is(err, db.ErrProductExists))
+	err = inventory.RenameProduct(ctx, "table", "bench")
+	assert.Assert(t, errors.Is(err, db.ErrProductNotFound))
+	err = inventory.RenameProduct(ctx, "stool", "bench")
+	assert.Assert(t, errors.Is(err, db.ErrProductNotFound))
+	products, err = inventory.GetAllProducts(ctx)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, products, data.ProductStocks{{Name: "chair", AvailableProductNo: "2"}, {Name: "desk", AvailableProductNo: "1"}})
+}
+
+func adjustArticle(t *testing.T, inventory db.Inventory) {
+	ctx := context.Background()
+	err, _ := inventory.UploadInventory(ctx, data.Inventory{Inventory: []data.Stock{{ArtId: "1", Name: "leg", Stock: "12"}}})
+	assert.NilError(t, err)
+	stock, version, err := inventory.GetArticle(ctx, "1")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, stock, data.Stock{ArtId: "1", Name: "leg", Stock: "12"})
+
+	//the first operator updates the version they read
+	delta := data.Quantity("-2")
+	stock, updated, err := inventory.AdjustArticle(ctx, data.StockAdjustment{ArtId: "1", Delta: &delta}, version)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, stock, data.Stock{ArtId: "1", Name: "leg", Stock: "10"})
+	assert.Equal(t, updated, version+1)
+
+	//the second one read the same version, the update is refused instead of overwriting the first one
+	set := data.Quantity("20")
+	_, _, err = inventory.AdjustArticle(ctx, data.StockAdjustment{ArtId: "1", Stock: &set}, version)
+	assert.Assert(t, errors.Is(err, db.ErrVersionMismatch))
+	tooMuch := data.Quantity("-11")
+	_, _, err = inventory.AdjustArticle(ctx, data.StockAdjustment{ArtId: "1", Delta: &tooMuch}, updated)
+	assert.Assert(t, errors.Is(err, db.ErrNotEnoughStock))
+	_, _, err = inventory.AdjustArticle(ctx, data.StockAdjustment{ArtId: "9", Stock: &set}, updated)
+	assert.Assert(t, errors.Is(err, db.ErrArticleNotFound))
+	_, _, err = inventory.GetArticle(ctx, "9")
+	assert.Assert(t, errors.Is(err, db.ErrArticleNotFound))
+
+	//the other updates move the version as well
+	_, err = inventory.AdjustArticles(ctx, []data.StockAdjustment{{ArtId: "1", Stock: &set}}, true)
+	assert.NilError(t, err)
+	_, version, err = inventory.GetArticle(ctx, "1")
+	assert.NilError(t, err)
+	assert.Equal(t, version, updated+1)
+	_, _, err = inventory.AdjustArticle(ctx, data.StockAdjustment{ArtId: "1", Delta: &delta}, updated)
+	assert.Assert(t, errors.Is(err, db.ErrVersionMismatch))
+}
+
+func previewSale(t *testing.T, inventory db.Inventory) {
+	ctx := context.Background()
+
+	inventoryData := ExampleInventory(t)
+	err, _ := inventory.UploadInventory(ctx, inventoryData)
+	assert.NilError(t, err)
+	products := ExampleProducts(t)
+	err, _ = inventory.UploadProducts(ctx, products, false)
+	assert.NilError(t, err)
+
+	preview, err := inventory.PreviewSale(ctx, "Dinning Table")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, preview, data.SalePreview{Name: "Dinning Table", Sellable: true, Inventory: []data.Stock{
+		{ArtId: "1", Name: "leg", Stock: "8"},
+		{ArtId: "2", Name: "screw", Stock: "9"},
+		{ArtId: "4", Name: "table top", Stock: "0"},
+	}})
+
+	//the dry run is rolled back, so the table can still be sold once
+	err, stocks := inventory.GetInventory(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, stocks[3].Stock, data.Quantity("1"))
+	err = inventory.SellProduct(ctx, "Dinning Table")
+	assert.NilError(t, err)
+
+	preview, err = inventory.PreviewSale(ctx, "Dinning Table")
+	assert.NilError(t, err)
+	assert.Equal(t, preview.Sellable, false)
+	assert.Equal(t, preview.Reason, "this product is not in stock, cannot be sold")
+	assert.Equal(t, preview.Inventory[2].Stock, data.Quantity("0"))
+
+	preview, err = inventory.PreviewSale(ctx, "NotExist")
+	assert.NilError(t, err)
+	assert.Equal(t, preview.Sellable, false)
+	assert.Equal(t, preview.Reason, "this product is not in system, cannot be sold")
+	assert.Equal(t, len(preview.Inventory), 0)
+}
+
+func unknownArticles(t *testing.T, inventory db.Inventory) {
+	ctx := context.Background()
+
+	err, _ := inventory.UploadInventory(ctx, data.Inventory{Inventory: []data.Stock{
+		{ArtId: "1", Name: "leg", Stock: "8"},
+		{ArtId: "2", Name: "seat", Stock: "0"},
+	}})
+	assert.NilError(t, err)
+
+	unknown, err := inventory.UnknownArticles(ctx, []string{"1", "2"})
+	assert.NilError(t, err)
+	assert.Equal(t, len(unknown), 0)
+
+	unknown, err = inventory.UnknownArticles(ctx, []string{"9", "1", "3"})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, unknown, []string{"9", "3"})
+}
+
+func getInventoryPage(t *testing.T, inventory db.Inventory) {
+	ctx := context.Background()
+
+	inventoryData := ExampleInventory(t)
+	err, _ := inventory.UploadInventory(ctx, inventoryData)
+	assert.NilError(t, err)
+
+	page, next, err := inventory.GetInventoryPage(ctx, "", 2)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, page, inventoryData.Inventory[:2])
+	assert.Equal(t, next, "2")
+
+	//articles inserted before and after the cursor between the pages neither shift nor repeat the next page
+	err, _ = inventory.UploadInventory(ctx, data.Inventory{Inventory: []data.Stock{
+		{ArtId: "0", Name: "nail", Stock: "100"},
+		{ArtId: "5", Name: "backrest", Stock: "3"},
+	}})
+	assert.NilError(t, err)
+	seen := append([]data.Stock(nil), page...)
+	for next != "" {
+		page, next, err = inventory.GetInventoryPage(ctx, next, 2)
+		assert.NilError(t, err)
+		seen = append(seen, page...)
+	}
+	assert.DeepEqual(t, seen, append(append([]data.Stock(nil), inventoryData.Inventory...), data.Stock{ArtId: "5", Name: "backrest", Stock: "3"}))
+
+	_, _, err = inventory.GetInventoryPage(ctx, "", 0)
+	assert.Error(t, err, "page limit must be positive")
+}
+
+func getInventorySince(t *testing.T, inventory db.Inventory) {
+	ctx := context.Background()
+
+	inventoryData := ExampleInventory(t)
+	err, _ := inventory.UploadInventory(ctx, inventoryData)
+	assert.NilError(t, err)
+
+	changed, err := inventory.GetInventorySince(ctx, time.Time{})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, changed, inventoryData.Inventory)
+
+	//the sleeps keep the times of the changes apart from since, sqlite stores them in milliseconds
+	time.Sleep(5 * time.Millisecond)
+	since := time.Now()
+	changed, err = inventory.GetInventorySince(ctx, since)
+	assert.NilError(t, err)
+	assert.Equal(t, len(changed), 0)
+
+	time.Sleep(5 * time.Millisecond)
+	delta := data.Quantity("-2")
+	_, err = inventory.AdjustArticles(ctx, []data.StockAdjustment{{ArtId: "2", Delta: &delta}}, true)
+	assert.NilError(t, err)
+	err, _ = inventory.UploadInventory(ctx, data.Inventory{Inventory: []data.Stock{{ArtId: "5", Name: "backrest", Stock: "3"}}})
+	assert.NilError(t, err)
+	changed, err = inventory.GetInventorySince(ctx, since)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, changed, []data.Stock{{ArtId: "2", Name: "screw", Stock: "15"}, {ArtId: "5", Name: "backrest", Stock: "3"}})
+
+	//other locations have their own changes
+	changed, err = inventory.GetInventorySince(request.WithLocation(ctx, "north"), time.Time{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(changed), 0)
+}
+
+func getLowestStock(t *testing.T, inventory db.Inventory) {
+	ctx := context.Background()
+
+	inventoryData := ExampleInventory(t)
+	err, _ := inventory.UploadInventory(ctx, inventoryData)
+	assert.NilError(t, err)
+
+	lowest, err := inventory.GetLowestStock(ctx, 2)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, lowest, []data.Stock{{ArtId: "4", Name: "table top", Stock: "1"}, {ArtId: "3", Name: "seat", Stock: "2"}})
+
+	//stock is compared as a number, ties are ordered by art_id
+	err, _ = inventory.UploadInventory(ctx, data.Inventory{Inventory: []data.Stock{{ArtId: "0", Name: "fabric", Stock: "1"}, {ArtId: "5", Name: "glue", Stock: "0.5"}}})
+	assert.NilError(t, err)
+	lowest, err = inventory.GetLowestStock(ctx, 10)
+	assert.NilError(t, err)
+	artIds := make([]string, 0, len(lowest))
+	for _, stock := range lowest {
+		artIds = append(artIds, stock.ArtId)
+	}
+	assert.DeepEqual(t, artIds, []string{"5", "0", "4", "3", "1", "2"})
+}
+
+func reorderArticles(t *testing.T, inventory db.Inventory) {
+	ctx := context.Background()
+
+	inventoryData := ExampleInventory(t)
+	err, _ := inventory.UploadInventory(ctx, inventoryData)
+	assert.NilError(t, err)
+	_, version, err := inventory.GetArticle(ctx, "3")
+	assert.NilError(t, err)
+
+	//leg and screw stay above their reorder points, seat is below and table top is at it
+	for artId, levels := range map[string]data.ReorderLevels{
+		"1": {ReorderPoint: "10", ReorderTo: "20"},
+		"2": {ReorderPoint: "5", ReorderTo: "30"},
+		"3": {ReorderPoint: "4", ReorderTo: "10.5"},
+		"4": {ReorderPoint: "1", ReorderTo: "6"},
+	} {
+		assert.NilError(t, inventory.SetReorderLevels(ctx, artId, levels))
+	}
+	reorders, err := inventory.GetReorderArticles(ctx)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, reorders, []data.Reorder{
+		{ArtId: "3", Name: "seat", Stock: "2", ReorderPoint: "4", ReorderTo: "10.5", Quantity: "8.5"},
+		{ArtId: "4", Name: "table top", Stock: "1", ReorderPoint: "1", ReorderTo: "6", Quantity: "5"},
+	})
+	//the levels are not stock, the version is kept
+	_, after, err := inventory.GetArticle(ctx, "3")
+	assert.NilError(t, err)
+	assert.Equal(t, after, version)
+
+	//cleared levels are not reordered, the levels are kept per location
+	assert.NilError(t, inventory.SetReorderLevels(ctx, "3", data.ReorderLevels{}))
+	reorders, err = inventory.GetReorderArticles(ctx)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, reorders, []data.Reorder{{ArtId: "4", Name: "table top", Stock: "1", ReorderPoint: "1", ReorderTo: "6", Quantity: "5"}})
+	reorders, err = inventory.GetReorderArticles(request.WithLocation(ctx, "north"))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, reorders, []data.Reorder{})
+
+	err = inventory.SetReorderLevels(request.WithLocation(ctx, "north"), "3", data.ReorderLevels{ReorderPoint: "1", ReorderTo: "2"})
+	assert.Assert(t, errors.Is(err, db.ErrArticleNotFound))
+}
+
+func searchArticles(t *testing.T, inventory db.Inventory) {
+	ctx := context.Background()
+
+	err, _ := inventory.UploadInventory(ctx, data.Inventory{Inventory: []data.Stock{
+		{ArtId: "1", Name: "Chair leg", Stock: "12"},
+		{ArtId: "2", Name: "armchair cushion", Stock: "3"},
+		{ArtId: "3", Name: "table_top", Stock: "1"},
+		{ArtId: "4", Name: "tableXtop", Stock: "1"},
+		{ArtId: "5", Name: "100% wool cover", Stock: "2"},
+	}})
+	assert.NilError(t, err)
+
+	tests := []struct {
+		name  string
+		query string
+		limit int
+		want  []string
+	}{
+		{name: "case_insensitive", query: "CHAIR", limit: 20, want: []string{"1", "2"}},
+		{name: "limited", query: "chair", limit: 1, want: []string{"1"}},
+		{name: "no_match", query: "screw", limit: 20},
+		{name: "underscore_is_not_a_wildcard", query: "table_", limit: 20, want: []string{"3"}},
+		{name: "percent_is_not_a_wildcard", query: "%", limit: 20, want: []string{"5"}},
+		{name: "backslash", query: `\`, limit: 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stocks, err := inventory.SearchArticles(ctx, tt.query, tt.limit)
+			assert.NilError(t, err)
+			var artIds []string
+			for _, stock := range stocks {
+				artIds = append(artIds, stock.ArtId)
+			}
+			assert.DeepEqual(t, artIds, tt.want)
+		})
+	}
+}
+
+func streamInventory(t *testing.T, inventory db.Inventory) {
+	ctx := context.Background()
+
+	inventoryData := ExampleInventory(t)
+	err, _ := inventory.UploadInventory(ctx, inventoryData)
+	assert.NilError(t, err)
+
+	var streamed []data.Stock
+	err = inventory.StreamInventory(ctx, func(stock data.Stock) error {
+		streamed = append(streamed, stock)
+		return nil
+	})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, streamed, inventoryData.Inventory)
+
+	//streaming stops at the first error
+	streamed = nil
+	err = inventory.StreamInventory(ctx, func(stock data.Stock) error {
+		streamed = append(streamed, stock)
+		return errors.New("client is gone")
+	})
+	assert.Error(t, err, "client is gone")
+	assert.Equal(t, len(streamed), 1)
+}
+
+func uploadProductsContinueOnError(t *testing.T, inventory db.Inventory) {
+	ctx := context.Background()
+
+	err, _ := inventory.UploadInventory(ctx, data.Inventory{Inventory: []data.Stock{{ArtId: "1", Name: "leg", Stock: "8"}}})
+	assert.NilError(t, err)
+	products := data.Products{Products: []data.Product{
+		{Name: "chair", ContainArticles: []data.ArticleContain{{ArtId: "1", AmountOf: "4"}}},
+		{Name: "table", ContainArticles: []data.ArticleContain{{ArtId: "1", AmountOf: "4"}, {ArtId: "9", AmountOf: "1"}}},
+		{Name: "stool", ContainArticles: []data.ArticleContain{{ArtId: "1", AmountOf: "3"}}},
+	}}
+
+	//all or nothing by default
+	err, inserted := inventory.UploadProducts(ctx, products, false)
+	assert.Error(t, err, "article is not in inventory: 9")
+	assert.Assert(t, errors.Is(err, db.ErrArticleNotFound))
+	assert.Equal(t, inserted, 0)
+	stats, err := inventory.GetStats(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, stats.TotalProducts, 0)
+
+	err, inserted = inventory.UploadProducts(ctx, products, true)
+	failures, ok := err.(data.ProductUploadErrors)
+	assert.Assert(t, ok)
+	assert.Equal(t, len(failures), 1)
+	assert.Equal(t, failures[0].Name, "table")
+	assert.Equal(t, inserted, 2)
+
+	//the article row of the failed product inserted before the failure is rolled back as well
+	err, stockOfProduct := inventory.GetProductStock(ctx, false)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, stockOfProduct, data.ProductStocks{
+		{Name: "chair", AvailableProductNo: "2"},
+		{Name: "stool", AvailableProductNo: "2"},
+	})
+}
+
+func uploadCatalog(t *testing.T, inventory db.Inventory) {
+	ctx := context.Background()
+
+	//the products can refer to the articles uploaded with them
+	err := inventory.UploadCatalog(ctx, data.Catalog{
+		Inventory: []data.Stock{{ArtId: "1", Name: "leg", Stock: "12"}, {ArtId: "2", Name: "seat", Stock: "2"}},
+		Products:  []data.Product{{Name: "chair", ContainArticles: []data.ArticleContain{{ArtId: "1", AmountOf: "4"}, {ArtId: "2", AmountOf: "1"}}}},
+	})
+	assert.NilError(t, err)
+	products, err := inventory.GetAllProducts(ctx)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, products, data.ProductStocks{{Name: "chair", AvailableProductNo: "2"}})
+
+	//a product referring to an article in neither the catalog nor the inventory rolls back the articles as well
+	err = inventory.UploadCatalog(ctx, data.Catalog{
+		Inventory: []data.Stock{{ArtId: "3", Name: "top", Stock: "1"}},
+		Products: []data.Product{
+			{Name: "table", ContainArticles: []data.ArticleContain{{ArtId: "1", AmountOf: "4"}, {ArtId: "3", AmountOf: "1"}}},
+			{Name: "stool", ContainArticles: []data.ArticleContain{{ArtId: "9", AmountOf: "3"}}},
+		},
+	})
+	assert.Assert(t, errors.Is(err, db.ErrArticleNotFound))
+	err, stocks := inventory.GetInventory(ctx)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, stocks, []data.Stock{{ArtId: "1", Name: "leg", Stock: "12"}, {ArtId: "2", Name: "seat", Stock: "2"}})
+	products, err = inventory.GetAllProducts(ctx)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, products, data.ProductStocks{{Name: "chair", AvailableProductNo: "2"}})
+
+	//an article already in inventory fails the catalog before its products
+	err = inventory.UploadCatalog(ctx, data.Catalog{
+		Inventory: []data.Stock{{ArtId: "2", Name: "seat", Stock: "5"}},
+		Products:  []data.Product{{Name: "bench", ContainArticles: []data.ArticleContain{{ArtId: "2", AmountOf: "2"}}}},
+	})
+	assert.Assert(t, err != nil)
+	products, err = inventory.GetAllProducts(ctx)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, products, data.ProductStocks{{Name: "chair", AvailableProductNo: "2"}})
+}
+
+func sentinelErrors(t *testing.T, inventory db.Inventory) {
+	ctx := context.Background()
+
+	err, _ := inventory.UploadInventory(ctx, data.Inventory{Inventory: []data.Stock{{ArtId: "1", Name: "leg", Stock: "0"}}})
+	assert.NilError(t, err)
+	err, _ = inventory.UploadProducts(ctx, data.Products{Products: []data.Product{
+		{Name: "chair", ContainArticles: []data.ArticleContain{{ArtId: "1", AmountOf: "4"}}},
+	}}, false)
+	assert.NilError(t, err)
+
+	err = inventory.SellProduct(ctx, "chair")
+	assert.Assert(t, errors.Is(err, db.ErrOutOfStock))
+	assert.Assert(t, !errors.Is(err, db.ErrProductNotFound))
+	err = inventory.SellProduct(ctx, "NotExist")
+	assert.Assert(t, errors.Is(err, db.ErrProductNotFound))
+	err = inventory.DeleteProduct(ctx, "NotExist")
+	assert.Assert(t, errors.Is(err, db.ErrProductNotFound))
+	assert.Error(t, err, "this product is not in system, cannot be deleted")
+
+	err, _ = inventory.UploadProducts(ctx, data.Products{Products: []data.Product{
+		{Name: "stool", ContainArticles: []data.ArticleContain{{ArtId: "1", AmountOf: "2"}, {ArtId: "1", AmountOf: "1"}}},
+	}}, false)
+	assert.Assert(t, errors.Is(err, db.ErrDuplicateProductArticle))
+	assert.Error(t, err, "product already contains the article: product stool, article 1")
+}
+
+func getArticleProducts(t *testing.T, inventory db.Inventory) {
+	ctx := context.Background()
+	err, _ := inventory.UploadInventory(ctx, data.Inventory{Inventory: []data.Stock{
+		{ArtId: "1", Name: "leg", Stock: "12"}, {ArtId: "2", Name: "seat", Stock: "2"}, {ArtId: "3", Name: "screw", Stock: "17"}, {ArtId: "5", Name: "paint", Stock: "1"},
+	}})
+	assert.NilError(t, err)
+	err, _ = inventory.UploadProducts(ctx, data.Products{Products: []data.Product{
+		{Name: "bench", ContainArticles: []data.ArticleContain{{ArtId: "1", AmountOf: "5"}}},
+		{Name: "chair", ContainArticles: []data.ArticleContain{{ArtId: "1", AmountOf: "4"}, {ArtId: "2", AmountOf: "1"}, {ArtId: "3", AmountOf: "8"}}},
+		{Name: "desk", ContainArticles: []data.ArticleContain{{ArtId: "1", AmountOf: "6"}, {ArtId: "2", AmountOf: "1"}}},
+		{Name: "shelf", ContainArticles: []data.ArticleContain{{ArtId: "3", AmountOf: "2"}}},
+		{Name: "stand", ContainArticles: []data.ArticleContain{{ArtId: "1", AmountOf: "4"}, {ArtId: "3", AmountOf: "1"}}},
+		{Name: "stool", ContainArticles: []data.ArticleContain{{ArtId: "1", AmountOf: "3"}}},
+	}}, false)
+	assert.NilError(t, err)
+	assert.NilError(t, inventory.DeleteProduct(ctx, "stool"))
+
+	//the legs limit bench and stand only, the seats limit chair and desk as much as the legs do
+	products, err := inventory.GetArticleProducts(ctx, "1")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, products, []data.ArticleProduct{
+		{Name: "bench", AmountOf: "5", Buildable: 2, GainPerUnit: "0.2"},
+		{Name: "chair", AmountOf: "4", Buildable: 2, GainPerUnit: "0"},
+		{Name: "desk", AmountOf: "6", Buildable: 2, GainPerUnit: "0"},
+		{Name: "stand", AmountOf: "4", Buildable: 3, GainPerUnit: "0.25"},
+	})
+	products, err = inventory.GetArticleProducts(ctx, "3")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, products, []data.ArticleProduct{
+		{Name: "chair", AmountOf: "8", Buildable: 2, GainPerUnit: "0"},
+		{Name: "shelf", AmountOf: "2", Buildable: 8, GainPerUnit: "0.5"},
+		{Name: "stand", AmountOf: "1", Buildable: 3, GainPerUnit: "0"},
+	})
+	products, err = inventory.GetArticleProducts(ctx, "5")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, products, []data.ArticleProduct{})
+	_, err = inventory.GetArticleProducts(ctx, "4")
+	assert.Assert(t, errors.Is(err, db.ErrArticleNotFound))
+}
+
+func checkCart(t *testing.T, inventory db.Inventory) {
+	ctx := context.Background()
+	err, _ := inventory.UploadInventory(ctx, data.Inventory{Inventory: []data.Stock{
+		{ArtId: "1", Name: "leg", Stock: "12"}, {ArtId: "2", Name: "seat", Stock: "3"}, {ArtId: "3", Name: "top", Stock: "1"},
+	}})
+	assert.NilError(t, err)
+	err, _ = inventory.UploadProducts(ctx, data.Products{Products: []data.Product{
+		{Name: "chair", ContainArticles: []data.ArticleContain{{ArtId: "1", AmountOf: "4"}, {ArtId: "2", AmountOf: "1"}}},
+		{Name: "table", ContainArticles: []data.ArticleContain{{ArtId: "1", AmountOf: "4"}, {ArtId: "3", AmountOf: "1"}}},
+	}}, false)
+	assert.NilError(t, err)
+	_, err = inventory.ReserveProduct(ctx, "table", 1, time.Now().Add(time.Hour))
+	assert.NilError(t, err)
+
+	//the reserved table holds 4 legs and the top, 2 chairs are left to promise
+	availability, err := inventory.CheckCart(ctx, data.Cart{{Name: "chair", Quantity: 2}, {Name: "table", Quantity: 1}, {Name: "sofa", Quantity: 1}})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, availability, data.CartAvailability{
+		Lines: []data.CartLineAvailability{
+			{Name: "chair", Requested: 2, Available: 2, Sufficient: true},
+			{Name: "table", Requested: 1, Available: 0, Sufficient: false},
+			{Name: "sofa", Requested: 1},
+		},
+		NotFound: []string{"sofa"},
+	})
+	availability, err = inventory.CheckCart(ctx, data.Cart{{Name: "chair", Quantity: 1}, {Name: "chair", Quantity: 1}})
+	assert.NilError(t, err)
+	assert.Equal(t, availability.Sufficient, true)
+	availability, err = inventory.CheckCart(ctx, data.Cart{{Name: "chair", Quantity: 2}, {Name: "chair", Quantity: 1}})
+	assert.NilError(t, err)
+	assert.Equal(t, availability.Sufficient, false)
+
+	//nothing is reserved or sold by the checks
+	buildable, maxBuildable, err := inventory.IsProductBuildable(ctx, "chair", 3)
+	assert.NilError(t, err)
+	assert.Equal(t, buildable, true)
+	assert.Equal(t, maxBuildable, 3)
+}
+
+func diffSnapshot(t *testing.T, inventory db.Inventory) {
+	ctx := context.Background()
+	err, _ := inventory.UploadInventory(ctx, data.Inventory{Inventory: []data.Stock{
+		{ArtId: "1", Name: "leg", Stock: "12"}, {ArtId: "2", Name: "seat", Stock: "3"}, {ArtId: "3", Name: "top", Stock: "1.5"},
+	}})
+	assert.NilError(t, err)
+	snapshot, err := inventory.CreateSnapshot(ctx, "monday")
+	assert.NilError(t, err)
+	assert.Equal(t, snapshot.Label, "monday")
+	assert.Equal(t, snapshot.Articles, 3)
+	_, err = inventory.CreateSnapshot(ctx, "monday")
+	assert.Assert(t, errors.Is(err, db.ErrSnapshotExists))
+	north := request.WithLocation(ctx, "north")
+	_, err = inventory.CreateSnapshot(north, "monday")
+	assert.NilError(t, err)
+
+	//the seat is unchanged, legs are taken, the top is removed and screws are added
+	delta := data.Quantity("-4")
+	_, err = inventory.AdjustArticles(ctx, []data.StockAdjustment{{ArtId: "1", Delta: &delta}}, true)
+	assert.NilError(t, err)
+	_, err = inventory.DeleteArticles(ctx, []string{"3"}, false)
+	assert.NilError(t, err)
+	err, _ = inventory.UploadInventory(ctx, data.Inventory{Inventory: []data.Stock{{ArtId: "4", Name: "screw", Stock: "2.25"}}})
+	assert.NilError(t, err)
+
+	diffed, deltas, err := inventory.DiffSnapshot(ctx, "monday")
+	assert.NilError(t, err)
+	assert.Equal(t, diffed.Articles, 3)
+	assert.Assert(t, diffed.CreatedAt.Equal(snapshot.CreatedAt))
+	assert.DeepEqual(t, deltas, []data.StockDelta{
+		{ArtId: "1", Name: "leg", Before: "12", After: "8", Delta: "-4"},
+		{ArtId: "3", Name: "top", Before: "1.5", After: "0", Delta: "-1.5"},
+		{ArtId: "4", Name: "screw", Before: "0", After: "2.25", Delta: "2.25"},
+	})
+	//the snapshot of the other location is diffed against its own stock
+	_, deltas, err = inventory.DiffSnapshot(north, "monday")
+	assert.NilError(t, err)
+	assert.Equal(t, len(deltas), 0)
+	_, _, err = inventory.DiffSnapshot(ctx, "tuesday")
+	assert.Assert(t, errors.Is(err, db.ErrSnapshotNotFound))
+}
+
+func getStockByName(t *testing.T, inventory db.Inventory) {
+	ctx := context.Background()
+	err, _ := inventory.UploadInventory(ctx, data.Inventory{Inventory: []data.Stock{
+		{ArtId: "leg-oak", Name: "leg", Stock: "12"}, {ArtId: "leg-pine", Name: "leg", Stock: "2.5"}, {ArtId: "top", Name: "top", Stock: "1"},
+	}})
+	assert.NilError(t, err)
+	north := request.WithLocation(ctx, "north")
+	err, _ = inventory.UploadInventory(north, data.Inventory{Inventory: []data.Stock{{ArtId: "leg-oak", Name: "leg", Stock: "100"}}})
+	assert.NilError(t, err)
+
+	stocks, err := inventory.GetStockByName(ctx)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, stocks, []data.NameStock{
+		{Name: "leg", Stock: "14.5", Articles: 2},
+		{Name: "top", Stock: "1", Articles: 1},
+	})
+	//only the stock of the location is summed
+	stocks, err = inventory.GetStockByName(request.WithLocation(ctx, "south"))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, stocks, []data.NameStock{})
+}
+
+func isProductBuildable(t *testing.T, inventory db.Inventory) {
+	ctx := context.Background()
+
+	err, _ := inventory.UploadInventory(ctx, data.Inventory{Inventory: []data.Stock{
+		{ArtId: "1", Name: "leg", Stock: "30"},
+		{ArtId: "2", Name: "seat", Stock: "7"},
+	}})
+	assert.NilError(t, err)
+	err, _ = inventory.UploadProducts(ctx, data.Products{Products: []data.Product{
+		{Name: "chair", ContainArticles: []data.ArticleContain{{ArtId: "1", AmountOf: "4"}, {ArtId: "2", AmountOf: "1"}}},
+	}}, false)
+	assert.NilError(t, err)
+
+	//floor(30/4)=7 legs and 7 seats
+	buildable, maxBuildable, err := inventory.IsProductBuildable(ctx, "chair", 7)
+	assert.NilError(t, err)
+	assert.Equal(t, buildable, true)
+	assert.Equal(t, maxBuildable, 7)
+
+	buildable, _, err = inventory.IsProductBuildable(ctx, "chair", 8)
+	assert.NilError(t, err)
+	assert.Equal(t, buildable, false)
+
+	_, _, err = inventory.IsProductBuildable(ctx, "NotExist", 1)
+	assert.Error(t, err, "this product is not in system")
+	assert.Assert(t, errors.Is(err, db.ErrProductNotFound))
+}
+
+func deleteArticles(t *testing.T, inventory db.Inventory) {
+	ctx := context.Background()
+
+	inventoryData := ExampleInventory(t)
+	err, _ := inventory.UploadInventory(ctx, inventoryData)
+	assert.NilError(t, err)
+	products := ExampleProducts(t)
+	err, _ = inventory.UploadProducts(ctx, products, false)
+	assert.NilError(t, err)
+
+	//seat is only used by "Dining Chair", table top only by "Dinning Table"
+	deleted, err := inventory.DeleteArticles(ctx, []string{"3", "4"}, false)
+	assert.DeepEqual(t, err, data.ArticlesInUse{"Dining Chair", "Dinning Table"})
+	assert.Equal(t, deleted, 0)
+	err, stocks := inventory.GetInventory(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, len(stocks), 4)
+
+	deleted, err = inventory.DeleteArticles(ctx, []string{"4", "NotExist"}, true)
+	assert.NilError(t, err)
+	assert.Equal(t, deleted, 1)
+	err, stocks = inventory.GetInventory(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, len(stocks), 3)
+	err, stockOfProduct := inventory.GetProductStock(ctx, false)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, stockOfProduct, data.ProductStocks{{Name: "Dining Chair", AvailableProductNo: "2"}})
+	//the blocking product is soft deleted, it is kept and can be restored
+	err = inventory.RestoreProduct(ctx, "Dinning Table")
+	assert.NilError(t, err)
+}
+
+func locations(t *testing.T, inventory db.Inventory) {
+	north := request.WithLocation(context.Background(), "north")
+	south := request.WithLocation(context.Background(), "south")
+
+	err, _ := inventory.UploadInventory(north, data.Inventory{Inventory: []data.Stock{
+		{ArtId: "1", Name: "leg", Stock: "4"},
+		{ArtId: "2", Name: "seat", Stock: "1"},
+	}})
+	assert.NilError(t, err)
+	//the same article can be stocked in every location
+	err, _ = inventory.UploadInventory(south, data.Inventory{Inventory: []data.Stock{{ArtId: "1", Name: "leg", Stock: "8"}}})
+	assert.NilError(t, err)
+	err, _ = inventory.UploadProducts(north, data.Products{Products: []data.Product{
+		{Name: "chair", ContainArticles: []data.ArticleContain{{ArtId: "1", AmountOf: "4"}, {ArtId: "2", AmountOf: "1"}}},
+	}}, false)
+	assert.NilError(t, err)
+
+	err, stock := inventory.GetInventory(south)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, stock, []data.Stock{{ArtId: "1", Name: "leg", Stock: "8"}})
+	err, stock = inventory.GetInventory(context.Background())
+	assert.NilError(t, err)
+	assert.Equal(t, len(stock), 0)
+
+	//south has no seats, the chair can only be sold from north
+	err, productStock := inventory.GetProductStock(south, false)
+	assert.NilError(t, err)
+	assert.Equal(t, len(productStock), 0)
+	assert.Assert(t, errors.Is(inventory.SellProduct(south, "chair"), db.ErrOutOfStock))
+
+	assert.NilError(t, inventory.SellProduct(north, "chair"))
+	err, stock = inventory.GetInventory(north)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, stock, []data.Stock{{ArtId: "1", Name: "leg", Stock: "0"}, {ArtId: "2", Name: "seat", Stock: "0"}})
+	err, stock = inventory.GetInventory(south)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, stock, []data.Stock{{ArtId: "1", Name: "leg", Stock: "8"}})
+}
+
+func getProductAvailability(t *testing.T, inventory db.Inventory) {
+	north := request.WithLocation(context.Background(), "north")
+	south := request.WithLocation(context.Background(), "south")
+
+	err, _ := inventory.UploadInventory(north, data.Inventory{Inventory: []data.Stock{
+		{ArtId: "1", Name: "leg", Stock: "12"},
+		{ArtId: "2", Name: "seat", Stock: "2"},
+	}})
+	assert.NilError(t, err)
+	err, _ = inventory.UploadInventory(south, data.Inventory{Inventory: []data.Stock{
+		{ArtId: "1", Name: "leg", Stock: "21"},
+		{ArtId: "2", Name: "seat", Stock: "9"},
+	}})
+	assert.NilError(t, err)
+	err, _ = inventory.UploadProducts(north, data.Products{Products: []data.Product{
+		{Name: "chair", ContainArticles: []data.ArticleContain{{ArtId: "1", AmountOf: "4"}, {ArtId: "2", AmountOf: "1"}}},
+	}}, false)
+	assert.NilError(t, err)
+
+	//north runs out of seats after 2 chairs, south of legs after floor(21/4)=5
+	availability, err := inventory.GetProductAvailability(context.Background(), "chair")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, availability, data.ProductAvailability{
+		Name:      "chair",
+		Locations: []data.LocationAvailability{{Location: "north", Available: 2}, {Location: "south", Available: 5}},
+		Total:     7,
+	})
+
+	_, err = inventory.GetProductAvailability(context.Background(), "NotExist")
+	assert.Assert(t, errors.Is(err, db.ErrProductNotFound))
+}
+
+func fractionalStock(t *testing.T, inventory db.Inventory) {
+	ctx := context.Background()
+
+	err, _ := inventory.UploadInventory(ctx, data.Inventory{Inventory: []data.Stock{{ArtId: "1", Name: "fabric", Stock: "2.5"}}})
+	assert.NilError(t, err)
+	err, _ = inventory.UploadProducts(ctx, data.Products{Products: []data.Product{
+		{Name: "cushion", ContainArticles: []data.ArticleContain{{ArtId: "1", AmountOf: "0.75"}}},
+	}}, false)
+	assert.NilError(t, err)
+
+	//every sale takes 0.75 of the fabric, the last 0.25 is not enough
+	for i := 0; i < 3; i++ {
+		assert.NilError(t, inventory.SellProduct(ctx, "cushion"))
+	}
+	assert.Assert(t, errors.Is(inventory.SellProduct(ctx, "cushion"), db.ErrOutOfStock))
+	err, stocks := inventory.GetInventory(ctx)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, stocks, []data.Stock{{ArtId: "1", Name: "fabric", Stock: "0.25"}})
+
+	delta, tooMuch := data.Quantity("-0.125"), data.Quantity("-1")
+	adjusted, err := inventory.AdjustArticles(ctx, []data.StockAdjustment{{ArtId: "1", Delta: &delta}}, true)
+	assert.NilError(t, err)
+	assert.Equal(t, adjusted, 1)
+	_, err = inventory.AdjustArticles(ctx, []data.StockAdjustment{{ArtId: "1", Delta: &tooMuch}}, true)
+	assert.Assert(t, err != nil)
+	err, stocks = inventory.GetInventory(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, stocks[0].Stock, data.Quantity("0.125"))
+}
+
+func fractionalBuildable(t *testing.T, inventory db.Inventory) {
+	ctx := context.Background()
+
+	err, _ := inventory.UploadInventory(ctx, data.Inventory{Inventory: []data.Stock{
+		{ArtId: "1", Name: "fabric", Stock: "2.5"}, {ArtId: "2", Name: "foam", Stock: "1.5"}, {ArtId: "3", Name: "thread", Stock: "0.999"},
+	}})
+	assert.NilError(t, err)
+	err, _ = inventory.UploadProducts(ctx, data.Products{Products: []data.Product{
+		{Name: "cushion", ContainArticles: []data.ArticleContain{{ArtId: "1", AmountOf: "0.75"}}},
+		{Name: "pillow", ContainArticles: []data.ArticleContain{{ArtId: "1", AmountOf: "0.5"}, {ArtId: "2", AmountOf: "0.3"}}},
+		{Name: "seam", ContainArticles: []data.ArticleContain{{ArtId: "3", AmountOf: "0.001"}}},
+	}}, false)
+	assert.NilError(t, err)
+
+	//the counts are floored, 2.5/0.75 is 3 and the exact 1.5/0.3 and 2.5/0.5 are 5, not one less
+	err, productStocks := inventory.GetProductStock(ctx, false)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, productStocks, data.ProductStocks{{Name: "cushion", AvailableProductNo: "3"}, {Name: "pillow", AvailableProductNo: "5"},
+		{Name: "seam", AvailableProductNo: "999"}})
+	buildable, maxBuildable, err := inventory.IsProductBuildable(ctx, "cushion", 4)
+	assert.NilError(t, err)
+	assert.Equal(t, buildable, false)
+	assert.Equal(t, maxBuildable, 3)
+	products, err := inventory.GetArticleProducts(ctx, "1")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, products, []data.ArticleProduct{
+		{Name: "cushion", AmountOf: "0.75", Buildable: 3, GainPerUnit: "1.333"},
+		{Name: "pillow", AmountOf: "0.5", Buildable: 5, GainPerUnit: "0"},
+	})
+}
+
+func getSales(t *testing.T, inventory db.Inventory) {
+	north := request.WithLocation(context.Background(), "north")
+	south := request.WithLocation(context.Background(), "south")
+	for _, ctx := range []context.Context{north, south} {
+		err, _ := inventory.UploadInventory(ctx, data.Inventory{Inventory: []data.Stock{
+			{ArtId: "1", Name: "leg", Stock: "30"},
+			{ArtId: "2", Name: "seat", Stock: "7"},
+		}})
+		assert.NilError(t, err)
+	}
+	err, _ := inventory.UploadProducts(north, data.Products{Products: []data.Product{
+		{Name: "chair", ContainArticles: []data.ArticleContain{{ArtId: "1", AmountOf: "4"}, {ArtId: "2", AmountOf: "1"}}},
+		{Name: "stool", ContainArticles: []data.ArticleContain{{ArtId: "1", AmountOf: "3"}}},
+	}}, false)
+	assert.NilError(t, err)
+
+	before := time.Now().Add(-time.Second)
+	assert.NilError(t, inventory.SellProduct(north, "stool"))
+	assert.NilError(t, inventory.SellProduct(north, "chair"))
+	assert.NilError(t, inventory.SellProduct(north, "chair"))
+	assert.NilError(t, inventory.SellProduct(south, "stool"))
+	//refused and previewed sales are not recorded
+	assert.Assert(t, errors.Is(inventory.SellProduct(north, "NotExist"), db.ErrProductNotFound))
+	_, err = inventory.PreviewSale(north, "chair")
+	assert.NilError(t, err)
+
+	sales, err := inventory.GetSales(north, time.Time{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(sales), 2)
+	assert.Equal(t, sales[0].Name, "chair")
+	assert.Equal(t, sales[0].Sold, 2)
+	assert.Equal(t, sales[1].Name, "stool")
+	assert.Equal(t, sales[1].Sold, 1)
+	assert.Assert(t, sales[0].FirstSoldAt.After(before))
+	assert.Assert(t, !sales[0].LastSoldAt.Before(sales[0].FirstSoldAt))
+
+	sales, err = inventory.GetSales(south, time.Time{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(sales), 1)
+	assert.Equal(t, sales[0].Name, "stool")
+
+	sales, err = inventory.GetSales(north, time.Now().Add(time.Hour))
+	assert.NilError(t, err)
+	assert.Equal(t, len(sales), 0)
+}
+
+func returnProduct(t *testing.T, inventory db.Inventory) {
+	north := request.WithLocation(context.Background(), "north")
+	south := request.WithLocation(context.Background(), "south")
+	original := []data.Stock{{ArtId: "1", Name: "leg", Stock: "30"}, {ArtId: "2", Name: "seat", Stock: "7.5"}}
+	err, _ := inventory.UploadInventory(north, data.Inventory{Inventory: original})
+	assert.NilError(t, err)
+	err, _ = inventory.UploadInventory(south, data.Inventory{Inventory: []data.Stock{{ArtId: "1", Name: "leg", Stock: "8"}}})
+	assert.NilError(t, err)
+	err, _ = inventory.UploadProducts(north, data.Products{Products: []data.Product{
+		{Name: "chair", ContainArticles: []data.ArticleContain{{ArtId: "1", AmountOf: "4"}, {ArtId: "2", AmountOf: "1.5"}}},
+	}}, false)
+	assert.NilError(t, err)
+
+	//the returned chairs restore the stock the sales took
+	assert.NilError(t, inventory.SellProduct(north, "chair"))
+	assert.NilError(t, inventory.SellProduct(north, "chair"))
+	assert.NilError(t, inventory.ReturnProduct(north, "chair", 2))
+	err, stocks := inventory.GetInventory(north)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, stocks, original)
+
+	sales, err := inventory.GetSales(north, time.Time{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(sales), 1)
+	assert.Equal(t, sales[0].Sold, 0)
+	entries, err := inventory.GetAuditLog(north, "chair", 2)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, []string{entries[0].Operation, entries[0].ArtId, string(entries[0].StockBefore), string(entries[0].StockAfter)},
+		[]string{data.AuditReturnProduct, "2", "4.5", "7.5"})
+
+	//the seat is not stocked in south, nothing is returned there
+	err = inventory.ReturnProduct(south, "chair", 1)
+	assert.Assert(t, errors.Is(err, db.ErrArticleNotFound))
+	err, stocks = inventory.GetInventory(south)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, stocks, []data.Stock{{ArtId: "1", Name: "leg", Stock: "8"}})
+
+	assert.Assert(t, errors.Is(inventory.ReturnProduct(north, "NotExist", 1), db.ErrProductNotFound))
+	assert.Error(t, inventory.ReturnProduct(north, "chair", 0), "return quantity must be positive")
+}
+
+func planFulfillment(t *testing.T, inventory db.Inventory) {
+	ctx := context.Background()
+	north := request.WithLocation(ctx, "north")
+	err, _ := inventory.UploadInventory(ctx, data.Inventory{Inventory: []data.Stock{{ArtId: "1", Name: "leg", Stock: "8"}, {ArtId: "2", Name: "seat", Stock: "5"}}})
+	assert.NilError(t, err)
+	err, _ = inventory.UploadInventory(north, data.Inventory{Inventory: []data.Stock{{ArtId: "1", Name: "leg", Stock: "20"}, {ArtId: "2", Name: "seat", Stock: "3"}}})
+	assert.NilError(t, err)
+	err, _ = inventory.UploadProducts(ctx, data.Products{Products: []data.Product{
+		{Name: "chair", ContainArticles: []data.ArticleContain{{ArtId: "1", AmountOf: "4"}, {ArtId: "2", AmountOf: "1"}}},
+	}}, false)
+	assert.NilError(t, err)
+
+	//neither location can build 4 chairs, the nearest one builds 2 and north the others
+	allocations, err := inventory.PlanFulfillment(ctx, data.Order{Lines: data.Cart{{Name: "chair", Quantity: 4}}, Strategy: data.FulfillNearest})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, allocations, []data.Allocation{{Location: "default", Name: "chair", Quantity: 2}, {Location: "north", Name: "chair", Quantity: 2}})
+	err, stocks := inventory.GetInventory(ctx)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, stocks, []data.Stock{{ArtId: "1", Name: "leg", Stock: "0"}, {ArtId: "2", Name: "seat", Stock: "3"}})
+	err, stocks = inventory.GetInventory(north)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, stocks, []data.Stock{{ArtId: "1", Name: "leg", Stock: "12"}, {ArtId: "2", Name: "seat", Stock: "1"}})
+	sales, err := inventory.GetSales(north, time.Time{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(sales), 1)
+	assert.Equal(t, sales[0].Sold, 2)
+
+	//the last chair of north is more than the locations together have left, nothing is sold
+	_, err = inventory.PlanFulfillment(ctx, data.Order{Lines: data.Cart{{Name: "chair", Quantity: 2}}, Strategy: data.FulfillMostStock})
+	assert.Assert(t, errors.Is(err, db.ErrOutOfStock))
+	err, stocks = inventory.GetInventory(north)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, stocks, []data.Stock{{ArtId: "1", Name: "leg", Stock: "12"}, {ArtId: "2", Name: "seat", Stock: "1"}})
+
+	//the listed locations are the only ones taken from
+	_, err = inventory.PlanFulfillment(ctx, data.Order{Lines: data.Cart{{Name: "chair", Quantity: 1}}, Locations: []string{"default"}})
+	assert.Assert(t, errors.Is(err, db.ErrOutOfStock))
+	allocations, err = inventory.PlanFulfillment(ctx, data.Order{Lines: data.Cart{{Name: "chair", Quantity: 1}}, Locations: []string{"north"}})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, allocations, []data.Allocation{{Location: "north", Name: "chair", Quantity: 1}})
+
+	_, err = inventory.PlanFulfillment(ctx, data.Order{Lines: data.Cart{{Name: "NotExist", Quantity: 1}}})
+	assert.Assert(t, errors.Is(err, db.ErrProductNotFound))
+}
+
+func reserveProduct(t *testing.T, inventory db.Inventory) {
+	north := request.WithLocation(context.Background(), "north")
+	south := request.WithLocation(context.Background(), "south")
+	err, _ := inventory.UploadInventory(north, data.Inventory{Inventory: []data.Stock{{ArtId: "1", Name: "leg", Stock: "16"}, {ArtId: "2", Name: "seat", Stock: "4"}}})
+	assert.NilError(t, err)
+	err, _ = inventory.UploadInventory(south, data.Inventory{Inventory: []data.Stock{{ArtId: "1", Name: "leg", Stock: "4"}, {ArtId: "2", Name: "seat", Stock: "1"}}})
+	assert.NilError(t, err)
+	err, _ = inventory.UploadProducts(north, data.Products{Products: []data.Product{
+		{Name: "chair", ContainArticles: []data.ArticleContain{{ArtId: "1", AmountOf: "4"}, {ArtId: "2", AmountOf: "1"}}},
+		{Name: "stool", ContainArticles: []data.ArticleContain{{ArtId: "1", AmountOf: "3"}}},
+	}}, false)
+	assert.NilError(t, err)
+
+	//3 of the 4 chairs are reserved, only 1 is available to promise
+	reservation, err := inventory.ReserveProduct(north, "chair", 3, time.Now().Add(time.Hour))
+	assert.NilError(t, err)
+	assert.Equal(t, reservation.Name, "chair")
+	assert.Equal(t, reservation.Location, "north")
+	assert.Equal(t, reservation.Quantity, 3)
+	assert.NilError(t, inventory.SellProduct(north, "chair"))
+	assert.Assert(t, errors.Is(inventory.SellProduct(north, "chair"), db.ErrOutOfStock))
+	preview, err := inventory.PreviewSale(north, "chair")
+	assert.NilError(t, err)
+	assert.Equal(t, preview.Sellable, false)
+	//the legs the chairs hold are not promised to a stool either
+	assert.Assert(t, errors.Is(inventory.SellProduct(north, "stool"), db.ErrOutOfStock))
+	_, err = inventory.ReserveProduct(north, "chair", 1, time.Now().Add(time.Hour))
+	assert.ErrorContains(t, err, "only 0 of product chair can be reserved in north")
+	assert.Assert(t, errors.Is(err, db.ErrOutOfStock))
+	//the reservation is of north, the chair of south can be sold
+	assert.Assert(t, errors.Is(inventory.ReleaseReservation(south, reservation.Id), db.ErrReservationNotFound))
+	assert.NilError(t, inventory.SellProduct(south, "chair"))
+
+	//the released chairs can be sold again
+	assert.NilError(t, inventory.ReleaseReservation(north, reservation.Id))
+	assert.Assert(t, errors.Is(inventory.ReleaseReservation(north, reservation.Id), db.ErrReservationNotFound))
+	assert.NilError(t, inventory.SellProduct(north, "chair"))
+
+	//an expired reservation holds nothing
+	_, err = inventory.ReserveProduct(north, "chair", 2, time.Now().Add(20*time.Millisecond))
+	assert.NilError(t, err)
+	assert.Assert(t, errors.Is(inventory.SellProduct(north, "chair"), db.ErrOutOfStock))
+	time.Sleep(50 * time.Millisecond)
+	assert.NilError(t, inventory.SellProduct(north, "chair"))
+
+	_, err = inventory.ReserveProduct(north, "NotExist", 1, time.Now().Add(time.Hour))
+	assert.Assert(t, errors.Is(err, db.ErrProductNotFound))
+	_, err = inventory.ReserveProduct(north, "chair", 0, time.Now().Add(time.Hour))
+	assert.Error(t, err, "reservation quantity must be positive")
+	_, err = inventory.ReserveProduct(north, "chair", 1, time.Now())
+	assert.Error(t, err, "reservation must expire in the future")
+}
+
+func resetInventory(t *testing.T, inventory db.Inventory) {
+	north := request.WithLocation(context.Background(), "north")
+
+	err, _ := inventory.UploadInventory(context.Background(), data.Inventory{Inventory: []data.Stock{
+		{ArtId: "1", Name: "leg", Stock: "8"},
+		{ArtId: "2", Name: "seat", Stock: "2"},
+	}})
+	assert.NilError(t, err)
+	err, _ = inventory.UploadInventory(north, data.Inventory{Inventory: []data.Stock{{ArtId: "1", Name: "leg", Stock: "4"}}})
+	assert.NilError(t, err)
+	err, _ = inventory.UploadProducts(context.Background(), data.Products{Products: []data.Product{
+		{Name: "chair", ContainArticles: []data.ArticleContain{{ArtId: "1", AmountOf: "4"}, {ArtId: "2", AmountOf: "1"}}},
+	}}, false)
+	assert.NilError(t, err)
+
+	articles, products, err := inventory.ResetInventory(context.Background())
+	assert.NilError(t, err)
+	assert.Equal(t, articles, 3)
+	assert.Equal(t, products, 1)
+
+	err, stock := inventory.GetInventory(north)
+	assert.NilError(t, err)
+	assert.Equal(t, len(stock), 0)
+	err, productStock := inventory.GetProductStock(context.Background(), true)
+	assert.NilError(t, err)
+	assert.Equal(t, len(productStock), 0)
+}
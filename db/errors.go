@@ -0,0 +1,32 @@
+package db
+
+import (
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+)
+
+//Errors returned by the Inventory implementations, wrapped with the context they happen in. Callers match them
+//with errors.Is
+var (
+	ErrProductNotFound         = errors.New("this product is not in system")
+	ErrOutOfStock              = errors.New("this product is not in stock")
+	ErrArticleNotFound         = errors.New("article is not in inventory")
+	ErrDuplicateProductArticle = errors.New("product already contains the article")
+	ErrVersionMismatch         = errors.New("article is changed since the given version")
+	ErrNotEnoughStock          = errors.New("not enough stock for the given delta")
+	ErrProductExists           = errors.New("a product with this name is already in system")
+	ErrReservationNotFound     = errors.New("reservation is not in system")
+	ErrSnapshotNotFound        = errors.New("snapshot is not in system")
+	ErrSnapshotExists          = errors.New("a snapshot with this label is already in system")
+)
+
+//IsConnectionLost checks if err is a database connection that is lost or cannot be made, e.g. while the database
+//restarts. The request may succeed when it is sent again
+func IsConnectionLost(err error) bool {
+	var netErr *net.OpError
+	return errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.As(err, &netErr)
+}
@@ -0,0 +1,177 @@
+//Package events provides a db.Inventory decorator that publishes the stock changes to the subscribers of a Broker
+package events
+
+import (
+	"context"
+	"github.com/auknl/warehouse/data"
+	"github.com/auknl/warehouse/db"
+	"github.com/auknl/warehouse/request"
+	"sync"
+)
+
+var _ db.Inventory = (*PublishingInventory)(nil)
+
+//subscriberBuffer is the number of changes kept for a subscriber that is not reading, later ones are dropped
+const subscriberBuffer = 64
+
+//StockChange is the new stock of an article in a location
+type StockChange struct {
+	ArtId    string        `json:"art_id"`
+	Stock    data.Quantity `json:"stock"`
+	Location string        `json:"location"`
+}
+
+//Broker passes the published changes to every subscriber
+type Broker struct {
+	mutex       sync.Mutex
+	subscribers map[chan StockChange]struct{}
+}
+
+//NewBroker creates a Broker without subscribers
+func NewBroker() *Broker {
+	return &Broker{subscribers: map[chan StockChange]struct{}{}}
+}
+
+//Subscribe returns the channel of the changes published from now on, unsubscribe closes it
+func (broker *Broker) Subscribe() (<-chan StockChange, func()) {
+	changes := make(chan StockChange, subscriberBuffer)
+	broker.mutex.Lock()
+	broker.subscribers[changes] = struct{}{}
+	broker.mutex.Unlock()
+	var once sync.Once
+	return changes, func() {
+		once.Do(func() {
+			broker.mutex.Lock()
+			delete(broker.subscribers, changes)
+			broker.mutex.Unlock()
+			close(changes)
+		})
+	}
+}
+
+//Subscribed tells if anyone listens to the changes, nothing has to be published otherwise
+func (broker *Broker) Subscribed() bool {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	return len(broker.subscribers) != 0
+}
+
+//Publish passes the change to the subscribers without waiting, subscribers with a full buffer miss it
+func (broker *Broker) Publish(change StockChange) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	for subscriber := range broker.subscribers {
+		select {
+		case subscriber <- change:
+		default:
+		}
+	}
+}
+
+//PublishingInventory publishes the new stock of the articles changed by uploads, sales and adjustments of the
+//wrapped inventory. The stock is read back after the change, only while the broker has subscribers. DeleteArticles and
+//ResetInventory are not published, the removed articles have no stock to read back
+type PublishingInventory struct {
+	db.Inventory
+	broker *Broker
+}
+
+//NewPublishingInventory wraps the inventory so its stock changes are published to the broker
+func NewPublishingInventory(inventory db.Inventory, broker *Broker) *PublishingInventory {
+	return &PublishingInventory{Inventory: inventory, broker: broker}
+}
+
+//publishArticles reads the stock of the articles in the location of ctx and publishes it, articles that cannot be
+//read are skipped
+func (publishing *PublishingInventory) publishArticles(ctx context.Context, artIds []string) {
+	for _, artId := range artIds {
+		stock, _, err := publishing.Inventory.GetArticle(ctx, artId)
+		if err != nil {
+			continue
+		}
+		publishing.broker.Publish(StockChange{ArtId: stock.ArtId, Stock: stock.Stock, Location: request.LocationFromContext(ctx)})
+	}
+}
+
+func (publishing *PublishingInventory) UploadInventory(ctx context.Context, inventory data.Inventory) (error, int) {
+	err, inserted := publishing.Inventory.UploadInventory(ctx, inventory)
+	if err == nil && publishing.broker.Subscribed() {
+		artIds := make([]string, 0, len(inventory.Inventory))
+		for _, stock := range inventory.Inventory {
+			artIds = append(artIds, stock.ArtId)
+		}
+		publishing.publishArticles(ctx, artIds)
+	}
+	return err, inserted
+}
+
+func (publishing *PublishingInventory) UploadCatalog(ctx context.Context, catalog data.Catalog) error {
+	err := publishing.Inventory.UploadCatalog(ctx, catalog)
+	if err == nil && publishing.broker.Subscribed() {
+		artIds := make([]string, 0, len(catalog.Inventory))
+		for _, stock := range catalog.Inventory {
+			artIds = append(artIds, stock.ArtId)
+		}
+		publishing.publishArticles(ctx, artIds)
+	}
+	return err
+}
+
+func (publishing *PublishingInventory) SellProduct(ctx context.Context, productName string) error {
+	err := publishing.Inventory.SellProduct(ctx, productName)
+	if err == nil && publishing.broker.Subscribed() {
+		publishing.publishProduct(ctx, productName)
+	}
+	return err
+}
+
+func (publishing *PublishingInventory) ReturnProduct(ctx context.Context, productName string, quantity int) error {
+	err := publishing.Inventory.ReturnProduct(ctx, productName, quantity)
+	if err == nil && publishing.broker.Subscribed() {
+		publishing.publishProduct(ctx, productName)
+	}
+	return err
+}
+
+func (publishing *PublishingInventory) PlanFulfillment(ctx context.Context, order data.Order) ([]data.Allocation, error) {
+	allocations, err := publishing.Inventory.PlanFulfillment(ctx, order)
+	if err == nil && publishing.broker.Subscribed() {
+		for _, allocation := range allocations {
+			publishing.publishProduct(request.WithLocation(ctx, allocation.Location), allocation.Name)
+		}
+	}
+	return allocations, err
+}
+
+//publishProduct publishes the stock of the articles of the latest recipe of the product
+func (publishing *PublishingInventory) publishProduct(ctx context.Context, productName string) {
+	product, err := publishing.Inventory.GetProductArticles(ctx, productName, 0)
+	if err != nil {
+		return
+	}
+	artIds := make([]string, 0, len(product.ContainArticles))
+	for _, article := range product.ContainArticles {
+		artIds = append(artIds, article.ArtId)
+	}
+	publishing.publishArticles(ctx, artIds)
+}
+
+func (publishing *PublishingInventory) AdjustArticles(ctx context.Context, adjustments []data.StockAdjustment, atomic bool) (int, error) {
+	updated, err := publishing.Inventory.AdjustArticles(ctx, adjustments, atomic)
+	if updated != 0 && publishing.broker.Subscribed() {
+		artIds := make([]string, 0, len(adjustments))
+		for _, adjustment := range adjustments {
+			artIds = append(artIds, adjustment.ArtId)
+		}
+		publishing.publishArticles(ctx, artIds)
+	}
+	return updated, err
+}
+
+func (publishing *PublishingInventory) AdjustArticle(ctx context.Context, adjustment data.StockAdjustment, version int64) (data.Stock, int64, error) {
+	stock, updated, err := publishing.Inventory.AdjustArticle(ctx, adjustment, version)
+	if err == nil {
+		publishing.broker.Publish(StockChange{ArtId: stock.ArtId, Stock: stock.Stock, Location: request.LocationFromContext(ctx)})
+	}
+	return stock, updated, err
+}
@@ -0,0 +1,94 @@
+package events
+
+import (
+	"context"
+	"github.com/auknl/warehouse/data"
+	"github.com/auknl/warehouse/db/inventorymock"
+	"github.com/auknl/warehouse/request"
+	"gotest.tools/assert"
+	"testing"
+)
+
+//stockInventory returns an inventory mock of a chair made of the articles 1 and 2
+func stockInventory() *inventorymock.Inventory {
+	return &inventorymock.Inventory{
+		GetProductArticlesFunc: func(ctx context.Context, productName string, version int) (data.Product, error) {
+			return data.Product{Name: productName, ContainArticles: []data.ArticleContain{{ArtId: "1", AmountOf: "4"}, {ArtId: "2", AmountOf: "1"}}}, nil
+		},
+		GetArticleFunc: func(ctx context.Context, artId string) (data.Stock, int64, error) {
+			return data.Stock{ArtId: artId, Stock: data.Quantity(artId + "0")}, 1, nil
+		},
+	}
+}
+
+func TestPublishingInventory_SellProduct(t *testing.T) {
+	inventory := stockInventory()
+	broker := NewBroker()
+	publishing := NewPublishingInventory(inventory, broker)
+	ctx := request.WithLocation(context.Background(), "north")
+
+	//nothing is read back without subscribers
+	assert.NilError(t, publishing.SellProduct(ctx, "chair"))
+	assert.Equal(t, len(inventory.RecordedCalls()), 1)
+
+	changes, unsubscribe := broker.Subscribe()
+	assert.NilError(t, publishing.SellProduct(ctx, "chair"))
+	assert.DeepEqual(t, <-changes, StockChange{ArtId: "1", Stock: "10", Location: "north"})
+	assert.DeepEqual(t, <-changes, StockChange{ArtId: "2", Stock: "20", Location: "north"})
+
+	unsubscribe()
+	_, open := <-changes
+	assert.Equal(t, open, false)
+	assert.Equal(t, broker.Subscribed(), false)
+	unsubscribe()
+}
+
+func TestPublishingInventory_ReturnProduct(t *testing.T) {
+	inventory := stockInventory()
+	broker := NewBroker()
+	publishing := NewPublishingInventory(inventory, broker)
+	ctx := request.WithLocation(context.Background(), "north")
+	changes, unsubscribe := broker.Subscribe()
+	defer unsubscribe()
+
+	assert.NilError(t, publishing.ReturnProduct(ctx, "chair", 2))
+	assert.DeepEqual(t, <-changes, StockChange{ArtId: "1", Stock: "10", Location: "north"})
+	assert.DeepEqual(t, <-changes, StockChange{ArtId: "2", Stock: "20", Location: "north"})
+	assert.DeepEqual(t, inventory.RecordedCalls()[0], inventorymock.Call{Method: "ReturnProduct", Args: []interface{}{"chair", 2}})
+	//the articles come from the recipe, nothing is sold again to find them
+	assert.DeepEqual(t, inventory.RecordedCalls()[1], inventorymock.Call{Method: "GetProductArticles", Args: []interface{}{"chair", 0}})
+}
+
+func TestPublishingInventory_PlanFulfillment(t *testing.T) {
+	inventory := stockInventory()
+	inventory.PlanFulfillmentFunc = func(ctx context.Context, order data.Order) ([]data.Allocation, error) {
+		return []data.Allocation{{Location: "north", Name: "chair", Quantity: 2}, {Location: "south", Name: "chair", Quantity: 1}}, nil
+	}
+	broker := NewBroker()
+	publishing := NewPublishingInventory(inventory, broker)
+	changes, unsubscribe := broker.Subscribe()
+	defer unsubscribe()
+
+	//the stock is published in the locations the units are taken from
+	_, err := publishing.PlanFulfillment(context.Background(), data.Order{Lines: data.Cart{{Name: "chair", Quantity: 3}}})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, <-changes, StockChange{ArtId: "1", Stock: "10", Location: "north"})
+	assert.DeepEqual(t, <-changes, StockChange{ArtId: "2", Stock: "20", Location: "north"})
+	assert.DeepEqual(t, <-changes, StockChange{ArtId: "1", Stock: "10", Location: "south"})
+	assert.DeepEqual(t, <-changes, StockChange{ArtId: "2", Stock: "20", Location: "south"})
+}
+
+func TestBroker_Publish(t *testing.T) {
+	broker := NewBroker()
+	first, unsubscribeFirst := broker.Subscribe()
+	defer unsubscribeFirst()
+	second, unsubscribeSecond := broker.Subscribe()
+	defer unsubscribeSecond()
+
+	//a subscriber that does not read misses the changes over its buffer, the others are not blocked by it
+	for i := 0; i < subscriberBuffer+1; i++ {
+		broker.Publish(StockChange{ArtId: "1"})
+	}
+	assert.Equal(t, len(first), subscriberBuffer)
+	assert.Equal(t, len(second), subscriberBuffer)
+}
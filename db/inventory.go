@@ -2,15 +2,61 @@ package db
 
 import (
 	"context"
+	"database/sql"
 	"github.com/auknl/warehouse/data"
+	"time"
 )
 
+//SchemaVersion is the version of the last migration in db/migrations, the schema the queries of this binary are
+//written for
+const SchemaVersion = 14
+
 type Inventory interface {
 	Ping() error
+	PoolStats() sql.DBStats
 	Open() error
 	GetInventory(ctx context.Context) (error, []data.Stock)
-	GetProductStock(ctx context.Context) (error, data.ProductStocks)
-	UploadProducts(ctx context.Context, product data.Products) (error, int)
+	GetInventoryPage(ctx context.Context, after string, limit int) ([]data.Stock, string, error)
+	GetInventorySince(ctx context.Context, since time.Time) ([]data.Stock, error)
+	SearchArticles(ctx context.Context, query string, limit int) ([]data.Stock, error)
+	GetLowestStock(ctx context.Context, n int) ([]data.Stock, error)
+	StreamInventory(ctx context.Context, each func(stock data.Stock) error) error
+	GetProductStock(ctx context.Context, includeDeleted bool) (error, data.ProductStocks)
+	GetAllProducts(ctx context.Context) (data.ProductStocks, error)
+	GetProductsByNames(ctx context.Context, names []string) (data.ProductStocks, error)
+	GetCachedProductStock(ctx context.Context, includeDeleted bool) (data.ProductStocks, error)
+	RefreshProductStock(ctx context.Context) error
+	UploadProducts(ctx context.Context, product data.Products, continueOnError bool) (error, int)
 	UploadInventory(ctx context.Context, inventory data.Inventory) (error, int)
+	UploadCatalog(ctx context.Context, catalog data.Catalog) error
 	SellProduct(ctx context.Context, productName string) error
+	ReturnProduct(ctx context.Context, productName string, quantity int) error
+	ReserveProduct(ctx context.Context, productName string, quantity int, expiresAt time.Time) (data.Reservation, error)
+	ReleaseReservation(ctx context.Context, id int64) error
+	PreviewSale(ctx context.Context, productName string) (data.SalePreview, error)
+	IsProductBuildable(ctx context.Context, productName string, quantity int) (bool, int, error)
+	GetProductAvailability(ctx context.Context, productName string) (data.ProductAvailability, error)
+	DeleteProduct(ctx context.Context, productName string) error
+	RestoreProduct(ctx context.Context, productName string) error
+	RenameProduct(ctx context.Context, oldName string, newName string) error
+	GetArticle(ctx context.Context, artId string) (data.Stock, int64, error) //the version of the article is its ETag
+	AdjustArticles(ctx context.Context, adjustments []data.StockAdjustment, atomic bool) (int, error)
+	AdjustArticle(ctx context.Context, adjustment data.StockAdjustment, version int64) (data.Stock, int64, error)
+	DeleteArticles(ctx context.Context, artIds []string, force bool) (int, error)
+	ResetInventory(ctx context.Context) (int, int, error)
+	CheckIntegrity(ctx context.Context) (data.IntegrityReport, error)
+	GetAuditLog(ctx context.Context, entity string, limit int) ([]data.AuditEntry, error)
+	GetSales(ctx context.Context, since time.Time) ([]data.Sale, error)
+	GetStats(ctx context.Context) (data.Stats, error)
+	UnknownArticles(ctx context.Context, artIds []string) ([]string, error)
+	GetReorderArticles(ctx context.Context) ([]data.Reorder, error)
+	SetReorderLevels(ctx context.Context, artId string, levels data.ReorderLevels) error
+	GetProductArticles(ctx context.Context, productName string, version int) (data.Product, error)
+	GetSchemaVersion(ctx context.Context) (int, bool, error)
+	GetArticleProducts(ctx context.Context, artId string) ([]data.ArticleProduct, error)
+	CheckCart(ctx context.Context, cart data.Cart) (data.CartAvailability, error)
+	CreateSnapshot(ctx context.Context, label string) (data.Snapshot, error)
+	DiffSnapshot(ctx context.Context, label string) (data.Snapshot, []data.StockDelta, error)
+	GetStockByName(ctx context.Context) ([]data.NameStock, error)
+	PlanFulfillment(ctx context.Context, order data.Order) ([]data.Allocation, error)
 }
@@ -0,0 +1,464 @@
+//Package inventorymock provides a db.Inventory for tests that do not need a database
+package inventorymock
+
+import (
+	"context"
+	"database/sql"
+	"github.com/auknl/warehouse/data"
+	"github.com/auknl/warehouse/db"
+	"sync"
+	"time"
+)
+
+var _ db.Inventory = (*Inventory)(nil)
+
+//Call is a call made to the mock, Args are the arguments after the context
+type Call struct {
+	Method string
+	Args   []interface{}
+}
+
+//Inventory is a configurable db.Inventory. The result of a method is set with its Func field, methods without one
+//return zero values. Every call is recorded
+type Inventory struct {
+	PingFunc                   func() error
+	PoolStatsFunc              func() sql.DBStats
+	OpenFunc                   func() error
+	GetInventoryFunc           func(ctx context.Context) (error, []data.Stock)
+	GetInventoryPageFunc       func(ctx context.Context, after string, limit int) ([]data.Stock, string, error)
+	GetInventorySinceFunc      func(ctx context.Context, since time.Time) ([]data.Stock, error)
+	SearchArticlesFunc         func(ctx context.Context, query string, limit int) ([]data.Stock, error)
+	GetLowestStockFunc         func(ctx context.Context, n int) ([]data.Stock, error)
+	StreamInventoryFunc        func(ctx context.Context, each func(stock data.Stock) error) error
+	GetProductStockFunc        func(ctx context.Context, includeDeleted bool) (error, data.ProductStocks)
+	GetAllProductsFunc         func(ctx context.Context) (data.ProductStocks, error)
+	GetProductsByNamesFunc     func(ctx context.Context, names []string) (data.ProductStocks, error)
+	GetCachedProductStockFunc  func(ctx context.Context, includeDeleted bool) (data.ProductStocks, error)
+	RefreshProductStockFunc    func(ctx context.Context) error
+	UploadProductsFunc         func(ctx context.Context, product data.Products, continueOnError bool) (error, int)
+	UploadInventoryFunc        func(ctx context.Context, inventory data.Inventory) (error, int)
+	UploadCatalogFunc          func(ctx context.Context, catalog data.Catalog) error
+	SellProductFunc            func(ctx context.Context, productName string) error
+	ReturnProductFunc          func(ctx context.Context, productName string, quantity int) error
+	ReserveProductFunc         func(ctx context.Context, productName string, quantity int, expiresAt time.Time) (data.Reservation, error)
+	ReleaseReservationFunc     func(ctx context.Context, id int64) error
+	PreviewSaleFunc            func(ctx context.Context, productName string) (data.SalePreview, error)
+	IsProductBuildableFunc     func(ctx context.Context, productName string, quantity int) (bool, int, error)
+	GetProductAvailabilityFunc func(ctx context.Context, productName string) (data.ProductAvailability, error)
+	DeleteProductFunc          func(ctx context.Context, productName string) error
+	RestoreProductFunc         func(ctx context.Context, productName string) error
+	RenameProductFunc          func(ctx context.Context, oldName string, newName string) error
+	GetArticleFunc             func(ctx context.Context, artId string) (data.Stock, int64, error)
+	AdjustArticlesFunc         func(ctx context.Context, adjustments []data.StockAdjustment, atomic bool) (int, error)
+	AdjustArticleFunc          func(ctx context.Context, adjustment data.StockAdjustment, version int64) (data.Stock, int64, error)
+	DeleteArticlesFunc         func(ctx context.Context, artIds []string, force bool) (int, error)
+	ResetInventoryFunc         func(ctx context.Context) (int, int, error)
+	CheckIntegrityFunc         func(ctx context.Context) (data.IntegrityReport, error)
+	GetAuditLogFunc            func(ctx context.Context, entity string, limit int) ([]data.AuditEntry, error)
+	GetSalesFunc               func(ctx context.Context, since time.Time) ([]data.Sale, error)
+	GetStatsFunc               func(ctx context.Context) (data.Stats, error)
+	UnknownArticlesFunc        func(ctx context.Context, artIds []string) ([]string, error)
+	GetReorderArticlesFunc     func(ctx context.Context) ([]data.Reorder, error)
+	SetReorderLevelsFunc       func(ctx context.Context, artId string, levels data.ReorderLevels) error
+	GetProductArticlesFunc     func(ctx context.Context, productName string, version int) (data.Product, error)
+	GetSchemaVersionFunc       func(ctx context.Context) (int, bool, error)
+	GetArticleProductsFunc     func(ctx context.Context, artId string) ([]data.ArticleProduct, error)
+	CheckCartFunc              func(ctx context.Context, cart data.Cart) (data.CartAvailability, error)
+	CreateSnapshotFunc         func(ctx context.Context, label string) (data.Snapshot, error)
+	DiffSnapshotFunc           func(ctx context.Context, label string) (data.Snapshot, []data.StockDelta, error)
+	GetStockByNameFunc         func(ctx context.Context) ([]data.NameStock, error)
+	PlanFulfillmentFunc        func(ctx context.Context, order data.Order) ([]data.Allocation, error)
+
+	mutex sync.Mutex
+	calls []Call
+}
+
+//RecordedCalls returns the calls made to the mock in order
+func (inventory *Inventory) RecordedCalls() []Call {
+	inventory.mutex.Lock()
+	defer inventory.mutex.Unlock()
+	return append([]Call(nil), inventory.calls...)
+}
+
+//record keeps the call of the method
+func (inventory *Inventory) record(method string, args ...interface{}) {
+	inventory.mutex.Lock()
+	defer inventory.mutex.Unlock()
+	inventory.calls = append(inventory.calls, Call{Method: method, Args: args})
+}
+
+func (inventory *Inventory) Ping() error {
+	inventory.record("Ping")
+	if inventory.PingFunc == nil {
+		return nil
+	}
+	return inventory.PingFunc()
+}
+
+func (inventory *Inventory) PoolStats() sql.DBStats {
+	inventory.record("PoolStats")
+	if inventory.PoolStatsFunc == nil {
+		return sql.DBStats{}
+	}
+	return inventory.PoolStatsFunc()
+}
+
+func (inventory *Inventory) Open() error {
+	inventory.record("Open")
+	if inventory.OpenFunc == nil {
+		return nil
+	}
+	return inventory.OpenFunc()
+}
+
+func (inventory *Inventory) GetInventory(ctx context.Context) (error, []data.Stock) {
+	inventory.record("GetInventory")
+	if inventory.GetInventoryFunc == nil {
+		return nil, nil
+	}
+	return inventory.GetInventoryFunc(ctx)
+}
+
+func (inventory *Inventory) GetInventoryPage(ctx context.Context, after string, limit int) ([]data.Stock, string, error) {
+	inventory.record("GetInventoryPage", after, limit)
+	if inventory.GetInventoryPageFunc == nil {
+		return nil, "", nil
+	}
+	return inventory.GetInventoryPageFunc(ctx, after, limit)
+}
+
+func (inventory *Inventory) GetInventorySince(ctx context.Context, since time.Time) ([]data.Stock, error) {
+	inventory.record("GetInventorySince", since)
+	if inventory.GetInventorySinceFunc == nil {
+		return nil, nil
+	}
+	return inventory.GetInventorySinceFunc(ctx, since)
+}
+
+func (inventory *Inventory) SearchArticles(ctx context.Context, query string, limit int) ([]data.Stock, error) {
+	inventory.record("SearchArticles", query, limit)
+	if inventory.SearchArticlesFunc == nil {
+		return nil, nil
+	}
+	return inventory.SearchArticlesFunc(ctx, query, limit)
+}
+
+func (inventory *Inventory) GetLowestStock(ctx context.Context, n int) ([]data.Stock, error) {
+	inventory.record("GetLowestStock", n)
+	if inventory.GetLowestStockFunc == nil {
+		return nil, nil
+	}
+	return inventory.GetLowestStockFunc(ctx, n)
+}
+
+func (inventory *Inventory) StreamInventory(ctx context.Context, each func(stock data.Stock) error) error {
+	inventory.record("StreamInventory")
+	if inventory.StreamInventoryFunc == nil {
+		return nil
+	}
+	return inventory.StreamInventoryFunc(ctx, each)
+}
+
+func (inventory *Inventory) GetProductStock(ctx context.Context, includeDeleted bool) (error, data.ProductStocks) {
+	inventory.record("GetProductStock", includeDeleted)
+	if inventory.GetProductStockFunc == nil {
+		return nil, nil
+	}
+	return inventory.GetProductStockFunc(ctx, includeDeleted)
+}
+
+func (inventory *Inventory) GetAllProducts(ctx context.Context) (data.ProductStocks, error) {
+	inventory.record("GetAllProducts")
+	if inventory.GetAllProductsFunc == nil {
+		return nil, nil
+	}
+	return inventory.GetAllProductsFunc(ctx)
+}
+
+func (inventory *Inventory) GetProductsByNames(ctx context.Context, names []string) (data.ProductStocks, error) {
+	inventory.record("GetProductsByNames", names)
+	if inventory.GetProductsByNamesFunc == nil {
+		return nil, nil
+	}
+	return inventory.GetProductsByNamesFunc(ctx, names)
+}
+
+func (inventory *Inventory) GetCachedProductStock(ctx context.Context, includeDeleted bool) (data.ProductStocks, error) {
+	inventory.record("GetCachedProductStock", includeDeleted)
+	if inventory.GetCachedProductStockFunc == nil {
+		return nil, nil
+	}
+	return inventory.GetCachedProductStockFunc(ctx, includeDeleted)
+}
+
+func (inventory *Inventory) RefreshProductStock(ctx context.Context) error {
+	inventory.record("RefreshProductStock")
+	if inventory.RefreshProductStockFunc == nil {
+		return nil
+	}
+	return inventory.RefreshProductStockFunc(ctx)
+}
+
+func (inventory *Inventory) UploadProducts(ctx context.Context, product data.Products, continueOnError bool) (error, int) {
+	inventory.record("UploadProducts", product, continueOnError)
+	if inventory.UploadProductsFunc == nil {
+		return nil, 0
+	}
+	return inventory.UploadProductsFunc(ctx, product, continueOnError)
+}
+
+func (inventory *Inventory) UploadInventory(ctx context.Context, inventoryToInsert data.Inventory) (error, int) {
+	inventory.record("UploadInventory", inventoryToInsert)
+	if inventory.UploadInventoryFunc == nil {
+		return nil, 0
+	}
+	return inventory.UploadInventoryFunc(ctx, inventoryToInsert)
+}
+
+func (inventory *Inventory) UploadCatalog(ctx context.Context, catalog data.Catalog) error {
+	inventory.record("UploadCatalog", catalog)
+	if inventory.UploadCatalogFunc == nil {
+		return nil
+	}
+	return inventory.UploadCatalogFunc(ctx, catalog)
+}
+
+func (inventory *Inventory) SellProduct(ctx context.Context, productName string) error {
+	inventory.record("SellProduct", productName)
+	if inventory.SellProductFunc == nil {
+		return nil
+	}
+	return inventory.SellProductFunc(ctx, productName)
+}
+
+func (inventory *Inventory) ReturnProduct(ctx context.Context, productName string, quantity int) error {
+	inventory.record("ReturnProduct", productName, quantity)
+	if inventory.ReturnProductFunc == nil {
+		return nil
+	}
+	return inventory.ReturnProductFunc(ctx, productName, quantity)
+}
+
+func (inventory *Inventory) ReserveProduct(ctx context.Context, productName string, quantity int, expiresAt time.Time) (data.Reservation, error) {
+	inventory.record("ReserveProduct", productName, quantity, expiresAt)
+	if inventory.ReserveProductFunc == nil {
+		return data.Reservation{}, nil
+	}
+	return inventory.ReserveProductFunc(ctx, productName, quantity, expiresAt)
+}
+
+func (inventory *Inventory) ReleaseReservation(ctx context.Context, id int64) error {
+	inventory.record("ReleaseReservation", id)
+	if inventory.ReleaseReservationFunc == nil {
+		return nil
+	}
+	return inventory.ReleaseReservationFunc(ctx, id)
+}
+
+func (inventory *Inventory) PreviewSale(ctx context.Context, productName string) (data.SalePreview, error) {
+	inventory.record("PreviewSale", productName)
+	if inventory.PreviewSaleFunc == nil {
+		return data.SalePreview{}, nil
+	}
+	return inventory.PreviewSaleFunc(ctx, productName)
+}
+
+func (inventory *Inventory) IsProductBuildable(ctx context.Context, productName string, quantity int) (bool, int, error) {
+	inventory.record("IsProductBuildable", productName, quantity)
+	if inventory.IsProductBuildableFunc == nil {
+		return false, 0, nil
+	}
+	return inventory.IsProductBuildableFunc(ctx, productName, quantity)
+}
+
+func (inventory *Inventory) GetProductAvailability(ctx context.Context, productName string) (data.ProductAvailability, error) {
+	inventory.record("GetProductAvailability", productName)
+	if inventory.GetProductAvailabilityFunc == nil {
+		return data.ProductAvailability{}, nil
+	}
+	return inventory.GetProductAvailabilityFunc(ctx, productName)
+}
+
+func (inventory *Inventory) DeleteProduct(ctx context.Context, productName string) error {
+	inventory.record("DeleteProduct", productName)
+	if inventory.DeleteProductFunc == nil {
+		return nil
+	}
+	return inventory.DeleteProductFunc(ctx, productName)
+}
+
+func (inventory *Inventory) RestoreProduct(ctx context.Context, productName string) error {
+	inventory.record("RestoreProduct", productName)
+	if inventory.RestoreProductFunc == nil {
+		return nil
+	}
+	return inventory.RestoreProductFunc(ctx, productName)
+}
+
+func (inventory *Inventory) RenameProduct(ctx context.Context, oldName string, newName string) error {
+	inventory.record("RenameProduct", oldName, newName)
+	if inventory.RenameProductFunc == nil {
+		return nil
+	}
+	return inventory.RenameProductFunc(ctx, oldName, newName)
+}
+
+func (inventory *Inventory) AdjustArticles(ctx context.Context, adjustments []data.StockAdjustment, atomic bool) (int, error) {
+	inventory.record("AdjustArticles", adjustments, atomic)
+	if inventory.AdjustArticlesFunc == nil {
+		return 0, nil
+	}
+	return inventory.AdjustArticlesFunc(ctx, adjustments, atomic)
+}
+
+func (inventory *Inventory) GetArticle(ctx context.Context, artId string) (data.Stock, int64, error) {
+	inventory.record("GetArticle", artId)
+	if inventory.GetArticleFunc == nil {
+		return data.Stock{}, 0, nil
+	}
+	return inventory.GetArticleFunc(ctx, artId)
+}
+
+func (inventory *Inventory) AdjustArticle(ctx context.Context, adjustment data.StockAdjustment, version int64) (data.Stock, int64, error) {
+	inventory.record("AdjustArticle", adjustment, version)
+	if inventory.AdjustArticleFunc == nil {
+		return data.Stock{}, 0, nil
+	}
+	return inventory.AdjustArticleFunc(ctx, adjustment, version)
+}
+
+func (inventory *Inventory) DeleteArticles(ctx context.Context, artIds []string, force bool) (int, error) {
+	inventory.record("DeleteArticles", artIds, force)
+	if inventory.DeleteArticlesFunc == nil {
+		return 0, nil
+	}
+	return inventory.DeleteArticlesFunc(ctx, artIds, force)
+}
+
+func (inventory *Inventory) ResetInventory(ctx context.Context) (int, int, error) {
+	inventory.record("ResetInventory")
+	if inventory.ResetInventoryFunc == nil {
+		return 0, 0, nil
+	}
+	return inventory.ResetInventoryFunc(ctx)
+}
+
+func (inventory *Inventory) CheckIntegrity(ctx context.Context) (data.IntegrityReport, error) {
+	inventory.record("CheckIntegrity")
+	if inventory.CheckIntegrityFunc == nil {
+		return data.IntegrityReport{}, nil
+	}
+	return inventory.CheckIntegrityFunc(ctx)
+}
+
+func (inventory *Inventory) GetAuditLog(ctx context.Context, entity string, limit int) ([]data.AuditEntry, error) {
+	inventory.record("GetAuditLog", entity, limit)
+	if inventory.GetAuditLogFunc == nil {
+		return nil, nil
+	}
+	return inventory.GetAuditLogFunc(ctx, entity, limit)
+}
+
+func (inventory *Inventory) GetSales(ctx context.Context, since time.Time) ([]data.Sale, error) {
+	inventory.record("GetSales", since)
+	if inventory.GetSalesFunc == nil {
+		return nil, nil
+	}
+	return inventory.GetSalesFunc(ctx, since)
+}
+
+func (inventory *Inventory) GetStats(ctx context.Context) (data.Stats, error) {
+	inventory.record("GetStats")
+	if inventory.GetStatsFunc == nil {
+		return data.Stats{}, nil
+	}
+	return inventory.GetStatsFunc(ctx)
+}
+
+func (inventory *Inventory) UnknownArticles(ctx context.Context, artIds []string) ([]string, error) {
+	inventory.record("UnknownArticles", artIds)
+	if inventory.UnknownArticlesFunc == nil {
+		return nil, nil
+	}
+	return inventory.UnknownArticlesFunc(ctx, artIds)
+}
+
+func (inventory *Inventory) GetReorderArticles(ctx context.Context) ([]data.Reorder, error) {
+	inventory.record("GetReorderArticles")
+	if inventory.GetReorderArticlesFunc == nil {
+		return nil, nil
+	}
+	return inventory.GetReorderArticlesFunc(ctx)
+}
+
+func (inventory *Inventory) SetReorderLevels(ctx context.Context, artId string, levels data.ReorderLevels) error {
+	inventory.record("SetReorderLevels", artId, levels)
+	if inventory.SetReorderLevelsFunc == nil {
+		return nil
+	}
+	return inventory.SetReorderLevelsFunc(ctx, artId, levels)
+}
+
+func (inventory *Inventory) GetProductArticles(ctx context.Context, productName string, version int) (data.Product, error) {
+	inventory.record("GetProductArticles", productName, version)
+	if inventory.GetProductArticlesFunc == nil {
+		return data.Product{}, nil
+	}
+	return inventory.GetProductArticlesFunc(ctx, productName, version)
+}
+
+func (inventory *Inventory) GetSchemaVersion(ctx context.Context) (int, bool, error) {
+	inventory.record("GetSchemaVersion")
+	if inventory.GetSchemaVersionFunc == nil {
+		return 0, false, nil
+	}
+	return inventory.GetSchemaVersionFunc(ctx)
+}
+
+func (inventory *Inventory) GetArticleProducts(ctx context.Context, artId string) ([]data.ArticleProduct, error) {
+	inventory.record("GetArticleProducts", artId)
+	if inventory.GetArticleProductsFunc == nil {
+		return nil, nil
+	}
+	return inventory.GetArticleProductsFunc(ctx, artId)
+}
+
+func (inventory *Inventory) CheckCart(ctx context.Context, cart data.Cart) (data.CartAvailability, error) {
+	inventory.record("CheckCart", cart)
+	if inventory.CheckCartFunc == nil {
+		return data.CartAvailability{}, nil
+	}
+	return inventory.CheckCartFunc(ctx, cart)
+}
+
+func (inventory *Inventory) CreateSnapshot(ctx context.Context, label string) (data.Snapshot, error) {
+	inventory.record("CreateSnapshot", label)
+	if inventory.CreateSnapshotFunc == nil {
+		return data.Snapshot{}, nil
+	}
+	return inventory.CreateSnapshotFunc(ctx, label)
+}
+
+func (inventory *Inventory) DiffSnapshot(ctx context.Context, label string) (data.Snapshot, []data.StockDelta, error) {
+	inventory.record("DiffSnapshot", label)
+	if inventory.DiffSnapshotFunc == nil {
+		return data.Snapshot{}, nil, nil
+	}
+	return inventory.DiffSnapshotFunc(ctx, label)
+}
+
+func (inventory *Inventory) GetStockByName(ctx context.Context) ([]data.NameStock, error) {
+	inventory.record("GetStockByName")
+	if inventory.GetStockByNameFunc == nil {
+		return nil, nil
+	}
+	return inventory.GetStockByNameFunc(ctx)
+}
+
+func (inventory *Inventory) PlanFulfillment(ctx context.Context, order data.Order) ([]data.Allocation, error) {
+	inventory.record("PlanFulfillment", order)
+	if inventory.PlanFulfillmentFunc == nil {
+		return nil, nil
+	}
+	return inventory.PlanFulfillmentFunc(ctx, order)
+}
@@ -0,0 +1,10 @@
+DELETE FROM inventory
+WHERE location_id <> 'default';
+ALTER TABLE inventory
+    DROP CONSTRAINT IF EXISTS inventory_pkey;
+ALTER TABLE inventory
+    DROP COLUMN IF EXISTS location_id;
+ALTER TABLE inventory
+    ADD PRIMARY KEY (art_id);
+ALTER TABLE product
+    ADD CONSTRAINT product_art_id_fkey FOREIGN KEY (art_id) REFERENCES inventory (art_id);
@@ -0,0 +1,8 @@
+ALTER TABLE product
+    DROP CONSTRAINT IF EXISTS product_art_id_fkey;
+ALTER TABLE inventory
+    DROP CONSTRAINT IF EXISTS inventory_pkey;
+ALTER TABLE inventory
+    ADD COLUMN location_id VARCHAR(255) NOT NULL DEFAULT 'default';
+ALTER TABLE inventory
+    ADD PRIMARY KEY (location_id, art_id);
@@ -0,0 +1,4 @@
+ALTER TABLE inventory
+    ALTER COLUMN stock TYPE INT USING stock / 1000;
+ALTER TABLE product
+    ALTER COLUMN amount TYPE INT USING greatest(amount / 1000, 1);
@@ -0,0 +1,4 @@
+ALTER TABLE inventory
+    ALTER COLUMN stock TYPE BIGINT USING stock * 1000;
+ALTER TABLE product
+    ALTER COLUMN amount TYPE BIGINT USING amount * 1000;
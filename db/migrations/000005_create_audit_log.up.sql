@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS audit_log
+(
+    id           BIGSERIAL PRIMARY KEY,
+    operation    VARCHAR(64)  NOT NULL,
+    rid          VARCHAR(255) NOT NULL,
+    entity       VARCHAR(255) NOT NULL,
+    art_id       VARCHAR(255) NULL,
+    location_id  VARCHAR(255) NOT NULL,
+    stock_before BIGINT       NULL,
+    stock_after  BIGINT       NULL,
+    created_at   TIMESTAMPTZ  NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS audit_log_entity_idx ON audit_log (entity);
+CREATE INDEX IF NOT EXISTS audit_log_art_id_idx ON audit_log (art_id);
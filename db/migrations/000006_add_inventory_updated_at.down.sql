@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS inventory_updated_at_idx;
+ALTER TABLE inventory
+    DROP COLUMN IF EXISTS updated_at;
@@ -0,0 +1,3 @@
+ALTER TABLE inventory
+    ADD COLUMN updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
+CREATE INDEX IF NOT EXISTS inventory_updated_at_idx ON inventory (location_id, updated_at);
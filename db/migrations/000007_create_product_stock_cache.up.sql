@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS product_stock_cache
+(
+    location_id       VARCHAR(255) NOT NULL,
+    product_name      VARCHAR(255) NOT NULL,
+    available_product BIGINT       NOT NULL,
+    deleted           BOOLEAN      NOT NULL,
+    PRIMARY KEY (location_id, product_name)
+);
@@ -0,0 +1,2 @@
+ALTER TABLE inventory
+    DROP COLUMN IF EXISTS version;
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS sale
+(
+    id           BIGSERIAL PRIMARY KEY,
+    product_name VARCHAR(255) NOT NULL,
+    location_id  VARCHAR(255) NOT NULL,
+    sold_at      TIMESTAMPTZ  NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS sale_sold_at_idx ON sale (location_id, sold_at);
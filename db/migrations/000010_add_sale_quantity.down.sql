@@ -0,0 +1,4 @@
+DELETE FROM sale
+WHERE quantity < 0;
+ALTER TABLE sale
+    DROP COLUMN IF EXISTS quantity;
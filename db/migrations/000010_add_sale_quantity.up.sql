@@ -0,0 +1,2 @@
+ALTER TABLE sale
+    ADD COLUMN quantity BIGINT NOT NULL DEFAULT 1;
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS reservation
+(
+    id           BIGSERIAL PRIMARY KEY,
+    product_name VARCHAR(255) NOT NULL,
+    location_id  VARCHAR(255) NOT NULL,
+    quantity     BIGINT       NOT NULL CHECK (quantity > 0),
+    expires_at   TIMESTAMPTZ  NOT NULL
+);
+CREATE INDEX IF NOT EXISTS reservation_expires_at_idx ON reservation (location_id, expires_at);
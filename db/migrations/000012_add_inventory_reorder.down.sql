@@ -0,0 +1,4 @@
+ALTER TABLE inventory
+    DROP COLUMN IF EXISTS reorder_to;
+ALTER TABLE inventory
+    DROP COLUMN IF EXISTS reorder_point;
@@ -0,0 +1,4 @@
+ALTER TABLE inventory
+    ADD COLUMN reorder_point BIGINT NULL;
+ALTER TABLE inventory
+    ADD COLUMN reorder_to BIGINT NULL;
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS product_recipe
+(
+    product_name   VARCHAR(255) NOT NULL,
+    recipe_version BIGINT       NOT NULL,
+    art_id         VARCHAR(255) NOT NULL,
+    amount         BIGINT       NOT NULL CHECK (amount > 0),
+    created_at     TIMESTAMPTZ  NOT NULL DEFAULT now(),
+    PRIMARY KEY (product_name, recipe_version, art_id)
+);
+INSERT INTO product_recipe(product_name, recipe_version, art_id, amount)
+SELECT product_name, 1, art_id, amount
+FROM product;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS inventory_snapshot_stock;
+DROP TABLE IF EXISTS inventory_snapshot;
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS inventory_snapshot
+(
+    label       VARCHAR(255) NOT NULL,
+    location_id VARCHAR(255) NOT NULL,
+    created_at  TIMESTAMPTZ  NOT NULL DEFAULT now(),
+    PRIMARY KEY (location_id, label)
+);
+CREATE TABLE IF NOT EXISTS inventory_snapshot_stock
+(
+    label       VARCHAR(255) NOT NULL,
+    location_id VARCHAR(255) NOT NULL,
+    art_id      VARCHAR(255) NOT NULL,
+    art_name    VARCHAR(255) NOT NULL,
+    stock       BIGINT       NOT NULL,
+    PRIMARY KEY (location_id, label, art_id),
+    FOREIGN KEY (location_id, label) REFERENCES inventory_snapshot (location_id, label) ON DELETE CASCADE
+);
@@ -1,78 +1,134 @@
 package main
 
 import (
+	"context"
 	"github.com/auknl/warehouse/api"
+	"github.com/auknl/warehouse/config"
 	"github.com/auknl/warehouse/db"
+	"github.com/auknl/warehouse/db/cache"
+	"github.com/auknl/warehouse/memory"
 	"github.com/auknl/warehouse/postgres"
-	"github.com/kelseyhightower/envconfig"
+	"github.com/auknl/warehouse/sqlite"
 	_ "github.com/lib/pq"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"gopkg.in/natefinch/lumberjack.v2"
+	"io"
+	_ "modernc.org/sqlite"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
-//configuration keeps all config info for warehouse service
-type configuration struct {
-	LogLevel       string `mapstructure:"LOGLEVEL" default:"info"`
-	Version        string `mapstructure:"VERSION" required:"true"`
-	Environment    string `mapstructure:"ENVIRONMENT" required:"true"`
-	BackendTimeout string `mapstructure:"BACKENDTIMEOUT" default:"25s"`
-	ListenAddress  string `mapstructure:"LISTENADDRESS" default:":8080"`
-	DBDriver       string `mapstructure:"DBDRIVER" required:"true"`
-	DBHost         string `mapstructure:"DBHOST" required:"true"`
-	DBPort         string `mapstructure:"DBPORT" required:"true"`
-	DBUser         string `mapstructure:"DBUSER" required:"true"`
-	DBPassword     string `mapstructure:"DBPASSWORD" required:"true"`
-	DBName         string `mapstructure:"DBDBNAME" required:"true"`
-}
-
 func main() {
 	logger := initializeLogger()
 	config := setConfig(logger)
 
 	//logger related settings
-	lvl, err := logrus.ParseLevel(config.LogLevel)
-	if err == nil {
-		logger.SetLevel(lvl)
-	}
+	configureLogger(logger, config)
 	loggerEntry := logger.WithFields(logrus.Fields{
 		"release": config.Version,
 		"service": "inventory",
 	})
+	loggerEntry.WithFields(config.Redacted()).Info("configuration loaded")
+
+	shutdownTracer := initializeTracer(config, loggerEntry)
+	defer shutdownTracer()
 
 	var inventory db.Inventory
 
-	if config.DBDriver == "postgres" {
+	switch config.DBDriver {
+	case "postgres":
+		queryTimeout, _ := time.ParseDuration(config.DBQueryTimeout)           //not set is no timeout
+		validationTimeout, _ := time.ParseDuration(config.DBValidationTimeout) //checked by Validate
+		var queries postgres.Queries
+		if config.DBQueries != "" {
+			queries, _ = postgres.LoadQueries(config.DBQueries) //checked by Validate
+		}
 		config := postgres.Config{
-			Logger:   loggerEntry,
-			Driver:   config.DBDriver,
-			Host:     config.DBHost,
-			Port:     config.DBPort,
-			User:     config.DBUser,
-			Password: config.DBPassword,
-			Dbname:   config.DBName,
+			Logger:            loggerEntry,
+			Driver:            config.DBDriver,
+			Host:              config.DBHost,
+			Port:              config.DBPort,
+			User:              config.DBUser,
+			Password:          config.DBPassword,
+			Dbname:            config.DBName,
+			URL:               config.DBURL,
+			Retries:           config.DBRetries,
+			QueryTimeout:      queryTimeout,
+			Queries:           queries,
+			LogSQL:            config.LogSQL,
+			RedactSQL:         config.LogSQLRedact,
+			ValidationQuery:   config.DBValidationQuery,
+			ValidationTimeout: validationTimeout,
 		}
 		inventory = postgres.NewPInventory(config)
+		//sql.Open does not connect, bad credentials would only fail the first request
+		err := inventory.(*postgres.PInventoryDB).ValidateConnection(context.Background())
+		if err != nil {
+			loggerEntry.WithField("err", err).Fatal("Database is not reachable")
+		}
+	case "sqlite":
+		//DBName is the database file, :memory: keeps it in memory
+		config := sqlite.Config{
+			Logger:     loggerEntry,
+			Driver:     config.DBDriver,
+			DataSource: config.DBName,
+		}
+		inventory = sqlite.NewSInventory(config)
+	case "memory":
+		//nothing is persisted, the inventory is empty on every start
+		inventory = memory.NewMInventory(memory.Config{Logger: loggerEntry})
 	}
 
-	server := api.NewServer(inventory,
-		api.Configuration{
-			ListenAddress:  config.ListenAddress,
-			BackendTimeout: config.BackendTimeout},
-		loggerEntry)
+	if config.Seed {
+		_, err := seed(context.Background(), inventory, loggerEntry)
+		if err != nil {
+			loggerEntry.WithField("err", err).Fatal("Could not seed the demo data")
+		}
+	}
+
+	if config.CacheTTL != "" {
+		ttl, _ := time.ParseDuration(config.CacheTTL)
+		if ttl > 0 {
+			inventory = cache.NewCachedInventory(inventory, ttl)
+		}
+	}
 
-	err = server.Start()
+	serverConfig, err := api.NewConfiguration(config)
+	if err != nil {
+		loggerEntry.WithField("err", err).Fatal("Invalid config")
+	}
+	server := api.NewServer(inventory, serverConfig, loggerEntry)
+
+	//SIGTERM is sent by the orchestrator before it stops the container, SIGINT by ctrl+c
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		<-signals
+		close(stop)
+	}()
+
+	err = server.Start(stop)
 	if err != nil {
 		server.Logger.Fatal("cannot start server:", err)
 	}
 }
 
-//setConfig gets the required config from env and fills the config
-func setConfig(logger *logrus.Logger) configuration {
-	var config configuration
-	err := envconfig.Process("ISC", &config) //Warehouse service config
+//setConfig loads the config from the ISC_ environment variables, the service does not start with an invalid one
+func setConfig(logger *logrus.Logger) config.Configuration {
+	loaded, err := config.Load(os.LookupEnv)
 	if err != nil {
-		logger.WithField("err", err).Error("Could not load required config")
+		logger.WithField("err", err).Fatal("Invalid config")
 	}
-	return config
+	return loaded
 }
 
 //initializeLogger initialize the logger with formatter and caller settings
@@ -82,3 +138,65 @@ func initializeLogger() *logrus.Logger {
 	log.SetReportCaller(true)
 	return log
 }
+
+//configureLogger applies the log level, format, caller reporting and output of the config to the logger
+func configureLogger(logger *logrus.Logger, config config.Configuration) {
+	logger.SetOutput(logOutput(config))
+	lvl, err := logrus.ParseLevel(config.LogLevel)
+	if err == nil {
+		logger.SetLevel(lvl)
+	}
+	if config.LogFormat == "text" {
+		logger.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
+	} else {
+		logger.SetFormatter(&logrus.JSONFormatter{})
+	}
+	logger.SetReportCaller(config.LogReportCaller)
+}
+
+//logOutput is the writer of the LogOutput, a file is rotated once it reaches LogMaxSize
+func logOutput(config config.Configuration) io.Writer {
+	switch config.LogOutput {
+	case "", "stderr":
+		return os.Stderr
+	case "stdout":
+		return os.Stdout
+	}
+	return &lumberjack.Logger{
+		Filename:   config.LogOutput,
+		MaxSize:    config.LogMaxSize,
+		MaxBackups: config.LogMaxBackups,
+		MaxAge:     config.LogMaxAge,
+	}
+}
+
+//initializeTracer sets the global tracer provider exporting the spans via OTLP when tracing is enabled,
+//and returns the function flushing the remaining spans
+func initializeTracer(config config.Configuration, logger *logrus.Entry) func() {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if !config.TracingEnabled {
+		return func() {}
+	}
+
+	exporter, err := otlptracegrpc.New(context.Background())
+	if err != nil {
+		logger.WithField("err", err).Error("Could not create trace exporter")
+		return func() {}
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceName("warehouse"),
+			semconv.ServiceVersion(config.Version),
+			semconv.DeploymentEnvironment(config.Environment),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func() {
+		err := provider.Shutdown(context.Background())
+		if err != nil {
+			logger.WithField("err", err).Error("Could not shutdown tracer provider")
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"github.com/auknl/warehouse/config"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/natefinch/lumberjack.v2"
+	"gotest.tools/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//loggerConfiguration is the logging part of the default configuration
+func loggerConfiguration() config.Configuration {
+	return config.Configuration{LogFormat: "json", LogOutput: "stderr", LogMaxSize: 100, LogMaxBackups: 3, LogMaxAge: 28}
+}
+
+func TestConfigureLogger(t *testing.T) {
+	logger := logrus.New()
+	config := loggerConfiguration()
+	config.LogLevel = "debug"
+	config.LogFormat = "text"
+	configureLogger(logger, config)
+	_, isText := logger.Formatter.(*logrus.TextFormatter)
+	assert.Assert(t, isText)
+	assert.Equal(t, logger.ReportCaller, false)
+	assert.Equal(t, logger.GetLevel(), logrus.DebugLevel)
+
+	config.LogFormat = "json"
+	config.LogReportCaller = true
+	configureLogger(logger, config)
+	_, isJSON := logger.Formatter.(*logrus.JSONFormatter)
+	assert.Assert(t, isJSON)
+	assert.Equal(t, logger.ReportCaller, true)
+	assert.Equal(t, logger.Out, os.Stderr)
+
+	config.LogOutput = "stdout"
+	configureLogger(logger, config)
+	assert.Equal(t, logger.Out, os.Stdout)
+}
+
+func TestConfigureLogger_file(t *testing.T) {
+	logger := logrus.New()
+	config := loggerConfiguration()
+	config.LogOutput = filepath.Join(t.TempDir(), "warehouse.log")
+	configureLogger(logger, config)
+	file, isFile := logger.Out.(*lumberjack.Logger)
+	assert.Assert(t, isFile)
+	assert.Equal(t, file.MaxSize, 100)
+	assert.Equal(t, file.MaxBackups, 3)
+	assert.Equal(t, file.MaxAge, 28)
+
+	logger.WithField("rid", "rid-1").Info("written to the file")
+	assert.NilError(t, file.Close())
+	written, err := ioutil.ReadFile(config.LogOutput)
+	assert.NilError(t, err)
+	assert.Assert(t, strings.Contains(string(written), `"msg":"written to the file"`), string(written))
+	assert.Assert(t, strings.Contains(string(written), `"rid":"rid-1"`), string(written))
+}
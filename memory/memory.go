@@ -0,0 +1,1530 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/auknl/warehouse/data"
+	"github.com/auknl/warehouse/db"
+	"github.com/auknl/warehouse/request"
+	"github.com/sirupsen/logrus"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//MInventoryDB keeps the inventory in maps guarded by a mutex, nothing is persisted. It is meant for demos and tests
+//where running a database is not worth it. A mutation works on a copy of the tables that replaces them once it
+//succeeds, so a failing one changes nothing like a rolled back transaction
+type MInventoryDB struct {
+	config Config
+	lock   sync.RWMutex
+	tables tables
+	cache  map[string]data.ProductStocks //product stock of every location at the last refresh, deleted ones included
+	audit  []data.AuditEntry
+	sales  []sale
+	lastId int64
+	//reservations are kept apart from the tables, they hold no stock and are read by the sales
+	reservations      map[int64]data.Reservation
+	lastReservationId int64
+	snapshots         map[string]map[string]snapshot //keyed by location and label, they outlive the reset like the sales
+}
+
+//Config keeps the memory inventory related configurations
+type Config struct {
+	Logger *logrus.Entry
+}
+
+//article is the stock of an article in a location, stock and the reorder levels are in thousandths like the db
+//columns
+type article struct {
+	name         string
+	stock        int64
+	version      int64
+	updatedAt    time.Time
+	reorder      bool //reorderPoint and reorderTo are set
+	reorderPoint int64
+	reorderTo    int64
+}
+
+//productArticle is an article and the amount of it a product contains, in thousandths
+type productArticle struct {
+	artId  string
+	amount int64
+}
+
+//product keeps the articles of a product in the order they are uploaded
+type product struct {
+	articles []productArticle
+	deleted  bool
+}
+
+//sale is the units of a product sold in a location, a return is a negative sale
+type sale struct {
+	product  string
+	location string
+	quantity int
+	soldAt   time.Time
+}
+
+//snapshot is the stock of the articles of a location when the snapshot was created
+type snapshot struct {
+	createdAt time.Time
+	articles  map[string]article
+}
+
+//tables is the state the mutations replace, inventory is keyed by location and art_id
+type tables struct {
+	inventory map[string]map[string]article
+	products  map[string]product
+	recipes   map[string][][]productArticle //every recipe version of the products, the version n at n-1
+}
+
+//transaction is a copy of the tables a mutation changes, with the audit entries and the sold products written on
+//commit
+type transaction struct {
+	tables
+	ctx          context.Context
+	audits       []data.AuditEntry
+	sales        []sale
+	reservations map[int64]data.Reservation //read only
+}
+
+//integritySamples is the number of inconsistent records CheckIntegrity returns per issue
+const integritySamples = 10
+
+//errors of SellProduct that are reported as the reason of a refused sale by PreviewSale
+var (
+	errProductNotExist   = fmt.Errorf("%w, cannot be sold", db.ErrProductNotFound)
+	errProductOutOfStock = fmt.Errorf("%w, cannot be sold", db.ErrOutOfStock)
+)
+
+//NewMInventory creates new in-memory inventory instance
+func NewMInventory(config Config) db.Inventory {
+	config.Logger.Debug("NewMInventory entry...")
+	inventory := MInventoryDB{config: config}
+	_ = inventory.Open() //cannot fail
+	return &inventory
+}
+
+//Ping always succeeds, there is no connection to lose
+func (inventory *MInventoryDB) Ping() error {
+	inventory.config.Logger.Debug("Ping() entry...")
+	return nil
+}
+
+//PoolStats is empty, there is no connection pool
+func (inventory *MInventoryDB) PoolStats() sql.DBStats {
+	return sql.DBStats{}
+}
+
+//GetSchemaVersion is always the one of the binary, there is no schema to migrate
+func (inventory *MInventoryDB) GetSchemaVersion(ctx context.Context) (int, bool, error) {
+	inventory.config.Logger.WithField("rid", request.GetRID(ctx)).Debug("GetSchemaVersion() entry...")
+	return db.SchemaVersion, false, nil
+}
+
+//Open starts with an empty inventory
+func (inventory *MInventoryDB) Open() error {
+	inventory.config.Logger.Debug("Open() entry...")
+	inventory.lock.Lock()
+	defer inventory.lock.Unlock()
+	inventory.tables = tables{inventory: map[string]map[string]article{}, products: map[string]product{}, recipes: map[string][][]productArticle{}}
+	inventory.cache = map[string]data.ProductStocks{}
+	inventory.audit = nil
+	inventory.lastId = 0
+	inventory.reservations = map[int64]data.Reservation{}
+	inventory.snapshots = map[string]map[string]snapshot{}
+	return nil
+}
+
+//GetInventory gets all inventory/stock info in system
+func (inventory *MInventoryDB) GetInventory(ctx context.Context) (error, []data.Stock) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("GetInventory() entry...")
+	inventory.lock.RLock()
+	defer inventory.lock.RUnlock()
+	stocks := inventory.tables.stocks(ctx, func(artId string, article article) bool { return true })
+	log.WithField("number of inventory record to be returned: ", len(stocks)).Debug("GetInventory(), returns the stocks...")
+	return nil, stocks
+}
+
+//SearchArticles gets at most limit articles whose name contains the query, case-insensitive, ordered by art_id
+func (inventory *MInventoryDB) SearchArticles(ctx context.Context, query string, limit int) ([]data.Stock, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("SearchArticles() entry...")
+	inventory.lock.RLock()
+	defer inventory.lock.RUnlock()
+	query = strings.ToLower(query)
+	stocks := inventory.tables.stocks(ctx, func(artId string, article article) bool {
+		return strings.Contains(strings.ToLower(article.name), query)
+	})
+	if limit >= 0 && len(stocks) > limit {
+		stocks = stocks[:limit]
+	}
+	log.WithField("number of inventory record to be returned: ", len(stocks)).Debug("SearchArticles(), returns the stocks...")
+	return stocks, nil
+}
+
+//GetInventoryPage gets at most limit stock records ordered by art_id, starting after the art_id of the after cursor.
+//The returned cursor is the art_id of the last record when there are more, empty on the last page
+func (inventory *MInventoryDB) GetInventoryPage(ctx context.Context, after string, limit int) ([]data.Stock, string, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("GetInventoryPage() entry...")
+	if limit <= 0 {
+		return nil, "", errors.New("page limit must be positive")
+	}
+	inventory.lock.RLock()
+	defer inventory.lock.RUnlock()
+	stocks := inventory.tables.stocks(ctx, func(artId string, article article) bool { return artId > after })
+	next := ""
+	if len(stocks) > limit {
+		stocks = stocks[:limit]
+		next = stocks[limit-1].ArtId
+	}
+	log.WithField("number of inventory record to be returned: ", len(stocks)).Debug("GetInventoryPage(), returns the stocks...")
+	return stocks, next, nil
+}
+
+//GetInventorySince gets the articles whose stock was inserted or changed after since, ordered by art_id. Deleted
+//articles are not reported
+func (inventory *MInventoryDB) GetInventorySince(ctx context.Context, since time.Time) ([]data.Stock, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("GetInventorySince() entry...")
+	inventory.lock.RLock()
+	defer inventory.lock.RUnlock()
+	stocks := inventory.tables.stocks(ctx, func(artId string, article article) bool { return article.updatedAt.After(since) })
+	log.WithField("number of inventory record to be returned: ", len(stocks)).Debug("GetInventorySince(), returns the stocks...")
+	return stocks, nil
+}
+
+//GetLowestStock gets the n articles with the smallest stock, ordered by stock and art_id
+func (inventory *MInventoryDB) GetLowestStock(ctx context.Context, n int) ([]data.Stock, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("GetLowestStock() entry...")
+	inventory.lock.RLock()
+	defer inventory.lock.RUnlock()
+	articles := inventory.tables.inventory[request.LocationFromContext(ctx)]
+	stocks := inventory.tables.stocks(ctx, func(artId string, article article) bool { return true })
+	//the stocks are ordered by art_id already, the stable sort keeps it for the ties
+	sort.SliceStable(stocks, func(i, j int) bool {
+		return articles[stocks[i].ArtId].stock < articles[stocks[j].ArtId].stock
+	})
+	if n >= 0 && len(stocks) > n {
+		stocks = stocks[:n]
+	}
+	log.WithField("number of inventory record to be returned: ", len(stocks)).Debug("GetLowestStock(), returns the stocks...")
+	return stocks, nil
+}
+
+//StreamInventory calls each for every inventory/stock info in system. The stock is copied before, so a slow each
+//does not hold back the changes
+func (inventory *MInventoryDB) StreamInventory(ctx context.Context, each func(stock data.Stock) error) error {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("StreamInventory() entry...")
+	_, stocks := inventory.GetInventory(ctx)
+	for _, stock := range stocks {
+		err := each(stock)
+		if err != nil {
+			log.WithField("err", err).Error("StreamInventory(), stopped streaming...")
+			return err
+		}
+	}
+	log.WithField("number of inventory record streamed: ", len(stocks)).Debug("StreamInventory(), streamed the stocks...")
+	return nil
+}
+
+//GetProductStock gets the stock of the available products in system. Soft-deleted products are only
+//returned when includeDeleted is set
+func (inventory *MInventoryDB) GetProductStock(ctx context.Context, includeDeleted bool) (error, data.ProductStocks) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("GetProductStock() entry...")
+	inventory.lock.RLock()
+	defer inventory.lock.RUnlock()
+	stocks := data.ProductStocks{}
+	for _, stock := range inventory.tables.productStocks(request.LocationFromContext(ctx), includeDeleted) {
+		if stock.AvailableProductNo != "0" { // if product items are enough
+			stocks = append(stocks, stock)
+		}
+	}
+	log.WithField("number of product to be returned: ", len(stocks)).Debug("GetProductStock(), returns the stocks...")
+	return nil, stocks
+}
+
+//GetAllProducts gets every product in system that is not deleted with the number of it can be built from the
+//stock, products that cannot be built are included with zero
+func (inventory *MInventoryDB) GetAllProducts(ctx context.Context) (data.ProductStocks, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("GetAllProducts() entry...")
+	inventory.lock.RLock()
+	defer inventory.lock.RUnlock()
+	stocks := append(data.ProductStocks{}, inventory.tables.productStocks(request.LocationFromContext(ctx), false)...)
+	log.WithField("number of product to be returned: ", len(stocks)).Debug("GetAllProducts(), returns the products...")
+	return stocks, nil
+}
+
+//GetProductsByNames gets the products of the given names that are not deleted with the number of them can be built
+//from the stock, like GetAllProducts. Names that are not in system are left out
+func (inventory *MInventoryDB) GetProductsByNames(ctx context.Context, names []string) (data.ProductStocks, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("GetProductsByNames() entry...")
+	inventory.lock.RLock()
+	defer inventory.lock.RUnlock()
+	stocks := data.ProductStocks{}
+	for _, stock := range inventory.tables.productStocks(request.LocationFromContext(ctx), false) {
+		if contains(names, stock.Name) {
+			stocks = append(stocks, stock)
+		}
+	}
+	log.WithField("number of product to be returned: ", len(stocks)).Debug("GetProductsByNames(), returns the products...")
+	return stocks, nil
+}
+
+//GetCachedProductStock gets the product stock like GetProductStock as it was at the last RefreshProductStock
+func (inventory *MInventoryDB) GetCachedProductStock(ctx context.Context, includeDeleted bool) (data.ProductStocks, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("GetCachedProductStock() entry...")
+	inventory.lock.RLock()
+	defer inventory.lock.RUnlock()
+	stocks := data.ProductStocks{}
+	for _, stock := range inventory.cache[request.LocationFromContext(ctx)] {
+		if stock.AvailableProductNo != "0" && (includeDeleted || !stock.Deleted) {
+			stocks = append(stocks, stock)
+		}
+	}
+	log.WithField("number of product to be returned: ", len(stocks)).Debug("GetCachedProductStock(), returns the stocks...")
+	return stocks, nil
+}
+
+//RefreshProductStock recomputes the cached product stock of every location from the current stock
+func (inventory *MInventoryDB) RefreshProductStock(ctx context.Context) error {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("RefreshProductStock() entry...")
+	inventory.lock.Lock()
+	defer inventory.lock.Unlock()
+	inventory.refresh()
+	log.Debug("RefreshProductStock(), product stock cache is refreshed...")
+	return nil
+}
+
+//refresh recomputes the product stock cache, the lock has to be held
+func (inventory *MInventoryDB) refresh() {
+	inventory.cache = map[string]data.ProductStocks{}
+	for location, articles := range inventory.tables.inventory {
+		if len(articles) != 0 {
+			inventory.cache[location] = inventory.tables.productStocks(location, true)
+		}
+	}
+}
+
+//UploadProducts inserts the product info. By default nothing is inserted when a product fails, with
+//continueOnError the failed products are left out one by one and reported in data.ProductUploadErrors
+func (inventory *MInventoryDB) UploadProducts(ctx context.Context, products data.Products, continueOnError bool) (error, int) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("UploadProducts() entry...")
+	inventory.lock.Lock()
+	defer inventory.lock.Unlock()
+	transaction := inventory.begin(ctx)
+	insertedRecord := 0
+	var failures data.ProductUploadErrors
+	for _, product := range products.Products {
+		//every product is inserted into its own copy, so a failed one leaves nothing behind
+		attempt := inventory.beginFrom(transaction)
+		err := attempt.insertProduct(product)
+		if err != nil && !continueOnError {
+			log.WithField("err: ", err).Error("UploadProducts(), failed to insert record...")
+			return err, 0
+		}
+		if err != nil {
+			log.WithField("err: ", err).Info("UploadProducts(), skipping the failed product...")
+			failures = append(failures, data.ProductUploadFailure{Name: product.Name, Error: err.Error()})
+			continue
+		}
+		attempt.audit(data.AuditEntry{Operation: data.AuditUploadProduct, Entity: product.Name})
+		transaction = attempt
+		insertedRecord++
+	}
+	inventory.commit(transaction)
+
+	log.WithField("number of product uploaded: ", insertedRecord).Debug("UploadProducts(), uploaded products...")
+	if len(failures) != 0 {
+		return failures, insertedRecord
+	}
+	return nil, insertedRecord
+}
+
+//UploadInventory inserts the inventory info, an article already in the location fails the upload
+func (inventory *MInventoryDB) UploadInventory(ctx context.Context, inventoryToInsert data.Inventory) (error, int) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("UploadInventory() entry...")
+	inventory.lock.Lock()
+	defer inventory.lock.Unlock()
+	transaction := inventory.begin(ctx)
+	for _, inventoryRec := range inventoryToInsert.Inventory {
+		err := transaction.insertStock(inventoryRec)
+		if err != nil {
+			log.WithField("err: ", err).Error("UploadInventory failed to insert record...")
+			return err, 0
+		}
+	}
+	inventory.commit(transaction)
+	insertedRecord := len(inventoryToInsert.Inventory)
+
+	log.WithField("number of inventory uploaded: ", insertedRecord).Debug("UploadInventory(), uploaded products...")
+	return nil, insertedRecord
+}
+
+//UploadCatalog inserts the inventory of the catalog and then its products in one transaction, nothing is inserted
+//when an article or a product fails
+func (inventory *MInventoryDB) UploadCatalog(ctx context.Context, catalog data.Catalog) error {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("UploadCatalog() entry...")
+	inventory.lock.Lock()
+	defer inventory.lock.Unlock()
+	transaction := inventory.begin(ctx)
+	for _, inventoryRec := range catalog.Inventory {
+		err := transaction.insertStock(inventoryRec)
+		if err != nil {
+			log.WithField("err: ", err).Error("UploadCatalog(), failed to insert article...")
+			return err
+		}
+	}
+	for _, product := range catalog.Products {
+		err := transaction.insertProduct(product)
+		if err != nil {
+			log.WithField("err: ", err).Error("UploadCatalog(), failed to insert product...")
+			return err
+		}
+		transaction.audit(data.AuditEntry{Operation: data.AuditUploadProduct, Entity: product.Name})
+	}
+	inventory.commit(transaction)
+
+	log.WithFields(logrus.Fields{"articles": len(catalog.Inventory), "products": len(catalog.Products)}).Debug("UploadCatalog(), uploaded the catalog...")
+	return nil
+}
+
+//SellProduct checks if the product exist and in stock. If true then update inventory accordingly
+func (inventory *MInventoryDB) SellProduct(ctx context.Context, productName string) error {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("sellProduct() entry...")
+	inventory.lock.Lock()
+	defer inventory.lock.Unlock()
+	transaction := inventory.begin(ctx)
+	err := transaction.sell(productName)
+	if err != nil {
+		log.WithField("err", err).Info("SellProduct(), product cannot be sold...")
+		return err
+	}
+	inventory.commit(transaction)
+
+	log.WithField("product is sold: ", productName).Debug("sellProduct(), sold the product and update the inventory...")
+	return nil
+}
+
+//ReturnProduct puts the articles of quantity units of a returned product back to the stock of the location, the
+//return is recorded as a negative sale. Every article of the product has to be stocked in the location
+func (inventory *MInventoryDB) ReturnProduct(ctx context.Context, productName string, quantity int) error {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("ReturnProduct() entry...")
+	if quantity <= 0 {
+		return errors.New("return quantity must be positive")
+	}
+	inventory.lock.Lock()
+	defer inventory.lock.Unlock()
+	transaction := inventory.begin(ctx)
+	err := transaction.returnProduct(productName, quantity)
+	if err != nil {
+		log.WithField("err", err).Info("ReturnProduct(), product cannot be returned...")
+		return err
+	}
+	inventory.commit(transaction)
+
+	log.WithField("product is returned: ", productName).Debug("ReturnProduct(), returned the product to the inventory...")
+	return nil
+}
+
+//ReserveProduct holds quantity units of the product in the location of ctx until expiresAt, so they are not sold to
+//anyone else. The units have to be available to promise, which is the stock minus the unexpired reservations
+func (inventory *MInventoryDB) ReserveProduct(ctx context.Context, productName string, quantity int, expiresAt time.Time) (data.Reservation, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("ReserveProduct() entry...")
+	location := request.LocationFromContext(ctx)
+	reservation := data.Reservation{Name: productName, Location: location, Quantity: quantity, ExpiresAt: expiresAt.UTC()}
+	if quantity <= 0 {
+		return reservation, errors.New("reservation quantity must be positive")
+	}
+	now := time.Now()
+	if !expiresAt.After(now) {
+		return reservation, errors.New("reservation must expire in the future")
+	}
+	inventory.lock.Lock()
+	defer inventory.lock.Unlock()
+	for id, reserved := range inventory.reservations {
+		if !reserved.ExpiresAt.After(now) {
+			delete(inventory.reservations, id)
+		}
+	}
+	product, ok := inventory.tables.products[productName]
+	if !ok || product.deleted {
+		log.Info("product is not found in system")
+		return reservation, fmt.Errorf("%w, cannot be reserved", db.ErrProductNotFound)
+	}
+	transaction := inventory.begin(ctx)
+	if promisable := int(transaction.promisable(product, now)); promisable < quantity {
+		log.WithField("promisable", promisable).Info("product items are out of stock")
+		return reservation, fmt.Errorf("%w: only %d of product %s can be reserved in %s", db.ErrOutOfStock, promisable, productName, location)
+	}
+	inventory.lastReservationId++
+	reservation.Id = inventory.lastReservationId
+	inventory.reservations[reservation.Id] = reservation
+
+	log.WithField("reservation", reservation.Id).Debug("ReserveProduct(), reserved the product...")
+	return reservation, nil
+}
+
+//ReleaseReservation removes the reservation of the location of ctx, its units can be sold again
+func (inventory *MInventoryDB) ReleaseReservation(ctx context.Context, id int64) error {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("ReleaseReservation() entry...")
+	inventory.lock.Lock()
+	defer inventory.lock.Unlock()
+	reservation, ok := inventory.reservations[id]
+	if !ok || reservation.Location != request.LocationFromContext(ctx) {
+		log.Info("reservation is not found in system")
+		return fmt.Errorf("%w: %d", db.ErrReservationNotFound, id)
+	}
+	delete(inventory.reservations, id)
+
+	log.WithField("reservation", id).Debug("ReleaseReservation(), released the reservation...")
+	return nil
+}
+
+//IsProductBuildable checks if quantity of the product can be built from the current stock, it also returns the
+//maximum number of the product that can be built
+func (inventory *MInventoryDB) IsProductBuildable(ctx context.Context, productName string, quantity int) (bool, int, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("IsProductBuildable() entry...")
+	inventory.lock.RLock()
+	defer inventory.lock.RUnlock()
+	product, ok := inventory.tables.products[productName]
+	if !ok || product.deleted {
+		log.Info("product is not found in system")
+		return false, 0, db.ErrProductNotFound
+	}
+	maxBuildable := int(inventory.tables.available(product, request.LocationFromContext(ctx)))
+
+	log.WithField("max buildable: ", maxBuildable).Debug("IsProductBuildable(), returns the buildable info...")
+	return maxBuildable >= quantity, maxBuildable, nil
+}
+
+//GetProductAvailability gets how many units of the product can be built at each location that stocks articles and
+//their total
+func (inventory *MInventoryDB) GetProductAvailability(ctx context.Context, productName string) (data.ProductAvailability, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("GetProductAvailability() entry...")
+	inventory.lock.RLock()
+	defer inventory.lock.RUnlock()
+	availability := data.ProductAvailability{Name: productName, Locations: []data.LocationAvailability{}}
+	product, ok := inventory.tables.products[productName]
+	if !ok || product.deleted {
+		log.Info("product is not found in system")
+		return availability, db.ErrProductNotFound
+	}
+	for _, location := range inventory.tables.locations() {
+		available := int(inventory.tables.available(product, location))
+		availability.Locations = append(availability.Locations, data.LocationAvailability{Location: location, Available: available})
+		availability.Total += available
+	}
+
+	log.WithField("total: ", availability.Total).Debug("GetProductAvailability(), returns the availability...")
+	return availability, nil
+}
+
+//PreviewSale runs the sale of the product on a copy of the tables that is dropped, so the inventory is not changed.
+//It returns if the product could be sold and the stock of its articles after the sale
+func (inventory *MInventoryDB) PreviewSale(ctx context.Context, productName string) (data.SalePreview, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("PreviewSale() entry...")
+	inventory.lock.RLock()
+	defer inventory.lock.RUnlock()
+	preview := data.SalePreview{Name: productName}
+	transaction := inventory.begin(ctx) //dry run, never committed
+
+	err := transaction.sell(productName)
+	if err == nil {
+		preview.Sellable = true
+	} else {
+		preview.Reason = err.Error()
+	}
+
+	preview.Inventory = []data.Stock{}
+	articles := transaction.inventory[request.LocationFromContext(ctx)]
+	for _, contain := range transaction.products[productName].articles {
+		if article, ok := articles[contain.artId]; ok {
+			preview.Inventory = append(preview.Inventory, data.Stock{ArtId: contain.artId, Name: article.name, Stock: data.QuantityOf(article.stock)})
+		}
+	}
+	sort.Slice(preview.Inventory, func(i, j int) bool { return preview.Inventory[i].ArtId < preview.Inventory[j].ArtId })
+
+	log.WithField("sellable: ", preview.Sellable).Debug("PreviewSale(), dropped the sale...")
+	return preview, nil
+}
+
+//DeleteProduct soft deletes the product, so its history is kept in system
+func (inventory *MInventoryDB) DeleteProduct(ctx context.Context, productName string) error {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("DeleteProduct() entry...")
+	return inventory.setProductDeleted(ctx, log, true, data.AuditDeleteProduct, productName, fmt.Errorf("%w, cannot be deleted", db.ErrProductNotFound))
+}
+
+//RestoreProduct brings back a soft deleted product
+func (inventory *MInventoryDB) RestoreProduct(ctx context.Context, productName string) error {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("RestoreProduct() entry...")
+	return inventory.setProductDeleted(ctx, log, false, data.AuditRestoreProduct, productName, errors.New("this product is not deleted, cannot be restored"))
+}
+
+//setProductDeleted marks the product deleted or restored and fails with notFound if it is not in the other state,
+//the change is audited as operation
+func (inventory *MInventoryDB) setProductDeleted(ctx context.Context, log *logrus.Entry, deleted bool, operation string, productName string, notFound error) error {
+	inventory.lock.Lock()
+	defer inventory.lock.Unlock()
+	transaction := inventory.begin(ctx)
+	product, ok := transaction.products[productName]
+	if !ok || product.deleted == deleted {
+		log.WithField("product", productName).Info(notFound.Error())
+		return notFound
+	}
+	product.deleted = deleted
+	transaction.products[productName] = product
+	transaction.audit(data.AuditEntry{Operation: operation, Entity: productName})
+	inventory.commit(transaction)
+
+	log.WithField("product: ", productName).Debug("setProductDeleted(), updated the product...")
+	return nil
+}
+
+//RenameProduct gives the product the new name. The new name cannot be the one of another product, deleted ones
+//included
+func (inventory *MInventoryDB) RenameProduct(ctx context.Context, oldName string, newName string) error {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("RenameProduct() entry...")
+	inventory.lock.Lock()
+	defer inventory.lock.Unlock()
+	transaction := inventory.begin(ctx)
+	_, hasRecipes := transaction.recipes[newName]
+	if _, taken := transaction.products[newName]; taken || hasRecipes {
+		log.WithField("product", newName).Info(db.ErrProductExists.Error())
+		return fmt.Errorf("%w, %s cannot be renamed to it", db.ErrProductExists, oldName)
+	}
+	product, ok := transaction.products[oldName]
+	if !ok || product.deleted {
+		log.WithField("product", oldName).Info(db.ErrProductNotFound.Error())
+		return fmt.Errorf("%w, cannot be renamed", db.ErrProductNotFound)
+	}
+	delete(transaction.products, oldName)
+	transaction.products[newName] = product
+	if versions, ok := transaction.recipes[oldName]; ok {
+		delete(transaction.recipes, oldName)
+		transaction.recipes[newName] = versions
+	}
+	//both names are audited, so the history of either one shows the rename
+	for _, name := range []string{oldName, newName} {
+		transaction.audit(data.AuditEntry{Operation: data.AuditRenameProduct, Entity: name})
+	}
+	inventory.commit(transaction)
+
+	log.WithField("product: ", newName).Debug("RenameProduct(), product is renamed...")
+	return nil
+}
+
+//AdjustArticles applies the stock adjustments all together. Lines that cannot be applied are reported in
+//data.StockAdjustmentErrors, if atomic is set none of the adjustments are applied in that case
+func (inventory *MInventoryDB) AdjustArticles(ctx context.Context, adjustments []data.StockAdjustment, atomic bool) (int, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("AdjustArticles() entry...")
+	inventory.lock.Lock()
+	defer inventory.lock.Unlock()
+	transaction := inventory.begin(ctx)
+
+	updated := 0
+	var failures data.StockAdjustmentErrors
+	for i, adjustment := range adjustments {
+		err := adjustment.Validate()
+		if err == nil {
+			_, err = transaction.adjust(adjustment)
+		}
+		switch {
+		case errors.Is(err, db.ErrArticleNotFound):
+			err = errors.New("article is not found in system")
+		case errors.Is(err, db.ErrNotEnoughStock):
+			err = db.ErrNotEnoughStock
+		}
+		if err != nil {
+			failures = append(failures, data.StockAdjustmentFailure{Line: i, ArtId: adjustment.ArtId, Error: err.Error()})
+			continue
+		}
+		updated++
+	}
+
+	if len(failures) != 0 && atomic {
+		log.WithField("failed lines: ", len(failures)).Info("AdjustArticles(), atomic adjustment is dropped...")
+		return 0, failures
+	}
+	inventory.commit(transaction)
+
+	log.WithField("number of article adjusted: ", updated).Debug("AdjustArticles(), adjusted the inventory...")
+	if len(failures) != 0 {
+		return updated, failures
+	}
+	return updated, nil
+}
+
+//GetArticle gets the article in the location of ctx with its version, the version changes on every stock update
+func (inventory *MInventoryDB) GetArticle(ctx context.Context, artId string) (data.Stock, int64, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("GetArticle() entry...")
+	inventory.lock.RLock()
+	defer inventory.lock.RUnlock()
+	article, ok := inventory.tables.inventory[request.LocationFromContext(ctx)][artId]
+	if !ok {
+		return data.Stock{}, 0, fmt.Errorf("%w: %s", db.ErrArticleNotFound, artId)
+	}
+	return data.Stock{ArtId: artId, Name: article.name, Stock: data.QuantityOf(article.stock)}, article.version, nil
+}
+
+//AdjustArticle applies the adjustment only if the article is still at the given version, so concurrent updates of
+//the same article do not overwrite each other. The article after the update and its new version are returned
+func (inventory *MInventoryDB) AdjustArticle(ctx context.Context, adjustment data.StockAdjustment, version int64) (data.Stock, int64, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("AdjustArticle() entry...")
+	err := adjustment.Validate()
+	if err != nil {
+		return data.Stock{}, 0, err
+	}
+	inventory.lock.Lock()
+	defer inventory.lock.Unlock()
+	transaction := inventory.begin(ctx)
+	current, ok := transaction.inventory[request.LocationFromContext(ctx)][adjustment.ArtId]
+	if ok && current.version != version {
+		return data.Stock{}, 0, fmt.Errorf("%w: %s is at version %d", db.ErrVersionMismatch, adjustment.ArtId, current.version)
+	}
+	article, err := transaction.adjust(adjustment)
+	if err != nil {
+		return data.Stock{}, 0, err
+	}
+	inventory.commit(transaction)
+
+	log.WithField("version", article.version).Debug("AdjustArticle(), adjusted the article...")
+	return data.Stock{ArtId: adjustment.ArtId, Name: article.name, Stock: data.QuantityOf(article.stock)}, article.version, nil
+}
+
+//DeleteArticles removes the articles from inventory of all locations and returns the number of deleted ones.
+//Articles used by products are not deleted and data.ArticlesInUse lists the products, unless force is set. Then the
+//products using them are soft deleted, they can be restored once the articles are back
+func (inventory *MInventoryDB) DeleteArticles(ctx context.Context, artIds []string, force bool) (int, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("DeleteArticles() entry...")
+	inventory.lock.Lock()
+	defer inventory.lock.Unlock()
+	transaction := inventory.begin(ctx)
+
+	var inUse data.ArticlesInUse
+	for _, name := range transaction.productNames() {
+		if transaction.products[name].deleted {
+			continue
+		}
+		for _, contain := range transaction.products[name].articles {
+			if contains(artIds, contain.artId) {
+				inUse = append(inUse, name)
+				break
+			}
+		}
+	}
+	if len(inUse) != 0 && !force {
+		log.WithField("products: ", inUse).Info("DeleteArticles(), articles are used by products...")
+		return 0, inUse
+	}
+	for _, name := range inUse {
+		product := transaction.products[name]
+		product.deleted = true
+		transaction.products[name] = product
+		transaction.audit(data.AuditEntry{Operation: data.AuditDeleteProduct, Entity: name})
+	}
+
+	deleted := 0
+	for _, location := range transaction.locations() {
+		articles := transaction.inventory[location]
+		for _, artId := range sortedKeys(articles) {
+			if !contains(artIds, artId) {
+				continue
+			}
+			transaction.audit(data.AuditEntry{Operation: data.AuditDeleteArticle, Entity: artId, ArtId: artId, Location: location, StockBefore: data.QuantityOf(articles[artId].stock)})
+			delete(articles, artId)
+			deleted++
+		}
+	}
+	inventory.commit(transaction)
+
+	log.WithField("number of article deleted: ", deleted).Debug("DeleteArticles(), deleted the articles...")
+	return deleted, nil
+}
+
+//CheckIntegrity reports the inconsistencies between the products and the inventory of all locations, at most
+//integritySamples records are kept per issue
+func (inventory *MInventoryDB) CheckIntegrity(ctx context.Context) (data.IntegrityReport, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("CheckIntegrity() entry...")
+	inventory.lock.RLock()
+	defer inventory.lock.RUnlock()
+	var report data.IntegrityReport
+	tables := inventory.tables
+
+	for _, name := range tables.productNames() {
+		counts := map[string]int{}
+		for _, contain := range tables.sortedArticles(name) {
+			counts[contain.artId]++
+			if !tables.articleExists(contain.artId) {
+				addSample(&report.MissingArticles, data.IntegrityRecord{ProductName: name, ArtId: contain.artId})
+			}
+			if counts[contain.artId] == 2 {
+				addSample(&report.DuplicateProductArticles, data.IntegrityRecord{ProductName: name, ArtId: contain.artId})
+			}
+		}
+	}
+	for _, location := range tables.locations() {
+		articles := tables.inventory[location]
+		for _, artId := range sortedKeys(articles) {
+			if articles[artId].stock < 0 {
+				addSample(&report.NegativeStock, data.IntegrityRecord{ArtId: artId, Location: location, Stock: data.QuantityOf(articles[artId].stock)})
+			}
+		}
+	}
+
+	log.WithField("consistent", report.Consistent()).Debug("CheckIntegrity(), returns the report...")
+	return report, nil
+}
+
+//ResetInventory removes every article, product and reservation of all locations, it returns the number of articles
+//and products removed. It is meant to clean test environments
+func (inventory *MInventoryDB) ResetInventory(ctx context.Context) (int, int, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("ResetInventory() entry...")
+	inventory.lock.Lock()
+	defer inventory.lock.Unlock()
+	transaction := inventory.begin(ctx)
+	articles := 0
+	for _, location := range transaction.inventory {
+		articles += len(location)
+	}
+	products := len(transaction.products)
+	transaction.tables = tables{inventory: map[string]map[string]article{}, products: map[string]product{}, recipes: map[string][][]productArticle{}}
+	transaction.audit(data.AuditEntry{Operation: data.AuditResetInventory, Entity: "inventory"})
+	inventory.commit(transaction)
+	inventory.reservations = map[int64]data.Reservation{}
+
+	log.WithFields(logrus.Fields{"articles": articles, "products": products}).Info("ResetInventory(), removed the inventory...")
+	return articles, products, nil
+}
+
+//GetSales gets how many units of each product were sold in the location of ctx at or after since, the most sold
+//first
+func (inventory *MInventoryDB) GetSales(ctx context.Context, since time.Time) ([]data.Sale, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("GetSales() entry...")
+	inventory.lock.RLock()
+	defer inventory.lock.RUnlock()
+	location := request.LocationFromContext(ctx)
+	sales := []data.Sale{}
+	index := make(map[string]int)
+	for _, sold := range inventory.sales {
+		if sold.location != location || sold.soldAt.Before(since) {
+			continue
+		}
+		i, ok := index[sold.product]
+		if !ok {
+			i = len(sales)
+			index[sold.product] = i
+			sales = append(sales, data.Sale{Name: sold.product, FirstSoldAt: sold.soldAt})
+		}
+		sales[i].Sold += sold.quantity
+		sales[i].LastSoldAt = sold.soldAt
+	}
+	sort.SliceStable(sales, func(i, j int) bool {
+		return sales[i].Sold > sales[j].Sold || sales[i].Sold == sales[j].Sold && sales[i].Name < sales[j].Name
+	})
+
+	log.WithField("number of sold product to be returned: ", len(sales)).Debug("GetSales(), returns the sales...")
+	return sales, nil
+}
+
+//GetAuditLog gets the latest limit audit entries of all locations, newest first. If entity is given only the
+//entries of that product or article are returned
+func (inventory *MInventoryDB) GetAuditLog(ctx context.Context, entity string, limit int) ([]data.AuditEntry, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("GetAuditLog() entry...")
+	if limit <= 0 {
+		return nil, errors.New("audit limit must be positive")
+	}
+	inventory.lock.RLock()
+	defer inventory.lock.RUnlock()
+	entries := []data.AuditEntry{}
+	for i := len(inventory.audit) - 1; i >= 0 && len(entries) < limit; i-- {
+		entry := inventory.audit[i]
+		if entity == "" || entry.Entity == entity || entry.ArtId == entity {
+			entries = append(entries, entry)
+		}
+	}
+	log.WithField("number of audit entry to be returned: ", len(entries)).Debug("GetAuditLog(), returns the entries...")
+	return entries, nil
+}
+
+//GetStats gets the aggregate info of articles and products in system
+func (inventory *MInventoryDB) GetStats(ctx context.Context) (data.Stats, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("GetStats() entry...")
+	inventory.lock.RLock()
+	defer inventory.lock.RUnlock()
+	location := request.LocationFromContext(ctx)
+	articles := inventory.tables.inventory[location]
+	total := int64(0)
+	for _, article := range articles {
+		total += article.stock
+	}
+	stats := data.Stats{TotalArticles: len(articles), TotalStock: data.QuantityOf(total)}
+	for _, product := range inventory.tables.products {
+		if product.deleted {
+			continue
+		}
+		stats.TotalProducts++
+		if inventory.tables.available(product, location) > 0 {
+			stats.BuildableProducts++
+		}
+	}
+
+	log.WithField("stats: ", stats).Debug("GetStats(), returns the stats...")
+	return stats, nil
+}
+
+//UnknownArticles returns the given article ids that are not in the inventory of any location
+func (inventory *MInventoryDB) UnknownArticles(ctx context.Context, artIds []string) ([]string, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("UnknownArticles() entry...")
+	inventory.lock.RLock()
+	defer inventory.lock.RUnlock()
+	var unknown []string
+	for _, artId := range artIds {
+		if !inventory.tables.articleExists(artId) {
+			unknown = append(unknown, artId)
+		}
+	}
+	log.WithField("number of unknown articles: ", len(unknown)).Debug("UnknownArticles(), returns the unknown articles...")
+	return unknown, nil
+}
+
+//GetReorderArticles gets the articles of the location of ctx whose stock is at or below their reorder point, ordered
+//by art_id, with the quantity that brings them up to their reorder level
+func (inventory *MInventoryDB) GetReorderArticles(ctx context.Context) ([]data.Reorder, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("GetReorderArticles() entry...")
+	inventory.lock.RLock()
+	defer inventory.lock.RUnlock()
+	articles := inventory.tables.inventory[request.LocationFromContext(ctx)]
+	reorders := []data.Reorder{}
+	for _, artId := range sortedKeys(articles) {
+		article := articles[artId]
+		if article.reorder && article.stock <= article.reorderPoint {
+			reorders = append(reorders, data.Reorder{ArtId: artId, Name: article.name, Stock: data.QuantityOf(article.stock),
+				ReorderPoint: data.QuantityOf(article.reorderPoint), ReorderTo: data.QuantityOf(article.reorderTo), Quantity: data.QuantityOf(article.reorderTo - article.stock)})
+		}
+	}
+	log.WithField("number of article to be reordered: ", len(reorders)).Debug("GetReorderArticles(), returns the articles...")
+	return reorders, nil
+}
+
+//SetReorderLevels sets the reorder point and level of the article in the location of ctx, empty levels clear them.
+//The levels are not stock, the version of the article is kept
+func (inventory *MInventoryDB) SetReorderLevels(ctx context.Context, artId string, levels data.ReorderLevels) error {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("SetReorderLevels() entry...")
+	inventory.lock.Lock()
+	defer inventory.lock.Unlock()
+	transaction := inventory.begin(ctx)
+	articles := transaction.inventory[request.LocationFromContext(ctx)]
+	article, ok := articles[artId]
+	if !ok {
+		log.Info("article is not found in system")
+		return fmt.Errorf("%w: %s", db.ErrArticleNotFound, artId)
+	}
+	article.reorder = levels.ReorderPoint != ""
+	article.reorderPoint, _ = levels.ReorderPoint.Thousandths()
+	article.reorderTo, _ = levels.ReorderTo.Thousandths()
+	articles[artId] = article
+	inventory.commit(transaction)
+
+	log.WithField("art_id", artId).Debug("SetReorderLevels(), updated the reorder levels...")
+	return nil
+}
+
+//GetProductArticles gets the articles of the recipe version of the product ordered by art_id, the latest version if
+//version is 0. The versions of a deleted product can still be read
+func (inventory *MInventoryDB) GetProductArticles(ctx context.Context, productName string, version int) (data.Product, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("GetProductArticles() entry...")
+	inventory.lock.RLock()
+	defer inventory.lock.RUnlock()
+	versions := inventory.tables.recipes[productName]
+	if version == 0 {
+		version = len(versions)
+	}
+	if version < 1 || version > len(versions) {
+		log.Info("product recipe is not found in system")
+		if version != 0 {
+			return data.Product{}, fmt.Errorf("%w: %s recipe version %d", db.ErrProductNotFound, productName, version)
+		}
+		return data.Product{}, fmt.Errorf("%w: %s", db.ErrProductNotFound, productName)
+	}
+	product := data.Product{Name: productName, ContainArticles: []data.ArticleContain{}, RecipeVersion: version}
+	for _, article := range versions[version-1] {
+		product.ContainArticles = append(product.ContainArticles, data.ArticleContain{ArtId: article.artId, AmountOf: data.QuantityOf(article.amount)})
+	}
+	sort.Slice(product.ContainArticles, func(i, j int) bool {
+		return product.ContainArticles[i].ArtId < product.ContainArticles[j].ArtId
+	})
+	log.WithField("recipe version", product.RecipeVersion).Debug("GetProductArticles(), returns the articles...")
+	return product, nil
+}
+
+//begin copies the tables for a mutation, the lock has to be held
+func (inventory *MInventoryDB) begin(ctx context.Context) *transaction {
+	return &transaction{tables: inventory.tables.clone(), ctx: ctx, reservations: inventory.reservations}
+}
+
+//beginFrom copies the tables of a transaction, so a part of it can be dropped on its own
+func (inventory *MInventoryDB) beginFrom(parent *transaction) *transaction {
+	return &transaction{tables: parent.tables.clone(), ctx: parent.ctx, audits: append([]data.AuditEntry(nil), parent.audits...), sales: append([]sale(nil), parent.sales...), reservations: parent.reservations}
+}
+
+//commit replaces the tables with the ones of the transaction, writes its audit entries and sales and refreshes the
+//product stock cache. The lock has to be held
+func (inventory *MInventoryDB) commit(transaction *transaction) {
+	inventory.tables = transaction.tables
+	now := time.Now().UTC()
+	for _, entry := range transaction.audits {
+		inventory.lastId++
+		entry.Id, entry.CreatedAt = inventory.lastId, now
+		inventory.audit = append(inventory.audit, entry)
+	}
+	for _, sold := range transaction.sales {
+		sold.soldAt = now
+		inventory.sales = append(inventory.sales, sold)
+	}
+	inventory.refresh()
+}
+
+//audit adds the entry to be written on commit, the location of ctx is used unless the entry has one
+func (transaction *transaction) audit(entry data.AuditEntry) {
+	entry.RID = request.GetRID(transaction.ctx)
+	if entry.Location == "" {
+		entry.Location = request.LocationFromContext(transaction.ctx)
+	}
+	transaction.audits = append(transaction.audits, entry)
+}
+
+//insertStock inserts the article into the inventory of the location of the transaction, an article already there fails
+func (transaction *transaction) insertStock(inventoryRec data.Stock) error {
+	location := request.LocationFromContext(transaction.ctx)
+	stock, err := inventoryRec.Stock.Thousandths()
+	if err == nil && stock < 0 {
+		err = errors.New("stock cannot be negative")
+	}
+	if _, ok := transaction.inventory[location][inventoryRec.ArtId]; err == nil && ok {
+		err = fmt.Errorf("article %s is already in inventory", inventoryRec.ArtId)
+	}
+	if err != nil {
+		return err
+	}
+	if transaction.inventory[location] == nil {
+		transaction.inventory[location] = map[string]article{}
+	}
+	transaction.inventory[location][inventoryRec.ArtId] = article{name: inventoryRec.Name, stock: stock, version: 1, updatedAt: time.Now()}
+	transaction.audit(data.AuditEntry{Operation: data.AuditUploadInventory, Entity: inventoryRec.ArtId, ArtId: inventoryRec.ArtId, StockAfter: data.QuantityOf(stock)})
+	return nil
+}
+
+//insertProduct makes the articles of the product its next recipe version, the articles have to be in inventory
+func (transaction *transaction) insertProduct(newProduct data.Product) error {
+	//the articles of the product are replaced, a deleted product is uploaded again
+	var product product
+	for _, contain := range newProduct.ContainArticles {
+		amount, err := contain.AmountOf.Thousandths()
+		if err != nil {
+			return err
+		}
+		if amount <= 0 {
+			return fmt.Errorf("amount of article %s must be positive", contain.ArtId)
+		}
+		for _, existing := range product.articles {
+			if existing.artId == contain.ArtId {
+				return fmt.Errorf("%w: product %s, article %s", db.ErrDuplicateProductArticle, newProduct.Name, contain.ArtId)
+			}
+		}
+		if !transaction.articleExists(contain.ArtId) {
+			return fmt.Errorf("%w: %s", db.ErrArticleNotFound, contain.ArtId)
+		}
+		product.articles = append(product.articles, productArticle{artId: contain.ArtId, amount: amount})
+	}
+	//a product without articles has no rows, like in the db
+	delete(transaction.products, newProduct.Name)
+	if len(product.articles) != 0 {
+		transaction.products[newProduct.Name] = product
+		transaction.recipes[newProduct.Name] = append(transaction.recipes[newProduct.Name], append([]productArticle(nil), product.articles...))
+	}
+	return nil
+}
+
+//sell checks if the product exist and in stock, then takes its articles from the stock of the location. The
+//reserved units are not available to promise
+func (transaction *transaction) sell(productName string) error {
+	product, ok := transaction.products[productName]
+	if !ok || product.deleted {
+		return errProductNotExist
+	}
+	if transaction.promisable(product, time.Now()) < 1 {
+		return errProductOutOfStock
+	}
+	transaction.take(productName, 1)
+	return nil
+}
+
+//take takes the articles of quantity units of the product from the stock of the location, audits them and records
+//the sale. The units are not checked, the caller has to
+func (transaction *transaction) take(productName string, quantity int) {
+	location := request.LocationFromContext(transaction.ctx)
+	articles := transaction.inventory[location]
+	for _, contain := range transaction.sortedArticles(productName) {
+		article := articles[contain.artId]
+		taken := contain.amount * int64(quantity)
+		transaction.audit(data.AuditEntry{Operation: data.AuditSell, Entity: productName, ArtId: contain.artId, StockBefore: data.QuantityOf(article.stock), StockAfter: data.QuantityOf(article.stock - taken)})
+		article.stock -= taken
+		article.version++
+		article.updatedAt = time.Now()
+		articles[contain.artId] = article
+	}
+	transaction.sales = append(transaction.sales, sale{product: productName, location: location, quantity: quantity})
+}
+
+//returnProduct puts the articles of quantity units of the product back to the stock of the location and audits them
+func (transaction *transaction) returnProduct(productName string, quantity int) error {
+	product, ok := transaction.products[productName]
+	if !ok || product.deleted {
+		return fmt.Errorf("%w, cannot be returned", db.ErrProductNotFound)
+	}
+	location := request.LocationFromContext(transaction.ctx)
+	articles := transaction.inventory[location]
+	for _, contain := range product.articles {
+		if _, ok := articles[contain.artId]; !ok {
+			return fmt.Errorf("%w: not every article of product %s is stocked in %s, it cannot be returned", db.ErrArticleNotFound, productName, location)
+		}
+	}
+
+	for _, contain := range transaction.sortedArticles(productName) {
+		article := articles[contain.artId]
+		returned := contain.amount * int64(quantity)
+		transaction.audit(data.AuditEntry{Operation: data.AuditReturnProduct, Entity: productName, ArtId: contain.artId, StockBefore: data.QuantityOf(article.stock), StockAfter: data.QuantityOf(article.stock + returned)})
+		article.stock += returned
+		article.version++
+		article.updatedAt = time.Now()
+		articles[contain.artId] = article
+	}
+	transaction.sales = append(transaction.sales, sale{product: productName, location: location, quantity: -quantity})
+	return nil
+}
+
+//promisable is the number of the product that can be built from the stock of the location of ctx the reservations
+//unexpired at now do not hold
+func (transaction *transaction) promisable(product product, now time.Time) int64 {
+	location := request.LocationFromContext(transaction.ctx)
+	var promisable int64
+	for i, contain := range product.articles {
+		buildable := transaction.promisableStock(contain.artId, location, now) / contain.amount
+		if i == 0 || buildable < promisable {
+			promisable = buildable
+		}
+	}
+	return promisable
+}
+
+//promisableStock is the stock of the article in the location the reservations unexpired at now do not hold
+func (transaction *transaction) promisableStock(artId string, location string, now time.Time) int64 {
+	stock := transaction.inventory[location][artId].stock
+	for _, reservation := range transaction.reservations {
+		reserved, ok := transaction.products[reservation.Name]
+		if reservation.Location != location || !reservation.ExpiresAt.After(now) || !ok || reserved.deleted {
+			continue
+		}
+		for _, held := range reserved.articles {
+			if held.artId == artId {
+				stock -= held.amount * int64(reservation.Quantity)
+			}
+		}
+	}
+	return stock
+}
+
+//adjust sets or changes the stock of the article in the location and audits it, the adjustment has to be valid
+func (transaction *transaction) adjust(adjustment data.StockAdjustment) (article, error) {
+	articles := transaction.inventory[request.LocationFromContext(transaction.ctx)]
+	article, ok := articles[adjustment.ArtId]
+	if !ok {
+		return article, fmt.Errorf("%w: %s", db.ErrArticleNotFound, adjustment.ArtId)
+	}
+	before := article.stock
+	if adjustment.Stock != nil {
+		article.stock, _ = adjustment.Stock.Thousandths()
+	} else {
+		delta, _ := adjustment.Delta.Thousandths()
+		article.stock += delta
+	}
+	if article.stock < 0 {
+		return article, fmt.Errorf("%w: %s", db.ErrNotEnoughStock, adjustment.ArtId)
+	}
+	article.version++
+	article.updatedAt = time.Now()
+	articles[adjustment.ArtId] = article
+	transaction.audit(data.AuditEntry{Operation: data.AuditAdjustArticle, Entity: adjustment.ArtId, ArtId: adjustment.ArtId, StockBefore: data.QuantityOf(before), StockAfter: data.QuantityOf(article.stock)})
+	return article, nil
+}
+
+//clone copies the tables deep enough that changing the copy leaves them as they are
+func (tables tables) clone() tables {
+	copied := tables
+	copied.inventory = make(map[string]map[string]article, len(tables.inventory))
+	for location, articles := range tables.inventory {
+		copied.inventory[location] = make(map[string]article, len(articles))
+		for artId, article := range articles {
+			copied.inventory[location][artId] = article
+		}
+	}
+	copied.products = make(map[string]product, len(tables.products))
+	for name, product := range tables.products {
+		product.articles = append([]productArticle(nil), product.articles...)
+		copied.products[name] = product
+	}
+	copied.recipes = make(map[string][][]productArticle, len(tables.recipes))
+	for name, versions := range tables.recipes {
+		copied.recipes[name] = append([][]productArticle(nil), versions...)
+	}
+	return copied
+}
+
+//stocks gets the articles of the location of ctx that match, ordered by art_id
+func (tables tables) stocks(ctx context.Context, match func(artId string, article article) bool) []data.Stock {
+	articles := tables.inventory[request.LocationFromContext(ctx)]
+	stocks := []data.Stock{}
+	for _, artId := range sortedKeys(articles) {
+		if match(artId, articles[artId]) {
+			stocks = append(stocks, data.Stock{ArtId: artId, Name: articles[artId].name, Stock: data.QuantityOf(articles[artId].stock)})
+		}
+	}
+	return stocks
+}
+
+//productStocks gets the number of every product that can be built from the stock of location, ordered by name
+func (tables tables) productStocks(location string, includeDeleted bool) data.ProductStocks {
+	stocks := data.ProductStocks{}
+	for _, name := range tables.productNames() {
+		product := tables.products[name]
+		if product.deleted && !includeDeleted {
+			continue
+		}
+		available := tables.available(product, location)
+		stocks = append(stocks, data.ProductStock{Name: name, AvailableProductNo: strconv.FormatInt(available, 10), Deleted: product.deleted})
+	}
+	return stocks
+}
+
+//available is the number of the product that can be built from the stock of location, a missing article has none
+func (tables tables) available(product product, location string) int64 {
+	var available int64
+	for i, contain := range product.articles {
+		buildable := tables.inventory[location][contain.artId].stock / contain.amount
+		if i == 0 || buildable < available {
+			available = buildable
+		}
+	}
+	return available
+}
+
+//articleExists checks if the article is stocked in any location
+func (tables tables) articleExists(artId string) bool {
+	for _, articles := range tables.inventory {
+		if _, ok := articles[artId]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+//productNames are the names of the products, deleted ones included, in order
+func (tables tables) productNames() []string {
+	names := make([]string, 0, len(tables.products))
+	for name := range tables.products {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//sortedArticles are the articles of the product ordered by art_id
+func (tables tables) sortedArticles(productName string) []productArticle {
+	articles := append([]productArticle(nil), tables.products[productName].articles...)
+	sort.SliceStable(articles, func(i, j int) bool { return articles[i].artId < articles[j].artId })
+	return articles
+}
+
+//locations are the locations having articles, in order
+func (tables tables) locations() []string {
+	locations := make([]string, 0, len(tables.inventory))
+	for location := range tables.inventory {
+		locations = append(locations, location)
+	}
+	sort.Strings(locations)
+	return locations
+}
+
+//addSample counts the record in the issue and keeps it if there are less than integritySamples
+func addSample(issue *data.IntegrityIssue, record data.IntegrityRecord) {
+	issue.Count++
+	if len(issue.Samples) < integritySamples {
+		issue.Samples = append(issue.Samples, record)
+	}
+}
+
+//sortedKeys are the art_ids of the articles in order
+func sortedKeys(articles map[string]article) []string {
+	artIds := make([]string, 0, len(articles))
+	for artId := range articles {
+		artIds = append(artIds, artId)
+	}
+	sort.Strings(artIds)
+	return artIds
+}
+
+//contains checks if values has value
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+//GetArticleProducts gets the products containing the article with the number of them that can be built from the
+//stock of the location and the gain of each unit of the article added, ordered by name
+func (inventory *MInventoryDB) GetArticleProducts(ctx context.Context, artId string) ([]data.ArticleProduct, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("GetArticleProducts() entry...")
+	inventory.lock.RLock()
+	defer inventory.lock.RUnlock()
+	location := request.LocationFromContext(ctx)
+	articles := inventory.tables.inventory[location]
+	products := []data.ArticleProduct{}
+	for _, name := range inventory.tables.productNames() {
+		recipe := inventory.tables.products[name]
+		if recipe.deleted {
+			continue
+		}
+		for _, contain := range recipe.articles {
+			if contain.artId != artId {
+				continue
+			}
+			var others product
+			for _, other := range recipe.articles {
+				if other.artId != artId {
+					others.articles = append(others.articles, other)
+				}
+			}
+			//the gain of a unit of the article in thousandths of the product
+			var gain int64
+			if len(others.articles) == 0 || articles[artId].stock/contain.amount < inventory.tables.available(others, location) {
+				gain = data.UnitGain / contain.amount
+			}
+			products = append(products, data.ArticleProduct{Name: name, AmountOf: data.QuantityOf(contain.amount),
+				Buildable: int(inventory.tables.available(recipe, location)), GainPerUnit: data.QuantityOf(gain)})
+		}
+	}
+	if _, ok := articles[artId]; !ok && len(products) == 0 {
+		log.Info("article is not found in system")
+		return nil, fmt.Errorf("%w: %s", db.ErrArticleNotFound, artId)
+	}
+
+	log.WithField("number of products: ", len(products)).Debug("GetArticleProducts(), returns the products...")
+	return products, nil
+}
+
+//CheckCart checks the units of the products of the cart available to promise in the location, line by line and all
+//together. Nothing is reserved
+func (inventory *MInventoryDB) CheckCart(ctx context.Context, cart data.Cart) (data.CartAvailability, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("CheckCart() entry...")
+	inventory.lock.RLock()
+	defer inventory.lock.RUnlock()
+	transaction := &transaction{tables: inventory.tables, ctx: ctx, reservations: inventory.reservations} //read only, the tables are not copied
+	location := request.LocationFromContext(ctx)
+	now := time.Now()
+
+	articles := make([][]data.CartArticle, len(cart))
+	for i, line := range cart {
+		product, ok := transaction.products[line.Name]
+		if !ok || product.deleted {
+			continue
+		}
+		for _, contain := range inventory.tables.sortedArticles(line.Name) {
+			articles[i] = append(articles[i], data.CartArticle{ArtId: contain.artId, Amount: contain.amount, Available: transaction.promisableStock(contain.artId, location, now)})
+		}
+	}
+	availability := data.CheckCart(cart, articles)
+
+	log.WithField("sufficient", availability.Sufficient).Debug("CheckCart(), returns the availability...")
+	return availability, nil
+}
+
+//CreateSnapshot saves the stock of every article of the location under the label, the stock can be diffed against it
+//later. A label is used once per location
+func (inventory *MInventoryDB) CreateSnapshot(ctx context.Context, label string) (data.Snapshot, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("CreateSnapshot() entry...")
+	inventory.lock.Lock()
+	defer inventory.lock.Unlock()
+	location := request.LocationFromContext(ctx)
+	if _, ok := inventory.snapshots[location][label]; ok {
+		log.WithField("label", label).Info(db.ErrSnapshotExists.Error())
+		return data.Snapshot{}, fmt.Errorf("%w: %s", db.ErrSnapshotExists, label)
+	}
+	saved := snapshot{createdAt: time.Now().UTC(), articles: make(map[string]article, len(inventory.tables.inventory[location]))}
+	for artId, stock := range inventory.tables.inventory[location] {
+		saved.articles[artId] = stock
+	}
+	if inventory.snapshots[location] == nil {
+		inventory.snapshots[location] = map[string]snapshot{}
+	}
+	inventory.snapshots[location][label] = saved
+
+	log.WithFields(logrus.Fields{"label": label, "articles": len(saved.articles)}).Debug("CreateSnapshot(), saved the stock...")
+	return data.Snapshot{Label: label, CreatedAt: saved.createdAt, Articles: len(saved.articles)}, nil
+}
+
+//DiffSnapshot gets the change of the stock of the articles of the location since the snapshot of the label, ordered
+//by art_id. The articles whose stock did not change are left out
+func (inventory *MInventoryDB) DiffSnapshot(ctx context.Context, label string) (data.Snapshot, []data.StockDelta, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("DiffSnapshot() entry...")
+	inventory.lock.RLock()
+	defer inventory.lock.RUnlock()
+	location := request.LocationFromContext(ctx)
+	saved, ok := inventory.snapshots[location][label]
+	if !ok {
+		log.Info("snapshot is not found in system")
+		return data.Snapshot{}, nil, fmt.Errorf("%w: %s", db.ErrSnapshotNotFound, label)
+	}
+	current := inventory.tables.inventory[location]
+	artIds := make([]string, 0, len(saved.articles))
+	for artId := range saved.articles {
+		artIds = append(artIds, artId)
+	}
+	for artId := range current {
+		if _, ok := saved.articles[artId]; !ok {
+			artIds = append(artIds, artId)
+		}
+	}
+	sort.Strings(artIds)
+	deltas := []data.StockDelta{}
+	for _, artId := range artIds {
+		before, after := saved.articles[artId], current[artId]
+		if before.stock == after.stock {
+			continue
+		}
+		name := after.name
+		if _, ok := current[artId]; !ok {
+			name = before.name //removed since, the name is the one in the snapshot
+		}
+		deltas = append(deltas, data.NewStockDelta(artId, name, before.stock, after.stock))
+	}
+
+	log.WithField("number of deltas: ", len(deltas)).Debug("DiffSnapshot(), returns the stock deltas...")
+	return data.Snapshot{Label: label, CreatedAt: saved.createdAt, Articles: len(saved.articles)}, deltas, nil
+}
+
+//GetStockByName gets the stock of the articles of the location summed by name, ordered by name
+func (inventory *MInventoryDB) GetStockByName(ctx context.Context) ([]data.NameStock, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("GetStockByName() entry...")
+	inventory.lock.RLock()
+	defer inventory.lock.RUnlock()
+	totals := make(map[string]int64)
+	counts := make(map[string]int)
+	for _, article := range inventory.tables.inventory[request.LocationFromContext(ctx)] {
+		totals[article.name] += article.stock
+		counts[article.name]++
+	}
+	names := make([]string, 0, len(totals))
+	for name := range totals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	stocks := []data.NameStock{}
+	for _, name := range names {
+		stocks = append(stocks, data.NameStock{Name: name, Stock: data.QuantityOf(totals[name]), Articles: counts[name]})
+	}
+	log.WithField("number of names to be returned: ", len(stocks)).Debug("GetStockByName(), returns the stocks...")
+	return stocks, nil
+}
+
+//PlanFulfillment sells the lines of the order from several locations. The units are allocated to the locations by the
+//strategy of the order from their stock available to promise, then taken from their stock together. Nothing is sold
+//when a product is not found or the locations cannot fill a line together
+func (inventory *MInventoryDB) PlanFulfillment(ctx context.Context, order data.Order) ([]data.Allocation, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("PlanFulfillment() entry...")
+	inventory.lock.Lock()
+	defer inventory.lock.Unlock()
+	transaction := inventory.begin(ctx)
+	now := time.Now()
+
+	var locations []data.FulfillmentLocation
+	for _, location := range order.FulfillmentLocations(transaction.locations(), request.LocationFromContext(ctx)) {
+		candidate := data.FulfillmentLocation{Location: location, Articles: make([][]data.CartArticle, len(order.Lines))}
+		for i, line := range order.Lines {
+			product, ok := transaction.products[line.Name]
+			if !ok || product.deleted {
+				continue
+			}
+			for _, contain := range transaction.sortedArticles(line.Name) {
+				candidate.Articles[i] = append(candidate.Articles[i], data.CartArticle{ArtId: contain.artId, Amount: contain.amount, Available: transaction.promisableStock(contain.artId, location, now)})
+			}
+		}
+		locations = append(locations, candidate)
+	}
+	allocations, notFound, unfilled := data.PlanFulfillment(order, locations)
+	if len(notFound) != 0 {
+		log.WithField("products", notFound).Info("product is not found in system")
+		return nil, fmt.Errorf("%w: %s, cannot be sold", db.ErrProductNotFound, strings.Join(notFound, ", "))
+	}
+	if len(unfilled) != 0 {
+		log.WithField("products", unfilled).Info("product items are out of stock")
+		return nil, fmt.Errorf("%w: the locations together cannot fill %s", db.ErrOutOfStock, strings.Join(unfilled, ", "))
+	}
+	for _, allocation := range allocations {
+		transaction.ctx = request.WithLocation(ctx, allocation.Location)
+		transaction.take(allocation.Name, allocation.Quantity)
+	}
+	inventory.commit(transaction)
+
+	log.WithField("number of allocations: ", len(allocations)).Debug("PlanFulfillment(), sold the order...")
+	return allocations, nil
+}
@@ -0,0 +1,216 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"github.com/auknl/warehouse/data"
+	"github.com/auknl/warehouse/db"
+	"github.com/auknl/warehouse/db/dbtest"
+	"github.com/auknl/warehouse/request"
+	"github.com/sirupsen/logrus"
+	"gotest.tools/assert"
+	"testing"
+	"time"
+)
+
+func newMemoryInventory(t *testing.T) *MInventoryDB {
+	inventory := &MInventoryDB{config: Config{Logger: logrus.NewEntry(logrus.New())}}
+	err := inventory.Open()
+	assert.NilError(t, err)
+	return inventory
+}
+
+func TestMInventoryDB_Conformance(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) db.Inventory {
+		return newMemoryInventory(t)
+	})
+}
+
+func TestMInventoryDB_PoolStats(t *testing.T) {
+	inventory := newMemoryInventory(t)
+
+	//there is no connection to check or pool
+	assert.NilError(t, inventory.Ping())
+	stats := inventory.PoolStats()
+	assert.Equal(t, stats.MaxOpenConnections, 0)
+	assert.Equal(t, stats.OpenConnections, 0)
+	assert.Equal(t, stats.InUse, 0)
+}
+
+func TestMInventoryDB_ProductStockCache(t *testing.T) {
+	inventory := newMemoryInventory(t)
+	ctx := context.Background()
+	north := request.WithLocation(ctx, "north")
+	//the cache has to match the product stock computed from the current stock
+	matches := func(ctx context.Context) {
+		t.Helper()
+		for _, includeDeleted := range []bool{false, true} {
+			err, fresh := inventory.GetProductStock(ctx, includeDeleted)
+			assert.NilError(t, err)
+			cached, err := inventory.GetCachedProductStock(ctx, includeDeleted)
+			assert.NilError(t, err)
+			assert.DeepEqual(t, cached, fresh)
+		}
+	}
+
+	inventoryData := dbtest.ExampleInventory(t)
+	err, _ := inventory.UploadInventory(ctx, inventoryData)
+	assert.NilError(t, err)
+	err, _ = inventory.UploadInventory(north, data.Inventory{Inventory: []data.Stock{{ArtId: "1", Name: "leg", Stock: "8"}, {ArtId: "2", Name: "screw", Stock: "16"}, {ArtId: "3", Name: "seat", Stock: "5"}}})
+	assert.NilError(t, err)
+	products := dbtest.ExampleProducts(t)
+	err, _ = inventory.UploadProducts(ctx, products, false)
+	assert.NilError(t, err)
+	matches(ctx)
+	matches(north)
+	cached, err := inventory.GetCachedProductStock(north, false)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, cached, data.ProductStocks{{Name: "Dining Chair", AvailableProductNo: "2"}})
+
+	//the changes refresh the cache
+	assert.NilError(t, inventory.SellProduct(ctx, "Dining Chair"))
+	matches(ctx)
+	assert.NilError(t, inventory.DeleteProduct(ctx, "Dinning Table"))
+	matches(ctx)
+	delta := data.Quantity("-4")
+	_, err = inventory.AdjustArticles(north, []data.StockAdjustment{{ArtId: "1", Delta: &delta}}, true)
+	assert.NilError(t, err)
+	matches(north)
+
+	//a change made around the inventory is only seen after a refresh
+	for _, articles := range inventory.tables.inventory {
+		leg := articles["1"]
+		leg.stock = 0
+		articles["1"] = leg
+	}
+	cached, err = inventory.GetCachedProductStock(north, false)
+	assert.NilError(t, err)
+	assert.Equal(t, len(cached), 1)
+	assert.NilError(t, inventory.RefreshProductStock(ctx))
+	matches(ctx)
+	matches(north)
+	cached, err = inventory.GetCachedProductStock(north, false)
+	assert.NilError(t, err)
+	assert.Equal(t, len(cached), 0)
+}
+
+func TestMInventoryDB_UploadInventoryFails(t *testing.T) {
+	inventory := newMemoryInventory(t)
+	ctx := context.Background()
+	err, _ := inventory.UploadInventory(ctx, data.Inventory{Inventory: []data.Stock{{ArtId: "1", Name: "leg", Stock: "8"}}})
+	assert.NilError(t, err)
+
+	//a failing record leaves out the records before it as well
+	err, inserted := inventory.UploadInventory(ctx, data.Inventory{Inventory: []data.Stock{{ArtId: "2", Name: "seat", Stock: "1"}, {ArtId: "1", Name: "leg", Stock: "4"}}})
+	assert.Error(t, err, "article 1 is already in inventory")
+	assert.Equal(t, inserted, 0)
+	err, _ = inventory.UploadInventory(ctx, data.Inventory{Inventory: []data.Stock{{ArtId: "3", Name: "top", Stock: "-1"}}})
+	assert.Error(t, err, "stock cannot be negative")
+	err, stocks := inventory.GetInventory(ctx)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, stocks, []data.Stock{{ArtId: "1", Name: "leg", Stock: "8"}})
+	entries, err := inventory.GetAuditLog(ctx, "", 10)
+	assert.NilError(t, err)
+	assert.Equal(t, len(entries), 1)
+
+	//the same article can be uploaded to another location
+	err, _ = inventory.UploadInventory(request.WithLocation(ctx, "north"), data.Inventory{Inventory: []data.Stock{{ArtId: "1", Name: "leg", Stock: "4"}}})
+	assert.NilError(t, err)
+}
+
+func TestMInventoryDB_AuditLog(t *testing.T) {
+	inventory := newMemoryInventory(t)
+	ctx := request.WithRID(context.Background(), "rid-1")
+
+	err, _ := inventory.UploadInventory(ctx, data.Inventory{Inventory: []data.Stock{
+		{ArtId: "1", Name: "leg", Stock: "8"},
+		{ArtId: "2", Name: "seat", Stock: "1"},
+	}})
+	assert.NilError(t, err)
+	err, _ = inventory.UploadProducts(ctx, data.Products{Products: []data.Product{
+		{Name: "chair", ContainArticles: []data.ArticleContain{{ArtId: "1", AmountOf: "4"}, {ArtId: "2", AmountOf: "1"}}},
+	}}, false)
+	assert.NilError(t, err)
+	assert.NilError(t, inventory.SellProduct(ctx, "chair"))
+
+	entries, err := inventory.GetAuditLog(ctx, "chair", 10)
+	assert.NilError(t, err)
+	assert.Equal(t, len(entries), 3)
+	for i := range entries {
+		assert.Assert(t, !entries[i].CreatedAt.IsZero())
+		entries[i].Id, entries[i].CreatedAt = 0, time.Time{}
+	}
+	assert.DeepEqual(t, entries, []data.AuditEntry{
+		{Operation: data.AuditSell, RID: "rid-1", Entity: "chair", ArtId: "2", Location: request.DefaultLocation, StockBefore: "1", StockAfter: "0"},
+		{Operation: data.AuditSell, RID: "rid-1", Entity: "chair", ArtId: "1", Location: request.DefaultLocation, StockBefore: "8", StockAfter: "4"},
+		{Operation: data.AuditUploadProduct, RID: "rid-1", Entity: "chair", Location: request.DefaultLocation},
+	})
+	//the article entries include the sale
+	entries, err = inventory.GetAuditLog(ctx, "1", 10)
+	assert.NilError(t, err)
+	assert.Equal(t, len(entries), 2)
+	assert.Equal(t, entries[1].Operation, data.AuditUploadInventory)
+	assert.Equal(t, entries[1].StockAfter, data.Quantity("8"))
+
+	//failed sales and the previews are not audited
+	err, _ = inventory.UploadInventory(ctx, data.Inventory{Inventory: []data.Stock{{ArtId: "3", Name: "top", Stock: "1"}}})
+	assert.NilError(t, err)
+	err, _ = inventory.UploadProducts(ctx, data.Products{Products: []data.Product{
+		{Name: "table", ContainArticles: []data.ArticleContain{{ArtId: "3", AmountOf: "1"}}},
+	}}, false)
+	assert.NilError(t, err)
+	assert.Assert(t, errors.Is(inventory.SellProduct(ctx, "chair"), db.ErrOutOfStock))
+	_, err = inventory.PreviewSale(ctx, "table")
+	assert.NilError(t, err)
+	entries, err = inventory.GetAuditLog(ctx, "", 10)
+	assert.NilError(t, err)
+	assert.Equal(t, len(entries), 7)
+	assert.Equal(t, entries[0].Operation, data.AuditUploadProduct)
+	assert.Equal(t, entries[0].Entity, "table")
+
+	_, err = inventory.GetAuditLog(ctx, "", 0)
+	assert.Error(t, err, "audit limit must be positive")
+}
+
+func TestMInventoryDB_CheckIntegrity(t *testing.T) {
+	inventory := newMemoryInventory(t)
+	ctx := context.Background()
+
+	err, _ := inventory.UploadInventory(ctx, data.Inventory{Inventory: []data.Stock{
+		{ArtId: "1", Name: "leg", Stock: "8"},
+		{ArtId: "2", Name: "seat", Stock: "2"},
+	}})
+	assert.NilError(t, err)
+	err, _ = inventory.UploadProducts(ctx, data.Products{Products: []data.Product{
+		{Name: "chair", ContainArticles: []data.ArticleContain{{ArtId: "1", AmountOf: "4"}, {ArtId: "2", AmountOf: "1"}}},
+	}}, false)
+	assert.NilError(t, err)
+
+	report, err := inventory.CheckIntegrity(ctx)
+	assert.NilError(t, err)
+	assert.Assert(t, report.Consistent())
+
+	//the methods prevent the inconsistencies, the tables are changed directly to seed them
+	inventory.tables.products["stool"] = product{articles: []productArticle{{artId: "9", amount: 3000}}}
+	seat := inventory.tables.inventory[request.DefaultLocation]["2"]
+	seat.stock = -2000
+	inventory.tables.inventory[request.DefaultLocation]["2"] = seat
+	chair := inventory.tables.products["chair"]
+	chair.articles = append(chair.articles, productArticle{artId: "1", amount: 4000})
+	inventory.tables.products["chair"] = chair
+
+	report, err = inventory.CheckIntegrity(ctx)
+	assert.NilError(t, err)
+	assert.Assert(t, !report.Consistent())
+	assert.DeepEqual(t, report, data.IntegrityReport{
+		MissingArticles: data.IntegrityIssue{Count: 1, Samples: []data.IntegrityRecord{
+			{ProductName: "stool", ArtId: "9"},
+		}},
+		NegativeStock: data.IntegrityIssue{Count: 1, Samples: []data.IntegrityRecord{
+			{ArtId: "2", Location: request.DefaultLocation, Stock: "-2"},
+		}},
+		DuplicateProductArticles: data.IntegrityIssue{Count: 1, Samples: []data.IntegrityRecord{
+			{ProductName: "chair", ArtId: "1"},
+		}},
+	})
+}
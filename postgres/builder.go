@@ -0,0 +1,120 @@
+package postgres
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//errors of the built queries, the columns and operators have to be allowed ones
+var (
+	errUnknownColumn   = errors.New("unknown column")
+	errUnknownOperator = errors.New("unknown operator")
+)
+
+//articleColumns are the inventory columns the article queries can filter and sort by, keyed by their json name
+var articleColumns = map[string]string{
+	"art_id":     "art_id",
+	"name":       "art_name",
+	"stock":      "stock",
+	"location":   "location_id",
+	"updated_at": "updated_at",
+}
+
+//operators the conditions of the built queries can use
+var operators = map[string]bool{"=": true, "<>": true, "<": true, "<=": true, ">": true, ">=": true, "ILIKE": true}
+
+//queryBuilder assembles a query from a SELECT statement and its WHERE, ORDER BY and LIMIT clauses. The columns are
+//looked up in the allowed ones and the values are bound parameters, so no input ever becomes SQL text. The first
+//problem is returned by Build
+type queryBuilder struct {
+	base    string
+	columns map[string]string
+	where   []string
+	orderBy []string
+	limit   string
+	args    []interface{}
+	err     error
+}
+
+//newQueryBuilder starts a query of the base statement, which has no clauses, filtering and sorting by the columns
+func newQueryBuilder(base string, columns map[string]string) *queryBuilder {
+	return &queryBuilder{base: base, columns: columns}
+}
+
+//Where adds the condition of the column, the conditions are joined with AND. The ILIKE patterns escape with \
+func (builder *queryBuilder) Where(name string, operator string, value interface{}) *queryBuilder {
+	column, ok := builder.column(name)
+	if !ok {
+		return builder
+	}
+	if !operators[operator] {
+		builder.fail(fmt.Errorf("%w %q", errUnknownOperator, operator))
+		return builder
+	}
+	condition := column + " " + operator + " " + builder.bind(value)
+	if operator == "ILIKE" {
+		condition += ` ESCAPE '\'`
+	}
+	builder.where = append(builder.where, condition)
+	return builder
+}
+
+//OrderBy sorts by the column, after the columns given before
+func (builder *queryBuilder) OrderBy(name string, descending bool) *queryBuilder {
+	column, ok := builder.column(name)
+	if !ok {
+		return builder
+	}
+	if descending {
+		column += " DESC"
+	}
+	builder.orderBy = append(builder.orderBy, column)
+	return builder
+}
+
+//Limit returns at most n rows
+func (builder *queryBuilder) Limit(n int) *queryBuilder {
+	builder.limit = " LIMIT " + builder.bind(n)
+	return builder
+}
+
+//Build returns the query and its arguments in the order of their placeholders
+func (builder *queryBuilder) Build() (string, []interface{}, error) {
+	if builder.err != nil {
+		return "", nil, builder.err
+	}
+	var query strings.Builder
+	query.WriteString(builder.base)
+	if len(builder.where) != 0 {
+		query.WriteString(" WHERE " + strings.Join(builder.where, " AND "))
+	}
+	if len(builder.orderBy) != 0 {
+		query.WriteString(" ORDER BY " + strings.Join(builder.orderBy, ", "))
+	}
+	query.WriteString(builder.limit)
+	return query.String(), builder.args, nil
+}
+
+//column is the SQL column of the name, it fails the query if the column is not allowed
+func (builder *queryBuilder) column(name string) (string, bool) {
+	column, ok := builder.columns[name]
+	if !ok {
+		builder.fail(fmt.Errorf("%w %q", errUnknownColumn, name))
+	}
+	return column, ok
+}
+
+//bind adds the value to the arguments and returns its placeholder
+func (builder *queryBuilder) bind(value interface{}) string {
+	builder.args = append(builder.args, value)
+	return "$" + strconv.Itoa(len(builder.args))
+}
+
+//fail keeps the first problem of the query
+func (builder *queryBuilder) fail(err error) {
+	if builder.err == nil {
+		builder.err = err
+	}
+}
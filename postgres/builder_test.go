@@ -0,0 +1,90 @@
+package postgres
+
+import (
+	"errors"
+	"gotest.tools/assert"
+	"testing"
+)
+
+func TestQueryBuilder_Build(t *testing.T) {
+	base := "SELECT art_id, art_name, stock FROM inventory"
+	tests := []struct {
+		name      string
+		build     func(builder *queryBuilder) *queryBuilder
+		wantQuery string
+		wantArgs  []interface{}
+		wantErr   error
+	}{
+		{
+			name:      "no_clauses",
+			build:     func(builder *queryBuilder) *queryBuilder { return builder },
+			wantQuery: base,
+		},
+		{
+			name: "location_ordered",
+			build: func(builder *queryBuilder) *queryBuilder {
+				return builder.Where("location", "=", "default").OrderBy("art_id", false)
+			},
+			wantQuery: base + " WHERE location_id = $1 ORDER BY art_id",
+			wantArgs:  []interface{}{"default"},
+		},
+		{
+			name: "search",
+			build: func(builder *queryBuilder) *queryBuilder {
+				return builder.Where("location", "=", "default").Where("name", "ILIKE", "%leg%").OrderBy("art_id", false).Limit(20)
+			},
+			wantQuery: base + ` WHERE location_id = $1 AND art_name ILIKE $2 ESCAPE '\' ORDER BY art_id LIMIT $3`,
+			wantArgs:  []interface{}{"default", "%leg%", 20},
+		},
+		{
+			name: "several_orders",
+			build: func(builder *queryBuilder) *queryBuilder {
+				return builder.Where("stock", "<=", "5").OrderBy("stock", true).OrderBy("name", false)
+			},
+			wantQuery: base + " WHERE stock <= $1 ORDER BY stock DESC, art_name",
+			wantArgs:  []interface{}{"5"},
+		},
+		{
+			name: "input_is_bound",
+			build: func(builder *queryBuilder) *queryBuilder {
+				return builder.Where("art_id", "=", "1'; DROP TABLE inventory; --")
+			},
+			wantQuery: base + " WHERE art_id = $1",
+			wantArgs:  []interface{}{"1'; DROP TABLE inventory; --"},
+		},
+		{
+			name: "unknown_filter_column",
+			build: func(builder *queryBuilder) *queryBuilder {
+				return builder.Where("art_name; DROP TABLE inventory", "=", "leg")
+			},
+			wantErr: errUnknownColumn,
+		},
+		{
+			name: "unknown_order_column",
+			build: func(builder *queryBuilder) *queryBuilder {
+				return builder.Where("location", "=", "default").OrderBy("version", false)
+			},
+			wantErr: errUnknownColumn,
+		},
+		{
+			name: "unknown_operator",
+			build: func(builder *queryBuilder) *queryBuilder {
+				return builder.Where("stock", "= 0 OR 1 =", "1")
+			},
+			wantErr: errUnknownOperator,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, args, err := tt.build(newQueryBuilder(base, articleColumns)).Build()
+			if tt.wantErr != nil {
+				assert.Assert(t, errors.Is(err, tt.wantErr))
+				assert.Equal(t, query, "")
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, query, tt.wantQuery)
+			assert.DeepEqual(t, args, tt.wantArgs)
+		})
+	}
+}
@@ -6,12 +6,16 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/auknl/warehouse/data"
 	"github.com/auknl/warehouse/db"
+	"github.com/auknl/warehouse/db/dbtest"
+	"github.com/auknl/warehouse/request"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/lib/pq"
 	"github.com/ory/dockertest/v3"
 	"github.com/sirupsen/logrus"
 	"gotest.tools/assert"
@@ -22,12 +26,14 @@ import (
 	"net/url"
 	"runtime"
 	"testing"
+	"time"
 
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 )
 
 type dockerDBConn struct {
 	Conn *sql.DB
+	URL  string
 }
 
 var (
@@ -37,6 +43,7 @@ var (
 )
 
 // refs: https://github.com/ory/dockertest
+
 func initDB(log *logrus.Logger) (*dockertest.Pool, *dockertest.Resource) {
 	pgURL := initPostgres()
 	pgPass, _ := pgURL.User.Password()
@@ -68,7 +75,7 @@ func initDB(log *logrus.Logger) (*dockertest.Pool, *dockertest.Resource) {
 		pgURL.Host = net.JoinHostPort(resource.GetBoundIP("5432/tcp"), resource.GetPort("5432/tcp"))
 	}
 
-	DockerDBConn = &dockerDBConn{}
+	DockerDBConn = &dockerDBConn{URL: pgURL.String()}
 	// exponential backoff-retry, because the application in the container might not be ready to accept connections yet
 	if err := pool.Retry(func() error {
 		DockerDBConn.Conn, err = sql.Open("postgres", pgURL.String())
@@ -86,6 +93,35 @@ func initDB(log *logrus.Logger) (*dockertest.Pool, *dockertest.Resource) {
 	return pool, resource
 }
 
+//startDB starts the container of the test with initDB and purges it when the test ends, the test is skipped if Docker
+//is not available
+
+func startDB(t *testing.T) {
+	t.Helper()
+	pool, err := dockertest.NewPool("")
+	if err == nil {
+		err = pool.Client.Ping()
+	}
+	if err != nil {
+		t.Skipf("Docker is not available: %s", err)
+	}
+	pool, resource := initDB(logger)
+	t.Cleanup(func() {
+		closeDB(pool, resource)
+	})
+}
+
+//newDockerInventory connects a PInventoryDB to the migrated database of a disposable container
+
+func newDockerInventory(t *testing.T) *PInventoryDB {
+	t.Helper()
+	startDB(t)
+	return &PInventoryDB{
+		db:     DockerDBConn.Conn,
+		config: Config{Logger: logrus.NewEntry(logrus.New())},
+	}
+}
+
 func closeDB(pool *dockertest.Pool, resource *dockertest.Resource) {
 	if err := pool.Purge(resource); err != nil {
 		phrase := fmt.Sprintf("Could not purge resource: %s", err)
@@ -93,6 +129,66 @@ func closeDB(pool *dockertest.Pool, resource *dockertest.Resource) {
 	}
 }
 
+func TestPInventoryDB_Conformance(t *testing.T) { //The scenarios every backend runs, each one in a container of its own
+	dbtest.Run(t, func(t *testing.T) db.Inventory {
+		return newDockerInventory(t)
+	})
+}
+
+func TestPInventoryDB_ValidateConnectionCredentials(t *testing.T) { //Wrong credentials are found before the first request
+	startDB(t)
+	good, err := url.Parse(DockerDBConn.URL)
+	assert.NilError(t, err)
+	bad := *good
+	bad.User = url.UserPassword(good.User.Username(), "wrong-password")
+	for _, tt := range []struct {
+		name    string
+		url     string
+		wantErr string
+	}{
+		{name: "good_credentials", url: good.String()},
+		{name: "bad_credentials", url: bad.String(), wantErr: "password authentication failed"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			inventory := &PInventoryDB{config: Config{Logger: logrus.NewEntry(logger), Driver: "postgres", URL: tt.url,
+				ValidationQuery: "SELECT 1 FROM inventory LIMIT 1"}}
+			assert.NilError(t, inventory.Open())
+			defer inventory.db.Close()
+			err := inventory.ValidateConnection(context.Background())
+			if tt.wantErr == "" {
+				assert.NilError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestPInventoryDB_GetSchemaVersion(t *testing.T) { //A database one migration behind is not at the version of the binary
+	inventory := newDockerInventory(t)
+	ctx := context.Background()
+	version, dirty, err := inventory.GetSchemaVersion(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, version, db.SchemaVersion)
+	assert.Equal(t, dirty, false)
+
+	driver, err := postgres.WithInstance(DockerDBConn.Conn, &postgres.Config{})
+	assert.NilError(t, err)
+	migrateSql, err := migrate.NewWithDatabaseInstance("file://../db/migrations", "inventory", driver)
+	assert.NilError(t, err)
+	assert.NilError(t, migrateSql.Steps(-1))
+	version, dirty, err = inventory.GetSchemaVersion(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, version, db.SchemaVersion-1)
+	assert.Equal(t, dirty, false)
+
+	_, err = DockerDBConn.Conn.ExecContext(ctx, "DROP TABLE schema_migrations")
+	assert.NilError(t, err)
+	version, _, err = inventory.GetSchemaVersion(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, version, 0)
+}
+
 func (db dockerDBConn) initMigrations() {
 	driver, err := postgres.WithInstance(db.Conn, &postgres.Config{})
 	if err != nil {
@@ -106,7 +202,7 @@ func (db dockerDBConn) initMigrations() {
 		log.Fatal(err)
 	}
 
-	err = migrateSql.Steps(1)
+	err = migrateSql.Up()
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -143,15 +239,14 @@ func uploadProduct(inventorydb db.Inventory, ctx context.Context) {
 	file, _ := ioutil.ReadFile("./testdata/example_products.json")
 	json.Unmarshal([]byte(file), &products)
 
-	err, _ := inventorydb.UploadProducts(ctx, products)
+	err, _ := inventorydb.UploadProducts(ctx, products, false)
 	if err != nil {
 		logger.WithError(err).Fatal("Could not upload products")
 	}
 }
 
 func TestPInventoryDB_UploadInventory(t *testing.T) {
-	pool, resource := initDB(logger)
-	defer closeDB(pool, resource)
+	startDB(t)
 	conn := DockerDBConn.Conn
 	recorder := httptest.NewRecorder()
 	ctx, _ := gin.CreateTestContext(recorder)
@@ -170,8 +265,7 @@ func TestPInventoryDB_UploadInventory(t *testing.T) {
 }
 
 func TestPInventoryDB_UploadProducts(t *testing.T) {
-	pool, resource := initDB(logger)
-	defer closeDB(pool, resource)
+	startDB(t)
 	conn := DockerDBConn.Conn
 	recorder := httptest.NewRecorder()
 	ctx, _ := gin.CreateTestContext(recorder)
@@ -183,18 +277,17 @@ func TestPInventoryDB_UploadProducts(t *testing.T) {
 	file, _ := ioutil.ReadFile("./testdata/example_products.json")
 	json.Unmarshal([]byte(file), &products)
 
-	//Product table has foreign key from Inventory table
+	//Products can only be made of articles in the inventory
 	uploadInventory(inventory, ctx)
 
-	err, stock := inventory.UploadProducts(ctx, products)
+	err, stock := inventory.UploadProducts(ctx, products, false)
 	assert.Equal(t, stock, len(products.Products))
 	assert.Equal(t, err, nil)
 
 }
 
 func TestPInventoryDB_GetInventory(t *testing.T) {
-	pool, resource := initDB(logger)
-	defer closeDB(pool, resource)
+	startDB(t)
 	conn := DockerDBConn.Conn
 	recorder := httptest.NewRecorder()
 	ctx, _ := gin.CreateTestContext(recorder)
@@ -213,9 +306,31 @@ func TestPInventoryDB_GetInventory(t *testing.T) {
 
 }
 
+func TestPInventoryDB_QueryTimeout(t *testing.T) { //Statements are cancelled at the query timeout, not the request deadline
+	startDB(t)
+	inventory := &PInventoryDB{
+		config: Config{Logger: logrus.NewEntry(logrus.New()), Driver: "postgres", URL: DockerDBConn.URL, QueryTimeout: 200 * time.Millisecond},
+	}
+	err := inventory.Open()
+	assert.Equal(t, err, nil)
+	defer inventory.db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	started := time.Now()
+	_, err = inventory.db.ExecContext(ctx, "SELECT pg_sleep(5)")
+	var pqErr *pq.Error
+	assert.Equal(t, errors.As(err, &pqErr), true)
+	assert.Equal(t, string(pqErr.Code), "57014") //query_canceled
+	assert.Assert(t, time.Since(started) < 2*time.Second)
+
+	//quick statements are not affected
+	_, err = inventory.db.ExecContext(ctx, "SELECT pg_sleep(0.05)")
+	assert.Equal(t, err, nil)
+}
+
 func TestPInventoryDB_GetProductStock(t *testing.T) {
-	pool, resource := initDB(logger)
-	defer closeDB(pool, resource)
+	startDB(t)
 	conn := DockerDBConn.Conn
 	recorder := httptest.NewRecorder()
 	ctx, _ := gin.CreateTestContext(recorder)
@@ -227,15 +342,14 @@ func TestPInventoryDB_GetProductStock(t *testing.T) {
 	uploadInventory(inventory, ctx)
 	uploadProduct(inventory, ctx)
 
-	err, stockOfProduct := inventory.GetProductStock(ctx)
+	err, stockOfProduct := inventory.GetProductStock(ctx, false)
 	assert.Equal(t, len(stockOfProduct), 2)
 	assert.Equal(t, err, nil)
 
 }
 
 func TestPInventoryDB_SellProduct(t *testing.T) {
-	pool, resource := initDB(logger)
-	defer closeDB(pool, resource)
+	startDB(t)
 	conn := DockerDBConn.Conn
 	recorder := httptest.NewRecorder()
 	ctx, _ := gin.CreateTestContext(recorder)
@@ -253,9 +367,48 @@ func TestPInventoryDB_SellProduct(t *testing.T) {
 
 }
 
+func TestPInventoryDB_ProductStockCache(t *testing.T) { //The cache matches a fresh computation after every change and refresh
+	startDB(t)
+	conn := DockerDBConn.Conn
+	recorder := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(recorder)
+	inventory := &PInventoryDB{
+		db:     conn,
+		config: Config{Logger: logrus.NewEntry(logrus.New())},
+	}
+	matches := func() {
+		t.Helper()
+		for _, includeDeleted := range []bool{false, true} {
+			err, fresh := inventory.GetProductStock(ctx, includeDeleted)
+			assert.Equal(t, err, nil)
+			cached, err := inventory.GetCachedProductStock(ctx, includeDeleted)
+			assert.Equal(t, err, nil)
+			assert.DeepEqual(t, cached, fresh)
+		}
+	}
+	uploadInventory(inventory, ctx)
+	uploadProduct(inventory, ctx)
+	matches()
+
+	err := inventory.SellProduct(ctx, "Dinning Table")
+	assert.Equal(t, err, nil)
+	matches()
+	err = inventory.DeleteProduct(ctx, "Dining Chair")
+	assert.Equal(t, err, nil)
+	matches()
+
+	_, err = conn.Exec("UPDATE inventory SET stock=0 WHERE art_id='3'")
+	assert.Equal(t, err, nil)
+	cached, err := inventory.GetCachedProductStock(ctx, true)
+	assert.Equal(t, err, nil)
+	assert.DeepEqual(t, cached, data.ProductStocks{{Name: "Dining Chair", AvailableProductNo: "1", Deleted: true}})
+	err = inventory.RefreshProductStock(ctx)
+	assert.Equal(t, err, nil)
+	matches()
+}
+
 func TestPInventoryDB_GetProductStockOOS(t *testing.T) { //After One "Dinning Table" Product Out Of Stock
-	pool, resource := initDB(logger)
-	defer closeDB(pool, resource)
+	startDB(t)
 	conn := DockerDBConn.Conn
 	recorder := httptest.NewRecorder()
 	ctx, _ := gin.CreateTestContext(recorder)
@@ -271,15 +424,14 @@ func TestPInventoryDB_GetProductStockOOS(t *testing.T) { //After One "Dinning Ta
 	//Only one product was in the stock,selling it
 	inventory.SellProduct(ctx, "Dinning Table")
 
-	err, stockOfProduct := inventory.GetProductStock(ctx)
+	err, stockOfProduct := inventory.GetProductStock(ctx, false)
 	assert.Equal(t, len(stockOfProduct), 1)
 	assert.Equal(t, err, nil)
 
 }
 
 func TestPInventoryDB_Ping(t *testing.T) {
-	pool, resource := initDB(logger)
-	defer closeDB(pool, resource)
+	startDB(t)
 	conn := DockerDBConn.Conn
 	inventory := &PInventoryDB{
 		db:     conn,
@@ -291,8 +443,7 @@ func TestPInventoryDB_Ping(t *testing.T) {
 }
 
 func TestPInventoryDB_Open(t *testing.T) {
-	pool, resource := initDB(logger)
-	defer closeDB(pool, resource)
+	startDB(t)
 	conn := DockerDBConn.Conn
 	inventory := &PInventoryDB{
 		db:     conn,
@@ -304,8 +455,7 @@ func TestPInventoryDB_Open(t *testing.T) {
 }
 
 func TestPInventoryDB_SellOOSProduct(t *testing.T) { //Try to sell "Dinning Table" which is  Out Of Stock
-	pool, resource := initDB(logger)
-	defer closeDB(pool, resource)
+	startDB(t)
 	conn := DockerDBConn.Conn
 	recorder := httptest.NewRecorder()
 	ctx, _ := gin.CreateTestContext(recorder)
@@ -323,13 +473,13 @@ func TestPInventoryDB_SellOOSProduct(t *testing.T) { //Try to sell "Dinning Tabl
 	err := inventory.SellProduct(ctx, "Dinning Table")
 	if err != nil {
 		assert.Equal(t, err.Error(), "this product is not in stock, cannot be sold")
+		assert.Equal(t, errors.Is(err, db.ErrOutOfStock), true)
 	}
 
 }
 
 func TestPInventoryDB_SellProductNotExist(t *testing.T) { //Try to sell a product that is not in system
-	pool, resource := initDB(logger)
-	defer closeDB(pool, resource)
+	startDB(t)
 	conn := DockerDBConn.Conn
 	recorder := httptest.NewRecorder()
 	ctx, _ := gin.CreateTestContext(recorder)
@@ -345,6 +495,235 @@ func TestPInventoryDB_SellProductNotExist(t *testing.T) { //Try to sell a produc
 	err := inventory.SellProduct(ctx, "NotExist")
 	if err != nil {
 		assert.Equal(t, err.Error(), "this product is not in system, cannot be sold")
+		assert.Equal(t, errors.Is(err, db.ErrProductNotFound), true)
+	}
+
+}
+
+func TestPInventoryDB_DeleteRestoreProduct(t *testing.T) { //Soft delete "Dining Chair", then restore it
+	startDB(t)
+	conn := DockerDBConn.Conn
+	recorder := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(recorder)
+	inventory := &PInventoryDB{
+		db:     conn,
+		config: Config{Logger: logrus.NewEntry(logrus.New())},
+	}
+
+	//fill the tables before apply query
+	uploadInventory(inventory, ctx)
+	uploadProduct(inventory, ctx)
+
+	err := inventory.DeleteProduct(ctx, "Dining Chair")
+	assert.Equal(t, err, nil)
+
+	//deleted product is hidden by default
+	err, stockOfProduct := inventory.GetProductStock(ctx, false)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(stockOfProduct), 1)
+	assert.Equal(t, stockOfProduct[0].Name, "Dinning Table")
+
+	//and returned when deleted ones are included
+	err, stockOfProduct = inventory.GetProductStock(ctx, true)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(stockOfProduct), 2)
+	assert.Equal(t, stockOfProduct[0].Name, "Dining Chair")
+	assert.Equal(t, stockOfProduct[0].Deleted, true)
+
+	//deleted product cannot be sold or deleted again
+	err = inventory.SellProduct(ctx, "Dining Chair")
+	assert.Error(t, err, "this product is not in system, cannot be sold")
+	err = inventory.DeleteProduct(ctx, "Dining Chair")
+	assert.Error(t, err, "this product is not in system, cannot be deleted")
+
+	err = inventory.RestoreProduct(ctx, "Dining Chair")
+	assert.Equal(t, err, nil)
+	err = inventory.RestoreProduct(ctx, "Dining Chair")
+	assert.Error(t, err, "this product is not deleted, cannot be restored")
+
+	err, stockOfProduct = inventory.GetProductStock(ctx, false)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(stockOfProduct), 2)
+	assert.Equal(t, stockOfProduct[0].Deleted, false)
+}
+
+func TestPInventoryDB_AdjustArticles(t *testing.T) {
+	startDB(t)
+	conn := DockerDBConn.Conn
+	recorder := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(recorder)
+	inventory := &PInventoryDB{
+		db:     conn,
+		config: Config{Logger: logrus.NewEntry(logrus.New())},
+	}
+	uploadInventory(inventory, ctx)
+
+	stock, delta, tooMuch := data.Quantity("10"), data.Quantity("-3"), data.Quantity("-100")
+	adjustments := []data.StockAdjustment{
+		{ArtId: "1", Stock: &stock},
+		{ArtId: "2", Delta: &delta},
+		{ArtId: "NotExist", Delta: &delta},
+		{ArtId: "3", Delta: &tooMuch},
+	}
+
+	//atomic adjustment does not apply anything when a line fails
+	updated, err := inventory.AdjustArticles(ctx, adjustments, true)
+	assert.Equal(t, updated, 0)
+	failures, ok := err.(data.StockAdjustmentErrors)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, len(failures), 2)
+	err, stocks := inventory.GetInventory(ctx)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, stocks[0].Stock, data.Quantity("12"))
+	assert.Equal(t, stocks[1].Stock, data.Quantity("17"))
+
+	//otherwise valid lines are applied and the failed ones are reported
+	updated, err = inventory.AdjustArticles(ctx, adjustments, false)
+	assert.Equal(t, updated, 2)
+	failures, ok = err.(data.StockAdjustmentErrors)
+	assert.Equal(t, ok, true)
+	assert.DeepEqual(t, failures, data.StockAdjustmentErrors{
+		{Line: 2, ArtId: "NotExist", Error: "article is not found in system"},
+		{Line: 3, ArtId: "3", Error: "not enough stock for the given delta"},
+	})
+	err, stocks = inventory.GetInventory(ctx)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, stocks[0].Stock, data.Quantity("10"))
+	assert.Equal(t, stocks[1].Stock, data.Quantity("14"))
+	assert.Equal(t, stocks[2].Stock, data.Quantity("2"))
+}
+
+func TestPInventoryDB_UploadSellVerify(t *testing.T) { //the whole flow of the service against real SQL
+	inventory := newDockerInventory(t)
+	ctx := request.WithLocation(context.Background(), "north")
+
+	uploadInventory(inventory, ctx)
+	uploadProduct(inventory, ctx)
+
+	//legs 12/4, screws 17/8, seats 2/1
+	_, maxBuildable, err := inventory.IsProductBuildable(ctx, "Dining Chair", 1)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, maxBuildable, 2)
+
+	assert.Equal(t, inventory.SellProduct(ctx, "Dining Chair"), nil)
+	assert.Equal(t, inventory.SellProduct(ctx, "Dining Chair"), nil)
+	err = inventory.SellProduct(ctx, "Dining Chair")
+	assert.Assert(t, errors.Is(err, db.ErrOutOfStock))
+
+	err, stock := inventory.GetInventory(ctx)
+	assert.Equal(t, err, nil)
+	assert.DeepEqual(t, stock, []data.Stock{
+		{ArtId: "1", Name: "leg", Stock: "4"},
+		{ArtId: "2", Name: "screw", Stock: "1"},
+		{ArtId: "3", Name: "seat", Stock: "0"},
+		{ArtId: "4", Name: "table top", Stock: "1"},
+	})
+	_, maxBuildable, err = inventory.IsProductBuildable(ctx, "Dining Chair", 1)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, maxBuildable, 0)
+
+	//each sale audits the stock of the 3 articles, the refused one nothing
+	entries, err := inventory.GetAuditLog(ctx, "Dining Chair", 10)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(entries), 6)
+	for _, entry := range entries {
+		assert.Equal(t, entry.Operation, data.AuditSell)
+		assert.Equal(t, entry.Location, "north")
+	}
+}
+
+func TestPInventoryDB_UploadDuplicateProduct(t *testing.T) {
+	startDB(t)
+	conn := DockerDBConn.Conn
+	recorder := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(recorder)
+	inventory := &PInventoryDB{
+		db:     conn,
+		config: Config{Logger: logrus.NewEntry(logrus.New())},
 	}
 
+	//fill the tables before apply query
+	uploadInventory(inventory, ctx)
+	uploadProduct(inventory, ctx)
+
+	err, inserted := inventory.UploadProducts(ctx, data.Products{Products: []data.Product{
+		{Name: "Stool", ContainArticles: []data.ArticleContain{{ArtId: "1", AmountOf: "2"}, {ArtId: "1", AmountOf: "1"}}},
+	}}, false)
+	assert.Equal(t, inserted, 0)
+	assert.Assert(t, errors.Is(err, db.ErrDuplicateProductArticle))
+	assert.Error(t, err, "product already contains the article: product Stool, article 1")
+}
+
+func TestPInventoryDB_AuditLog(t *testing.T) {
+	startDB(t)
+	conn := DockerDBConn.Conn
+	inventory := &PInventoryDB{
+		db:     conn,
+		config: Config{Logger: logrus.NewEntry(logrus.New())},
+	}
+	ctx := request.WithRID(context.Background(), "rid-1")
+
+	//fill the tables before apply query
+	uploadInventory(inventory, ctx)
+	uploadProduct(inventory, ctx)
+
+	err := inventory.SellProduct(ctx, "Dinning Table")
+	assert.Equal(t, err, nil)
+	entries, err := inventory.GetAuditLog(ctx, "Dinning Table", 10)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(entries), 4)
+	assert.Equal(t, entries[0].Operation, data.AuditSell)
+	assert.Equal(t, entries[0].RID, "rid-1")
+	assert.Equal(t, entries[0].ArtId, "4")
+	assert.Equal(t, entries[0].StockBefore, data.Quantity("1"))
+	assert.Equal(t, entries[0].StockAfter, data.Quantity("0"))
+	assert.Equal(t, entries[3].Operation, data.AuditUploadProduct)
+
+	//the audit of a failed sale is rolled back with it
+	_, err = conn.Exec("ALTER TABLE inventory ADD CONSTRAINT no_sale CHECK (stock >= 2000) NOT VALID")
+	assert.Equal(t, err, nil)
+	err = inventory.SellProduct(ctx, "Dining Chair")
+	assert.ErrorContains(t, err, "no_sale")
+	entries, err = inventory.GetAuditLog(ctx, "Dining Chair", 10)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(entries), 1)
+	assert.Equal(t, entries[0].Operation, data.AuditUploadProduct)
+}
+
+func TestPInventoryDB_CheckIntegrity(t *testing.T) {
+	startDB(t)
+	conn := DockerDBConn.Conn
+	recorder := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(recorder)
+	inventory := &PInventoryDB{
+		db:     conn,
+		config: Config{Logger: logrus.NewEntry(logrus.New())},
+	}
+
+	//fill the tables before apply query
+	uploadInventory(inventory, ctx)
+	uploadProduct(inventory, ctx)
+	report, err := inventory.CheckIntegrity(ctx)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, report.Consistent(), true)
+
+	//the constraints prevent the inconsistencies, they are dropped to seed them
+	for _, statement := range []string{
+		"ALTER TABLE inventory DROP CONSTRAINT inventory_stock_check",
+		"ALTER TABLE product DROP CONSTRAINT product_pkey",
+		"INSERT INTO product (product_name, art_id, amount) VALUES ('Stool', '9', 3)",
+		"UPDATE inventory SET stock=-2000 WHERE art_id='3'",
+		"INSERT INTO product (product_name, art_id, amount) VALUES ('Dining Chair', '1', 4)",
+	} {
+		_, err = conn.Exec(statement)
+		assert.Equal(t, err, nil)
+	}
+
+	report, err = inventory.CheckIntegrity(ctx)
+	assert.Equal(t, err, nil)
+	assert.DeepEqual(t, report, data.IntegrityReport{
+		MissingArticles:          data.IntegrityIssue{Count: 1, Samples: []data.IntegrityRecord{{ProductName: "Stool", ArtId: "9"}}},
+		NegativeStock:            data.IntegrityIssue{Count: 1, Samples: []data.IntegrityRecord{{ArtId: "3", Location: "default", Stock: "-2"}}},
+		DuplicateProductArticles: data.IntegrityIssue{Count: 1, Samples: []data.IntegrityRecord{{ProductName: "Dining Chair", ArtId: "1"}}},
+	})
 }
@@ -1,11 +1,252 @@
 package postgres
 
-const (
-	getInventory    = "SELECT * FROM inventory order by art_id"
-	insertProduct   = "INSERT INTO product (product_name, art_id, amount) VALUES ($1,$2,$3)"
-	insertStock     = "INSERT INTO inventory(art_id, art_name, stock) VALUES ($1,$2,$3)"
-	getProductStock = "SELECT pr.product_name, min(i.stock/pr.amount) as available_product FROM product pr,inventory i WHERE pr.art_id=i.art_id GROUP BY pr.product_name ORDER BY pr.product_name"
-	updateSaleInfo  = "UPDATE inventory i SET stock=stock-1 from product pr WHERE pr.art_id= i.art_id and stock>= 1 AND pr.product_name=$1"
-	inStock         = "SELECT count(*) from product pr, inventory i WHERE pr.art_id=i.art_id AND pr.product_name = $1 AND i.stock=0"
-	productExist    = "select count(*) from product where product_name=$1"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/auknl/warehouse/data"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 )
+
+//Queries are the SQL statements of PInventoryDB. Deployments with other table or column names override them with
+//Config.Queries, the statements left empty are the ones of the tables in db/migrations. SelectArticles is the base
+//of the built article queries, the clauses added to it use the columns of inventory
+type Queries struct {
+	SelectArticles             string
+	GetInventoryPage           string
+	GetInventorySince          string
+	GetLowestStock             string
+	InsertProduct              string
+	InsertStock                string
+	GetProductStock            string
+	GetAllProductStock         string
+	GetProductsByNames         string
+	GetCachedProductStock      string
+	LockProductStockCache      string
+	ClearProductStockCache     string
+	FillProductStockCache      string
+	UpdateSaleInfo             string
+	InStock                    string
+	GetProductArticles         string
+	ProductExist               string
+	DeleteProduct              string
+	RestoreProduct             string
+	LockProductName            string
+	ProductNameTaken           string
+	RenameProduct              string
+	SetArticleStock            string
+	AddArticleStock            string
+	ArticleExist               string
+	GetStats                   string
+	UnknownArticles            string
+	SavepointProduct           string
+	RollbackToProduct          string
+	ReleaseProduct             string
+	ProductBuildable           string
+	ProductAvailability        string
+	ArticleProducts            string
+	DeleteArticleProducts      string
+	DeleteArticles             string
+	CountProducts              string
+	ResetProducts              string
+	ResetInventory             string
+	MissingArticles            string
+	NegativeStock              string
+	DuplicateProductArticles   string
+	InsertAudit                string
+	AuditSale                  string
+	AuditDeleteArticles        string
+	AuditDeleteArticleProducts string
+	ArticleStock               string
+	GetArticle                 string
+	SetArticleStockAt          string
+	AddArticleStockAt          string
+	GetAuditLog                string
+	InsertSale                 string
+	AuditReturn                string
+	UpdateReturnInfo           string
+	AuditSaleUnits             string
+	UpdateSaleUnits            string
+	GetSales                   string
+	AvailableToPromise         string
+	InsertReservation          string
+	DeleteReservation          string
+	DeleteExpiredReservations  string
+	ResetReservations          string
+	GetReorderArticles         string
+	SetReorderLevels           string
+	ClearProductArticles       string
+	InsertRecipe               string
+	GetProductRecipe           string
+	RenameProductRecipe        string
+	ResetRecipes               string
+	GetSchemaVersion           string
+	GetArticleProducts         string
+	CartArticles               string
+	InsertSnapshot             string
+	InsertSnapshotStock        string
+	GetSnapshot                string
+	DiffSnapshot               string
+	GetStockByName             string
+	InventoryLocations         string
+}
+
+//reservedStock is the stock of the article pr.art_id in the location $2 that the unexpired reservations of the
+//products hold
+const reservedStock = "(SELECT coalesce(sum(r.quantity*rp.amount),0) FROM reservation r JOIN product rp ON rp.product_name=r.product_name AND rp.deleted_at IS NULL WHERE rp.art_id=pr.art_id AND r.location_id=$2 AND r.expires_at>now())"
+
+//defaultQueries are the statements of the tables in db/migrations
+var defaultQueries = Queries{
+	SelectArticles:             "SELECT art_id, art_name, stock FROM inventory",
+	GetInventoryPage:           "SELECT art_id, art_name, stock FROM inventory WHERE location_id=$1 AND art_id>$2 ORDER BY art_id LIMIT $3",
+	GetInventorySince:          "SELECT art_id, art_name, stock FROM inventory WHERE location_id=$1 AND updated_at>$2 ORDER BY art_id",
+	GetLowestStock:             "SELECT art_id, art_name, stock FROM inventory WHERE location_id=$1 ORDER BY stock ASC, art_id LIMIT $2",
+	InsertProduct:              "INSERT INTO product (product_name, art_id, amount) SELECT $1::varchar, $2::varchar, $3::bigint WHERE EXISTS (SELECT 1 FROM inventory WHERE art_id=$2)",
+	InsertStock:                "INSERT INTO inventory(art_id, art_name, stock, location_id) VALUES ($1,$2,$3,$4)",
+	GetProductStock:            "SELECT pr.product_name, min(coalesce(i.stock,0)/pr.amount) as available_product, bool_or(pr.deleted_at IS NOT NULL) as deleted FROM product pr LEFT JOIN inventory i ON pr.art_id=i.art_id AND i.location_id=$1 WHERE pr.deleted_at IS NULL GROUP BY pr.product_name ORDER BY pr.product_name",
+	GetAllProductStock:         "SELECT pr.product_name, min(coalesce(i.stock,0)/pr.amount) as available_product, bool_or(pr.deleted_at IS NOT NULL) as deleted FROM product pr LEFT JOIN inventory i ON pr.art_id=i.art_id AND i.location_id=$1 GROUP BY pr.product_name ORDER BY pr.product_name",
+	GetProductsByNames:         "SELECT pr.product_name, min(coalesce(i.stock,0)/pr.amount) as available_product, bool_or(pr.deleted_at IS NOT NULL) as deleted FROM product pr LEFT JOIN inventory i ON pr.art_id=i.art_id AND i.location_id=$1 WHERE pr.deleted_at IS NULL AND pr.product_name = ANY($2) GROUP BY pr.product_name ORDER BY pr.product_name",
+	GetCachedProductStock:      "SELECT product_name, available_product, deleted FROM product_stock_cache WHERE location_id=$1 AND available_product<>0 AND ($2 OR NOT deleted) ORDER BY product_name",
+	LockProductStockCache:      "SELECT pg_advisory_xact_lock(hashtext('product_stock_cache'))",
+	ClearProductStockCache:     "DELETE FROM product_stock_cache",
+	FillProductStockCache:      "INSERT INTO product_stock_cache(location_id, product_name, available_product, deleted) SELECT l.location_id, pr.product_name, min(coalesce(i.stock,0)/pr.amount), bool_or(pr.deleted_at IS NOT NULL) FROM (SELECT DISTINCT location_id FROM inventory) l CROSS JOIN product pr LEFT JOIN inventory i ON pr.art_id=i.art_id AND i.location_id=l.location_id GROUP BY l.location_id, pr.product_name",
+	UpdateSaleInfo:             "UPDATE inventory i SET stock=i.stock-pr.amount, version=i.version+1, updated_at=now() FROM product pr WHERE pr.art_id=i.art_id AND i.stock>=pr.amount AND pr.product_name=$1 AND i.location_id=$2",
+	InStock:                    "SELECT count(*) from product pr LEFT JOIN inventory i ON pr.art_id=i.art_id AND i.location_id=$2 WHERE pr.product_name = $1 AND coalesce(i.stock,0)-" + reservedStock + "<pr.amount",
+	GetProductArticles:         "SELECT i.art_id, i.art_name, i.stock FROM product pr, inventory i WHERE pr.art_id=i.art_id AND pr.product_name=$1 AND i.location_id=$2 ORDER BY i.art_id",
+	ProductExist:               "select count(*) from product where product_name=$1 AND deleted_at IS NULL",
+	DeleteProduct:              "UPDATE product SET deleted_at=now() WHERE product_name=$1 AND deleted_at IS NULL",
+	RestoreProduct:             "UPDATE product SET deleted_at=NULL WHERE product_name=$1 AND deleted_at IS NOT NULL",
+	LockProductName:            "SELECT pg_advisory_xact_lock(hashtext('product_name'), hashtext($1))",
+	ProductNameTaken:           "SELECT (SELECT count(*) FROM product WHERE product_name=$1)+(SELECT count(*) FROM product_recipe WHERE product_name=$1)",
+	RenameProduct:              "UPDATE product SET product_name=$2 WHERE product_name=$1 AND deleted_at IS NULL",
+	SetArticleStock:            "UPDATE inventory SET stock=$2, version=version+1, updated_at=now() WHERE art_id=$1 AND location_id=$3",
+	AddArticleStock:            "UPDATE inventory SET stock=stock+$2, version=version+1, updated_at=now() WHERE art_id=$1 AND location_id=$3 AND stock+$2>=0",
+	ArticleExist:               "SELECT count(*) FROM inventory WHERE art_id=$1 AND location_id=$2",
+	GetStats:                   "SELECT (SELECT count(*) FROM inventory WHERE location_id=$1), (SELECT coalesce(sum(stock),0) FROM inventory WHERE location_id=$1), (SELECT count(DISTINCT product_name) FROM product WHERE deleted_at IS NULL), (SELECT count(*) FROM (SELECT pr.product_name FROM product pr LEFT JOIN inventory i ON pr.art_id=i.art_id AND i.location_id=$1 WHERE pr.deleted_at IS NULL GROUP BY pr.product_name HAVING min(coalesce(i.stock,0)/pr.amount) > 0) buildable)",
+	UnknownArticles:            "SELECT a.art_id FROM unnest($1::varchar[]) WITH ORDINALITY a(art_id, line) WHERE NOT EXISTS (SELECT 1 FROM inventory i WHERE i.art_id=a.art_id) ORDER BY a.line",
+	SavepointProduct:           "SAVEPOINT upload_product",
+	RollbackToProduct:          "ROLLBACK TO SAVEPOINT upload_product",
+	ReleaseProduct:             "RELEASE SAVEPOINT upload_product",
+	ProductBuildable:           "SELECT count(*), coalesce(min(coalesce(i.stock,0)/pr.amount),0) FROM product pr LEFT JOIN inventory i ON pr.art_id=i.art_id AND i.location_id=$2 WHERE pr.product_name=$1 AND pr.deleted_at IS NULL",
+	ProductAvailability:        "SELECT l.location_id, min(coalesce(i.stock,0)/pr.amount) FROM (SELECT DISTINCT location_id FROM inventory) l CROSS JOIN product pr LEFT JOIN inventory i ON pr.art_id=i.art_id AND i.location_id=l.location_id WHERE pr.product_name=$1 AND pr.deleted_at IS NULL GROUP BY l.location_id ORDER BY l.location_id",
+	ArticleProducts:            "SELECT DISTINCT product_name FROM product WHERE art_id = ANY($1) AND deleted_at IS NULL ORDER BY product_name",
+	DeleteArticleProducts:      "UPDATE product SET deleted_at=now() WHERE deleted_at IS NULL AND product_name IN (SELECT product_name FROM product WHERE art_id = ANY($1))",
+	DeleteArticles:             "DELETE FROM inventory WHERE art_id = ANY($1)",
+	CountProducts:              "SELECT count(DISTINCT product_name) FROM product",
+	ResetProducts:              "DELETE FROM product",
+	ResetInventory:             "DELETE FROM inventory",
+	MissingArticles:            "SELECT pr.product_name, pr.art_id, '', '' FROM product pr WHERE NOT EXISTS (SELECT 1 FROM inventory i WHERE i.art_id=pr.art_id) ORDER BY pr.product_name, pr.art_id",
+	NegativeStock:              "SELECT '', art_id, location_id, stock FROM inventory WHERE stock<0 ORDER BY location_id, art_id",
+	DuplicateProductArticles:   "SELECT product_name, art_id, '', '' FROM product GROUP BY product_name, art_id HAVING count(*)>1 ORDER BY product_name, art_id",
+	InsertAudit:                "INSERT INTO audit_log(operation, rid, entity, art_id, location_id, stock_before, stock_after) VALUES ($1,$2,$3,NULLIF($4,''),$5,$6,$7)",
+	AuditSale:                  "INSERT INTO audit_log(operation, rid, entity, art_id, location_id, stock_before, stock_after) SELECT $4::varchar, $3::varchar, $1::varchar, i.art_id, i.location_id, i.stock, i.stock-pr.amount FROM product pr, inventory i WHERE pr.art_id=i.art_id AND pr.product_name=$1 AND i.location_id=$2 ORDER BY i.art_id",
+	AuditDeleteArticles:        "INSERT INTO audit_log(operation, rid, entity, art_id, location_id, stock_before, stock_after) SELECT $2::varchar, $3::varchar, art_id, art_id, location_id, stock, NULL FROM inventory WHERE art_id = ANY($1) ORDER BY location_id, art_id",
+	AuditDeleteArticleProducts: "INSERT INTO audit_log(operation, rid, entity, art_id, location_id, stock_before, stock_after) SELECT DISTINCT $2::varchar, $3::varchar, product_name, NULL::varchar, $4::varchar, NULL::bigint, NULL::bigint FROM product WHERE art_id = ANY($1) AND deleted_at IS NULL",
+	ArticleStock:               "SELECT stock FROM inventory WHERE art_id=$1 AND location_id=$2",
+	GetArticle:                 "SELECT art_id, art_name, stock, version FROM inventory WHERE art_id=$1 AND location_id=$2",
+	SetArticleStockAt:          "UPDATE inventory SET stock=$2, version=version+1, updated_at=now() WHERE art_id=$1 AND location_id=$3 AND version=$4",
+	AddArticleStockAt:          "UPDATE inventory SET stock=stock+$2, version=version+1, updated_at=now() WHERE art_id=$1 AND location_id=$3 AND stock+$2>=0 AND version=$4",
+	GetAuditLog:                "SELECT id, operation, rid, entity, coalesce(art_id,''), location_id, stock_before, stock_after, created_at FROM audit_log WHERE $1::varchar='' OR entity=$1 OR art_id=$1 ORDER BY id DESC LIMIT $2",
+	InsertSale:                 "INSERT INTO sale(product_name, location_id, quantity) VALUES ($1,$2,$3)",
+	GetSales:                   "SELECT product_name, sum(quantity), min(sold_at), max(sold_at) FROM sale WHERE location_id=$1 AND sold_at>=$2 GROUP BY product_name ORDER BY sum(quantity) DESC, product_name",
+	AuditReturn:                "INSERT INTO audit_log(operation, rid, entity, art_id, location_id, stock_before, stock_after) SELECT $4::varchar, $3::varchar, $1::varchar, i.art_id, i.location_id, i.stock, i.stock+pr.amount*$5 FROM product pr, inventory i WHERE pr.art_id=i.art_id AND pr.product_name=$1 AND i.location_id=$2 ORDER BY i.art_id",
+	UpdateReturnInfo:           "UPDATE inventory i SET stock=i.stock+pr.amount*$3, version=i.version+1, updated_at=now() FROM product pr WHERE pr.art_id=i.art_id AND pr.product_name=$1 AND i.location_id=$2",
+	AuditSaleUnits:             "INSERT INTO audit_log(operation, rid, entity, art_id, location_id, stock_before, stock_after) SELECT $4::varchar, $3::varchar, $1::varchar, i.art_id, i.location_id, i.stock, i.stock-pr.amount*$5 FROM product pr, inventory i WHERE pr.art_id=i.art_id AND pr.product_name=$1 AND i.location_id=$2 ORDER BY i.art_id",
+	UpdateSaleUnits:            "UPDATE inventory i SET stock=i.stock-pr.amount*$3, version=i.version+1, updated_at=now() FROM product pr WHERE pr.art_id=i.art_id AND pr.product_name=$1 AND i.location_id=$2",
+	AvailableToPromise:         "SELECT count(*), coalesce(min((coalesce(i.stock,0)-" + reservedStock + ")/pr.amount),0) FROM product pr LEFT JOIN inventory i ON pr.art_id=i.art_id AND i.location_id=$2 WHERE pr.product_name=$1 AND pr.deleted_at IS NULL",
+	InsertReservation:          "INSERT INTO reservation(product_name, location_id, quantity, expires_at) VALUES ($1,$2,$3,$4) RETURNING id",
+	DeleteReservation:          "DELETE FROM reservation WHERE id=$1 AND location_id=$2",
+	DeleteExpiredReservations:  "DELETE FROM reservation WHERE expires_at<=now()",
+	ResetReservations:          "DELETE FROM reservation",
+	GetReorderArticles:         "SELECT art_id, art_name, stock, reorder_point, reorder_to, reorder_to-stock FROM inventory WHERE location_id=$1 AND reorder_point IS NOT NULL AND stock<=reorder_point ORDER BY art_id",
+	SetReorderLevels:           "UPDATE inventory SET reorder_point=$2, reorder_to=$3 WHERE art_id=$1 AND location_id=$4",
+	ClearProductArticles:       "DELETE FROM product WHERE product_name=$1",
+	InsertRecipe:               "INSERT INTO product_recipe(product_name, recipe_version, art_id, amount) SELECT product_name, (SELECT coalesce(max(recipe_version),0)+1 FROM product_recipe WHERE product_name=$1), art_id, amount FROM product WHERE product_name=$1",
+	GetProductRecipe:           "SELECT recipe_version, art_id, amount FROM product_recipe WHERE product_name=$1 AND recipe_version=coalesce(nullif($2::bigint,0),(SELECT max(recipe_version) FROM product_recipe WHERE product_name=$1)) ORDER BY art_id",
+	RenameProductRecipe:        "UPDATE product_recipe SET product_name=$2 WHERE product_name=$1",
+	ResetRecipes:               "DELETE FROM product_recipe",
+	GetSchemaVersion:           "SELECT version, dirty FROM schema_migrations",
+	GetArticleProducts:         "SELECT pr.product_name, pr.amount, min(coalesce(i.stock,0)/p.amount), CASE WHEN coalesce(max(ia.stock),0)/pr.amount < coalesce(min(CASE WHEN p.art_id<>pr.art_id THEN coalesce(i.stock,0)/p.amount END),9223372036854775807) THEN " + strconv.FormatInt(data.UnitGain, 10) + "/pr.amount ELSE 0 END FROM product pr JOIN product p ON p.product_name=pr.product_name AND p.deleted_at IS NULL LEFT JOIN inventory i ON i.art_id=p.art_id AND i.location_id=$2 LEFT JOIN inventory ia ON ia.art_id=pr.art_id AND ia.location_id=$2 WHERE pr.art_id=$1 AND pr.deleted_at IS NULL GROUP BY pr.product_name, pr.amount ORDER BY pr.product_name",
+	CartArticles:               "SELECT pr.art_id, pr.amount, coalesce(i.stock,0)-" + reservedStock + " FROM product pr LEFT JOIN inventory i ON pr.art_id=i.art_id AND i.location_id=$2 WHERE pr.product_name=$1 AND pr.deleted_at IS NULL ORDER BY pr.art_id",
+	InsertSnapshot:             "INSERT INTO inventory_snapshot(label, location_id) VALUES ($1,$2) RETURNING created_at",
+	InsertSnapshotStock:        "INSERT INTO inventory_snapshot_stock(label, location_id, art_id, art_name, stock) SELECT $1::varchar, location_id, art_id, art_name, stock FROM inventory WHERE location_id=$2",
+	GetSnapshot:                "SELECT s.created_at, (SELECT count(*) FROM inventory_snapshot_stock ss WHERE ss.location_id=s.location_id AND ss.label=s.label) FROM inventory_snapshot s WHERE s.label=$1 AND s.location_id=$2",
+	DiffSnapshot:               "SELECT art_id, coalesce(max(current_name), max(saved_name)), sum(stock_before), sum(stock_after) FROM (SELECT art_id, art_name AS saved_name, NULL AS current_name, stock AS stock_before, 0 AS stock_after FROM inventory_snapshot_stock WHERE label=$1 AND location_id=$2 UNION ALL SELECT art_id, NULL, art_name, 0, stock FROM inventory WHERE location_id=$2) d GROUP BY art_id HAVING sum(stock_before)<>sum(stock_after) ORDER BY art_id",
+	GetStockByName:             "SELECT art_name, sum(stock)::bigint, count(*) FROM inventory WHERE location_id=$1 GROUP BY art_name ORDER BY art_name",
+	InventoryLocations:         "SELECT DISTINCT location_id FROM inventory ORDER BY location_id",
+}
+
+//placeholder matches the $N parameters of a statement
+var placeholder = regexp.MustCompile(`\$[0-9]+`)
+
+//withDefaults returns the queries with the default statements in place of the empty ones
+func (queries Queries) withDefaults() Queries {
+	merged := reflect.ValueOf(&queries).Elem()
+	defaults := reflect.ValueOf(defaultQueries)
+	for i := 0; i < merged.NumField(); i++ {
+		if merged.Field(i).String() == "" {
+			merged.Field(i).SetString(defaults.Field(i).String())
+		}
+	}
+	return queries
+}
+
+//Validate checks that every overridden statement has the placeholders of the default one, the methods pass the
+//arguments by their position
+func (queries Queries) Validate() error {
+	given := reflect.ValueOf(queries)
+	defaults := reflect.ValueOf(defaultQueries)
+	var problems []string
+	for i := 0; i < given.NumField(); i++ {
+		if given.Field(i).String() == "" {
+			continue
+		}
+		got := placeholders(given.Field(i).String())
+		want := placeholders(defaults.Field(i).String())
+		if !reflect.DeepEqual(got, want) {
+			problems = append(problems, fmt.Sprintf("%s has the placeholders [%s], [%s] are expected", given.Type().Field(i).Name, strings.Join(got, " "), strings.Join(want, " ")))
+		}
+	}
+	if len(problems) != 0 {
+		return errors.New(strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+//placeholders lists the distinct placeholders of the statement in order
+func placeholders(query string) []string {
+	seen := make(map[string]bool)
+	found := []string{}
+	for _, parameter := range placeholder.FindAllString(query, -1) {
+		if !seen[parameter] {
+			seen[parameter] = true
+			found = append(found, parameter)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		return len(found[i]) < len(found[j]) || len(found[i]) == len(found[j]) && found[i] < found[j]
+	})
+	return found
+}
+
+//LoadQueries reads the overridden statements from the json file, its keys are the field names of Queries
+func LoadQueries(path string) (Queries, error) {
+	var queries Queries
+	file, err := os.Open(path)
+	if err != nil {
+		return queries, err
+	}
+	defer file.Close()
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&queries)
+	if err != nil {
+		return queries, fmt.Errorf("%s: %w", path, err)
+	}
+	return queries, queries.Validate()
+}
@@ -0,0 +1,204 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"github.com/auknl/warehouse/data"
+	"github.com/auknl/warehouse/db"
+	"github.com/sirupsen/logrus"
+	"gotest.tools/assert"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+//recordingConnector connects to a database/sql driver that records the statements and returns no rows
+type recordingConnector struct {
+	statements *[]string
+}
+
+func (connector recordingConnector) Connect(context.Context) (driver.Conn, error) {
+	return recordingConn(connector), nil
+}
+
+func (connector recordingConnector) Driver() driver.Driver {
+	return nil
+}
+
+type recordingConn recordingConnector
+
+func (conn recordingConn) Prepare(query string) (driver.Stmt, error) {
+	*conn.statements = append(*conn.statements, query)
+	return emptyStmt{}, nil
+}
+
+func (conn recordingConn) Close() error {
+	return nil
+}
+
+func (conn recordingConn) Begin() (driver.Tx, error) {
+	return emptyTx{}, nil
+}
+
+type emptyTx struct{}
+
+func (emptyTx) Commit() error {
+	return nil
+}
+
+func (emptyTx) Rollback() error {
+	return nil
+}
+
+type emptyStmt struct{}
+
+func (emptyStmt) Close() error {
+	return nil
+}
+
+func (emptyStmt) NumInput() int {
+	return -1
+}
+
+func (emptyStmt) Exec([]driver.Value) (driver.Result, error) {
+	return driver.RowsAffected(0), nil
+}
+
+func (emptyStmt) Query([]driver.Value) (driver.Rows, error) {
+	return emptyRows{}, nil
+}
+
+type emptyRows struct{}
+
+func (emptyRows) Columns() []string {
+	return []string{"art_id", "art_name", "stock"}
+}
+
+func (emptyRows) Close() error {
+	return nil
+}
+
+func (emptyRows) Next([]driver.Value) error {
+	return io.EOF
+}
+
+func TestQueries_usedByMethods(t *testing.T) {
+	var statements []string
+	custom := "SELECT art_id, upper(art_name), stock FROM inventory"
+	inventory := &PInventoryDB{
+		db:     sql.OpenDB(recordingConnector{statements: &statements}),
+		config: Config{Logger: logrus.NewEntry(logrus.New()), Queries: Queries{SelectArticles: custom}},
+	}
+	defer inventory.db.Close()
+
+	err, _ := inventory.GetInventory(context.Background())
+	assert.NilError(t, err)
+	_, err = inventory.GetLowestStock(context.Background(), 3)
+	assert.NilError(t, err)
+	//the statements that are not overridden are the default ones
+	assert.DeepEqual(t, statements, []string{custom + " WHERE location_id = $1 ORDER BY art_id", defaultQueries.GetLowestStock})
+}
+
+//failingCommitConnector connects to the recording driver, the transactions fail to commit
+type failingCommitConnector struct {
+	recordingConnector
+}
+
+func (connector failingCommitConnector) Connect(context.Context) (driver.Conn, error) {
+	return failingCommitConn{recordingConn: recordingConn(connector.recordingConnector)}, nil
+}
+
+type failingCommitConn struct {
+	recordingConn
+}
+
+func (conn failingCommitConn) Begin() (driver.Tx, error) {
+	return failingCommitTx{}, nil
+}
+
+type failingCommitTx struct {
+	emptyTx
+}
+
+func (failingCommitTx) Commit() error {
+	return errConnectionReset
+}
+
+func TestPInventoryDB_UploadProductsCommitFails(t *testing.T) { //Nothing is reported as uploaded when the commit fails
+	var statements []string
+	inventory := &PInventoryDB{
+		db:     sql.OpenDB(failingCommitConnector{recordingConnector{statements: &statements}}),
+		config: Config{Logger: logrus.NewEntry(logrus.New())},
+	}
+	defer inventory.db.Close()
+
+	err, inserted := inventory.UploadProducts(context.Background(), data.Products{}, false)
+	assert.ErrorContains(t, err, "connection reset")
+	assert.Equal(t, inserted, 0)
+	//the product stock cache is not refreshed
+	assert.Equal(t, len(statements), 0)
+}
+
+func TestSchemaVersion(t *testing.T) { //The binary expects the last migration of db/migrations
+	migrations, err := filepath.Glob("../db/migrations/*.up.sql")
+	assert.NilError(t, err)
+	sort.Strings(migrations)
+	last := filepath.Base(migrations[len(migrations)-1])
+	version, err := strconv.Atoi(last[:strings.Index(last, "_")])
+	assert.NilError(t, err)
+	assert.Equal(t, version, db.SchemaVersion)
+}
+
+func TestQueries_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		queries Queries
+		wantErr string
+	}{
+		{name: "defaults", queries: Queries{}},
+		{name: "overridden", queries: Queries{ArticleStock: "SELECT quantity FROM stock_items WHERE warehouse=$2 AND sku=$1"}},
+		{
+			name:    "missing_placeholder",
+			queries: Queries{GetInventoryPage: "SELECT sku, title, quantity FROM stock_items WHERE warehouse=$1 LIMIT $3"},
+			wantErr: "GetInventoryPage has the placeholders [$1 $3], [$1 $2 $3] are expected",
+		},
+		{
+			name:    "extra_placeholder",
+			queries: Queries{GetLowestStock: "SELECT sku, title, quantity FROM stock_items WHERE warehouse=$1 AND sku>$3 LIMIT $2", InsertAudit: "INSERT INTO log VALUES ($1)"},
+			wantErr: "GetLowestStock has the placeholders [$1 $2 $3], [$1 $2] are expected, " +
+				"InsertAudit has the placeholders [$1], [$1 $2 $3 $4 $5 $6 $7] are expected",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.queries.Validate()
+			if tt.wantErr == "" {
+				assert.NilError(t, err)
+				return
+			}
+			assert.Error(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestLoadQueries(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "queries.json")
+	assert.NilError(t, ioutil.WriteFile(valid, []byte(`{"GetLowestStock":"SELECT sku, title, quantity FROM stock_items WHERE warehouse=$1 ORDER BY quantity LIMIT $2"}`), 0600))
+	queries, err := LoadQueries(valid)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, queries, Queries{GetLowestStock: "SELECT sku, title, quantity FROM stock_items WHERE warehouse=$1 ORDER BY quantity LIMIT $2"})
+	//the empty statements fall back to the defaults
+	assert.Equal(t, queries.withDefaults().GetInventoryPage, defaultQueries.GetInventoryPage)
+
+	//a misspelled name would silently keep the default statement
+	unknown := filepath.Join(dir, "unknown.json")
+	assert.NilError(t, ioutil.WriteFile(unknown, []byte(`{"GetInventry":"SELECT 1"}`), 0600))
+	_, err = LoadQueries(unknown)
+	assert.ErrorContains(t, err, `unknown field "GetInventry"`)
+}
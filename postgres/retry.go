@@ -0,0 +1,85 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/auknl/warehouse/db"
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+//defaultRetryBackoff is the wait before the first retry when no RetryBackoff is configured, it is doubled after each
+const defaultRetryBackoff = 50 * time.Millisecond
+
+//transientCodes are the Postgres error codes of the failures that may not happen when the transaction is run again
+var transientCodes = map[pq.ErrorCode]bool{
+	"40001": true, //serialization_failure
+	"40P01": true, //deadlock_detected
+	"08000": true, //connection_exception
+	"08003": true, //connection_does_not_exist
+	"08006": true, //connection_failure
+	"57P01": true, //admin_shutdown
+}
+
+//isTransient checks if err is a serialization failure, a deadlock or a lost connection
+func isTransient(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return transientCodes[pqErr.Code]
+	}
+	return db.IsConnectionLost(err)
+}
+
+//retry runs transaction and runs it again up to Retries times while it fails with a transient error, waiting
+//RetryBackoff before the first retry and twice as long before each next one. transaction has to begin and end its
+//own db transaction so every run starts from scratch
+func (inventory *PInventoryDB) retry(ctx context.Context, log *logrus.Entry, transaction func() error) error {
+	backoff := inventory.config.RetryBackoff
+	if backoff <= 0 {
+		backoff = defaultRetryBackoff
+	}
+	err := transaction()
+	for attempt := 1; attempt <= inventory.config.Retries && isTransient(err); attempt++ {
+		log.WithFields(logrus.Fields{"err": err, "attempt": attempt}).Warn("Transient db error, retrying the transaction")
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		err = transaction()
+	}
+	return err
+}
+
+//read runs the query of a read and runs it once more if the connection is lost, the pool opens a new connection
+//for it. Reads change nothing, so running them again is safe. A connection lost while the rows are read is not
+//retried, the rows read before may be used already
+func (inventory *PInventoryDB) read(ctx context.Context, log *logrus.Entry, query string, args ...interface{}) (*sql.Rows, error) {
+	var rows *sql.Rows
+	err := inventory.readAgain(ctx, log, func() error {
+		var err error
+		rows, err = inventory.db.QueryContext(ctx, query, args...)
+		return err
+	})
+	return rows, err
+}
+
+//readRow is read for the queries of a single row, the row is scanned into dest
+func (inventory *PInventoryDB) readRow(ctx context.Context, log *logrus.Entry, query string, args []interface{}, dest ...interface{}) error {
+	return inventory.readAgain(ctx, log, func() error {
+		return inventory.db.QueryRowContext(ctx, query, args...).Scan(dest...)
+	})
+}
+
+//readAgain runs read once more if it fails with a lost connection and ctx is not done yet
+func (inventory *PInventoryDB) readAgain(ctx context.Context, log *logrus.Entry, read func() error) error {
+	err := read()
+	if db.IsConnectionLost(err) && ctx.Err() == nil {
+		log.WithField("err", err).Warn("Database connection is lost, running the read again")
+		err = read()
+	}
+	return err
+}
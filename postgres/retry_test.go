@@ -0,0 +1,139 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"github.com/auknl/warehouse/db"
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+	"gotest.tools/assert"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+//errConnectionReset is the error of a connection the database closes while it restarts
+var errConnectionReset = &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+
+//droppingConnector connects to the recording driver, the first drops statements fail as the connection is lost
+type droppingConnector struct {
+	recordingConnector
+	drops *int
+}
+
+func (connector droppingConnector) Connect(context.Context) (driver.Conn, error) {
+	return droppingConn{recordingConn: recordingConn(connector.recordingConnector), drops: connector.drops}, nil
+}
+
+type droppingConn struct {
+	recordingConn
+	drops *int
+}
+
+func (conn droppingConn) Prepare(query string) (driver.Stmt, error) {
+	if *conn.drops > 0 {
+		*conn.drops--
+		return nil, errConnectionReset
+	}
+	return conn.recordingConn.Prepare(query)
+}
+
+func TestIsTransient(t *testing.T) {
+	assert.Assert(t, isTransient(&pq.Error{Code: "40001"}))
+	assert.Assert(t, isTransient(fmt.Errorf("commit: %w", &pq.Error{Code: "40P01"})))
+	assert.Assert(t, isTransient(driver.ErrBadConn))
+	assert.Assert(t, isTransient(errConnectionReset))
+	assert.Assert(t, !isTransient(&pq.Error{Code: "23505"}))
+	assert.Assert(t, !isTransient(errProductOutOfStock))
+	assert.Assert(t, !isTransient(nil))
+}
+
+func TestPInventoryDB_retry(t *testing.T) {
+	tests := []struct {
+		name    string
+		errors  []error //returned by the runs of the transaction in order
+		runs    int
+		wantErr error
+	}{
+		{
+			name:   "succeeds_after_serialization_failures",
+			errors: []error{&pq.Error{Code: "40001"}, &pq.Error{Code: "40001"}, nil},
+			runs:   3,
+		},
+		{
+			name:    "not_transient",
+			errors:  []error{errProductOutOfStock},
+			runs:    1,
+			wantErr: errProductOutOfStock,
+		},
+		{
+			name:    "retries_exhausted",
+			errors:  []error{&pq.Error{Code: "40P01"}, &pq.Error{Code: "40P01"}, &pq.Error{Code: "40P01"}, &pq.Error{Code: "40P01"}},
+			runs:    4,
+			wantErr: &pq.Error{Code: "40P01"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inventory := &PInventoryDB{config: Config{Logger: logrus.NewEntry(logrus.New()), Retries: 3, RetryBackoff: time.Millisecond}}
+			runs := 0
+			err := inventory.retry(context.Background(), inventory.config.Logger, func() error {
+				runs++
+				return tt.errors[runs-1]
+			})
+			assert.Equal(t, runs, tt.runs)
+			if tt.wantErr == nil {
+				assert.NilError(t, err)
+				return
+			}
+			assert.Error(t, err, tt.wantErr.Error())
+		})
+	}
+}
+
+func TestPInventoryDB_retryCancelled(t *testing.T) {
+	inventory := &PInventoryDB{config: Config{Logger: logrus.NewEntry(logrus.New()), Retries: 3, RetryBackoff: time.Hour}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	runs := 0
+	err := inventory.retry(ctx, inventory.config.Logger, func() error {
+		runs++
+		return &pq.Error{Code: "40001"}
+	})
+	assert.Assert(t, errors.As(err, new(*pq.Error)))
+	assert.Equal(t, runs, 1)
+}
+
+func TestPInventoryDB_connectionLost(t *testing.T) {
+	var statements []string
+	drops := 0
+	inventory := &PInventoryDB{
+		db:     sql.OpenDB(droppingConnector{recordingConnector: recordingConnector{statements: &statements}, drops: &drops}),
+		config: Config{Logger: logrus.NewEntry(logrus.New())},
+	}
+	defer inventory.db.Close()
+	ctx := context.Background()
+
+	//the reads are run again once
+	drops = 1
+	_, err := inventory.GetLowestStock(ctx, 3)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, statements, []string{defaultQueries.GetLowestStock})
+	drops = 1
+	_, _, err = inventory.GetArticle(ctx, "1")
+	assert.Assert(t, errors.Is(err, db.ErrArticleNotFound))
+	drops = 2
+	_, err = inventory.GetLowestStock(ctx, 3)
+	assert.Assert(t, db.IsConnectionLost(err))
+	assert.Equal(t, drops, 0)
+
+	//the writes are not, the client is told to send them again
+	drops, statements = 1, nil
+	err = inventory.SellProduct(ctx, "chair")
+	assert.Assert(t, db.IsConnectionLost(err))
+	assert.Equal(t, len(statements), 0)
+}
@@ -0,0 +1,201 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"github.com/auknl/warehouse/request"
+	"github.com/sirupsen/logrus"
+)
+
+//redacted replaces the arguments of the logged statements when they are redacted
+const redacted = "[REDACTED]"
+
+//statementLogger connects with the connector and logs every statement the connections run with its arguments at
+//debug level. The connections pass everything else to the ones of the connector
+type statementLogger struct {
+	connector driver.Connector
+	logger    *logrus.Entry
+	redact    bool //the arguments are logged as [REDACTED], only their number is seen
+}
+
+//dsnConnector opens the connections of a driver that is not a driver.DriverContext
+type dsnConnector struct {
+	dsn    string
+	driver driver.Driver
+}
+
+func (connector dsnConnector) Connect(context.Context) (driver.Conn, error) {
+	return connector.driver.Open(connector.dsn)
+}
+
+func (connector dsnConnector) Driver() driver.Driver {
+	return connector.driver
+}
+
+//logStatements reopens conn, which has no connection yet, with the statements of its connections logged
+func logStatements(conn *sql.DB, dsn string, logger *logrus.Entry, redact bool) (*sql.DB, error) {
+	var connector driver.Connector = dsnConnector{dsn: dsn, driver: conn.Driver()}
+	if driverContext, ok := conn.Driver().(driver.DriverContext); ok {
+		var err error
+		connector, err = driverContext.OpenConnector(dsn)
+		if err != nil {
+			return nil, err
+		}
+	}
+	_ = conn.Close()
+	return sql.OpenDB(statementLogger{connector: connector, logger: logger, redact: redact}), nil
+}
+
+func (statements statementLogger) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := statements.connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return loggingConn{Conn: conn, statements: statements}, nil
+}
+
+func (statements statementLogger) Driver() driver.Driver {
+	return statements.connector.Driver()
+}
+
+//log writes the statement and its arguments, nothing is formatted unless debug logs are written
+func (statements statementLogger) log(ctx context.Context, query string, args []driver.NamedValue) {
+	if !statements.logger.Logger.IsLevelEnabled(logrus.DebugLevel) {
+		return
+	}
+	values := make([]interface{}, len(args))
+	for i, arg := range args {
+		if statements.redact {
+			values[i] = redacted
+		} else {
+			values[i] = arg.Value
+		}
+	}
+	statements.logger.WithFields(logrus.Fields{"rid": request.GetRID(ctx), "sql": query, "args": values}).Debug("SQL statement")
+}
+
+//loggingConn logs the statements run on the connection. The statements the driver cannot run directly are prepared by
+//database/sql, they are logged when the prepared statement runs
+type loggingConn struct {
+	driver.Conn
+	statements statementLogger
+}
+
+func (conn loggingConn) Prepare(query string) (driver.Stmt, error) {
+	return conn.PrepareContext(context.Background(), query)
+}
+
+func (conn loggingConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var stmt driver.Stmt
+	var err error
+	if preparer, ok := conn.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err = preparer.PrepareContext(ctx, query)
+	} else {
+		stmt, err = conn.Conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return loggingStmt{Stmt: stmt, query: query, statements: conn.statements}, nil
+}
+
+func (conn loggingConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if beginner, ok := conn.Conn.(driver.ConnBeginTx); ok {
+		return beginner.BeginTx(ctx, opts)
+	}
+	if opts.Isolation != 0 || opts.ReadOnly {
+		return nil, errors.New("sql: driver does not support non-default transaction options")
+	}
+	return conn.Conn.Begin()
+}
+
+func (conn loggingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := conn.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	conn.statements.log(ctx, query, args)
+	return queryer.QueryContext(ctx, query, args)
+}
+
+func (conn loggingConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := conn.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	conn.statements.log(ctx, query, args)
+	return execer.ExecContext(ctx, query, args)
+}
+
+func (conn loggingConn) Ping(ctx context.Context) error {
+	if pinger, ok := conn.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (conn loggingConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := conn.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (conn loggingConn) IsValid() bool {
+	if validator, ok := conn.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
+
+func (conn loggingConn) CheckNamedValue(value *driver.NamedValue) error {
+	if checker, ok := conn.Conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(value)
+	}
+	return driver.ErrSkip
+}
+
+//loggingStmt logs the prepared statement each time it runs
+type loggingStmt struct {
+	driver.Stmt
+	query      string
+	statements statementLogger
+}
+
+func (stmt loggingStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	stmt.statements.log(ctx, stmt.query, args)
+	if execer, ok := stmt.Stmt.(driver.StmtExecContext); ok {
+		return execer.ExecContext(ctx, args)
+	}
+	values, err := valuesOf(args)
+	if err != nil {
+		return nil, err
+	}
+	return stmt.Stmt.Exec(values)
+}
+
+func (stmt loggingStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	stmt.statements.log(ctx, stmt.query, args)
+	if queryer, ok := stmt.Stmt.(driver.StmtQueryContext); ok {
+		return queryer.QueryContext(ctx, args)
+	}
+	values, err := valuesOf(args)
+	if err != nil {
+		return nil, err
+	}
+	return stmt.Stmt.Query(values)
+}
+
+//valuesOf drops the ordinals of the arguments for the drivers without context support, they have no named arguments
+func valuesOf(args []driver.NamedValue) ([]driver.Value, error) {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		if arg.Name != "" {
+			return nil, errors.New("sql: driver does not support the use of Named Parameters")
+		}
+		values[i] = arg.Value
+	}
+	return values, nil
+}
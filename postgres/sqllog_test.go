@@ -0,0 +1,65 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"github.com/auknl/warehouse/request"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"gotest.tools/assert"
+	"testing"
+)
+
+//recordingDriver opens the connections of recordingConnector, it is registered for the tests opening by driver name
+type recordingDriver struct{}
+
+func (recordingDriver) Open(string) (driver.Conn, error) {
+	return recordingConn{statements: &[]string{}}, nil
+}
+
+func init() {
+	sql.Register("recording", recordingDriver{})
+}
+
+func TestPInventoryDB_logSQL(t *testing.T) {
+	tests := []struct {
+		name      string
+		logSQL    bool
+		redactSQL bool
+		args      []interface{}
+	}{
+		{name: "off"},
+		{name: "on", logSQL: true, args: []interface{}{"north", int64(3)}},
+		{name: "redacted", logSQL: true, redactSQL: true, args: []interface{}{redacted, redacted}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, hook := logtest.NewNullLogger()
+			logger.SetLevel(logrus.DebugLevel)
+			inventory := &PInventoryDB{config: Config{Logger: logrus.NewEntry(logger), Driver: "recording",
+				URL: "postgres://inventory@localhost/inventory", LogSQL: tt.logSQL, RedactSQL: tt.redactSQL}}
+			assert.NilError(t, inventory.Open())
+			defer inventory.db.Close()
+
+			ctx := request.WithRID(request.WithLocation(context.Background(), "north"), "rid-1")
+			_, err := inventory.GetLowestStock(ctx, 3)
+			assert.NilError(t, err)
+			var logged []*logrus.Entry
+			for _, entry := range hook.AllEntries() {
+				if entry.Message == "SQL statement" {
+					logged = append(logged, entry)
+				}
+			}
+			if !tt.logSQL {
+				assert.Equal(t, len(logged), 0)
+				return
+			}
+			assert.Equal(t, len(logged), 1)
+			assert.Equal(t, logged[0].Level, logrus.DebugLevel)
+			assert.Equal(t, logged[0].Data["sql"], defaultQueries.GetLowestStock)
+			assert.DeepEqual(t, logged[0].Data["args"], tt.args)
+			assert.Equal(t, logged[0].Data["rid"], "rid-1")
+		})
+	}
+}
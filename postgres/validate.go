@@ -0,0 +1,50 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+//defaultValidationQuery is run by ValidateConnection when no ValidationQuery is configured
+const defaultValidationQuery = "SELECT 1"
+
+//defaultValidationTimeout limits ValidateConnection when no ValidationTimeout is configured
+const defaultValidationTimeout = 5 * time.Second
+
+//ValidateConnection runs the ValidationQuery within the ValidationTimeout. Open does not connect, so an unreachable
+//database or wrong credentials are found on startup by this instead of by the first request
+func (inventory *PInventoryDB) ValidateConnection(ctx context.Context) error {
+	log := inventory.config.Logger
+	log.Debug("ValidateConnection() entry...")
+	if inventory.db == nil {
+		return errors.New("database connection is not opened")
+	}
+	query := inventory.config.ValidationQuery
+	if query == "" {
+		query = defaultValidationQuery
+	}
+	timeout := inventory.config.ValidationTimeout
+	if timeout <= 0 {
+		timeout = defaultValidationTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	rows, err := inventory.db.QueryContext(ctx, query)
+	if err == nil {
+		for rows.Next() {
+		}
+		err = rows.Err()
+		_ = rows.Close()
+	}
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("database did not answer the validation query within %s", timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("database validation query failed: %w", err)
+	}
+	log.Debug("ValidateConnection(), database is reachable...")
+	return nil
+}
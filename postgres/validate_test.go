@@ -0,0 +1,92 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"github.com/sirupsen/logrus"
+	"gotest.tools/assert"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+//hangingDriver opens connections whose statements run until their context is done
+type hangingDriver struct{}
+
+func (hangingDriver) Open(string) (driver.Conn, error) {
+	return hangingConn{}, nil
+}
+
+type hangingConn struct {
+	driver.Conn
+}
+
+func (hangingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (hangingConn) Close() error {
+	return nil
+}
+
+func init() {
+	sql.Register("hanging", hangingDriver{})
+}
+
+func TestPInventoryDB_ValidateConnection(t *testing.T) {
+	//nothing listens on the port of the closed listener, the connection is refused
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	closedAddress := listener.Addr().(*net.TCPAddr)
+	assert.NilError(t, listener.Close())
+
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr string
+	}{
+		{
+			name:   "reachable",
+			config: Config{Driver: "recording", URL: "postgres://inventory@localhost/inventory"},
+		},
+		{
+			name:   "custom_query",
+			config: Config{Driver: "recording", URL: "postgres://inventory@localhost/inventory", ValidationQuery: "SELECT 1 FROM inventory LIMIT 1"},
+		},
+		{
+			name: "refused",
+			config: Config{Driver: "postgres", Host: closedAddress.IP.String(), Port: strconv.Itoa(closedAddress.Port),
+				User: "inventory", Password: "secret", Dbname: "inventory"},
+			wantErr: "database validation query failed: dial tcp " + closedAddress.String() + ": connect: connection refused",
+		},
+		{
+			name:    "timeout",
+			config:  Config{Driver: "hanging", URL: "postgres://inventory@localhost/inventory", ValidationTimeout: 50 * time.Millisecond},
+			wantErr: "database did not answer the validation query within 50ms",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.Logger = logrus.NewEntry(logrus.New())
+			inventory := &PInventoryDB{config: tt.config}
+			assert.NilError(t, inventory.Open())
+			defer inventory.db.Close()
+
+			err := inventory.ValidateConnection(context.Background())
+			if tt.wantErr == "" {
+				assert.NilError(t, err)
+				return
+			}
+			assert.Error(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestPInventoryDB_ValidateConnectionNotOpened(t *testing.T) {
+	inventory := &PInventoryDB{config: Config{Logger: logrus.NewEntry(logrus.New())}}
+	err := inventory.ValidateConnection(context.Background())
+	assert.Error(t, err, "database connection is not opened")
+}
@@ -5,31 +5,48 @@ import (
 	"github.com/google/uuid"
 )
 
-//WithID returns context with contextIDKey
-func WithID(ctx context.Context, id string) context.Context {
-	return context.WithValue(ctx, contextIDKey, id)
-}
+type contextRIDType struct{}
 
-//IDFromContext returns the contextIDKey
-func IDFromContext(ctx context.Context) string {
-	v := ctx.Value(contextIDKey)
-	if v == nil {
-		return ""
-	}
-	return v.(string)
-}
+var contextRIDKey = &contextRIDType{}
 
-type contextIDType struct{}
+//WithRID returns context with the request id
+func WithRID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextRIDKey, id)
+}
 
-var contextIDKey = &contextIDType{}
+//RIDFromContext returns the request id of the context, empty if it is not set
+func RIDFromContext(ctx context.Context) string {
+	v, _ := ctx.Value(contextRIDKey).(string)
+	return v
+}
 
-//GetRID returns the contextIDKey by generating or using the existing one
+//GetRID returns the request id of the context, a new one is generated if it is not set. The generated id is not
+//kept in ctx, WithRID has to be used to share it
 func GetRID(ctx context.Context) string {
-	v := IDFromContext(ctx)
+	v := RIDFromContext(ctx)
 	if v == "" {
 		v = uuid.New().String()
-		WithID(ctx, v)
 	}
+	return v
+}
 
+//DefaultLocation is the warehouse location used when the request does not name one
+const DefaultLocation = "default"
+
+type contextLocationType struct{}
+
+var contextLocationKey = &contextLocationType{}
+
+//WithLocation returns context with the warehouse location the request is scoped to
+func WithLocation(ctx context.Context, location string) context.Context {
+	return context.WithValue(ctx, contextLocationKey, location)
+}
+
+//LocationFromContext returns the warehouse location of the context, DefaultLocation if it is not set
+func LocationFromContext(ctx context.Context) string {
+	v, _ := ctx.Value(contextLocationKey).(string)
+	if v == "" {
+		return DefaultLocation
+	}
 	return v
 }
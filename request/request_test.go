@@ -0,0 +1,30 @@
+package request
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"gotest.tools/assert"
+	"testing"
+)
+
+func TestRID(t *testing.T) {
+	ctx := WithRID(context.Background(), "rid-1")
+	assert.Equal(t, RIDFromContext(ctx), "rid-1")
+	assert.Equal(t, GetRID(ctx), "rid-1")
+
+	//a key of another package with the same name does not collide
+	other := context.WithValue(context.Background(), "rid", "rid-2")
+	assert.Equal(t, RIDFromContext(other), "")
+}
+
+func TestGetRIDFallback(t *testing.T) {
+	first := GetRID(context.Background())
+	_, err := uuid.Parse(first)
+	assert.NilError(t, err)
+	assert.Assert(t, first != GetRID(context.Background()))
+}
+
+func TestLocation(t *testing.T) {
+	assert.Equal(t, LocationFromContext(context.Background()), DefaultLocation)
+	assert.Equal(t, LocationFromContext(WithLocation(context.Background(), "north")), "north")
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"github.com/auknl/warehouse/data"
+	"github.com/auknl/warehouse/db"
+	"github.com/sirupsen/logrus"
+)
+
+//demoInventory and demoProducts are the demo data of ISC_SEED, the example of the README
+var (
+	demoInventory = data.Inventory{Inventory: []data.Stock{
+		{ArtId: "1", Name: "leg", Stock: "12"},
+		{ArtId: "2", Name: "screw", Stock: "17"},
+		{ArtId: "3", Name: "seat", Stock: "2"},
+		{ArtId: "4", Name: "table top", Stock: "1"},
+	}}
+	demoProducts = data.Products{Products: []data.Product{
+		{Name: "Dining Chair", ContainArticles: []data.ArticleContain{{ArtId: "1", AmountOf: "4"}, {ArtId: "2", AmountOf: "8"}, {ArtId: "3", AmountOf: "1"}}},
+		{Name: "Dinning Table", ContainArticles: []data.ArticleContain{{ArtId: "1", AmountOf: "4"}, {ArtId: "2", AmountOf: "8"}, {ArtId: "4", AmountOf: "1"}}},
+	}}
+)
+
+//seed uploads the demo articles and products when the inventory has neither, so it can run on every start. It
+//reports if the demo data is uploaded
+func seed(ctx context.Context, inventory db.Inventory, logger *logrus.Entry) (bool, error) {
+	err, stocks := inventory.GetInventory(ctx)
+	if err != nil {
+		return false, err
+	}
+	products, err := inventory.GetAllProducts(ctx)
+	if err != nil {
+		return false, err
+	}
+	if len(stocks) > 0 || len(products) > 0 {
+		logger.WithFields(logrus.Fields{"articles": len(stocks), "products": len(products)}).Info("Inventory is not empty, demo data is not seeded")
+		return false, nil
+	}
+	err, articles := inventory.UploadInventory(ctx, demoInventory)
+	if err != nil {
+		return false, err
+	}
+	err, uploaded := inventory.UploadProducts(ctx, demoProducts, false)
+	if err != nil {
+		return false, err
+	}
+	logger.WithFields(logrus.Fields{"articles": articles, "products": uploaded}).Info("Demo data seeded")
+	return true, nil
+}
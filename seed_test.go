@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"github.com/auknl/warehouse/data"
+	"github.com/auknl/warehouse/memory"
+	"github.com/sirupsen/logrus"
+	"gotest.tools/assert"
+	"testing"
+)
+
+func TestSeed(t *testing.T) {
+	logger := logrus.NewEntry(logrus.New())
+	inventory := memory.NewMInventory(memory.Config{Logger: logger})
+	ctx := context.Background()
+
+	seeded, err := seed(ctx, inventory, logger)
+	assert.NilError(t, err)
+	assert.Assert(t, seeded)
+	err, stocks := inventory.GetInventory(ctx)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, stocks, demoInventory.Inventory)
+	products, err := inventory.GetAllProducts(ctx)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, products, data.ProductStocks{{Name: "Dining Chair", AvailableProductNo: "2"}, {Name: "Dinning Table", AvailableProductNo: "1"}})
+
+	//the second start finds the demo data, nothing is uploaded again
+	seeded, err = seed(ctx, inventory, logger)
+	assert.NilError(t, err)
+	assert.Assert(t, !seeded)
+	err, stocks = inventory.GetInventory(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, len(stocks), len(demoInventory.Inventory))
+	products, err = inventory.GetAllProducts(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, len(products), len(demoProducts.Products))
+
+	//an inventory with articles only is not seeded either
+	inventory = memory.NewMInventory(memory.Config{Logger: logger})
+	err, _ = inventory.UploadInventory(ctx, data.Inventory{Inventory: []data.Stock{{ArtId: "9", Name: "bolt", Stock: "5"}}})
+	assert.NilError(t, err)
+	seeded, err = seed(ctx, inventory, logger)
+	assert.NilError(t, err)
+	assert.Assert(t, !seeded)
+	products, err = inventory.GetAllProducts(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, len(products), 0)
+}
@@ -0,0 +1,189 @@
+package sqlite
+
+import (
+	"github.com/auknl/warehouse/data"
+	"strconv"
+)
+
+//now is the current time in the timeFormat layout, SQLite has no time type and the times are compared as text
+const now = "strftime('%Y-%m-%dT%H:%M:%fZ','now')"
+
+//timeFormat is the layout of the times stored by now
+const timeFormat = "2006-01-02T15:04:05.000Z"
+
+//queries are kept same with the postgres ones where possible, placeholders use the ?NNN syntax of SQLite
+const (
+	getInventory               = "SELECT art_id, art_name, stock FROM inventory WHERE location_id=?1 order by art_id"
+	getInventoryPage           = "SELECT art_id, art_name, stock FROM inventory WHERE location_id=?1 AND art_id>?2 ORDER BY art_id LIMIT ?3"
+	getInventorySince          = "SELECT art_id, art_name, stock FROM inventory WHERE location_id=?1 AND updated_at>?2 ORDER BY art_id"
+	searchArticles             = "SELECT art_id, art_name, stock FROM inventory WHERE location_id=?1 AND art_name LIKE ?2 ESCAPE '\\' ORDER BY art_id LIMIT ?3"
+	getLowestStock             = "SELECT art_id, art_name, stock FROM inventory WHERE location_id=?1 ORDER BY stock ASC, art_id LIMIT ?2"
+	insertProduct              = "INSERT INTO product (product_name, art_id, amount) SELECT ?1, ?2, ?3 WHERE EXISTS (SELECT 1 FROM inventory WHERE art_id=?2)"
+	insertStock                = "INSERT INTO inventory(art_id, art_name, stock, location_id) VALUES (?1,?2,?3,?4)"
+	getProductStock            = "SELECT pr.product_name, min(coalesce(i.stock,0)/pr.amount) as available_product, max(pr.deleted_at IS NOT NULL) as deleted FROM product pr LEFT JOIN inventory i ON pr.art_id=i.art_id AND i.location_id=?1 WHERE pr.deleted_at IS NULL GROUP BY pr.product_name ORDER BY pr.product_name"
+	getAllProductStock         = "SELECT pr.product_name, min(coalesce(i.stock,0)/pr.amount) as available_product, max(pr.deleted_at IS NOT NULL) as deleted FROM product pr LEFT JOIN inventory i ON pr.art_id=i.art_id AND i.location_id=?1 GROUP BY pr.product_name ORDER BY pr.product_name"
+	getProductsByNames         = "SELECT pr.product_name, min(coalesce(i.stock,0)/pr.amount) as available_product, max(pr.deleted_at IS NOT NULL) as deleted FROM product pr LEFT JOIN inventory i ON pr.art_id=i.art_id AND i.location_id=?1 WHERE pr.deleted_at IS NULL AND pr.product_name IN (SELECT value FROM json_each(?2)) GROUP BY pr.product_name ORDER BY pr.product_name"
+	getCachedProductStock      = "SELECT product_name, available_product, deleted FROM product_stock_cache WHERE location_id=?1 AND available_product<>0 AND (?2 OR NOT deleted) ORDER BY product_name"
+	clearProductStockCache     = "DELETE FROM product_stock_cache"
+	fillProductStockCache      = "INSERT INTO product_stock_cache(location_id, product_name, available_product, deleted) SELECT l.location_id, pr.product_name, min(coalesce(i.stock,0)/pr.amount), max(pr.deleted_at IS NOT NULL) FROM (SELECT DISTINCT location_id FROM inventory) l CROSS JOIN product pr LEFT JOIN inventory i ON pr.art_id=i.art_id AND i.location_id=l.location_id GROUP BY l.location_id, pr.product_name"
+	updateSaleInfo             = "UPDATE inventory SET stock=stock-(SELECT pr.amount FROM product pr WHERE pr.product_name=?1 AND pr.art_id=inventory.art_id), version=version+1, updated_at=" + now + " WHERE location_id=?2 AND art_id IN (SELECT art_id FROM product WHERE product_name=?1)"
+	inStock                    = "SELECT count(*) from product pr LEFT JOIN inventory i ON pr.art_id=i.art_id AND i.location_id=?2 WHERE pr.product_name = ?1 AND coalesce(i.stock,0)-" + reservedStock + "<pr.amount"
+	getProductArticles         = "SELECT i.art_id, i.art_name, i.stock FROM product pr, inventory i WHERE pr.art_id=i.art_id AND pr.product_name=?1 AND i.location_id=?2 ORDER BY i.art_id"
+	productExist               = "select count(*) from product where product_name=?1 AND deleted_at IS NULL"
+	deleteProduct              = "UPDATE product SET deleted_at=CURRENT_TIMESTAMP WHERE product_name=?1 AND deleted_at IS NULL"
+	restoreProduct             = "UPDATE product SET deleted_at=NULL WHERE product_name=?1 AND deleted_at IS NOT NULL"
+	productNameTaken           = "SELECT (SELECT count(*) FROM product WHERE product_name=?1)+(SELECT count(*) FROM product_recipe WHERE product_name=?1)"
+	renameProduct              = "UPDATE product SET product_name=?2 WHERE product_name=?1 AND deleted_at IS NULL"
+	setArticleStock            = "UPDATE inventory SET stock=?2, version=version+1, updated_at=" + now + " WHERE art_id=?1 AND location_id=?3"
+	addArticleStock            = "UPDATE inventory SET stock=stock+?2, version=version+1, updated_at=" + now + " WHERE art_id=?1 AND location_id=?3 AND stock+?2>=0"
+	articleExist               = "SELECT count(*) FROM inventory WHERE art_id=?1 AND location_id=?2"
+	getStats                   = "SELECT (SELECT count(*) FROM inventory WHERE location_id=?1), (SELECT coalesce(sum(stock),0) FROM inventory WHERE location_id=?1), (SELECT count(DISTINCT product_name) FROM product WHERE deleted_at IS NULL), (SELECT count(*) FROM (SELECT pr.product_name FROM product pr LEFT JOIN inventory i ON pr.art_id=i.art_id AND i.location_id=?1 WHERE pr.deleted_at IS NULL GROUP BY pr.product_name HAVING min(coalesce(i.stock,0)/pr.amount) > 0) buildable)"
+	unknownArticles            = "SELECT a.value FROM json_each(?1) a WHERE a.value NOT IN (SELECT art_id FROM inventory) ORDER BY a.key"
+	savepointProduct           = "SAVEPOINT upload_product"
+	rollbackToProduct          = "ROLLBACK TO SAVEPOINT upload_product"
+	releaseProduct             = "RELEASE SAVEPOINT upload_product"
+	productBuildable           = "SELECT count(*), coalesce(min(coalesce(i.stock,0)/pr.amount),0) FROM product pr LEFT JOIN inventory i ON pr.art_id=i.art_id AND i.location_id=?2 WHERE pr.product_name=?1 AND pr.deleted_at IS NULL"
+	productAvailability        = "SELECT l.location_id, min(coalesce(i.stock,0)/pr.amount) FROM (SELECT DISTINCT location_id FROM inventory) l CROSS JOIN product pr LEFT JOIN inventory i ON pr.art_id=i.art_id AND i.location_id=l.location_id WHERE pr.product_name=?1 AND pr.deleted_at IS NULL GROUP BY l.location_id ORDER BY l.location_id"
+	articleProducts            = "SELECT DISTINCT product_name FROM product WHERE art_id IN (SELECT value FROM json_each(?1)) AND deleted_at IS NULL ORDER BY product_name"
+	deleteArticleProducts      = "UPDATE product SET deleted_at=" + now + " WHERE deleted_at IS NULL AND product_name IN (SELECT product_name FROM product WHERE art_id IN (SELECT value FROM json_each(?1)))"
+	deleteArticles             = "DELETE FROM inventory WHERE art_id IN (SELECT value FROM json_each(?1))"
+	countProducts              = "SELECT count(DISTINCT product_name) FROM product"
+	resetProducts              = "DELETE FROM product"
+	resetInventory             = "DELETE FROM inventory"
+	missingArticles            = "SELECT pr.product_name, pr.art_id, '', '' FROM product pr WHERE NOT EXISTS (SELECT 1 FROM inventory i WHERE i.art_id=pr.art_id) ORDER BY pr.product_name, pr.art_id"
+	negativeStock              = "SELECT '', art_id, location_id, stock FROM inventory WHERE stock<0 ORDER BY location_id, art_id"
+	duplicateProductArticles   = "SELECT product_name, art_id, '', '' FROM product GROUP BY product_name, art_id HAVING count(*)>1 ORDER BY product_name, art_id"
+	insertAudit                = "INSERT INTO audit_log(operation, rid, entity, art_id, location_id, stock_before, stock_after) VALUES (?1,?2,?3,NULLIF(?4,''),?5,?6,?7)"
+	auditSale                  = "INSERT INTO audit_log(operation, rid, entity, art_id, location_id, stock_before, stock_after) SELECT ?4, ?3, ?1, i.art_id, i.location_id, i.stock, i.stock-pr.amount FROM product pr, inventory i WHERE pr.art_id=i.art_id AND pr.product_name=?1 AND i.location_id=?2 ORDER BY i.art_id"
+	auditDeleteArticles        = "INSERT INTO audit_log(operation, rid, entity, art_id, location_id, stock_before, stock_after) SELECT ?2, ?3, art_id, art_id, location_id, stock, NULL FROM inventory WHERE art_id IN (SELECT value FROM json_each(?1)) ORDER BY location_id, art_id"
+	auditDeleteArticleProducts = "INSERT INTO audit_log(operation, rid, entity, art_id, location_id, stock_before, stock_after) SELECT DISTINCT ?2, ?3, product_name, NULL, ?4, NULL, NULL FROM product WHERE art_id IN (SELECT value FROM json_each(?1)) AND deleted_at IS NULL"
+	articleStock               = "SELECT stock FROM inventory WHERE art_id=?1 AND location_id=?2"
+	getArticle                 = "SELECT art_id, art_name, stock, version FROM inventory WHERE art_id=?1 AND location_id=?2"
+	setArticleStockAt          = "UPDATE inventory SET stock=?2, version=version+1, updated_at=" + now + " WHERE art_id=?1 AND location_id=?3 AND version=?4"
+	addArticleStockAt          = "UPDATE inventory SET stock=stock+?2, version=version+1, updated_at=" + now + " WHERE art_id=?1 AND location_id=?3 AND stock+?2>=0 AND version=?4"
+	getAuditLog                = "SELECT id, operation, rid, entity, coalesce(art_id,''), location_id, stock_before, stock_after, created_at FROM audit_log WHERE ?1='' OR entity=?1 OR art_id=?1 ORDER BY id DESC LIMIT ?2"
+	insertSale                 = "INSERT INTO sale(product_name, location_id, quantity) VALUES (?1,?2,?3)"
+	getSales                   = "SELECT product_name, sum(quantity), min(sold_at), max(sold_at) FROM sale WHERE location_id=?1 AND sold_at>=?2 GROUP BY product_name ORDER BY sum(quantity) DESC, product_name"
+	auditReturn                = "INSERT INTO audit_log(operation, rid, entity, art_id, location_id, stock_before, stock_after) SELECT ?4, ?3, ?1, i.art_id, i.location_id, i.stock, i.stock+pr.amount*?5 FROM product pr, inventory i WHERE pr.art_id=i.art_id AND pr.product_name=?1 AND i.location_id=?2 ORDER BY i.art_id"
+	updateReturnInfo           = "UPDATE inventory SET stock=stock+(SELECT pr.amount FROM product pr WHERE pr.product_name=?1 AND pr.art_id=inventory.art_id)*?3, version=version+1, updated_at=" + now + " WHERE location_id=?2 AND art_id IN (SELECT art_id FROM product WHERE product_name=?1)"
+	auditSaleUnits             = "INSERT INTO audit_log(operation, rid, entity, art_id, location_id, stock_before, stock_after) SELECT ?4, ?3, ?1, i.art_id, i.location_id, i.stock, i.stock-pr.amount*?5 FROM product pr, inventory i WHERE pr.art_id=i.art_id AND pr.product_name=?1 AND i.location_id=?2 ORDER BY i.art_id"
+	updateSaleUnits            = "UPDATE inventory SET stock=stock-(SELECT pr.amount FROM product pr WHERE pr.product_name=?1 AND pr.art_id=inventory.art_id)*?3, version=version+1, updated_at=" + now + " WHERE location_id=?2 AND art_id IN (SELECT art_id FROM product WHERE product_name=?1)"
+	availableToPromise         = "SELECT count(*), coalesce(min((coalesce(i.stock,0)-" + reservedStock + ")/pr.amount),0) FROM product pr LEFT JOIN inventory i ON pr.art_id=i.art_id AND i.location_id=?2 WHERE pr.product_name=?1 AND pr.deleted_at IS NULL"
+	insertReservation          = "INSERT INTO reservation(product_name, location_id, quantity, expires_at) VALUES (?1,?2,?3,?4)"
+	deleteReservation          = "DELETE FROM reservation WHERE id=?1 AND location_id=?2"
+	deleteExpiredReservations  = "DELETE FROM reservation WHERE expires_at<=" + now
+	resetReservations          = "DELETE FROM reservation"
+	getReorderArticles         = "SELECT art_id, art_name, stock, reorder_point, reorder_to, reorder_to-stock FROM inventory WHERE location_id=?1 AND reorder_point IS NOT NULL AND stock<=reorder_point ORDER BY art_id"
+	setReorderLevels           = "UPDATE inventory SET reorder_point=?2, reorder_to=?3 WHERE art_id=?1 AND location_id=?4"
+	clearProductArticles       = "DELETE FROM product WHERE product_name=?1"
+	insertRecipe               = "INSERT INTO product_recipe(product_name, recipe_version, art_id, amount) SELECT product_name, (SELECT coalesce(max(recipe_version),0)+1 FROM product_recipe WHERE product_name=?1), art_id, amount FROM product WHERE product_name=?1"
+	getProductRecipe           = "SELECT recipe_version, art_id, amount FROM product_recipe WHERE product_name=?1 AND recipe_version=coalesce(nullif(?2,0),(SELECT max(recipe_version) FROM product_recipe WHERE product_name=?1)) ORDER BY art_id"
+	renameProductRecipe        = "UPDATE product_recipe SET product_name=?2 WHERE product_name=?1"
+	resetRecipes               = "DELETE FROM product_recipe"
+	cartArticles               = "SELECT pr.art_id, pr.amount, coalesce(i.stock,0)-" + reservedStock + " FROM product pr LEFT JOIN inventory i ON pr.art_id=i.art_id AND i.location_id=?2 WHERE pr.product_name=?1 AND pr.deleted_at IS NULL ORDER BY pr.art_id"
+	insertSnapshot             = "INSERT INTO inventory_snapshot(label, location_id, created_at) VALUES (?1,?2,?3)"
+	insertSnapshotStock        = "INSERT INTO inventory_snapshot_stock(label, location_id, art_id, art_name, stock) SELECT ?1, location_id, art_id, art_name, stock FROM inventory WHERE location_id=?2"
+	getSnapshot                = "SELECT s.created_at, (SELECT count(*) FROM inventory_snapshot_stock ss WHERE ss.location_id=s.location_id AND ss.label=s.label) FROM inventory_snapshot s WHERE s.label=?1 AND s.location_id=?2"
+	diffSnapshot               = "SELECT art_id, coalesce(max(current_name), max(saved_name)), sum(stock_before), sum(stock_after) FROM (SELECT art_id, art_name AS saved_name, NULL AS current_name, stock AS stock_before, 0 AS stock_after FROM inventory_snapshot_stock WHERE label=?1 AND location_id=?2 UNION ALL SELECT art_id, NULL, art_name, 0, stock FROM inventory WHERE location_id=?2) d GROUP BY art_id HAVING sum(stock_before)<>sum(stock_after) ORDER BY art_id"
+	getStockByName             = "SELECT art_name, sum(stock), count(*) FROM inventory WHERE location_id=?1 GROUP BY art_name ORDER BY art_name"
+	inventoryLocations         = "SELECT DISTINCT location_id FROM inventory ORDER BY location_id"
+)
+
+//getArticleProducts lists the products containing the article ?1 with their buildable count in the location ?2 and
+//the gain of a unit of the article, data.UnitGain divided by its amount when the article limits the product
+var getArticleProducts = "SELECT pr.product_name, pr.amount, min(coalesce(i.stock,0)/p.amount), CASE WHEN coalesce(max(ia.stock),0)/pr.amount < coalesce(min(CASE WHEN p.art_id<>pr.art_id THEN coalesce(i.stock,0)/p.amount END),9223372036854775807) THEN " + strconv.FormatInt(data.UnitGain, 10) + "/pr.amount ELSE 0 END FROM product pr JOIN product p ON p.product_name=pr.product_name AND p.deleted_at IS NULL LEFT JOIN inventory i ON i.art_id=p.art_id AND i.location_id=?2 LEFT JOIN inventory ia ON ia.art_id=pr.art_id AND ia.location_id=?2 WHERE pr.art_id=?1 AND pr.deleted_at IS NULL GROUP BY pr.product_name, pr.amount ORDER BY pr.product_name"
+
+//reservedStock is the stock of the article pr.art_id in the location ?2 that the unexpired reservations of the
+//products hold
+const reservedStock = "(SELECT coalesce(sum(r.quantity*rp.amount),0) FROM reservation r JOIN product rp ON rp.product_name=r.product_name AND rp.deleted_at IS NULL WHERE rp.art_id=pr.art_id AND r.location_id=?2 AND r.expires_at>" + now + ")"
+
+//schema creates the tables of db/migrations, SQLite databases are created on Open
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS inventory
+(
+    art_id        VARCHAR(255) NOT NULL,
+    art_name      VARCHAR(255) NOT NULL,
+    stock         BIGINT       NOT NULL CHECK (stock >= 0),
+    location_id   VARCHAR(255) NOT NULL DEFAULT 'default',
+    updated_at    TIMESTAMP    NOT NULL DEFAULT (` + now + `),
+    version       BIGINT       NOT NULL DEFAULT 1,
+    reorder_point BIGINT       NULL,
+    reorder_to    BIGINT       NULL,
+    PRIMARY KEY (location_id, art_id)
+)`,
+	`CREATE INDEX IF NOT EXISTS inventory_updated_at_idx ON inventory (location_id, updated_at)`,
+	`CREATE TABLE IF NOT EXISTS product
+(
+    product_name VARCHAR(255) NOT NULL,
+    art_id       VARCHAR(255) NOT NULL,
+    amount       BIGINT       NOT NULL CHECK (amount > 0),
+    deleted_at   TIMESTAMP    NULL,
+    PRIMARY KEY (product_name, art_id)
+)`,
+	`CREATE TABLE IF NOT EXISTS product_recipe
+(
+    product_name   VARCHAR(255) NOT NULL,
+    recipe_version BIGINT       NOT NULL,
+    art_id         VARCHAR(255) NOT NULL,
+    amount         BIGINT       NOT NULL CHECK (amount > 0),
+    created_at     TIMESTAMP    NOT NULL DEFAULT (` + now + `),
+    PRIMARY KEY (product_name, recipe_version, art_id)
+)`,
+	`CREATE TABLE IF NOT EXISTS audit_log
+(
+    id           INTEGER PRIMARY KEY AUTOINCREMENT,
+    operation    VARCHAR(64)  NOT NULL,
+    rid          VARCHAR(255) NOT NULL,
+    entity       VARCHAR(255) NOT NULL,
+    art_id       VARCHAR(255) NULL,
+    location_id  VARCHAR(255) NOT NULL,
+    stock_before BIGINT       NULL,
+    stock_after  BIGINT       NULL,
+    created_at   TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP
+)`,
+	`CREATE INDEX IF NOT EXISTS audit_log_entity_idx ON audit_log (entity)`,
+	`CREATE INDEX IF NOT EXISTS audit_log_art_id_idx ON audit_log (art_id)`,
+	`CREATE TABLE IF NOT EXISTS product_stock_cache
+(
+    location_id       VARCHAR(255) NOT NULL,
+    product_name      VARCHAR(255) NOT NULL,
+    available_product BIGINT       NOT NULL,
+    deleted           BOOLEAN      NOT NULL,
+    PRIMARY KEY (location_id, product_name)
+)`,
+	`CREATE TABLE IF NOT EXISTS sale
+(
+    id           INTEGER PRIMARY KEY AUTOINCREMENT,
+    product_name VARCHAR(255) NOT NULL,
+    location_id  VARCHAR(255) NOT NULL,
+    sold_at      TIMESTAMP    NOT NULL DEFAULT (` + now + `),
+    quantity     BIGINT       NOT NULL DEFAULT 1
+)`,
+	`CREATE INDEX IF NOT EXISTS sale_sold_at_idx ON sale (location_id, sold_at)`,
+	`CREATE TABLE IF NOT EXISTS reservation
+(
+    id           INTEGER PRIMARY KEY AUTOINCREMENT,
+    product_name VARCHAR(255) NOT NULL,
+    location_id  VARCHAR(255) NOT NULL,
+    quantity     BIGINT       NOT NULL CHECK (quantity > 0),
+    expires_at   TIMESTAMP    NOT NULL
+)`,
+	`CREATE INDEX IF NOT EXISTS reservation_expires_at_idx ON reservation (location_id, expires_at)`,
+	`CREATE TABLE IF NOT EXISTS inventory_snapshot
+(
+    label       VARCHAR(255) NOT NULL,
+    location_id VARCHAR(255) NOT NULL,
+    created_at  TIMESTAMP    NOT NULL DEFAULT (` + now + `),
+    PRIMARY KEY (location_id, label)
+)`,
+	`CREATE TABLE IF NOT EXISTS inventory_snapshot_stock
+(
+    label       VARCHAR(255) NOT NULL,
+    location_id VARCHAR(255) NOT NULL,
+    art_id      VARCHAR(255) NOT NULL,
+    art_name    VARCHAR(255) NOT NULL,
+    stock       BIGINT       NOT NULL,
+    PRIMARY KEY (location_id, label, art_id),
+    FOREIGN KEY (location_id, label) REFERENCES inventory_snapshot (location_id, label) ON DELETE CASCADE
+)`,
+}
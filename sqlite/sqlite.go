@@ -0,0 +1,2120 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/auknl/warehouse/data"
+	"github.com/auknl/warehouse/db"
+	"github.com/auknl/warehouse/request"
+	"github.com/sirupsen/logrus"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//SInventoryDB keep db and configuration
+type SInventoryDB struct {
+	db     *sql.DB
+	config Config
+}
+
+//Config keeps db related configurations
+type Config struct {
+	Logger     *logrus.Entry
+	Driver     string
+	DataSource string //file path of the database or :memory:
+}
+
+//NewSInventory creates new SQLite inventory instance
+func NewSInventory(config Config) db.Inventory {
+	config.Logger.Debug("NewSInventory entry...")
+	inventory := SInventoryDB{config: config}
+	err := inventory.Open()
+	if err != nil {
+		config.Logger.WithField("err: ", err).Error("Connection could not be set..")
+	}
+
+	return &inventory
+}
+
+//Ping verifies a connection to the database is still alive
+func (inventory *SInventoryDB) Ping() error {
+	inventory.config.Logger.Debug("Ping() entry...")
+	return inventory.db.Ping()
+}
+
+//PoolStats is the state of the connection pool, connections in use and the waits for a free one
+func (inventory *SInventoryDB) PoolStats() sql.DBStats {
+	return inventory.db.Stats()
+}
+
+//GetSchemaVersion is always the one of the binary, the tables are created by Open
+func (inventory *SInventoryDB) GetSchemaVersion(ctx context.Context) (int, bool, error) {
+	inventory.config.Logger.WithField("rid", request.GetRID(ctx)).Debug("GetSchemaVersion() entry...")
+	return db.SchemaVersion, false, nil
+}
+
+//Open opens a SQLite database and creates the tables if they do not exist
+func (inventory *SInventoryDB) Open() error {
+	inventory.config.Logger.Debug("Open() entry...")
+	conn, err := sql.Open(inventory.config.Driver, inventory.config.DataSource)
+	if err != nil {
+		inventory.config.Logger.WithField("err: ", err).Error("Sql open failed")
+		return err
+	}
+	// every connection to :memory: is a new database, and SQLite has a single writer anyway
+	conn.SetMaxOpenConns(1)
+
+	_, err = conn.Exec("PRAGMA foreign_keys = ON")
+	if err != nil {
+		inventory.config.Logger.WithField("err: ", err).Error("Foreign keys could not be enabled")
+		return err
+	}
+	for _, statement := range schema {
+		_, err = conn.Exec(statement)
+		if err != nil {
+			inventory.config.Logger.WithField("err: ", err).Error("Schema could not be created")
+			return err
+		}
+	}
+
+	inventory.db = conn
+	inventory.config.Logger.Debug("Open(), connection is set with db...")
+	return nil
+}
+
+//GetInventory gets all inventory/stock info in system
+func (inventory *SInventoryDB) GetInventory(ctx context.Context) (error, []data.Stock) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("GetInventory() entry...")
+	ctx, span := startSpan(ctx, "GetInventory")
+	defer span.End()
+	rows, err := inventory.db.QueryContext(ctx, getInventory, request.LocationFromContext(ctx))
+	if err != nil {
+		log.WithField("err", err).Error("GetInventory query failed")
+		return err, nil
+	}
+
+	defer rows.Close()
+	var artId, artName string
+	var stock data.Quantity
+	stocks := []data.Stock{}
+	for rows.Next() {
+		err = rows.Scan(&artId, &artName, &stock)
+		if err != nil {
+			log.WithField("err", err).Error("Cannot scan the table")
+			return err, nil
+		}
+		stocks = append(stocks, data.Stock{ArtId: artId, Name: artName, Stock: stock})
+	}
+
+	err = rows.Err()
+	if err != nil {
+		log.WithField("err", err).Error("Error happened during the iteration")
+		return err, nil
+	}
+
+	log.WithField("number of inventory record to be returned: ", len(stocks)).Debug("GetInventory(), returns the stocks...")
+	return nil, stocks
+}
+
+//SearchArticles gets at most limit articles whose name contains the query, case-insensitive, ordered by art_id
+func (inventory *SInventoryDB) SearchArticles(ctx context.Context, query string, limit int) ([]data.Stock, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("SearchArticles() entry...")
+	ctx, span := startSpan(ctx, "SearchArticles")
+	defer span.End()
+	rows, err := inventory.db.QueryContext(ctx, searchArticles, request.LocationFromContext(ctx), containsPattern(query), limit)
+	if err != nil {
+		log.WithField("err", err).Error("SearchArticles query failed")
+		return nil, err
+	}
+
+	defer rows.Close()
+	stocks := []data.Stock{}
+	for rows.Next() {
+		var stock data.Stock
+		err = rows.Scan(&stock.ArtId, &stock.Name, &stock.Stock)
+		if err != nil {
+			log.WithField("err", err).Error("Cannot scan the table")
+			return nil, err
+		}
+		stocks = append(stocks, stock)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		log.WithField("err", err).Error("Error happened during the iteration")
+		return nil, err
+	}
+
+	log.WithField("number of inventory record to be returned: ", len(stocks)).Debug("SearchArticles(), returns the stocks...")
+	return stocks, nil
+}
+
+//likeEscaper escapes the wildcards of LIKE, so they match themselves in user input
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+//containsPattern is the LIKE pattern matching the names that contain query
+func containsPattern(query string) string {
+	return "%" + likeEscaper.Replace(query) + "%"
+}
+
+//GetInventoryPage gets at most limit stock records ordered by art_id, starting after the art_id of the after cursor.
+//The returned cursor is the art_id of the last record when there are more, empty on the last page. As the page is
+//keyed on art_id instead of an offset, articles inserted or deleted between pages do not shift the next page
+func (inventory *SInventoryDB) GetInventoryPage(ctx context.Context, after string, limit int) ([]data.Stock, string, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("GetInventoryPage() entry...")
+	ctx, span := startSpan(ctx, "GetInventoryPage")
+	defer span.End()
+	if limit <= 0 {
+		return nil, "", errors.New("page limit must be positive")
+	}
+	//one more record is read to know if there is a next page
+	rows, err := inventory.db.QueryContext(ctx, getInventoryPage, request.LocationFromContext(ctx), after, limit+1)
+	if err != nil {
+		log.WithField("err", err).Error("GetInventoryPage query failed")
+		return nil, "", err
+	}
+
+	defer rows.Close()
+	stocks := []data.Stock{}
+	for rows.Next() {
+		var stock data.Stock
+		err = rows.Scan(&stock.ArtId, &stock.Name, &stock.Stock)
+		if err != nil {
+			log.WithField("err", err).Error("Cannot scan the table")
+			return nil, "", err
+		}
+		stocks = append(stocks, stock)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		log.WithField("err", err).Error("Error happened during the iteration")
+		return nil, "", err
+	}
+
+	next := ""
+	if len(stocks) > limit {
+		stocks = stocks[:limit]
+		next = stocks[limit-1].ArtId
+	}
+	log.WithField("number of inventory record to be returned: ", len(stocks)).Debug("GetInventoryPage(), returns the stocks...")
+	return stocks, next, nil
+}
+
+//GetInventorySince gets the articles whose stock was inserted or changed after since, ordered by art_id. Deleted
+//articles are not reported
+func (inventory *SInventoryDB) GetInventorySince(ctx context.Context, since time.Time) ([]data.Stock, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("GetInventorySince() entry...")
+	ctx, span := startSpan(ctx, "GetInventorySince")
+	defer span.End()
+	rows, err := inventory.db.QueryContext(ctx, getInventorySince, request.LocationFromContext(ctx), since.UTC().Format(timeFormat))
+	if err != nil {
+		log.WithField("err", err).Error("GetInventorySince query failed")
+		return nil, err
+	}
+
+	defer rows.Close()
+	stocks := []data.Stock{}
+	for rows.Next() {
+		var stock data.Stock
+		err = rows.Scan(&stock.ArtId, &stock.Name, &stock.Stock)
+		if err != nil {
+			log.WithField("err", err).Error("Cannot scan the table")
+			return nil, err
+		}
+		stocks = append(stocks, stock)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		log.WithField("err", err).Error("Error happened during the iteration")
+		return nil, err
+	}
+
+	log.WithField("number of inventory record to be returned: ", len(stocks)).Debug("GetInventorySince(), returns the stocks...")
+	return stocks, nil
+}
+
+//GetLowestStock gets the n articles with the smallest stock, ordered by stock and art_id
+func (inventory *SInventoryDB) GetLowestStock(ctx context.Context, n int) ([]data.Stock, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("GetLowestStock() entry...")
+	ctx, span := startSpan(ctx, "GetLowestStock")
+	defer span.End()
+	rows, err := inventory.db.QueryContext(ctx, getLowestStock, request.LocationFromContext(ctx), n)
+	if err != nil {
+		log.WithField("err", err).Error("GetLowestStock query failed")
+		return nil, err
+	}
+
+	defer rows.Close()
+	stocks := []data.Stock{}
+	for rows.Next() {
+		var stock data.Stock
+		err = rows.Scan(&stock.ArtId, &stock.Name, &stock.Stock)
+		if err != nil {
+			log.WithField("err", err).Error("Cannot scan the table")
+			return nil, err
+		}
+		stocks = append(stocks, stock)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		log.WithField("err", err).Error("Error happened during the iteration")
+		return nil, err
+	}
+
+	log.WithField("number of inventory record to be returned: ", len(stocks)).Debug("GetLowestStock(), returns the stocks...")
+	return stocks, nil
+}
+
+//StreamInventory calls each for every inventory/stock info in system as the rows are read, nothing is buffered.
+//Iteration stops at the first error of each
+func (inventory *SInventoryDB) StreamInventory(ctx context.Context, each func(stock data.Stock) error) error {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("StreamInventory() entry...")
+	ctx, span := startSpan(ctx, "StreamInventory")
+	defer span.End()
+	rows, err := inventory.db.QueryContext(ctx, getInventory, request.LocationFromContext(ctx))
+	if err != nil {
+		log.WithField("err", err).Error("StreamInventory query failed")
+		return err
+	}
+
+	defer rows.Close()
+	var stock data.Stock
+	streamed := 0
+	for rows.Next() {
+		err = rows.Scan(&stock.ArtId, &stock.Name, &stock.Stock)
+		if err != nil {
+			log.WithField("err", err).Error("Cannot scan the table")
+			return err
+		}
+		err = each(stock)
+		if err != nil {
+			log.WithField("err", err).Error("StreamInventory(), stopped streaming...")
+			return err
+		}
+		streamed++
+	}
+
+	err = rows.Err()
+	if err != nil {
+		log.WithField("err", err).Error("Error happened during the iteration")
+		return err
+	}
+
+	log.WithField("number of inventory record streamed: ", streamed).Debug("StreamInventory(), streamed the stocks...")
+	return nil
+}
+
+//GetProductStock gets the stock of the available products in system. Soft-deleted products are only
+//returned when includeDeleted is set
+func (inventory *SInventoryDB) GetProductStock(ctx context.Context, includeDeleted bool) (error, data.ProductStocks) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("GetProductStock() entry...")
+	ctx, span := startSpan(ctx, "GetProductStock")
+	defer span.End()
+	query := getProductStock
+	if includeDeleted {
+		query = getAllProductStock
+	}
+	rows, err := inventory.db.QueryContext(ctx, query, request.LocationFromContext(ctx))
+	if err != nil {
+		log.WithField("err", err).Error("GetProductStock query failed")
+		return err, nil
+	}
+
+	defer rows.Close()
+	var productName string
+	var stock string
+	var deleted bool
+	stocks := data.ProductStocks{}
+	for rows.Next() {
+		err = rows.Scan(&productName, &stock, &deleted)
+		if err != nil {
+			log.WithField("err", err).Error("Cannot scan the table")
+			return err, nil
+		}
+		stockNo, _ := strconv.ParseInt(stock, 10, 64)
+		if stockNo != 0 { // if product items are enough
+			stocks = append(stocks, data.ProductStock{Name: productName, AvailableProductNo: stock, Deleted: deleted})
+		}
+	}
+
+	err = rows.Err()
+	if err != nil {
+		log.WithField("err", err).Error("Error happened during the getProductStock iteration")
+		return err, nil
+	}
+
+	log.WithField("number of product to be returned: ", len(stocks)).Debug("GetProductStock(), returns the stocks...")
+	return nil, stocks
+}
+
+//GetAllProducts gets every product in system that is not deleted with the number of it can be built from the
+//stock, products that cannot be built are included with zero
+func (inventory *SInventoryDB) GetAllProducts(ctx context.Context) (data.ProductStocks, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("GetAllProducts() entry...")
+	ctx, span := startSpan(ctx, "GetAllProducts")
+	defer span.End()
+	rows, err := inventory.db.QueryContext(ctx, getProductStock, request.LocationFromContext(ctx))
+	if err != nil {
+		log.WithField("err", err).Error("GetAllProducts query failed")
+		return nil, err
+	}
+
+	defer rows.Close()
+	stocks := data.ProductStocks{}
+	for rows.Next() {
+		var stock data.ProductStock
+		err = rows.Scan(&stock.Name, &stock.AvailableProductNo, &stock.Deleted)
+		if err != nil {
+			log.WithField("err", err).Error("Cannot scan the table")
+			return nil, err
+		}
+		stocks = append(stocks, stock)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		log.WithField("err", err).Error("Error happened during the iteration")
+		return nil, err
+	}
+
+	log.WithField("number of product to be returned: ", len(stocks)).Debug("GetAllProducts(), returns the products...")
+	return stocks, nil
+}
+
+//GetProductsByNames gets the products of the given names that are not deleted with the number of them can be built
+//from the stock, like GetAllProducts. Names that are not in system are left out
+func (inventory *SInventoryDB) GetProductsByNames(ctx context.Context, names []string) (data.ProductStocks, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("GetProductsByNames() entry...")
+	ctx, span := startSpan(ctx, "GetProductsByNames")
+	defer span.End()
+	nameList, err := json.Marshal(names) //SQLite has no arrays, names are passed as json array
+	if err != nil {
+		return nil, err
+	}
+	rows, err := inventory.db.QueryContext(ctx, getProductsByNames, request.LocationFromContext(ctx), string(nameList))
+	if err != nil {
+		log.WithField("err", err).Error("GetProductsByNames query failed")
+		return nil, err
+	}
+
+	defer rows.Close()
+	stocks := data.ProductStocks{}
+	for rows.Next() {
+		var stock data.ProductStock
+		err = rows.Scan(&stock.Name, &stock.AvailableProductNo, &stock.Deleted)
+		if err != nil {
+			log.WithField("err", err).Error("Cannot scan the table")
+			return nil, err
+		}
+		stocks = append(stocks, stock)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		log.WithField("err", err).Error("Error happened during the iteration")
+		return nil, err
+	}
+
+	log.WithField("number of product to be returned: ", len(stocks)).Debug("GetProductsByNames(), returns the products...")
+	return stocks, nil
+}
+
+//GetCachedProductStock gets the product stock like GetProductStock from the product_stock_cache table, so it is as
+//old as the last RefreshProductStock
+func (inventory *SInventoryDB) GetCachedProductStock(ctx context.Context, includeDeleted bool) (data.ProductStocks, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("GetCachedProductStock() entry...")
+	ctx, span := startSpan(ctx, "GetCachedProductStock")
+	defer span.End()
+	rows, err := inventory.db.QueryContext(ctx, getCachedProductStock, request.LocationFromContext(ctx), includeDeleted)
+	if err != nil {
+		log.WithField("err", err).Error("GetCachedProductStock query failed")
+		return nil, err
+	}
+
+	defer rows.Close()
+	stocks := data.ProductStocks{}
+	for rows.Next() {
+		var stock data.ProductStock
+		err = rows.Scan(&stock.Name, &stock.AvailableProductNo, &stock.Deleted)
+		if err != nil {
+			log.WithField("err", err).Error("Cannot scan the table")
+			return nil, err
+		}
+		stocks = append(stocks, stock)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		log.WithField("err", err).Error("Error happened during the iteration")
+		return nil, err
+	}
+
+	log.WithField("number of product to be returned: ", len(stocks)).Debug("GetCachedProductStock(), returns the stocks...")
+	return stocks, nil
+}
+
+//RefreshProductStock recomputes the product_stock_cache table of every location from the current stock
+func (inventory *SInventoryDB) RefreshProductStock(ctx context.Context) error {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("RefreshProductStock() entry...")
+	ctx, span := startSpan(ctx, "RefreshProductStock")
+	defer span.End()
+	transaction, err := inventory.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.WithField("err", err).Error("Transaction begin failed")
+		return err
+	}
+	defer transaction.Rollback()
+
+	_, err = transaction.ExecContext(ctx, clearProductStockCache)
+	if err != nil {
+		log.WithField("err", err).Error("RefreshProductStock, clearing the cache failed")
+		return err
+	}
+	_, err = transaction.ExecContext(ctx, fillProductStockCache)
+	if err != nil {
+		log.WithField("err", err).Error("RefreshProductStock, filling the cache failed")
+		return err
+	}
+	err = transaction.Commit()
+	if err != nil {
+		log.WithField("err", err).Error("RefreshProductStock, failed to commit")
+		return err
+	}
+	log.Debug("RefreshProductStock(), product stock cache is refreshed...")
+	return nil
+}
+
+//refreshAfterChange refreshes the product stock cache once a change of the stock or the products is committed. The
+//change is done anyway, so a failing refresh is only logged
+func (inventory *SInventoryDB) refreshAfterChange(ctx context.Context, log *logrus.Entry) {
+	err := inventory.RefreshProductStock(ctx)
+	if err != nil {
+		log.WithField("err", err).Error("Product stock cache could not be refreshed")
+	}
+}
+
+//UploadProducts inserts the product info into db. By default nothing is inserted when a product fails, with
+//continueOnError the failed products are rolled back one by one and reported in data.ProductUploadErrors
+func (inventory *SInventoryDB) UploadProducts(ctx context.Context, product data.Products, continueOnError bool) (error, int) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("UploadProducts() entry...")
+	ctx, span := startSpan(ctx, "UploadProducts")
+	defer span.End()
+	transaction, err := inventory.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.WithField("err", err).Error("Transaction begin failed")
+		return err, 0
+	}
+	defer transaction.Rollback()
+	insertedRecord := 0
+	var failures data.ProductUploadErrors
+	for _, product := range product.Products {
+		if continueOnError {
+			_, err = transaction.ExecContext(ctx, savepointProduct)
+			if err != nil {
+				log.WithField("err: ", err).Error("UploadProducts(), failed to set savepoint...")
+				return err, 0
+			}
+		}
+		err = replaceProduct(ctx, transaction, product)
+		if err == nil {
+			err = audit(ctx, transaction, data.AuditEntry{Operation: data.AuditUploadProduct, Entity: product.Name})
+		}
+		if err != nil && !continueOnError {
+			log.WithField("err: ", err).Error("UploadProducts(), failed to insert record...")
+			return err, 0
+		}
+		if err != nil {
+			log.WithField("err: ", err).Info("UploadProducts(), skipping the failed product...")
+			failures = append(failures, data.ProductUploadFailure{Name: product.Name, Error: err.Error()})
+			_, err = transaction.ExecContext(ctx, rollbackToProduct)
+		} else if continueOnError {
+			insertedRecord++
+			_, err = transaction.ExecContext(ctx, releaseProduct)
+		}
+		if err != nil {
+			log.WithField("err: ", err).Error("UploadProducts(), failed to end savepoint...")
+			return err, 0
+		}
+	}
+	err = transaction.Commit()
+	if err != nil {
+		log.WithField("err: ", err).Error("Transaction commit failed to insert product...")
+		return err, 0
+	}
+	if !continueOnError {
+		insertedRecord = len(product.Products)
+	}
+
+	inventory.refreshAfterChange(ctx, log)
+	log.WithField("number of product uploaded: ", insertedRecord).Debug("UploadProducts(), uploaded products...")
+	if len(failures) != 0 {
+		return failures, insertedRecord
+	}
+	return nil, insertedRecord
+}
+
+//replaceProduct makes the articles of the product its next recipe version. The rows of the previous version are
+//replaced, a deleted product is uploaded again, and every version is kept in product_recipe
+func replaceProduct(ctx context.Context, transaction *sql.Tx, product data.Product) error {
+	_, err := transaction.ExecContext(ctx, clearProductArticles, product.Name)
+	if err != nil {
+		return err
+	}
+	err = insertProductArticles(ctx, transaction, product)
+	if err != nil {
+		return err
+	}
+	_, err = transaction.ExecContext(ctx, insertRecipe, product.Name)
+	return err
+}
+
+//insertProductArticles inserts the article mapping rows of the product, the articles have to be in inventory
+func insertProductArticles(ctx context.Context, transaction *sql.Tx, product data.Product) error {
+	for _, contain := range product.ContainArticles {
+		result, err := transaction.ExecContext(ctx, insertProduct, product.Name, contain.ArtId, contain.AmountOf)
+		if isUniqueViolation(err) {
+			return fmt.Errorf("%w: product %s, article %s", db.ErrDuplicateProductArticle, product.Name, contain.ArtId)
+		}
+		if err != nil {
+			return err
+		}
+		inserted, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if inserted == 0 {
+			return fmt.Errorf("%w: %s", db.ErrArticleNotFound, contain.ArtId)
+		}
+	}
+	return nil
+}
+
+//isUniqueViolation checks if err is a unique constraint violation. The SQLite drivers do not share an error type,
+//all of them report the violation with the message of SQLite
+func isUniqueViolation(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
+
+//UploadInventory inserts the inventory info into db
+func (inventory *SInventoryDB) UploadInventory(ctx context.Context, inventoryToInsert data.Inventory) (error, int) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("UploadInventory() entry...")
+	ctx, span := startSpan(ctx, "UploadInventory")
+	defer span.End()
+	transaction, err := inventory.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.WithField("err", err).Error("Transaction begin failed")
+		return err, 0
+	}
+	defer transaction.Rollback()
+	err = insertStocks(ctx, transaction, inventoryToInsert.Inventory)
+	if err != nil {
+		log.WithField("err: ", err).Error("UploadInventory failed to insert record...")
+		return err, 0
+	}
+	err = transaction.Commit()
+	if err != nil {
+		log.WithField("err: ", err).Error("Failed to commit...")
+		return err, 0
+	}
+	insertedRecord := len(inventoryToInsert.Inventory)
+
+	inventory.refreshAfterChange(ctx, log)
+	log.WithField("number of inventory uploaded: ", insertedRecord).Debug("UploadInventory(), uploaded products...")
+	return nil, insertedRecord
+}
+
+//insertStocks inserts the articles into the inventory of the location of ctx, an article already there fails
+func insertStocks(ctx context.Context, transaction *sql.Tx, stocks []data.Stock) error {
+	for _, inventoryRec := range stocks {
+		_, err := transaction.ExecContext(ctx, insertStock, inventoryRec.ArtId, inventoryRec.Name, inventoryRec.Stock, request.LocationFromContext(ctx))
+		if err == nil {
+			err = audit(ctx, transaction, data.AuditEntry{Operation: data.AuditUploadInventory, Entity: inventoryRec.ArtId, ArtId: inventoryRec.ArtId, StockAfter: inventoryRec.Stock})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//UploadCatalog inserts the inventory of the catalog and then its products in one transaction, nothing is inserted
+//when an article or a product fails
+func (inventory *SInventoryDB) UploadCatalog(ctx context.Context, catalog data.Catalog) error {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("UploadCatalog() entry...")
+	ctx, span := startSpan(ctx, "UploadCatalog")
+	defer span.End()
+	transaction, err := inventory.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.WithField("err", err).Error("Transaction begin failed")
+		return err
+	}
+	defer transaction.Rollback()
+	err = insertStocks(ctx, transaction, catalog.Inventory)
+	if err != nil {
+		log.WithField("err: ", err).Error("UploadCatalog(), failed to insert article...")
+		return err
+	}
+	for _, product := range catalog.Products {
+		err = replaceProduct(ctx, transaction, product)
+		if err == nil {
+			err = audit(ctx, transaction, data.AuditEntry{Operation: data.AuditUploadProduct, Entity: product.Name})
+		}
+		if err != nil {
+			log.WithField("err: ", err).Error("UploadCatalog(), failed to insert product...")
+			return err
+		}
+	}
+	err = transaction.Commit()
+	if err != nil {
+		log.WithField("err: ", err).Error("Failed to commit...")
+		return err
+	}
+
+	inventory.refreshAfterChange(ctx, log)
+	log.WithFields(logrus.Fields{"articles": len(catalog.Inventory), "products": len(catalog.Products)}).Debug("UploadCatalog(), uploaded the catalog...")
+	return nil
+}
+
+//errors of SellProduct that are reported as the reason of a refused sale by PreviewSale
+var (
+	errProductNotExist   = fmt.Errorf("%w, cannot be sold", db.ErrProductNotFound)
+	errProductOutOfStock = fmt.Errorf("%w, cannot be sold", db.ErrOutOfStock)
+)
+
+//SellProduct checks if the product exist and in stock. If true then update inventory accordingly
+func (inventory *SInventoryDB) SellProduct(ctx context.Context, productName string) error {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("sellProduct() entry...")
+	ctx, span := startSpan(ctx, "SellProduct")
+	defer span.End()
+	transaction, err := inventory.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.WithField("err", err).Error("Transaction begin failed")
+		return err
+	}
+	defer transaction.Rollback()
+
+	err = inventory.sell(ctx, log, transaction, productName)
+	if err != nil {
+		return err
+	}
+	err = transaction.Commit()
+	if err != nil {
+		log.WithField("err: ", err).Error("SellProduct(), failed to commit...")
+		return err
+	}
+
+	inventory.refreshAfterChange(ctx, log)
+	log.WithField("product is sold: ", productName).Debug("sellProduct(), sold the product and update the inventory...")
+	return nil
+}
+
+//ReturnProduct puts the articles of quantity units of a returned product back to the stock of the location, the
+//return is recorded as a negative sale. Every article of the product has to be stocked in the location
+func (inventory *SInventoryDB) ReturnProduct(ctx context.Context, productName string, quantity int) error {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("ReturnProduct() entry...")
+	ctx, span := startSpan(ctx, "ReturnProduct")
+	defer span.End()
+	if quantity <= 0 {
+		return errors.New("return quantity must be positive")
+	}
+	transaction, err := inventory.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.WithField("err", err).Error("Transaction begin failed")
+		return err
+	}
+
+	defer transaction.Rollback()
+	location := request.LocationFromContext(ctx)
+	var articleNo, buildable int
+	err = transaction.QueryRowContext(ctx, productBuildable, productName, location).Scan(&articleNo, &buildable)
+	if err != nil {
+		log.WithField("err", err).Error("ProductBuildable query failed")
+		return err
+	}
+	if articleNo == 0 {
+		log.Info("product is not found in system")
+		return fmt.Errorf("%w, cannot be returned", db.ErrProductNotFound)
+	}
+
+	//the stock before and after the return is audited before the update
+	_, err = transaction.ExecContext(ctx, auditReturn, productName, location, request.GetRID(ctx), data.AuditReturnProduct, quantity)
+	if err != nil {
+		log.WithField("err: ", err).Error("ReturnProduct(), failed to audit the return...")
+		return err
+	}
+	result, err := transaction.ExecContext(ctx, updateReturnInfo, productName, location, quantity)
+	if err != nil {
+		log.WithField("err: ", err).Error("ReturnProduct(), failed to update inventory...")
+		return err
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated != int64(articleNo) {
+		log.WithField("updated", updated).Info("product articles are not stocked in the location")
+		return fmt.Errorf("%w: not every article of product %s is stocked in %s, it cannot be returned", db.ErrArticleNotFound, productName, location)
+	}
+	_, err = transaction.ExecContext(ctx, insertSale, productName, location, -quantity)
+	if err != nil {
+		log.WithField("err: ", err).Error("ReturnProduct(), failed to record the return...")
+		return err
+	}
+	err = transaction.Commit()
+	if err != nil {
+		log.WithField("err: ", err).Error("ReturnProduct(), failed to commit...")
+		return err
+	}
+
+	inventory.refreshAfterChange(ctx, log)
+	log.WithField("product is returned: ", productName).Debug("ReturnProduct(), returned the product to the inventory...")
+	return nil
+}
+
+//ReserveProduct holds quantity units of the product in the location of ctx until expiresAt, so they are not sold to
+//anyone else. The units have to be available to promise, which is the stock minus the unexpired reservations
+func (inventory *SInventoryDB) ReserveProduct(ctx context.Context, productName string, quantity int, expiresAt time.Time) (data.Reservation, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("ReserveProduct() entry...")
+	ctx, span := startSpan(ctx, "ReserveProduct")
+	defer span.End()
+	location := request.LocationFromContext(ctx)
+	reservation := data.Reservation{Name: productName, Location: location, Quantity: quantity, ExpiresAt: expiresAt.UTC().Truncate(time.Millisecond)}
+	if quantity <= 0 {
+		return reservation, errors.New("reservation quantity must be positive")
+	}
+	if !expiresAt.After(time.Now()) {
+		return reservation, errors.New("reservation must expire in the future")
+	}
+	transaction, err := inventory.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.WithField("err", err).Error("Transaction begin failed")
+		return reservation, err
+	}
+
+	defer transaction.Rollback()
+	_, err = transaction.ExecContext(ctx, deleteExpiredReservations)
+	if err != nil {
+		log.WithField("err: ", err).Error("ReserveProduct(), failed to delete the expired reservations...")
+		return reservation, err
+	}
+	var articleNo, promisable int
+	err = transaction.QueryRowContext(ctx, availableToPromise, productName, location).Scan(&articleNo, &promisable)
+	if err != nil {
+		log.WithField("err", err).Error("AvailableToPromise query failed")
+		return reservation, err
+	}
+	if articleNo == 0 {
+		log.Info("product is not found in system")
+		return reservation, fmt.Errorf("%w, cannot be reserved", db.ErrProductNotFound)
+	}
+	if promisable < quantity {
+		log.WithField("promisable", promisable).Info("product items are out of stock")
+		return reservation, fmt.Errorf("%w: only %d of product %s can be reserved in %s", db.ErrOutOfStock, promisable, productName, location)
+	}
+	result, err := transaction.ExecContext(ctx, insertReservation, productName, location, quantity, reservation.ExpiresAt.Format(timeFormat))
+	if err != nil {
+		log.WithField("err: ", err).Error("ReserveProduct(), failed to insert the reservation...")
+		return reservation, err
+	}
+	reservation.Id, err = result.LastInsertId()
+	if err != nil {
+		return reservation, err
+	}
+	err = transaction.Commit()
+	if err != nil {
+		log.WithField("err: ", err).Error("ReserveProduct(), failed to commit...")
+		return reservation, err
+	}
+
+	log.WithField("reservation", reservation.Id).Debug("ReserveProduct(), reserved the product...")
+	return reservation, nil
+}
+
+//ReleaseReservation removes the reservation of the location of ctx, its units can be sold again
+func (inventory *SInventoryDB) ReleaseReservation(ctx context.Context, id int64) error {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("ReleaseReservation() entry...")
+	ctx, span := startSpan(ctx, "ReleaseReservation")
+	defer span.End()
+	result, err := inventory.db.ExecContext(ctx, deleteReservation, id, request.LocationFromContext(ctx))
+	if err != nil {
+		log.WithField("err: ", err).Error("ReleaseReservation(), failed to delete the reservation...")
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		log.Info("reservation is not found in system")
+		return fmt.Errorf("%w: %d", db.ErrReservationNotFound, id)
+	}
+
+	log.WithField("reservation", id).Debug("ReleaseReservation(), released the reservation...")
+	return nil
+}
+
+//IsProductBuildable checks if quantity of the product can be built from the current stock, it also returns the
+//maximum number of the product that can be built
+func (inventory *SInventoryDB) IsProductBuildable(ctx context.Context, productName string, quantity int) (bool, int, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("IsProductBuildable() entry...")
+	ctx, span := startSpan(ctx, "IsProductBuildable")
+	defer span.End()
+	var articleNo, maxBuildable int
+	err := inventory.db.QueryRowContext(ctx, productBuildable, productName, request.LocationFromContext(ctx)).Scan(&articleNo, &maxBuildable)
+	if err != nil {
+		log.WithField("err", err).Error("ProductBuildable query failed")
+		return false, 0, err
+	}
+	if articleNo == 0 {
+		log.Info("product is not found in system")
+		return false, 0, db.ErrProductNotFound
+	}
+
+	log.WithField("max buildable: ", maxBuildable).Debug("IsProductBuildable(), returns the buildable info...")
+	return maxBuildable >= quantity, maxBuildable, nil
+}
+
+//GetProductAvailability gets how many units of the product can be built at each location that stocks articles and
+//their total
+func (inventory *SInventoryDB) GetProductAvailability(ctx context.Context, productName string) (data.ProductAvailability, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("GetProductAvailability() entry...")
+	ctx, span := startSpan(ctx, "GetProductAvailability")
+	defer span.End()
+	availability := data.ProductAvailability{Name: productName, Locations: []data.LocationAvailability{}}
+	var productNo int
+	err := inventory.db.QueryRowContext(ctx, productExist, productName).Scan(&productNo)
+	if err != nil {
+		log.WithField("err", err).Error("ProductExist query failed")
+		return availability, err
+	}
+	if productNo == 0 {
+		log.Info("product is not found in system")
+		return availability, db.ErrProductNotFound
+	}
+
+	rows, err := inventory.db.QueryContext(ctx, productAvailability, productName)
+	if err != nil {
+		log.WithField("err", err).Error("ProductAvailability query failed")
+		return availability, err
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		var location data.LocationAvailability
+		err = rows.Scan(&location.Location, &location.Available)
+		if err != nil {
+			log.WithField("err", err).Error("Cannot scan the table")
+			return availability, err
+		}
+		availability.Locations = append(availability.Locations, location)
+		availability.Total += location.Available
+	}
+
+	err = rows.Err()
+	if err != nil {
+		log.WithField("err", err).Error("Error happened during the iteration")
+		return availability, err
+	}
+
+	log.WithField("total: ", availability.Total).Debug("GetProductAvailability(), returns the availability...")
+	return availability, nil
+}
+
+//PreviewSale runs the sale of the product in a transaction that is rolled back, so the inventory is not changed.
+//It returns if the product could be sold and the stock of its articles after the sale
+func (inventory *SInventoryDB) PreviewSale(ctx context.Context, productName string) (data.SalePreview, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("PreviewSale() entry...")
+	ctx, span := startSpan(ctx, "PreviewSale")
+	defer span.End()
+	preview := data.SalePreview{Name: productName}
+	transaction, err := inventory.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.WithField("err", err).Error("Transaction begin failed")
+		return preview, err
+	}
+	defer transaction.Rollback() //dry run, nothing is committed
+
+	err = inventory.sell(ctx, log, transaction, productName)
+	switch {
+	case err == nil:
+		preview.Sellable = true
+	case errors.Is(err, db.ErrProductNotFound), errors.Is(err, db.ErrOutOfStock):
+		preview.Reason = err.Error()
+	default:
+		return preview, err
+	}
+
+	rows, err := transaction.QueryContext(ctx, getProductArticles, productName, request.LocationFromContext(ctx))
+	if err != nil {
+		log.WithField("err", err).Error("GetProductArticles query failed")
+		return preview, err
+	}
+	defer rows.Close()
+	preview.Inventory = []data.Stock{}
+	var stock data.Stock
+	for rows.Next() {
+		err = rows.Scan(&stock.ArtId, &stock.Name, &stock.Stock)
+		if err != nil {
+			log.WithField("err", err).Error("Cannot scan the table")
+			return preview, err
+		}
+		preview.Inventory = append(preview.Inventory, stock)
+	}
+	err = rows.Err()
+	if err != nil {
+		log.WithField("err", err).Error("Error happened during the iteration")
+		return preview, err
+	}
+
+	log.WithField("sellable: ", preview.Sellable).Debug("PreviewSale(), rolled back the sale...")
+	return preview, nil
+}
+
+//sell checks if the product exist and in stock, then updates the inventory within the given transaction
+func (inventory *SInventoryDB) sell(ctx context.Context, log *logrus.Entry, transaction *sql.Tx, productName string) error {
+	// do not sell if the product does not exist
+	var productNo int
+	err := transaction.QueryRowContext(ctx, productExist, productName).Scan(&productNo)
+	if err != nil {
+		log.WithField("err", err).Error("ProductExist query failed")
+		return err
+	}
+	if productNo == 0 {
+		log.Info("product is not found in system")
+		return errProductNotExist
+	}
+
+	// do not sell if the product is not in stock, the reserved units are not available to promise
+	var stockNo int
+	err = transaction.QueryRowContext(ctx, inStock, productName, request.LocationFromContext(ctx)).Scan(&stockNo)
+	if err != nil {
+		log.WithField("err", err).Error("InStock query failed")
+		return err
+	}
+	if stockNo != 0 {
+		log.Info("product items are out of stock")
+		return errProductOutOfStock
+	}
+
+	//the stock before and after the sale is audited before the update
+	_, err = transaction.ExecContext(ctx, auditSale, productName, request.LocationFromContext(ctx), request.GetRID(ctx), data.AuditSell)
+	if err != nil {
+		log.WithField("err: ", err).Error("SellProduct(), failed to audit the sale...")
+		return err
+	}
+	_, err = transaction.ExecContext(ctx, updateSaleInfo, productName, request.LocationFromContext(ctx))
+	if err != nil {
+		log.WithField("err: ", err).Error("SellProduct(), failed to update inventory...")
+		return err
+	}
+	_, err = transaction.ExecContext(ctx, insertSale, productName, request.LocationFromContext(ctx), 1)
+	if err != nil {
+		log.WithField("err: ", err).Error("SellProduct(), failed to record the sale...")
+		return err
+	}
+	return nil
+}
+
+//DeleteProduct soft deletes the product by setting its deleted_at, so its history is kept in system
+func (inventory *SInventoryDB) DeleteProduct(ctx context.Context, productName string) error {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("DeleteProduct() entry...")
+	ctx, span := startSpan(ctx, "DeleteProduct")
+	defer span.End()
+	return inventory.setProductDeleted(ctx, log, deleteProduct, data.AuditDeleteProduct, productName, fmt.Errorf("%w, cannot be deleted", db.ErrProductNotFound))
+}
+
+//RestoreProduct brings back a soft deleted product
+func (inventory *SInventoryDB) RestoreProduct(ctx context.Context, productName string) error {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("RestoreProduct() entry...")
+	ctx, span := startSpan(ctx, "RestoreProduct")
+	defer span.End()
+	return inventory.setProductDeleted(ctx, log, restoreProduct, data.AuditRestoreProduct, productName, errors.New("this product is not deleted, cannot be restored"))
+}
+
+//RenameProduct gives the articles of the product the new name in a single transaction. The new name cannot be the
+//one of another product, deleted ones included
+func (inventory *SInventoryDB) RenameProduct(ctx context.Context, oldName string, newName string) error {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("RenameProduct() entry...")
+	ctx, span := startSpan(ctx, "RenameProduct")
+	defer span.End()
+	transaction, err := inventory.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.WithField("err", err).Error("Transaction begin failed")
+		return err
+	}
+	defer transaction.Rollback()
+
+	var taken int
+	err = transaction.QueryRowContext(ctx, productNameTaken, newName).Scan(&taken)
+	if err != nil {
+		log.WithField("err", err).Error("ProductNameTaken query failed")
+		return err
+	}
+	if taken != 0 {
+		log.WithField("product", newName).Info(db.ErrProductExists.Error())
+		return fmt.Errorf("%w, %s cannot be renamed to it", db.ErrProductExists, oldName)
+	}
+	result, err := transaction.ExecContext(ctx, renameProduct, oldName, newName)
+	if err != nil {
+		log.WithField("err: ", err).Error("Failed to rename the product...")
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		log.WithField("err: ", err).Error("Failed to get affected rows...")
+		return err
+	}
+	if affected == 0 {
+		log.WithField("product", oldName).Info(db.ErrProductNotFound.Error())
+		return fmt.Errorf("%w, cannot be renamed", db.ErrProductNotFound)
+	}
+	_, err = transaction.ExecContext(ctx, renameProductRecipe, oldName, newName)
+	if err != nil {
+		log.WithField("err: ", err).Error("Failed to rename the recipes of the product...")
+		return err
+	}
+	//both names are audited, so the history of either one shows the rename
+	for _, name := range []string{oldName, newName} {
+		err = audit(ctx, transaction, data.AuditEntry{Operation: data.AuditRenameProduct, Entity: name})
+		if err != nil {
+			log.WithField("err: ", err).Error("Failed to audit the product...")
+			return err
+		}
+	}
+
+	err = transaction.Commit()
+	if err != nil {
+		log.WithField("err: ", err).Error("Failed to commit...")
+		return err
+	}
+
+	inventory.refreshAfterChange(ctx, log)
+	log.WithField("product: ", newName).Debug("RenameProduct(), product is renamed...")
+	return nil
+}
+
+//setProductDeleted runs the given soft delete/restore statement and fails with notFound if no row is affected, the
+//change is audited as operation
+func (inventory *SInventoryDB) setProductDeleted(ctx context.Context, log *logrus.Entry, statement string, operation string, productName string, notFound error) error {
+	transaction, err := inventory.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.WithField("err", err).Error("Transaction begin failed")
+		return err
+	}
+	defer transaction.Rollback()
+
+	result, err := transaction.ExecContext(ctx, statement, productName)
+	if err != nil {
+		log.WithField("err: ", err).Error("Failed to update deleted_at of product...")
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		log.WithField("err: ", err).Error("Failed to get affected rows...")
+		return err
+	}
+	if affected == 0 {
+		log.WithField("product", productName).Info(notFound.Error())
+		return notFound
+	}
+	err = audit(ctx, transaction, data.AuditEntry{Operation: operation, Entity: productName})
+	if err != nil {
+		log.WithField("err: ", err).Error("Failed to audit the product...")
+		return err
+	}
+	err = transaction.Commit()
+	if err != nil {
+		log.WithField("err: ", err).Error("Failed to commit...")
+		return err
+	}
+
+	inventory.refreshAfterChange(ctx, log)
+	log.WithField("product: ", productName).Debug("setProductDeleted(), updated deleted_at of product...")
+	return nil
+}
+
+//AdjustArticles applies the stock adjustments in a single transaction. Lines that cannot be applied are reported
+//in data.StockAdjustmentErrors, if atomic is set none of the adjustments are applied in that case
+func (inventory *SInventoryDB) AdjustArticles(ctx context.Context, adjustments []data.StockAdjustment, atomic bool) (int, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("AdjustArticles() entry...")
+	ctx, span := startSpan(ctx, "AdjustArticles")
+	defer span.End()
+	transaction, err := inventory.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.WithField("err", err).Error("Transaction begin failed")
+		return 0, err
+	}
+	defer transaction.Rollback()
+
+	updated := 0
+	var failures data.StockAdjustmentErrors
+	for i, adjustment := range adjustments {
+		err = adjustment.Validate()
+		if err != nil {
+			failures = append(failures, data.StockAdjustmentFailure{Line: i, ArtId: adjustment.ArtId, Error: err.Error()})
+			continue
+		}
+
+		before, err := stockOf(ctx, transaction, adjustment.ArtId)
+		if err != nil {
+			log.WithField("err", err).Error("ArticleStock query failed")
+			return 0, err
+		}
+		var result sql.Result
+		if adjustment.Stock != nil {
+			result, err = transaction.ExecContext(ctx, setArticleStock, adjustment.ArtId, *adjustment.Stock, request.LocationFromContext(ctx))
+		} else {
+			result, err = transaction.ExecContext(ctx, addArticleStock, adjustment.ArtId, *adjustment.Delta, request.LocationFromContext(ctx))
+		}
+		if err != nil {
+			log.WithField("err: ", err).Error("AdjustArticles(), failed to update inventory...")
+			return 0, err
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			log.WithField("err: ", err).Error("AdjustArticles(), failed to get affected rows...")
+			return 0, err
+		}
+		if affected != 0 {
+			after, err := stockOf(ctx, transaction, adjustment.ArtId)
+			if err == nil {
+				err = audit(ctx, transaction, data.AuditEntry{Operation: data.AuditAdjustArticle, Entity: adjustment.ArtId, ArtId: adjustment.ArtId, StockBefore: before, StockAfter: after})
+			}
+			if err != nil {
+				log.WithField("err: ", err).Error("AdjustArticles(), failed to audit the adjustment...")
+				return 0, err
+			}
+			updated++
+			continue
+		}
+
+		// nothing is updated, either the article is unknown or the delta takes the stock below zero
+		var articleNo int
+		err = transaction.QueryRowContext(ctx, articleExist, adjustment.ArtId, request.LocationFromContext(ctx)).Scan(&articleNo)
+		if err != nil {
+			log.WithField("err", err).Error("ArticleExist query failed")
+			return 0, err
+		}
+		reason := "article is not found in system"
+		if articleNo != 0 {
+			reason = "not enough stock for the given delta"
+		}
+		failures = append(failures, data.StockAdjustmentFailure{Line: i, ArtId: adjustment.ArtId, Error: reason})
+	}
+
+	if len(failures) != 0 && atomic {
+		log.WithField("failed lines: ", len(failures)).Info("AdjustArticles(), atomic adjustment is rolled back...")
+		return 0, failures
+	}
+
+	err = transaction.Commit()
+	if err != nil {
+		log.WithField("err: ", err).Error("AdjustArticles(), failed to commit...")
+		return 0, err
+	}
+
+	inventory.refreshAfterChange(ctx, log)
+	log.WithField("number of article adjusted: ", updated).Debug("AdjustArticles(), adjusted the inventory...")
+	if len(failures) != 0 {
+		return updated, failures
+	}
+	return updated, nil
+}
+
+//GetArticle gets the article in the location of ctx with its version, the version changes on every stock update
+func (inventory *SInventoryDB) GetArticle(ctx context.Context, artId string) (data.Stock, int64, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("GetArticle() entry...")
+	ctx, span := startSpan(ctx, "GetArticle")
+	defer span.End()
+	var stock data.Stock
+	var version int64
+	err := inventory.db.QueryRowContext(ctx, getArticle, artId, request.LocationFromContext(ctx)).Scan(&stock.ArtId, &stock.Name, &stock.Stock, &version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return data.Stock{}, 0, fmt.Errorf("%w: %s", db.ErrArticleNotFound, artId)
+	}
+	if err != nil {
+		log.WithField("err", err).Error("GetArticle query failed")
+		return data.Stock{}, 0, err
+	}
+	return stock, version, nil
+}
+
+//AdjustArticle applies the adjustment only if the article is still at the given version, so concurrent updates of
+//the same article do not overwrite each other. The article after the update and its new version are returned
+func (inventory *SInventoryDB) AdjustArticle(ctx context.Context, adjustment data.StockAdjustment, version int64) (data.Stock, int64, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("AdjustArticle() entry...")
+	ctx, span := startSpan(ctx, "AdjustArticle")
+	defer span.End()
+	err := adjustment.Validate()
+	if err != nil {
+		return data.Stock{}, 0, err
+	}
+	transaction, err := inventory.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.WithField("err", err).Error("Transaction begin failed")
+		return data.Stock{}, 0, err
+	}
+	defer transaction.Rollback()
+
+	before, err := stockOf(ctx, transaction, adjustment.ArtId)
+	if err != nil {
+		log.WithField("err", err).Error("ArticleStock query failed")
+		return data.Stock{}, 0, err
+	}
+	var result sql.Result
+	if adjustment.Stock != nil {
+		result, err = transaction.ExecContext(ctx, setArticleStockAt, adjustment.ArtId, *adjustment.Stock, request.LocationFromContext(ctx), version)
+	} else {
+		result, err = transaction.ExecContext(ctx, addArticleStockAt, adjustment.ArtId, *adjustment.Delta, request.LocationFromContext(ctx), version)
+	}
+	if err != nil {
+		log.WithField("err: ", err).Error("AdjustArticle(), failed to update inventory...")
+		return data.Stock{}, 0, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		log.WithField("err: ", err).Error("AdjustArticle(), failed to get affected rows...")
+		return data.Stock{}, 0, err
+	}
+
+	var stock data.Stock
+	var current int64
+	err = transaction.QueryRowContext(ctx, getArticle, adjustment.ArtId, request.LocationFromContext(ctx)).Scan(&stock.ArtId, &stock.Name, &stock.Stock, &current)
+	if errors.Is(err, sql.ErrNoRows) {
+		return data.Stock{}, 0, fmt.Errorf("%w: %s", db.ErrArticleNotFound, adjustment.ArtId)
+	}
+	if err != nil {
+		log.WithField("err", err).Error("GetArticle query failed")
+		return data.Stock{}, 0, err
+	}
+	// nothing is updated, either the article is changed since the version or the delta takes the stock below zero
+	if affected == 0 && current != version {
+		return data.Stock{}, 0, fmt.Errorf("%w: %s is at version %d", db.ErrVersionMismatch, adjustment.ArtId, current)
+	}
+	if affected == 0 {
+		return data.Stock{}, 0, fmt.Errorf("%w: %s", db.ErrNotEnoughStock, adjustment.ArtId)
+	}
+
+	err = audit(ctx, transaction, data.AuditEntry{Operation: data.AuditAdjustArticle, Entity: adjustment.ArtId, ArtId: adjustment.ArtId, StockBefore: before, StockAfter: stock.Stock})
+	if err != nil {
+		log.WithField("err: ", err).Error("AdjustArticle(), failed to audit the adjustment...")
+		return data.Stock{}, 0, err
+	}
+	err = transaction.Commit()
+	if err != nil {
+		log.WithField("err: ", err).Error("AdjustArticle(), failed to commit...")
+		return data.Stock{}, 0, err
+	}
+
+	inventory.refreshAfterChange(ctx, log)
+	log.WithField("version", current).Debug("AdjustArticle(), adjusted the article...")
+	return stock, current, nil
+}
+
+//DeleteArticles removes the articles from inventory in a single transaction and returns the number of deleted ones.
+//Articles used by products are not deleted and data.ArticlesInUse lists the products, unless force is set. Then the
+//products using them are soft deleted, they can be restored once the articles are back
+func (inventory *SInventoryDB) DeleteArticles(ctx context.Context, artIds []string, force bool) (int, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("DeleteArticles() entry...")
+	ctx, span := startSpan(ctx, "DeleteArticles")
+	defer span.End()
+	artIdList, err := json.Marshal(artIds) //SQLite has no arrays, ids are passed as json array
+	if err != nil {
+		return 0, err
+	}
+	transaction, err := inventory.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.WithField("err", err).Error("Transaction begin failed")
+		return 0, err
+	}
+	defer transaction.Rollback()
+
+	if force {
+		_, err = transaction.ExecContext(ctx, auditDeleteArticleProducts, string(artIdList), data.AuditDeleteProduct, request.GetRID(ctx), request.LocationFromContext(ctx))
+		if err != nil {
+			log.WithField("err: ", err).Error("DeleteArticles(), failed to audit the products of articles...")
+			return 0, err
+		}
+		_, err = transaction.ExecContext(ctx, deleteArticleProducts, string(artIdList))
+		if err != nil {
+			log.WithField("err: ", err).Error("DeleteArticles(), failed to soft delete the products of articles...")
+			return 0, err
+		}
+	} else {
+		rows, err := transaction.QueryContext(ctx, articleProducts, string(artIdList))
+		if err != nil {
+			log.WithField("err", err).Error("ArticleProducts query failed")
+			return 0, err
+		}
+		defer rows.Close()
+		var productName string
+		var inUse data.ArticlesInUse
+		for rows.Next() {
+			err = rows.Scan(&productName)
+			if err != nil {
+				log.WithField("err", err).Error("Cannot scan the table")
+				return 0, err
+			}
+			inUse = append(inUse, productName)
+		}
+		err = rows.Err()
+		if err != nil {
+			log.WithField("err", err).Error("Error happened during the iteration")
+			return 0, err
+		}
+		if len(inUse) != 0 {
+			log.WithField("products: ", inUse).Info("DeleteArticles(), articles are used by products...")
+			return 0, inUse
+		}
+	}
+
+	_, err = transaction.ExecContext(ctx, auditDeleteArticles, string(artIdList), data.AuditDeleteArticle, request.GetRID(ctx))
+	if err != nil {
+		log.WithField("err: ", err).Error("DeleteArticles(), failed to audit the articles...")
+		return 0, err
+	}
+	result, err := transaction.ExecContext(ctx, deleteArticles, string(artIdList))
+	if err != nil {
+		log.WithField("err: ", err).Error("DeleteArticles(), failed to delete articles...")
+		return 0, err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		log.WithField("err: ", err).Error("DeleteArticles(), failed to get affected rows...")
+		return 0, err
+	}
+	err = transaction.Commit()
+	if err != nil {
+		log.WithField("err: ", err).Error("DeleteArticles(), failed to commit...")
+		return 0, err
+	}
+
+	inventory.refreshAfterChange(ctx, log)
+	log.WithField("number of article deleted: ", deleted).Debug("DeleteArticles(), deleted the articles...")
+	return int(deleted), nil
+}
+
+//integritySamples is the number of inconsistent records CheckIntegrity returns per issue
+const integritySamples = 10
+
+//CheckIntegrity reports the inconsistencies between the product and inventory tables of all locations. The checks
+//read the same snapshot of the tables in a transaction, at most integritySamples records are kept per issue
+func (inventory *SInventoryDB) CheckIntegrity(ctx context.Context) (data.IntegrityReport, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("CheckIntegrity() entry...")
+	ctx, span := startSpan(ctx, "CheckIntegrity")
+	defer span.End()
+	var report data.IntegrityReport
+	transaction, err := inventory.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.WithField("err", err).Error("Transaction begin failed")
+		return report, err
+	}
+	defer transaction.Rollback() //read only
+
+	checks := []struct {
+		query string
+		issue *data.IntegrityIssue
+	}{
+		{missingArticles, &report.MissingArticles},
+		{negativeStock, &report.NegativeStock},
+		{duplicateProductArticles, &report.DuplicateProductArticles},
+	}
+	for _, check := range checks {
+		*check.issue, err = integrityIssue(ctx, transaction, check.query)
+		if err != nil {
+			log.WithField("err", err).Error("Integrity query failed")
+			return report, err
+		}
+	}
+
+	log.WithField("consistent", report.Consistent()).Debug("CheckIntegrity(), returns the report...")
+	return report, nil
+}
+
+//integrityIssue counts the records the query finds and keeps the first integritySamples of them
+func integrityIssue(ctx context.Context, transaction *sql.Tx, query string) (data.IntegrityIssue, error) {
+	var issue data.IntegrityIssue
+	rows, err := transaction.QueryContext(ctx, query)
+	if err != nil {
+		return issue, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var record data.IntegrityRecord
+		err = rows.Scan(&record.ProductName, &record.ArtId, &record.Location, &record.Stock)
+		if err != nil {
+			return issue, err
+		}
+		issue.Count++
+		if len(issue.Samples) < integritySamples {
+			issue.Samples = append(issue.Samples, record)
+		}
+	}
+	return issue, rows.Err()
+}
+
+//ResetInventory removes every article, product and reservation of all locations in a transaction, it returns the
+//number of articles and products removed. It is meant to clean test environments
+func (inventory *SInventoryDB) ResetInventory(ctx context.Context) (int, int, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("ResetInventory() entry...")
+	ctx, span := startSpan(ctx, "ResetInventory")
+	defer span.End()
+	transaction, err := inventory.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.WithField("err", err).Error("Transaction begin failed")
+		return 0, 0, err
+	}
+	defer transaction.Rollback()
+
+	var products int
+	err = transaction.QueryRowContext(ctx, countProducts).Scan(&products)
+	if err != nil {
+		log.WithField("err", err).Error("CountProducts query failed")
+		return 0, 0, err
+	}
+	_, err = transaction.ExecContext(ctx, resetProducts)
+	if err != nil {
+		log.WithField("err: ", err).Error("ResetInventory(), failed to delete products...")
+		return 0, 0, err
+	}
+	_, err = transaction.ExecContext(ctx, resetRecipes)
+	if err != nil {
+		log.WithField("err: ", err).Error("ResetInventory(), failed to delete recipes...")
+		return 0, 0, err
+	}
+	_, err = transaction.ExecContext(ctx, resetReservations)
+	if err != nil {
+		log.WithField("err: ", err).Error("ResetInventory(), failed to delete reservations...")
+		return 0, 0, err
+	}
+	result, err := transaction.ExecContext(ctx, resetInventory)
+	if err != nil {
+		log.WithField("err: ", err).Error("ResetInventory(), failed to delete articles...")
+		return 0, 0, err
+	}
+	articles, err := result.RowsAffected()
+	if err != nil {
+		log.WithField("err: ", err).Error("ResetInventory(), failed to get affected rows...")
+		return 0, 0, err
+	}
+	err = audit(ctx, transaction, data.AuditEntry{Operation: data.AuditResetInventory, Entity: "inventory"})
+	if err != nil {
+		log.WithField("err: ", err).Error("ResetInventory(), failed to audit the reset...")
+		return 0, 0, err
+	}
+	err = transaction.Commit()
+	if err != nil {
+		log.WithField("err: ", err).Error("ResetInventory(), failed to commit...")
+		return 0, 0, err
+	}
+
+	inventory.refreshAfterChange(ctx, log)
+	log.WithFields(logrus.Fields{"articles": articles, "products": products}).Info("ResetInventory(), removed the inventory...")
+	return int(articles), products, nil
+}
+
+//GetSales gets how many units of each product were sold in the location of ctx at or after since, the most sold
+//first
+func (inventory *SInventoryDB) GetSales(ctx context.Context, since time.Time) ([]data.Sale, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("GetSales() entry...")
+	ctx, span := startSpan(ctx, "GetSales")
+	defer span.End()
+	rows, err := inventory.db.QueryContext(ctx, getSales, request.LocationFromContext(ctx), since.UTC().Format(timeFormat))
+	if err != nil {
+		log.WithField("err", err).Error("GetSales query failed")
+		return nil, err
+	}
+
+	defer rows.Close()
+	sales := []data.Sale{}
+	for rows.Next() {
+		var sale data.Sale
+		var firstSoldAt, lastSoldAt string //the aggregates are text, the column type is not known to the driver
+		err = rows.Scan(&sale.Name, &sale.Sold, &firstSoldAt, &lastSoldAt)
+		if err == nil {
+			sale.FirstSoldAt, err = time.Parse(timeFormat, firstSoldAt)
+		}
+		if err == nil {
+			sale.LastSoldAt, err = time.Parse(timeFormat, lastSoldAt)
+		}
+		if err != nil {
+			log.WithField("err", err).Error("Cannot scan the table")
+			return nil, err
+		}
+		sales = append(sales, sale)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		log.WithField("err", err).Error("Error happened during the iteration")
+		return nil, err
+	}
+
+	log.WithField("number of sold product to be returned: ", len(sales)).Debug("GetSales(), returns the sales...")
+	return sales, nil
+}
+
+//GetAuditLog gets the latest limit audit entries of all locations, newest first. If entity is given only the
+//entries of that product or article are returned
+func (inventory *SInventoryDB) GetAuditLog(ctx context.Context, entity string, limit int) ([]data.AuditEntry, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("GetAuditLog() entry...")
+	ctx, span := startSpan(ctx, "GetAuditLog")
+	defer span.End()
+	if limit <= 0 {
+		return nil, errors.New("audit limit must be positive")
+	}
+	rows, err := inventory.db.QueryContext(ctx, getAuditLog, entity, limit)
+	if err != nil {
+		log.WithField("err", err).Error("GetAuditLog query failed")
+		return nil, err
+	}
+
+	defer rows.Close()
+	entries := []data.AuditEntry{}
+	for rows.Next() {
+		var entry data.AuditEntry
+		err = rows.Scan(&entry.Id, &entry.Operation, &entry.RID, &entry.Entity, &entry.ArtId, &entry.Location, &entry.StockBefore, &entry.StockAfter, &entry.CreatedAt)
+		if err != nil {
+			log.WithField("err", err).Error("Cannot scan the table")
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		log.WithField("err", err).Error("Error happened during the iteration")
+		return nil, err
+	}
+
+	log.WithField("number of audit entry to be returned: ", len(entries)).Debug("GetAuditLog(), returns the entries...")
+	return entries, nil
+}
+
+//audit writes the entry to the audit log within the transaction of the mutation, so it is rolled back with it
+func audit(ctx context.Context, transaction *sql.Tx, entry data.AuditEntry) error {
+	_, err := transaction.ExecContext(ctx, insertAudit, entry.Operation, request.GetRID(ctx), entry.Entity, entry.ArtId, request.LocationFromContext(ctx), entry.StockBefore, entry.StockAfter)
+	return err
+}
+
+//stockOf gets the stock of the article in the location of ctx, empty if it is not stocked there
+func stockOf(ctx context.Context, transaction *sql.Tx, artId string) (data.Quantity, error) {
+	var stock data.Quantity
+	err := transaction.QueryRowContext(ctx, articleStock, artId, request.LocationFromContext(ctx)).Scan(&stock)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return stock, err
+}
+
+//GetStats gets the aggregate info of articles and products in system
+func (inventory *SInventoryDB) GetStats(ctx context.Context) (data.Stats, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("GetStats() entry...")
+	ctx, span := startSpan(ctx, "GetStats")
+	defer span.End()
+	var stats data.Stats
+	err := inventory.db.QueryRowContext(ctx, getStats, request.LocationFromContext(ctx)).Scan(&stats.TotalArticles, &stats.TotalStock, &stats.TotalProducts, &stats.BuildableProducts)
+	if err != nil {
+		log.WithField("err", err).Error("GetStats query failed")
+		return data.Stats{}, err
+	}
+
+	log.WithField("stats: ", stats).Debug("GetStats(), returns the stats...")
+	return stats, nil
+}
+
+//UnknownArticles returns the given article ids that are not in the inventory, checked with a single query
+func (inventory *SInventoryDB) UnknownArticles(ctx context.Context, artIds []string) ([]string, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("UnknownArticles() entry...")
+	ctx, span := startSpan(ctx, "UnknownArticles")
+	defer span.End()
+	artIdList, err := json.Marshal(artIds) //SQLite has no arrays, ids are passed as json array
+	if err != nil {
+		return nil, err
+	}
+	rows, err := inventory.db.QueryContext(ctx, unknownArticles, string(artIdList))
+	if err != nil {
+		log.WithField("err", err).Error("UnknownArticles query failed")
+		return nil, err
+	}
+
+	defer rows.Close()
+	var artId string
+	var unknown []string
+	for rows.Next() {
+		err = rows.Scan(&artId)
+		if err != nil {
+			log.WithField("err", err).Error("Cannot scan the table")
+			return nil, err
+		}
+		unknown = append(unknown, artId)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		log.WithField("err", err).Error("Error happened during the iteration")
+		return nil, err
+	}
+
+	log.WithField("number of unknown articles: ", len(unknown)).Debug("UnknownArticles(), returns the unknown articles...")
+	return unknown, nil
+}
+
+//GetReorderArticles gets the articles of the location of ctx whose stock is at or below their reorder point, ordered
+//by art_id, with the quantity that brings them up to their reorder level
+func (inventory *SInventoryDB) GetReorderArticles(ctx context.Context) ([]data.Reorder, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("GetReorderArticles() entry...")
+	ctx, span := startSpan(ctx, "GetReorderArticles")
+	defer span.End()
+	rows, err := inventory.db.QueryContext(ctx, getReorderArticles, request.LocationFromContext(ctx))
+	if err != nil {
+		log.WithField("err", err).Error("GetReorderArticles query failed")
+		return nil, err
+	}
+
+	defer rows.Close()
+	reorders := []data.Reorder{}
+	for rows.Next() {
+		var reorder data.Reorder
+		err = rows.Scan(&reorder.ArtId, &reorder.Name, &reorder.Stock, &reorder.ReorderPoint, &reorder.ReorderTo, &reorder.Quantity)
+		if err != nil {
+			log.WithField("err", err).Error("Cannot scan the table")
+			return nil, err
+		}
+		reorders = append(reorders, reorder)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		log.WithField("err", err).Error("Error happened during the iteration")
+		return nil, err
+	}
+
+	log.WithField("number of article to be reordered: ", len(reorders)).Debug("GetReorderArticles(), returns the articles...")
+	return reorders, nil
+}
+
+//SetReorderLevels sets the reorder point and level of the article in the location of ctx, empty levels clear them.
+//The levels are not stock, the version of the article is kept
+func (inventory *SInventoryDB) SetReorderLevels(ctx context.Context, artId string, levels data.ReorderLevels) error {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("SetReorderLevels() entry...")
+	ctx, span := startSpan(ctx, "SetReorderLevels")
+	defer span.End()
+	result, err := inventory.db.ExecContext(ctx, setReorderLevels, artId, levels.ReorderPoint, levels.ReorderTo, request.LocationFromContext(ctx))
+	if err != nil {
+		log.WithField("err: ", err).Error("SetReorderLevels(), failed to update the article...")
+		return err
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		log.Info("article is not found in system")
+		return fmt.Errorf("%w: %s", db.ErrArticleNotFound, artId)
+	}
+
+	log.WithField("art_id", artId).Debug("SetReorderLevels(), updated the reorder levels...")
+	return nil
+}
+
+//GetProductArticles gets the articles of the recipe version of the product ordered by art_id, the latest version if
+//version is 0. The versions of a deleted product can still be read
+func (inventory *SInventoryDB) GetProductArticles(ctx context.Context, productName string, version int) (data.Product, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("GetProductArticles() entry...")
+	ctx, span := startSpan(ctx, "GetProductArticles")
+	defer span.End()
+	rows, err := inventory.db.QueryContext(ctx, getProductRecipe, productName, version)
+	if err != nil {
+		log.WithField("err", err).Error("GetProductRecipe query failed")
+		return data.Product{}, err
+	}
+
+	defer rows.Close()
+	product := data.Product{Name: productName, ContainArticles: []data.ArticleContain{}}
+	for rows.Next() {
+		var contain data.ArticleContain
+		err = rows.Scan(&product.RecipeVersion, &contain.ArtId, &contain.AmountOf)
+		if err != nil {
+			log.WithField("err", err).Error("Cannot scan the table")
+			return data.Product{}, err
+		}
+		product.ContainArticles = append(product.ContainArticles, contain)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		log.WithField("err", err).Error("Error happened during the iteration")
+		return data.Product{}, err
+	}
+	if len(product.ContainArticles) == 0 {
+		log.Info("product recipe is not found in system")
+		if version != 0 {
+			return data.Product{}, fmt.Errorf("%w: %s recipe version %d", db.ErrProductNotFound, productName, version)
+		}
+		return data.Product{}, fmt.Errorf("%w: %s", db.ErrProductNotFound, productName)
+	}
+
+	log.WithField("recipe version", product.RecipeVersion).Debug("GetProductArticles(), returns the articles...")
+	return product, nil
+}
+
+//GetArticleProducts gets the products containing the article with the number of them that can be built from the
+//stock of the location and the gain of each unit of the article added, ordered by name
+func (inventory *SInventoryDB) GetArticleProducts(ctx context.Context, artId string) ([]data.ArticleProduct, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("GetArticleProducts() entry...")
+	ctx, span := startSpan(ctx, "GetArticleProducts")
+	defer span.End()
+	rows, err := inventory.db.QueryContext(ctx, getArticleProducts, artId, request.LocationFromContext(ctx))
+	if err != nil {
+		log.WithField("err", err).Error("GetArticleProducts query failed")
+		return nil, err
+	}
+
+	defer rows.Close()
+	products := []data.ArticleProduct{}
+	for rows.Next() {
+		var product data.ArticleProduct
+		err = rows.Scan(&product.Name, &product.AmountOf, &product.Buildable, &product.GainPerUnit)
+		if err != nil {
+			log.WithField("err", err).Error("Cannot scan the table")
+			return nil, err
+		}
+		products = append(products, product)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		log.WithField("err", err).Error("Error happened during the iteration")
+		return nil, err
+	}
+	if len(products) == 0 {
+		var articleNo int
+		err = inventory.db.QueryRowContext(ctx, articleExist, artId, request.LocationFromContext(ctx)).Scan(&articleNo)
+		if err != nil {
+			log.WithField("err", err).Error("ArticleExist query failed")
+			return nil, err
+		}
+		if articleNo == 0 {
+			log.Info("article is not found in system")
+			return nil, fmt.Errorf("%w: %s", db.ErrArticleNotFound, artId)
+		}
+	}
+
+	log.WithField("number of products: ", len(products)).Debug("GetArticleProducts(), returns the products...")
+	return products, nil
+}
+
+//CheckCart checks the units of the products of the cart available to promise in the location, line by line and all
+//together. The articles of the products are read from the same snapshot in a transaction, nothing is reserved
+func (inventory *SInventoryDB) CheckCart(ctx context.Context, cart data.Cart) (data.CartAvailability, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("CheckCart() entry...")
+	ctx, span := startSpan(ctx, "CheckCart")
+	defer span.End()
+	transaction, err := inventory.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.WithField("err", err).Error("Transaction begin failed")
+		return data.CartAvailability{}, err
+	}
+	defer transaction.Rollback() //read only
+
+	articles := make([][]data.CartArticle, len(cart))
+	for i, line := range cart {
+		articles[i], err = readCartArticles(ctx, transaction, cartArticles, line.Name, request.LocationFromContext(ctx))
+		if err != nil {
+			log.WithField("err", err).Error("CartArticles query failed")
+			return data.CartAvailability{}, err
+		}
+	}
+	availability := data.CheckCart(cart, articles)
+
+	log.WithField("sufficient", availability.Sufficient).Debug("CheckCart(), returns the availability...")
+	return availability, nil
+}
+
+//readCartArticles reads the articles of the product with their stock available to promise in the location
+func readCartArticles(ctx context.Context, transaction *sql.Tx, query string, productName string, location string) ([]data.CartArticle, error) {
+	rows, err := transaction.QueryContext(ctx, query, productName, location)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var articles []data.CartArticle
+	for rows.Next() {
+		var article data.CartArticle
+		err = rows.Scan(&article.ArtId, &article.Amount, &article.Available)
+		if err != nil {
+			return nil, err
+		}
+		articles = append(articles, article)
+	}
+	return articles, rows.Err()
+}
+
+//planFulfillment reads the locations the order draws from and the stock of the articles of its lines in each of them,
+//and allocates the lines to them with data.PlanFulfillment
+func planFulfillment(ctx context.Context, transaction *sql.Tx, locationsQuery string, articlesQuery string, order data.Order) ([]data.Allocation, error) {
+	rows, err := transaction.QueryContext(ctx, locationsQuery)
+	if err != nil {
+		return nil, err
+	}
+	var all []string
+	for rows.Next() {
+		var location string
+		err = rows.Scan(&location)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		all = append(all, location)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var locations []data.FulfillmentLocation
+	for _, location := range order.FulfillmentLocations(all, request.LocationFromContext(ctx)) {
+		candidate := data.FulfillmentLocation{Location: location, Articles: make([][]data.CartArticle, len(order.Lines))}
+		for i, line := range order.Lines {
+			candidate.Articles[i], err = readCartArticles(ctx, transaction, articlesQuery, line.Name, location)
+			if err != nil {
+				return nil, err
+			}
+		}
+		locations = append(locations, candidate)
+	}
+	allocations, notFound, unfilled := data.PlanFulfillment(order, locations)
+	if len(notFound) != 0 {
+		return nil, fmt.Errorf("%w: %s, cannot be sold", db.ErrProductNotFound, strings.Join(notFound, ", "))
+	}
+	if len(unfilled) != 0 {
+		return nil, fmt.Errorf("%w: the locations together cannot fill %s", db.ErrOutOfStock, strings.Join(unfilled, ", "))
+	}
+	return allocations, nil
+}
+
+//fulfill takes the articles of the allocated units from the stock of its location, audits them and records the sale
+func fulfill(ctx context.Context, transaction *sql.Tx, auditQuery string, updateQuery string, saleQuery string, allocation data.Allocation) error {
+	_, err := transaction.ExecContext(ctx, auditQuery, allocation.Name, allocation.Location, request.GetRID(ctx), data.AuditSell, allocation.Quantity)
+	if err != nil {
+		return err
+	}
+	_, err = transaction.ExecContext(ctx, updateQuery, allocation.Name, allocation.Location, allocation.Quantity)
+	if err != nil {
+		return err
+	}
+	_, err = transaction.ExecContext(ctx, saleQuery, allocation.Name, allocation.Location, allocation.Quantity)
+	return err
+}
+
+//CreateSnapshot saves the stock of every article of the location under the label, the stock can be diffed against it
+//later. A label is used once per location
+func (inventory *SInventoryDB) CreateSnapshot(ctx context.Context, label string) (data.Snapshot, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("CreateSnapshot() entry...")
+	ctx, span := startSpan(ctx, "CreateSnapshot")
+	defer span.End()
+	location := request.LocationFromContext(ctx)
+	snapshot := data.Snapshot{Label: label, CreatedAt: time.Now().UTC().Truncate(time.Millisecond)}
+	transaction, err := inventory.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.WithField("err", err).Error("Transaction begin failed")
+		return data.Snapshot{}, err
+	}
+
+	defer transaction.Rollback()
+	_, err = transaction.ExecContext(ctx, insertSnapshot, label, location, snapshot.CreatedAt.Format(timeFormat))
+	if isUniqueViolation(err) {
+		log.WithField("label", label).Info(db.ErrSnapshotExists.Error())
+		return data.Snapshot{}, fmt.Errorf("%w: %s", db.ErrSnapshotExists, label)
+	}
+	if err != nil {
+		log.WithField("err: ", err).Error("CreateSnapshot(), failed to insert the snapshot...")
+		return data.Snapshot{}, err
+	}
+	result, err := transaction.ExecContext(ctx, insertSnapshotStock, label, location)
+	if err != nil {
+		log.WithField("err: ", err).Error("CreateSnapshot(), failed to copy the stock...")
+		return data.Snapshot{}, err
+	}
+	articles, err := result.RowsAffected()
+	if err != nil {
+		return data.Snapshot{}, err
+	}
+	snapshot.Articles = int(articles)
+	err = transaction.Commit()
+	if err != nil {
+		log.WithField("err: ", err).Error("CreateSnapshot(), failed to commit...")
+		return data.Snapshot{}, err
+	}
+
+	log.WithFields(logrus.Fields{"label": label, "articles": snapshot.Articles}).Debug("CreateSnapshot(), saved the stock...")
+	return snapshot, nil
+}
+
+//DiffSnapshot gets the change of the stock of the articles of the location since the snapshot of the label, ordered
+//by art_id. The articles whose stock did not change are left out
+func (inventory *SInventoryDB) DiffSnapshot(ctx context.Context, label string) (data.Snapshot, []data.StockDelta, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("DiffSnapshot() entry...")
+	ctx, span := startSpan(ctx, "DiffSnapshot")
+	defer span.End()
+	location := request.LocationFromContext(ctx)
+	snapshot := data.Snapshot{Label: label}
+	err := inventory.db.QueryRowContext(ctx, getSnapshot, label, location).Scan(&snapshot.CreatedAt, &snapshot.Articles)
+	if errors.Is(err, sql.ErrNoRows) {
+		log.Info("snapshot is not found in system")
+		return data.Snapshot{}, nil, fmt.Errorf("%w: %s", db.ErrSnapshotNotFound, label)
+	}
+	if err != nil {
+		log.WithField("err", err).Error("GetSnapshot query failed")
+		return data.Snapshot{}, nil, err
+	}
+	snapshot.CreatedAt = snapshot.CreatedAt.UTC()
+
+	rows, err := inventory.db.QueryContext(ctx, diffSnapshot, label, location)
+	if err != nil {
+		log.WithField("err", err).Error("DiffSnapshot query failed")
+		return data.Snapshot{}, nil, err
+	}
+	defer rows.Close()
+	deltas := []data.StockDelta{}
+	for rows.Next() {
+		var artId, name string
+		var before, after int64
+		err = rows.Scan(&artId, &name, &before, &after)
+		if err != nil {
+			log.WithField("err", err).Error("Cannot scan the table")
+			return data.Snapshot{}, nil, err
+		}
+		deltas = append(deltas, data.NewStockDelta(artId, name, before, after))
+	}
+
+	err = rows.Err()
+	if err != nil {
+		log.WithField("err", err).Error("Error happened during the iteration")
+		return data.Snapshot{}, nil, err
+	}
+
+	log.WithField("number of deltas: ", len(deltas)).Debug("DiffSnapshot(), returns the stock deltas...")
+	return snapshot, deltas, nil
+}
+
+//GetStockByName gets the stock of the articles of the location summed by name, ordered by name
+func (inventory *SInventoryDB) GetStockByName(ctx context.Context) ([]data.NameStock, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("GetStockByName() entry...")
+	ctx, span := startSpan(ctx, "GetStockByName")
+	defer span.End()
+	rows, err := inventory.db.QueryContext(ctx, getStockByName, request.LocationFromContext(ctx))
+	if err != nil {
+		log.WithField("err", err).Error("GetStockByName query failed")
+		return nil, err
+	}
+
+	defer rows.Close()
+	stocks := []data.NameStock{}
+	for rows.Next() {
+		var stock data.NameStock
+		err = rows.Scan(&stock.Name, &stock.Stock, &stock.Articles)
+		if err != nil {
+			log.WithField("err", err).Error("Cannot scan the table")
+			return nil, err
+		}
+		stocks = append(stocks, stock)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		log.WithField("err", err).Error("Error happened during the iteration")
+		return nil, err
+	}
+
+	log.WithField("number of names to be returned: ", len(stocks)).Debug("GetStockByName(), returns the stocks...")
+	return stocks, nil
+}
+
+//PlanFulfillment sells the lines of the order from several locations. The units are allocated to the locations by the
+//strategy of the order from their stock available to promise, then taken from their stock together. Nothing is sold
+//when a product is not found or the locations cannot fill a line together
+func (inventory *SInventoryDB) PlanFulfillment(ctx context.Context, order data.Order) ([]data.Allocation, error) {
+	log := inventory.config.Logger.WithField("rid", request.GetRID(ctx))
+	log.Debug("PlanFulfillment() entry...")
+	ctx, span := startSpan(ctx, "PlanFulfillment")
+	defer span.End()
+	transaction, err := inventory.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.WithField("err", err).Error("Transaction begin failed")
+		return nil, err
+	}
+
+	defer transaction.Rollback()
+	allocations, err := planFulfillment(ctx, transaction, inventoryLocations, cartArticles, order)
+	if err != nil {
+		log.WithField("err", err).Info("PlanFulfillment(), order cannot be fulfilled...")
+		return nil, err
+	}
+	for _, allocation := range allocations {
+		err = fulfill(ctx, transaction, auditSaleUnits, updateSaleUnits, insertSale, allocation)
+		if err != nil {
+			log.WithField("err: ", err).Error("PlanFulfillment(), failed to take the stock...")
+			return nil, err
+		}
+	}
+	err = transaction.Commit()
+	if err != nil {
+		log.WithField("err: ", err).Error("PlanFulfillment(), failed to commit...")
+		return nil, err
+	}
+
+	inventory.refreshAfterChange(ctx, log)
+	log.WithField("number of allocations: ", len(allocations)).Debug("PlanFulfillment(), sold the order...")
+	return allocations, nil
+}
@@ -0,0 +1,198 @@
+package sqlite
+
+import (
+	"context"
+	"github.com/auknl/warehouse/data"
+	"github.com/auknl/warehouse/db"
+	"github.com/auknl/warehouse/db/dbtest"
+	"github.com/auknl/warehouse/request"
+	"github.com/sirupsen/logrus"
+	"gotest.tools/assert"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+func newMemoryInventory(t *testing.T) *SInventoryDB {
+	inventory := &SInventoryDB{
+		config: Config{Logger: logrus.NewEntry(logrus.New()), Driver: "sqlite", DataSource: ":memory:"},
+	}
+	err := inventory.Open()
+	assert.NilError(t, err)
+	return inventory
+}
+
+func TestSInventoryDB_Conformance(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) db.Inventory {
+		return newMemoryInventory(t)
+	})
+}
+
+func TestSInventoryDB_PoolStats(t *testing.T) {
+	inventory := newMemoryInventory(t)
+
+	stats := inventory.PoolStats()
+	//the single connection of the memory database is kept open and idle between the statements
+	assert.Equal(t, stats.MaxOpenConnections, 1)
+	assert.Equal(t, stats.OpenConnections, 1)
+	assert.Equal(t, stats.InUse, 0)
+}
+
+func TestSInventoryDB_ProductStockCache(t *testing.T) {
+	inventory := newMemoryInventory(t)
+	ctx := context.Background()
+	north := request.WithLocation(ctx, "north")
+	//the cache has to match the product stock computed from the current stock
+	matches := func(ctx context.Context) {
+		t.Helper()
+		for _, includeDeleted := range []bool{false, true} {
+			err, fresh := inventory.GetProductStock(ctx, includeDeleted)
+			assert.NilError(t, err)
+			cached, err := inventory.GetCachedProductStock(ctx, includeDeleted)
+			assert.NilError(t, err)
+			assert.DeepEqual(t, cached, fresh)
+		}
+	}
+
+	inventoryData := dbtest.ExampleInventory(t)
+	err, _ := inventory.UploadInventory(ctx, inventoryData)
+	assert.NilError(t, err)
+	err, _ = inventory.UploadInventory(north, data.Inventory{Inventory: []data.Stock{{ArtId: "1", Name: "leg", Stock: "8"}, {ArtId: "2", Name: "screw", Stock: "16"}, {ArtId: "3", Name: "seat", Stock: "5"}}})
+	assert.NilError(t, err)
+	products := dbtest.ExampleProducts(t)
+	err, _ = inventory.UploadProducts(ctx, products, false)
+	assert.NilError(t, err)
+	matches(ctx)
+	matches(north)
+	cached, err := inventory.GetCachedProductStock(north, false)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, cached, data.ProductStocks{{Name: "Dining Chair", AvailableProductNo: "2"}})
+
+	//the changes refresh the cache
+	assert.NilError(t, inventory.SellProduct(ctx, "Dining Chair"))
+	matches(ctx)
+	assert.NilError(t, inventory.DeleteProduct(ctx, "Dinning Table"))
+	matches(ctx)
+	delta := data.Quantity("-4")
+	_, err = inventory.AdjustArticles(north, []data.StockAdjustment{{ArtId: "1", Delta: &delta}}, true)
+	assert.NilError(t, err)
+	matches(north)
+
+	//a change made around the inventory is only seen after a refresh
+	_, err = inventory.db.Exec("UPDATE inventory SET stock=0 WHERE art_id='1'")
+	assert.NilError(t, err)
+	cached, err = inventory.GetCachedProductStock(north, false)
+	assert.NilError(t, err)
+	assert.Equal(t, len(cached), 1)
+	assert.NilError(t, inventory.RefreshProductStock(ctx))
+	matches(ctx)
+	matches(north)
+	cached, err = inventory.GetCachedProductStock(north, false)
+	assert.NilError(t, err)
+	assert.Equal(t, len(cached), 0)
+}
+
+func TestSInventoryDB_AuditLog(t *testing.T) {
+	inventory := newMemoryInventory(t)
+	ctx := request.WithRID(context.Background(), "rid-1")
+
+	err, _ := inventory.UploadInventory(ctx, data.Inventory{Inventory: []data.Stock{
+		{ArtId: "1", Name: "leg", Stock: "8"},
+		{ArtId: "2", Name: "seat", Stock: "1"},
+	}})
+	assert.NilError(t, err)
+	err, _ = inventory.UploadProducts(ctx, data.Products{Products: []data.Product{
+		{Name: "chair", ContainArticles: []data.ArticleContain{{ArtId: "1", AmountOf: "4"}, {ArtId: "2", AmountOf: "1"}}},
+	}}, false)
+	assert.NilError(t, err)
+	assert.NilError(t, inventory.SellProduct(ctx, "chair"))
+
+	entries, err := inventory.GetAuditLog(ctx, "chair", 10)
+	assert.NilError(t, err)
+	assert.Equal(t, len(entries), 3)
+	for i := range entries {
+		assert.Assert(t, !entries[i].CreatedAt.IsZero())
+		entries[i].Id, entries[i].CreatedAt = 0, time.Time{}
+	}
+	assert.DeepEqual(t, entries, []data.AuditEntry{
+		{Operation: data.AuditSell, RID: "rid-1", Entity: "chair", ArtId: "2", Location: request.DefaultLocation, StockBefore: "1", StockAfter: "0"},
+		{Operation: data.AuditSell, RID: "rid-1", Entity: "chair", ArtId: "1", Location: request.DefaultLocation, StockBefore: "8", StockAfter: "4"},
+		{Operation: data.AuditUploadProduct, RID: "rid-1", Entity: "chair", Location: request.DefaultLocation},
+	})
+	//the article entries include the sale
+	entries, err = inventory.GetAuditLog(ctx, "1", 10)
+	assert.NilError(t, err)
+	assert.Equal(t, len(entries), 2)
+	assert.Equal(t, entries[1].Operation, data.AuditUploadInventory)
+	assert.Equal(t, entries[1].StockAfter, data.Quantity("8"))
+
+	//the audit of a failed sale is rolled back with it
+	_, err = inventory.db.Exec("CREATE TRIGGER fail_sale BEFORE UPDATE ON inventory BEGIN SELECT RAISE(ABORT, 'sale failed'); END")
+	assert.NilError(t, err)
+	err, _ = inventory.UploadInventory(ctx, data.Inventory{Inventory: []data.Stock{{ArtId: "3", Name: "top", Stock: "1"}}})
+	assert.NilError(t, err)
+	err, _ = inventory.UploadProducts(ctx, data.Products{Products: []data.Product{
+		{Name: "table", ContainArticles: []data.ArticleContain{{ArtId: "3", AmountOf: "1"}}},
+	}}, false)
+	assert.NilError(t, err)
+	assert.ErrorContains(t, inventory.SellProduct(ctx, "table"), "sale failed")
+	_, err = inventory.PreviewSale(ctx, "chair")
+	assert.NilError(t, err)
+	entries, err = inventory.GetAuditLog(ctx, "", 10)
+	assert.NilError(t, err)
+	assert.Equal(t, len(entries), 7)
+	assert.Equal(t, entries[0].Operation, data.AuditUploadProduct)
+	assert.Equal(t, entries[0].Entity, "table")
+
+	_, err = inventory.GetAuditLog(ctx, "", 0)
+	assert.Error(t, err, "audit limit must be positive")
+}
+
+func TestSInventoryDB_CheckIntegrity(t *testing.T) {
+	inventory := newMemoryInventory(t)
+	ctx := context.Background()
+
+	err, _ := inventory.UploadInventory(ctx, data.Inventory{Inventory: []data.Stock{
+		{ArtId: "1", Name: "leg", Stock: "8"},
+		{ArtId: "2", Name: "seat", Stock: "2"},
+	}})
+	assert.NilError(t, err)
+	err, _ = inventory.UploadProducts(ctx, data.Products{Products: []data.Product{
+		{Name: "chair", ContainArticles: []data.ArticleContain{{ArtId: "1", AmountOf: "4"}, {ArtId: "2", AmountOf: "1"}}},
+	}}, false)
+	assert.NilError(t, err)
+
+	report, err := inventory.CheckIntegrity(ctx)
+	assert.NilError(t, err)
+	assert.Assert(t, report.Consistent())
+
+	//the schema prevents the inconsistencies, it is bypassed to seed them
+	for _, statement := range []string{
+		"INSERT INTO product (product_name, art_id, amount) VALUES ('stool', '9', 3)",
+		"PRAGMA ignore_check_constraints = ON",
+		"UPDATE inventory SET stock=-2000 WHERE art_id='2'",
+		"CREATE TABLE product_copy AS SELECT * FROM product",
+		"DROP TABLE product",
+		"ALTER TABLE product_copy RENAME TO product",
+		"INSERT INTO product (product_name, art_id, amount) VALUES ('chair', '1', 4)",
+	} {
+		_, err = inventory.db.Exec(statement)
+		assert.NilError(t, err)
+	}
+
+	report, err = inventory.CheckIntegrity(ctx)
+	assert.NilError(t, err)
+	assert.Assert(t, !report.Consistent())
+	assert.DeepEqual(t, report, data.IntegrityReport{
+		MissingArticles: data.IntegrityIssue{Count: 1, Samples: []data.IntegrityRecord{
+			{ProductName: "stool", ArtId: "9"},
+		}},
+		NegativeStock: data.IntegrityIssue{Count: 1, Samples: []data.IntegrityRecord{
+			{ArtId: "2", Location: request.DefaultLocation, Stock: "-2"},
+		}},
+		DuplicateProductArticles: data.IntegrityIssue{Count: 1, Samples: []data.IntegrityRecord{
+			{ProductName: "chair", ArtId: "1"},
+		}},
+	})
+}
@@ -0,0 +1,19 @@
+package sqlite
+
+import (
+	"context"
+	"github.com/auknl/warehouse/request"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/auknl/warehouse/sqlite")
+
+//startSpan starts the span of a db operation as a child of the request span in ctx
+func startSpan(ctx context.Context, operation string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemSqlite, semconv.DBOperation(operation), attribute.String("rid", request.GetRID(ctx))))
+}